@@ -0,0 +1,23 @@
+// 管理员身份
+package main
+
+import (
+	"crypto/subtle"
+	"sync/atomic"
+)
+
+// 用口令获取管理员身份, 消息格式: admin|口令
+// server没有配置口令时任何人都不能成为管理员
+func (this *User) DoAdmin(token string) {
+	// 口令本身不记进审计记录
+	if this.server.AdminToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(this.server.AdminToken)) != 1 {
+		this.server.audit(this, "admin", "", "", auditDenied)
+		this.errorReply(ErrPermission, "admin.wrong_token")
+		this.failed(failLogin)
+		return
+	}
+
+	atomic.StoreInt32(&this.admin, 1)
+	this.server.audit(this, "admin", "", "", auditOK)
+	this.SendMsg(t(this, "admin.granted") + "\n")
+}
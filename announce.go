@@ -0,0 +1,29 @@
+// 服务器自己发的广播, 例如维护模式、全员禁言、撤回的通知, 不需要一个发送的用户
+// 统一加上 [系统] 前缀, 用户名不能以括号开头, 所以用户发的消息冒充不了(见spoofsPrefix); 客户端按这个前缀显示成系统消息
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// 系统广播的前缀
+const (
+	systemPrefix = "[系统] "
+	noticePrefix = "[系统公告] " // 管理员的定时公告, 见schedule.go
+)
+
+// 广播一条系统消息, 跟用户的广播走同一个队列, 按每个用户的分帧方式发出
+// 等待超过broadcastTimeout时放弃并记一条日志
+func (this *Server) Announce(text string) error {
+	return this.announce(systemPrefix, text)
+}
+
+func (this *Server) announce(prefix, text string) error {
+	atomic.AddUint64(&this.stats.announcements, 1)
+	err := this.enqueue(prefix + text)
+	if err != nil {
+		fmt.Printf("announce err: %v text=%q\n", err, text)
+	}
+	return err
+}
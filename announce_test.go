@@ -0,0 +1,31 @@
+package main
+
+import (
+	"sync/atomic"
+	"testing"
+)
+
+// 按行和按帧的用户收到同样的系统消息, 只算进announcements
+func TestAnnounce(t *testing.T) {
+	server := startTestServer(t)
+	bob := dialTest(t, server, "bob")
+	alice := dialFramed(t, server)
+
+	messages := atomic.LoadUint64(&server.stats.messages)
+	if err := server.Announce("维护 十分钟后开始"); err != nil {
+		t.Fatal(err)
+	}
+	if line := bob.waitFor("维护"); line != systemPrefix+"维护 十分钟后开始" {
+		t.Fatalf("按行收到 %q", line)
+	}
+	if payload := alice.waitFor("维护"); payload != systemPrefix+"维护 十分钟后开始" {
+		t.Fatalf("按帧收到 %q", payload)
+	}
+
+	if got := atomic.LoadUint64(&server.stats.announcements); got != 1 {
+		t.Fatalf("announcements = %d", got)
+	}
+	if got := atomic.LoadUint64(&server.stats.messages); got != messages {
+		t.Fatalf("系统消息算进了用户消息: %d -> %d", messages, got)
+	}
+}
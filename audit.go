@@ -0,0 +1,139 @@
+// 管理操作的审计记录: 每条管理员命令(包括没有权限被拒绝的)记一行, 跟聊天记录分开
+// 开启 -audit-log 时追加写到文件, 管理员可以用 audit|tail|条数 查看最近的记录
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 写入文件前最多缓存多少行, 写文件跟不上时丢弃新的记录, 不影响命令处理
+const auditQueue = 1024
+
+// 内存里保留最近多少条, audit|tail 最多能看这么多
+const auditRecent = 200
+
+// 审计结果
+const (
+	auditOK      = "ok"      // 执行成功
+	auditDenied  = "denied"  // 没有权限
+	auditInvalid = "invalid" // 参数不正确或者状态没有变化
+)
+
+// 审计记录, 由单独的goroutine写文件; file为nil时只保存在内存里
+type AuditLog struct {
+	file  *os.File
+	lines chan string
+
+	lock    sync.Mutex
+	recent  []string
+	dropped int // 因为队列满没有写进文件的行数
+}
+
+func NewAuditLog() *AuditLog {
+	return &AuditLog{}
+}
+
+// 打开审计文件, 只追加不覆盖
+func OpenAuditLog(path string) (*AuditLog, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &AuditLog{
+		file:  file,
+		lines: make(chan string, auditQueue),
+	}
+	go l.run()
+
+	return l, nil
+}
+
+// 格式化一行记录: 时间 操作人 连接编号 操作 对象 参数 结果
+// 对象和参数是用户输入的, 加上引号, 里面有空格时也能按空格拆开
+func formatAuditLine(now time.Time, actor string, session uint64, action, target, params, result string) string {
+	return fmt.Sprintf("%s actor=%q session=#%d action=%s target=%q params=%q result=%s",
+		now.Format(time.RFC3339), actor, session, action, target, params, result)
+}
+
+// 记录一行, 不会阻塞调用方
+func (this *AuditLog) Record(line string) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if len(this.recent) >= auditRecent {
+		this.recent = this.recent[1:]
+	}
+	this.recent = append(this.recent, line)
+
+	if this.file == nil {
+		return
+	}
+	select {
+	case this.lines <- line + "\n":
+	default:
+		this.dropped++
+	}
+}
+
+// 最近n条记录, 按时间顺序
+func (this *AuditLog) Tail(n int) []string {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	lines := this.recent
+	if n < len(lines) {
+		lines = lines[len(lines)-n:]
+	}
+	return append([]string(nil), lines...)
+}
+
+func (this *AuditLog) run() {
+	w := bufio.NewWriter(this.file)
+	for line := range this.lines {
+		w.WriteString(line)
+
+		// 队列空了就刷到文件里, 避免异常退出时丢掉太多
+		if len(this.lines) == 0 {
+			w.Flush()
+		}
+	}
+}
+
+// 记录一条管理操作, actor为nil时表示不是用户发起的, 例如收到信号
+func (this *Server) audit(actor *User, action, target, params, result string) {
+	name, session := "-", uint64(0)
+	if actor != nil {
+		name, session = actor.Name(), actor.ID
+	}
+	this.Audit.Record(formatAuditLine(this.Clock.Now(), name, session, action, target, params, result))
+}
+
+// 查看最近的审计记录, 消息格式: audit|tail|条数, 只有管理员可以查看
+func (this *User) DoAudit(arg string) {
+	if !this.IsAdmin() {
+		this.server.audit(this, "audit", "", arg, auditDenied)
+		this.errorReply(ErrPermission, "audit.admin_only")
+		return
+	}
+	n, err := strconv.Atoi(strings.TrimPrefix(arg, "tail|"))
+	if !strings.HasPrefix(arg, "tail|") || err != nil || n <= 0 {
+		this.errorReply(ErrUsage, "audit.usage")
+		return
+	}
+
+	lines := this.server.Audit.Tail(n)
+	if len(lines) == 0 {
+		this.SendMsg(t(this, "audit.none") + "\n")
+		return
+	}
+	for _, line := range lines {
+		this.SendMsg(line + "\n")
+	}
+}
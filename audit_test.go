@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// 管理命令都有记录, 被拒绝的也记, 口令不会写进去; 开启 -audit-log 时同样的内容写到文件
+func TestAuditLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	audit, err := OpenAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := NewFakeClock(testEpoch)
+	server := startTestServer(t, WithClock(clock), WithAdminToken("secret"), WithAuditLog(audit))
+	alice := dialTest(t, server, "alice")
+	alice.send("lockdown|on", "admin|guess")
+	alice.waitFor(text("admin.wrong_token"))
+	admin := dialAdmin(t, server, "admin")
+	admin.send("lockdown|maybe", "lockdown|on")
+	alice.waitFor(text("lockdown.on"))
+
+	alice.send("audit|tail|5")
+	alice.waitFor(text("audit.admin_only"))
+	admin.send("audit|tail|0")
+	admin.waitFor(text("audit.usage"))
+	admin.send("audit|tail|6")
+	at := testEpoch.Format("2006-01-02T15:04:05Z07:00")
+	want := []string{
+		fmt.Sprintf(`%s actor="alice" session=#1 action=lockdown target="" params="on" result=denied`, at),
+		fmt.Sprintf(`%s actor="alice" session=#1 action=admin target="" params="" result=denied`, at),
+		fmt.Sprintf(`%s actor="admin" session=#2 action=admin target="" params="" result=ok`, at),
+		fmt.Sprintf(`%s actor="admin" session=#2 action=lockdown target="" params="maybe" result=invalid`, at),
+		fmt.Sprintf(`%s actor="admin" session=#2 action=lockdown target="" params="on" result=ok`, at),
+		fmt.Sprintf(`%s actor="alice" session=#1 action=audit target="" params="tail|5" result=denied`, at),
+	}
+	for _, line := range want {
+		admin.waitFor(line)
+	}
+
+	eventually(t, "审计记录没有写到文件", func() bool {
+		data, _ := os.ReadFile(path)
+		return strings.Count(string(data), "\n") >= len(want)
+	})
+	data, _ := os.ReadFile(path)
+	if strings.Contains(string(data), "secret") || strings.Contains(string(data), "guess") {
+		t.Fatalf("口令写进了审计记录:\n%s", data)
+	}
+	if !strings.HasPrefix(string(data), strings.Join(want, "\n")+"\n") {
+		t.Fatalf("audit.log:\n%s", data)
+	}
+}
+
+// 内存里只保留最近的auditRecent条
+func TestAuditTail(t *testing.T) {
+	audit := NewAuditLog()
+	if len(audit.Tail(10)) != 0 {
+		t.Fatal("空的记录")
+	}
+	for i := 0; i < auditRecent+50; i++ {
+		audit.Record(fmt.Sprint(i))
+	}
+	all := audit.Tail(auditRecent * 2)
+	if len(all) != auditRecent || all[0] != "50" || all[len(all)-1] != fmt.Sprint(auditRecent+49) {
+		t.Fatalf("Tail = %d 条, %s ... %s", len(all), all[0], all[len(all)-1])
+	}
+	if last := audit.Tail(2); strings.Join(last, ",") != fmt.Sprintf("%d,%d", auditRecent+48, auditRecent+49) {
+		t.Fatalf("Tail(2) = %q", last)
+	}
+}
@@ -0,0 +1,36 @@
+// 机器人账号: 连接时声明 caps|bot, 或者由管理员用 mark-bot|用户名 标记
+// 机器人在who和广播中带有[bot]标记, 不会因为闲置被踢掉
+package main
+
+// 机器人在who和广播中显示的标记
+const botTag = "[bot]"
+
+func (this *User) IsBot() bool {
+	return this.HasCap(capBot)
+}
+
+// 广播中显示的用户名, 机器人前面带有[bot]标记, 见format.go
+func (this *User) DisplayName() string {
+	if this.IsBot() {
+		return botTag + this.Name()
+	}
+	return this.Name()
+}
+
+// 把一个用户标记为机器人, 消息格式: mark-bot|用户名 或 mark-bot|#编号, 只有管理员可以标记
+func (this *User) DoMarkBot(target string) {
+	if !this.IsAdmin() {
+		this.server.audit(this, "mark-bot", target, "", auditDenied)
+		this.errorReply(ErrPermission, "bot.admin_only")
+		return
+	}
+	user, ok := this.server.lookupUser(target)
+	if !ok {
+		this.server.audit(this, "mark-bot", target, "", auditInvalid)
+		this.errorReply(ErrNoSuchUser, "user.not_found")
+		return
+	}
+	user.setCap(capBot)
+	this.server.audit(this, "mark-bot", user.Name(), "", auditOK)
+	this.SendMsg(t(this, "bot.marked", user.Name()) + "\n")
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// 机器人在广播和who里带[bot]标记, 只有管理员可以标记别人
+func TestBotDisplay(t *testing.T) {
+	server := startTestServer(t, WithAdminToken("secret"))
+	alice := dialTest(t, server, "alice")
+	robo := dialTest(t, server, "robo")
+	declareCaps(t, robo, capBot)
+
+	robo.send("beep")
+	alice.waitFor("]" + botTag + "robo:beep")
+	alice.send("who")
+	alice.waitFor("}" + botTag + "robo:在线...")
+
+	alice.send("mark-bot|alice")
+	alice.waitFor(text("bot.admin_only"))
+	admin := dialAdmin(t, server, "admin")
+	admin.send("mark-bot|alice")
+	admin.waitFor(text("bot.marked", "alice"))
+	alice.send("hello")
+	alice.waitFor("]" + botTag + "alice:hello")
+}
+
+// 机器人不会因为空闲被踢
+func TestBotIdleExempt(t *testing.T) {
+	clock := NewFakeClock(testEpoch)
+	const timeout = 100 * time.Second
+	server := startTestServer(t, WithClock(clock), WithIdleTimeout(timeout))
+	alice := dialTest(t, server, "alice")
+	robo := dialTest(t, server, "robo")
+	declareCaps(t, robo, capBot)
+
+	clock.Advance(timeout + timeout/20)
+	alice.waitFor(text("user.kicked"))
+	alice.waitClosed()
+	robo.send("whoami")
+	robo.waitFor("whoami name=robo")
+	if robo.saw(text("user.kicked")) {
+		t.Fatal("机器人因为空闲被踢掉了")
+	}
+}
@@ -0,0 +1,209 @@
+// 批量操作: 清理一大群机器人连接时不用一个一个踢
+// kickall|模式 踢掉用户名匹配的全部用户, msgall|模式|内容 给他们每人发一条私聊, 只有管理员可以使用
+// 模式里 * 匹配任意多个字符, ? 匹配一个字符, 例如 guest-*; 不会匹配到自己和其他管理员
+// 匹配超过 bulkConfirmAfter 个用户时先只回复人数, 在命令最后加上 |confirm 重新发送后才执行
+// |confirm 只对同一个管理员 bulkConfirmWindow 内预览过的同一条命令有效, 而且匹配的人数没有变; 不能直接带着 |confirm 跳过预览
+// 先在锁里取出匹配的用户, 踢人和发消息都在锁外面做, 之后逐个回复结果
+package main
+
+import (
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// 匹配超过多少个用户时需要确认
+const bulkConfirmAfter = 5
+
+// 需要确认时最多列出多少个名字
+const bulkPreview = 10
+
+// 确认执行时加在命令最后的参数
+const bulkConfirm = "confirm"
+
+// 预览之后多久内可以确认
+const bulkConfirmWindow = 30 * time.Second
+
+// 一次需要确认的预览: 哪条命令、去掉 |confirm 之后的参数、匹配了多少人、什么时候
+type bulkPreviewed struct {
+	command string
+	arg     string
+	count   int
+	at      time.Time
+}
+
+// 用户名是否匹配模式, 只支持 * 和 ?, 按字符而不是字节匹配
+func globMatch(pattern, name string) bool {
+	// 记下最近一个 * 的位置, 后面匹配失败时让它多吃一个字符再试
+	star, starName := -1, 0
+	p, n := 0, 0
+	for n < len(name) {
+		if p < len(pattern) {
+			switch pattern[p] {
+			case '*':
+				star, starName = p, n
+				p++
+				continue
+			case '?':
+				_, size := utf8.DecodeRuneInString(name[n:])
+				p, n = p+1, n+size
+				continue
+			default:
+				pr, psize := utf8.DecodeRuneInString(pattern[p:])
+				nr, nsize := utf8.DecodeRuneInString(name[n:])
+				if pr == nr {
+					p, n = p+psize, n+nsize
+					continue
+				}
+			}
+		}
+		if star < 0 {
+			return false
+		}
+		_, size := utf8.DecodeRuneInString(name[starName:])
+		starName += size
+		p, n = star+1, starName
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// 取出本实例上用户名匹配的用户, 按名字排序, 不包括自己和其他管理员
+func (this *User) bulkTargets(pattern string) []*User {
+	this.server.mapLock.RLock()
+	var list []*User
+	for name, user := range this.server.OnlineMap {
+		if user != this && !user.IsAdmin() && globMatch(pattern, name) {
+			list = append(list, user)
+		}
+	}
+	this.server.mapLock.RUnlock()
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Name() < list[j].Name() })
+	return list
+}
+
+// 去掉最后的 |confirm, 返回是否有
+func cutConfirm(arg string) (string, bool) {
+	if rest, ok := strings.CutSuffix(arg, "|"+bulkConfirm); ok {
+		return rest, true
+	}
+	return arg, false
+}
+
+// 检查匹配的人数: 没有匹配或者需要确认时回复后返回false
+// arg是去掉 |confirm 之后的参数, 确认时要跟预览的那次一样
+func (this *User) bulkReady(command string, arg string, targets []*User, pattern string, confirmed bool) bool {
+	pending := this.bulkPending
+	this.bulkPending = bulkPreviewed{}
+	if len(targets) == 0 {
+		this.errorReply(ErrNoSuchUser, "bulk.none", pattern)
+		return false
+	}
+	if len(targets) <= bulkConfirmAfter {
+		return true
+	}
+	if confirmed {
+		if pending.command == command && pending.arg == arg && pending.count == len(targets) &&
+			this.server.Clock.Now().Sub(pending.at) <= bulkConfirmWindow {
+			return true
+		}
+		// 没有预览过、预览过期了或者匹配的人数变了, 重新预览
+		this.errorReply(ErrExpired, "bulk.confirm_stale")
+	}
+	this.bulkPending = bulkPreviewed{command: command, arg: arg, count: len(targets), at: this.server.Clock.Now()}
+	names := make([]string, 0, bulkPreview)
+	for i, user := range targets {
+		if i == bulkPreview {
+			names = append(names, "...")
+			break
+		}
+		names = append(names, user.Name())
+	}
+	this.SendMsg(t(this, "bulk.confirm", len(targets), strings.Join(names, ", ")) + "\n")
+	return false
+}
+
+// 用户是否还在线并且还用着这个名字
+func (this *Server) stillOnline(user *User, name string) bool {
+	this.mapLock.RLock()
+	defer this.mapLock.RUnlock()
+	return this.OnlineMap[name] == user
+}
+
+// 踢掉用户名匹配的全部用户, 消息格式: kickall|模式 或 kickall|模式|confirm
+func (this *User) DoKickAll(arg string) {
+	if !this.IsAdmin() {
+		this.server.audit(this, "kickall", "", arg, auditDenied)
+		this.errorReply(ErrPermission, "kickall.admin_only")
+		return
+	}
+	pattern, confirmed := cutConfirm(arg)
+	if pattern == "" || strings.Contains(pattern, "|") {
+		this.server.audit(this, "kickall", "", arg, auditInvalid)
+		this.errorReply(ErrUsage, "kickall.usage")
+		return
+	}
+	targets := this.bulkTargets(pattern)
+	if !this.bulkReady("kickall", pattern, targets, pattern, confirmed) {
+		return
+	}
+
+	ok := 0
+	for _, user := range targets {
+		name := user.Name()
+		if !this.server.stillOnline(user, name) {
+			this.SendMsg(t(this, "bulk.gone", name) + "\n")
+			continue
+		}
+		user.SendMsg(t(user, "kickall.kicked") + "\n")
+		// 关闭连接, 读消息的goroutine读到错误后会执行下线业务
+		user.disconnect()
+		this.server.audit(this, "kick", name, "", auditOK)
+		this.SendMsg(t(this, "bulk.kicked", name) + "\n")
+		ok++
+	}
+	this.server.audit(this, "kickall", pattern, "", auditOK)
+	this.SendMsg(t(this, "bulk.done", ok, len(targets)-ok) + "\n")
+}
+
+// 给用户名匹配的全部用户发私聊, 消息格式: msgall|模式|内容 或 msgall|模式|内容|confirm
+func (this *User) DoMsgAll(arg string) {
+	if !this.IsAdmin() {
+		this.server.audit(this, "msgall", "", arg, auditDenied)
+		this.errorReply(ErrPermission, "msgall.admin_only")
+		return
+	}
+	arg, confirmed := cutConfirm(arg)
+	pattern, text, _ := strings.Cut(arg, "|")
+	if pattern == "" || text == "" {
+		this.server.audit(this, "msgall", "", arg, auditInvalid)
+		this.errorReply(ErrUsage, "msgall.usage")
+		return
+	}
+	targets := this.bulkTargets(pattern)
+	if !this.bulkReady("msgall", arg, targets, pattern, confirmed) {
+		return
+	}
+
+	ok := 0
+	line := privateLine(this.Name(), text) + "\n"
+	for _, user := range targets {
+		name := user.Name()
+		if !this.server.stillOnline(user, name) {
+			this.SendMsg(t(this, "bulk.gone", name) + "\n")
+			continue
+		}
+		if err := user.SendMsg(line); err != nil {
+			this.SendMsg(t(this, "bulk.failed", name) + "\n")
+			continue
+		}
+		this.SendMsg(t(this, "bulk.sent", name) + "\n")
+		ok++
+	}
+	this.server.audit(this, "msgall", pattern, "", auditOK)
+	this.SendMsg(t(this, "bulk.done", ok, len(targets)-ok) + "\n")
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// 上线n个机器人: bot1 bot2 ...
+func dialBots(t *testing.T, server *Server, n int) []*testConn {
+	t.Helper()
+	bots := make([]*testConn, n)
+	for i := range bots {
+		bots[i] = dialTest(t, server, "bot"+strconv.Itoa(i+1))
+	}
+	return bots
+}
+
+func TestGlobMatch(t *testing.T) {
+	for _, c := range []struct {
+		pattern, name string
+		want          bool
+	}{
+		{"guest-*", "guest-12", true},
+		{"guest-*", "guest", false},
+		{"b?b", "bob", true},
+		{"*ob", "bob", true},
+		{"张?", "张三", true},
+		{"a*b*c", "axxbyyc", true},
+		{"a*b*c", "axxbyy", false},
+	} {
+		if got := globMatch(c.pattern, c.name); got != c.want {
+			t.Errorf("globMatch(%q, %q) = %v", c.pattern, c.name, got)
+		}
+	}
+}
+
+// 预览里名字列表之前的部分
+func confirmPrompt(n int) string {
+	prompt, _, _ := strings.Cut(text("bulk.confirm", n, "\x00"), "\x00")
+	return prompt
+}
+
+// 不能直接带着 |confirm 跳过预览
+func TestBulkConfirmNeedsPreview(t *testing.T) {
+	server := startTestServer(t, WithAdminToken("secret"))
+	admin := dialAdmin(t, server, "admin")
+	dialBots(t, server, bulkConfirmAfter+1)
+
+	admin.send("kickall|bot*|confirm")
+	admin.waitFor(text("bulk.confirm_stale"))
+	admin.waitFor(text("bulk.confirm", bulkConfirmAfter+1, "bot1, bot2, bot3, bot4, bot5, bot6"))
+	onlineUser(t, server, "bot1")
+
+	// 预览过的同一条命令可以确认
+	admin.send("kickall|bot*|confirm")
+	admin.waitFor(text("bulk.done", bulkConfirmAfter+1, 0))
+}
+
+// 预览过期、换了命令或者匹配的人数变了都要重新确认
+func TestBulkConfirmMismatch(t *testing.T) {
+	clock := NewFakeClock(testEpoch)
+	server := startTestServer(t, WithAdminToken("secret"), WithClock(clock))
+	admin := dialAdmin(t, server, "admin")
+	bots := dialBots(t, server, bulkConfirmAfter+2)
+
+	admin.send("msgall|bot*|hi")
+	admin.waitFor(confirmPrompt(bulkConfirmAfter + 2))
+	clock.Advance(bulkConfirmWindow + 1)
+	admin.send("msgall|bot*|hi|confirm")
+	admin.waitFor(text("bulk.confirm_stale"))
+	admin.waitFor(confirmPrompt(bulkConfirmAfter + 2))
+
+	// 预览的是别的内容
+	admin.send("msgall|bot*|bye|confirm")
+	admin.waitFor(text("bulk.confirm_stale"))
+	admin.waitFor(confirmPrompt(bulkConfirmAfter + 2))
+
+	// 预览之后有人下线了
+	admin.send("kickall|bot*")
+	admin.waitFor(confirmPrompt(bulkConfirmAfter + 2))
+	bots[0].conn.Close()
+	eventually(t, "bot1 没有下线", func() bool {
+		_, ok := server.lookupUser("bot1")
+		return !ok
+	})
+	admin.send("kickall|bot*|confirm")
+	admin.waitFor(text("bulk.confirm_stale"))
+	admin.send("kickall|bot*|confirm")
+	admin.waitFor(text("bulk.done", bulkConfirmAfter+1, 0))
+}
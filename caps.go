@@ -0,0 +1,69 @@
+// 客户端能力协商
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// 服务端支持的能力
+const (
+	capReceipts    = "receipts"     // 私聊已读回执
+	capBot         = "bot"          // 机器人账号, 见bot.go
+	capRenameReply = "rename-reply" // 改名的结果用 rename-ok/rename-err 回复, 见DoRename
+	capErrors      = "errors"       // 错误回复带上编号: err|编号|提示文字, 见errors.go
+	capMsgID       = "msg-id"       // to|用户名|消息内容|消息ID 的最后一段是消息ID, 没发出去时回复 nak|消息ID, 见dedupe.go
+	capRecall      = "recall"       // 公聊消息撤回时另外收到 recall|序号, 序号跟 recall|list 里的一样, 见history.go
+)
+
+var supportedCaps = map[string]bool{
+	capReceipts:    true,
+	capBot:         true,
+	capRenameReply: true,
+	capErrors:      true,
+	capMsgID:       true,
+	capRecall:      true,
+}
+
+// 处理客户端声明的能力, 消息格式: caps|receipts,xxx
+// 没有声明过的能力一律按老客户端处理
+func (this *User) DoCaps(arg string) {
+	this.capLock.Lock()
+	defer this.capLock.Unlock()
+
+	for _, c := range strings.Split(arg, ",") {
+		c = strings.TrimSpace(c)
+		if supportedCaps[c] {
+			this.caps[c] = true
+		}
+	}
+}
+
+// 给当前用户加上一个能力, 例如管理员标记机器人
+func (this *User) setCap(c string) {
+	this.capLock.Lock()
+	defer this.capLock.Unlock()
+
+	this.caps[c] = true
+}
+
+// 当前用户是否声明了某个能力
+func (this *User) HasCap(c string) bool {
+	this.capLock.RLock()
+	defer this.capLock.RUnlock()
+
+	return this.caps[c]
+}
+
+// 当前用户的全部能力, 按名字排序
+func (this *User) Caps() []string {
+	this.capLock.RLock()
+	defer this.capLock.RUnlock()
+
+	list := make([]string, 0, len(this.caps))
+	for c := range this.caps {
+		list = append(list, c)
+	}
+	sort.Strings(list)
+	return list
+}
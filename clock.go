@@ -0,0 +1,134 @@
+// 时钟接口, 所有跟时间有关的逻辑都通过Server上的Clock获取时间
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// 时钟, 默认使用系统时间, 测试时可以换成FakeClock
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+	NewTicker(d time.Duration) Ticker
+}
+
+// 定时器, 对应time.Ticker
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// 使用系统时间的时钟
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+func (realClock) NewTicker(d time.Duration) Ticker       { return realTicker{time.NewTicker(d)} }
+
+type realTicker struct {
+	t *time.Ticker
+}
+
+func (this realTicker) C() <-chan time.Time { return this.t.C }
+func (this realTicker) Stop()               { this.t.Stop() }
+
+// 手动拨动的时钟, 只有调用Advance时时间才会前进
+type FakeClock struct {
+	lock    sync.Mutex
+	now     time.Time
+	waiters []*fakeWaiter
+}
+
+// 一个等待中的After或Ticker
+type fakeWaiter struct {
+	at     time.Time
+	period time.Duration // 大于0时是Ticker, 触发后按周期重新等待
+	c      chan time.Time
+}
+
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+func (this *FakeClock) Now() time.Time {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	return this.now
+}
+
+func (this *FakeClock) After(d time.Duration) <-chan time.Time {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	w := &fakeWaiter{at: this.now.Add(d), c: make(chan time.Time, 1)}
+	this.waiters = append(this.waiters, w)
+	return w.c
+}
+
+func (this *FakeClock) NewTicker(d time.Duration) Ticker {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	w := &fakeWaiter{at: this.now.Add(d), period: d, c: make(chan time.Time, 1)}
+	this.waiters = append(this.waiters, w)
+	return &fakeTicker{clock: this, w: w}
+}
+
+// 让时间前进d, 到期的After和Ticker会被触发
+func (this *FakeClock) Advance(d time.Duration) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	this.now = this.now.Add(d)
+
+	waiters := this.waiters[:0]
+	for _, w := range this.waiters {
+		if w.at.After(this.now) {
+			waiters = append(waiters, w)
+			continue
+		}
+
+		// 跟time.Ticker一样, 接收方来不及处理时丢弃这次触发
+		select {
+		case w.c <- this.now:
+		default:
+		}
+
+		if w.period > 0 {
+			for !w.at.After(this.now) {
+				w.at = w.at.Add(w.period)
+			}
+			waiters = append(waiters, w)
+		}
+	}
+	this.waiters = waiters
+}
+
+// 当前还在等待的After和Ticker数量, 测试里可以用来确认goroutine已经开始等待
+func (this *FakeClock) Waiters() int {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	return len(this.waiters)
+}
+
+type fakeTicker struct {
+	clock *FakeClock
+	w     *fakeWaiter
+}
+
+func (this *fakeTicker) C() <-chan time.Time { return this.w.c }
+
+func (this *fakeTicker) Stop() {
+	this.clock.lock.Lock()
+	defer this.clock.lock.Unlock()
+
+	for i, w := range this.clock.waiters {
+		if w == this.w {
+			this.clock.waiters = append(this.clock.waiters[:i], this.clock.waiters[i+1:]...)
+			return
+		}
+	}
+}
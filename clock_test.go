@@ -0,0 +1,57 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// 还没到时间的After不会触发, Ticker错过的触发只留一次, Stop以后不再等待
+func TestFakeClock(t *testing.T) {
+	clock := NewFakeClock(testEpoch)
+	after := clock.After(time.Minute)
+	ticker := clock.NewTicker(10 * time.Second)
+
+	clock.Advance(59 * time.Second)
+	select {
+	case <-after:
+		t.Fatal("After提前触发了")
+	default:
+	}
+	if got := <-ticker.C(); !got.Equal(testEpoch.Add(59 * time.Second)) {
+		t.Fatalf("ticker = %v", got)
+	}
+	select {
+	case <-ticker.C():
+		t.Fatal("错过的触发没有合并")
+	default:
+	}
+
+	clock.Advance(time.Second)
+	if got := <-after; !got.Equal(testEpoch.Add(time.Minute)) {
+		t.Fatalf("after = %v", got)
+	}
+	<-ticker.C()
+	if n := clock.Waiters(); n != 1 {
+		t.Fatalf("触发过的After还在等待: %d", n)
+	}
+	ticker.Stop()
+	if n := clock.Waiters(); n != 0 {
+		t.Fatalf("Stop以后还在等待: %d", n)
+	}
+}
+
+// 改名的间隔按Server的时钟计算, 提示的秒数向上多算一秒
+func TestRenameIntervalUsesClock(t *testing.T) {
+	clock := NewFakeClock(testEpoch)
+	server := startTestServer(t, WithClock(clock), WithRenameLimit(30*time.Second, 0))
+	alice := dialTest(t, server, "alice")
+
+	alice.send("rename|alice2")
+	alice.waitFor(text("rename.wait", 31))
+	clock.Advance(20 * time.Second)
+	alice.send("rename|alice2")
+	alice.waitFor(text("rename.wait", 11))
+	clock.Advance(10 * time.Second)
+	alice.send("rename|alice2")
+	alice.waitFor(text("rename.done", "alice2"))
+}
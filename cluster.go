@@ -0,0 +1,184 @@
+// 集群模式: 多个server实例通过Broker共享广播和在线用户
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// 在线用户列表的过期时间, 实例每过三分之一的时间刷新一次
+const presenceTTL = 30 * time.Second
+
+// 等待发布到其他实例的广播消息最多缓存多少条
+const clusterQueue = 1024
+
+// 其他实例上的一个在线用户
+type Presence struct {
+	Instance string
+	Addr     string
+}
+
+// 实例之间传递消息的方式, 默认实现是RedisBroker
+type Broker interface {
+	Publish(channel string, payload string) error
+	// 订阅频道, 一直阻塞到连接出错或者stop关闭为止
+	Subscribe(channels []string, handler func(channel string, payload string), stop <-chan struct{}) error
+	// 用新的在线用户列表替换一个实例的在线用户, key是用户名, value是地址
+	SetPresence(instance string, users map[string]string, ttl time.Duration) error
+	// 全部实例的在线用户, key是用户名
+	Presence() (map[string]Presence, error)
+}
+
+type Cluster struct {
+	server *Server
+	broker Broker
+	id     string // 本实例的名字, 不能包含'|'
+
+	outgoing chan string   // 等待发布的广播消息
+	changed  chan struct{} // 本实例的在线用户有变化
+}
+
+func NewCluster(server *Server, broker Broker, id string) *Cluster {
+	return &Cluster{
+		server:   server,
+		broker:   broker,
+		id:       id,
+		outgoing: make(chan string, clusterQueue),
+		changed:  make(chan struct{}, 1),
+	}
+}
+
+// 启动订阅、发布和刷新在线用户的goroutine, 服务器停止后它们都退出
+func (this *Cluster) Start() {
+	go this.subscribeLoop()
+	go this.publishLoop()
+	go this.presenceLoop()
+}
+
+// 订阅广播频道和本实例的私聊频道, 断开后自动重连, 服务器停止后退出
+func (this *Cluster) subscribeLoop() {
+	stopped := this.server.stopped
+	for {
+		err := this.broker.Subscribe([]string{redisBroadcast, instanceChannel(this.id)}, this.handle, stopped)
+		select {
+		case <-stopped:
+			return
+		default:
+		}
+		fmt.Println("cluster subscribe err:", err)
+		select {
+		case <-this.server.Clock.After(time.Second):
+		case <-stopped:
+			return
+		}
+	}
+}
+
+// 处理其他实例发来的消息
+func (this *Cluster) handle(channel string, payload string) {
+	if channel == redisBroadcast {
+		// 格式: 实例|消息, 自己发布的消息已经在本地发过了
+		parts := strings.SplitN(payload, "|", 2)
+		if len(parts) == 2 && parts[0] != this.id {
+			this.server.deliverLocal(parts[1])
+		}
+		return
+	}
+
+	// 私聊消息, 格式: 发送方实例|发送方|接收方|消息内容
+	parts := strings.SplitN(payload, "|", 4)
+	if len(parts) != 4 {
+		return
+	}
+	this.server.mapLock.RLock()
+	user, ok := this.server.OnlineMap[parts[2]]
+	this.server.mapLock.RUnlock()
+	if ok {
+		user.SendMsg(privateLine(parts[1], parts[3]) + "\n")
+	}
+}
+
+// 把本实例的广播消息发布给其他实例, 不阻塞本地的广播
+func (this *Cluster) PublishBroadcast(msg string) {
+	select {
+	case this.outgoing <- msg:
+	default:
+		fmt.Println("cluster queue full, broadcast not published:", msg)
+	}
+}
+
+func (this *Cluster) publishLoop() {
+	for {
+		select {
+		case msg := <-this.outgoing:
+			if err := this.broker.Publish(redisBroadcast, this.id+"|"+msg); err != nil {
+				fmt.Println("cluster publish err:", err)
+			}
+		case <-this.server.stopped:
+			return
+		}
+	}
+}
+
+// 本实例的在线用户有变化时调用, 尽快刷新在线用户列表
+func (this *Cluster) PresenceChanged() {
+	select {
+	case this.changed <- struct{}{}:
+	default:
+	}
+}
+
+// 定时刷新本实例的在线用户列表, 实例退出后列表会自动过期
+func (this *Cluster) presenceLoop() {
+	ticker := this.server.Clock.NewTicker(presenceTTL / 3)
+	defer ticker.Stop()
+
+	for {
+		users := make(map[string]string)
+		this.server.mapLock.RLock()
+		for name, user := range this.server.OnlineMap {
+			users[name] = user.Addr
+		}
+		this.server.mapLock.RUnlock()
+
+		if err := this.broker.SetPresence(this.id, users, presenceTTL); err != nil {
+			fmt.Println("cluster presence err:", err)
+		}
+
+		select {
+		case <-ticker.C():
+		case <-this.changed:
+		case <-this.server.stopped:
+			return
+		}
+	}
+}
+
+// 其他实例上的在线用户
+func (this *Cluster) RemoteUsers() map[string]Presence {
+	all, err := this.broker.Presence()
+	if err != nil {
+		fmt.Println("cluster presence err:", err)
+		return nil
+	}
+	for name, p := range all {
+		if p.Instance == this.id {
+			delete(all, name)
+		}
+	}
+	return all
+}
+
+// 给其他实例上的用户发私聊消息, 找不到这个用户时返回false
+func (this *Cluster) SendPrivate(from string, to string, content string) bool {
+	p, ok := this.RemoteUsers()[to]
+	if !ok {
+		return false
+	}
+	if err := this.broker.Publish(instanceChannel(p.Instance), this.id+"|"+from+"|"+to+"|"+content); err != nil {
+		fmt.Println("cluster publish err:", err)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Achiyun/Golang-IM-System/internal/leakcheck"
+)
+
+// 进程内的Broker, 几个测试服务器共用一个, 代替Redis
+type fakeBroker struct {
+	lock      sync.Mutex
+	handlers  map[string][]func(channel string, payload string)
+	presence  map[string]map[string]string
+	published []string // 发布过的 频道 消息
+}
+
+func newFakeBroker() *fakeBroker {
+	return &fakeBroker{
+		handlers: make(map[string][]func(string, string)),
+		presence: make(map[string]map[string]string),
+	}
+}
+
+func (this *fakeBroker) Publish(channel string, payload string) error {
+	this.lock.Lock()
+	this.published = append(this.published, channel+" "+payload)
+	handlers := append([]func(string, string){}, this.handlers[channel]...)
+	this.lock.Unlock()
+	for _, h := range handlers {
+		h(channel, payload)
+	}
+	return nil
+}
+
+// 订阅以后一直不断开, 直到服务器停止
+func (this *fakeBroker) Subscribe(channels []string, handler func(channel string, payload string), stop <-chan struct{}) error {
+	this.lock.Lock()
+	for _, c := range channels {
+		this.handlers[c] = append(this.handlers[c], handler)
+	}
+	this.lock.Unlock()
+	<-stop
+	return nil
+}
+
+func (this *fakeBroker) SetPresence(instance string, users map[string]string, ttl time.Duration) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.presence[instance] = users
+	return nil
+}
+
+func (this *fakeBroker) Presence() (map[string]Presence, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	all := make(map[string]Presence)
+	for instance, users := range this.presence {
+		for name, addr := range users {
+			all[name] = Presence{Instance: instance, Addr: addr}
+		}
+	}
+	return all, nil
+}
+
+// 发布过的消息里有几条包含want
+func (this *fakeBroker) count(want string) int {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	n := 0
+	for _, p := range this.published {
+		if strings.Contains(p, want) {
+			n++
+		}
+	}
+	return n
+}
+
+// 两个实例共用一个Broker, alice连在a上, bob连在b上
+func startCluster(t *testing.T) (*fakeBroker, *testConn, *testConn, *Server) {
+	t.Helper()
+	leakcheck.Check(t)
+	broker := newFakeBroker()
+	a := startTestServer(t, WithCluster(broker, "a"))
+	b := startTestServer(t, WithCluster(broker, "b"))
+	alice := dialTest(t, a, "alice")
+	bob := dialTest(t, b, "bob")
+	eventually(t, "a 看不到 b 上的 bob", func() bool {
+		_, ok := a.cluster.RemoteUsers()["bob"]
+		return ok
+	})
+	return broker, alice, bob, a
+}
+
+func TestClusterBroadcast(t *testing.T) {
+	broker, alice, bob, _ := startCluster(t)
+	alice.send("across instances")
+	bob.waitFor("alice:across instances")
+
+	// 别的实例发来的广播不会再发布一次
+	bob.send("sync-b")
+	alice.waitFor("sync-b")
+	if n := broker.count("across instances"); n != 1 {
+		t.Fatalf("发布了 %d 次", n)
+	}
+}
+
+func TestClusterPrivate(t *testing.T) {
+	broker, alice, bob, a := startCluster(t)
+	alice.send("to|bob|remote secret")
+	bob.waitFor(privateLine("alice", "remote secret"))
+
+	// 同一个实例上的私聊不经过Broker
+	carol := dialTest(t, a, "carol")
+	alice.send("to|carol|local secret")
+	carol.waitFor(privateLine("alice", "local secret"))
+	if broker.count("local secret") != 0 {
+		t.Fatal("本实例的私聊发布到了Broker")
+	}
+}
+
+// 对方下线以后别的实例上也找不到了
+func TestClusterPresenceGone(t *testing.T) {
+	_, alice, bob, a := startCluster(t)
+	bob.conn.Close()
+	eventually(t, "bob 下线以后 a 还能看到", func() bool {
+		_, ok := a.cluster.RemoteUsers()["bob"]
+		return !ok
+	})
+	alice.send("caps|errors", "to|bob|hi")
+	alice.waitFor("err|")
+}
@@ -0,0 +1,800 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/Achiyun/Golang-IM-System/cmd/client/transcript"
+)
+
+type Client struct {
+	ServerIp   string
+	ServerPort int
+	name       string       // 当前的用户名, 读server消息的goroutine和输入的goroutine都会用, 用Name()读
+	nameLock   sync.RWMutex // 保护name
+	conn       net.Conn
+	reader     *bufio.Reader // 读server消息, 握手和dealLines共用
+	flag       int
+
+	hello       map[string]string // server在hello里发来的信息, 见client_hello.go
+	errorNames  map[int]string    // server在hello里发来的错误表, 见client_errors.go
+	sendFraming int32             // 发送的分帧方式, 见client_framing.go
+	recvFraming int               // 接收的分帧方式, 只在dealLines里使用
+	compress    *compressWriter   // 开启压缩后发送都经过它, 在writeLock里读写, 见client_compress.go
+	writeLock   sync.Mutex        // 写连接时持有, 发送可能来自好几个goroutine(比如传文件)
+
+	receipts bool            // 是否开启已读回执
+	acked    map[string]bool // 已经发过回执的私聊消息序号, 重复显示时不再发送
+
+	renames renameWaiter // 等待改名的结果, 见client_rename.go
+	outbox  outbox       // 等待服务器确认的消息, 见client_outbox.go
+	files   transfers    // 正在传的文件, 见client_file.go
+
+	timestamps bool               // 显示消息时加上本地收到的时间
+	log        *msgLog            // 本地消息记录, 为nil时不记录
+	transcript *transcript.Writer // HTML聊天记录, 为nil时不写, 见client_transcript.go
+	color      bool               // 彩色输出
+
+	editor      *LineEditor   // 标准输入是终端时使用的行编辑器
+	input       *bufio.Reader // 标准输入不是终端时直接按行读取
+	inputClosed bool          // 标准输入已经结束
+	restoreTerm func()        // 恢复终端设置
+
+	roster        Roster // 在线用户名单, 用于Tab补全
+	pickingTarget bool   // 正在输入私聊对象, 整行都按用户名补全
+
+	aliases map[string]string // 快捷回复, 见client_alias.go
+
+	notify    bool      // 私聊和提到自己的消息响铃提醒
+	important Important // 最近的重要消息
+
+	joinRoster chan struct{} // 连接后等待显示的在线用户名单, 显示后关闭, 为nil时不显示
+	whoUsers   []whoLine     // 正在收集的who列表, 不在列表中间时为nil
+
+	debugProtocol bool      // 把收发的原始消息打印到标准错误
+	trace         io.Closer // -trace 的文件, 见client_trace.go
+
+	// 本次连接的统计, 见client_stats.go
+	started  time.Time
+	msgsSent uint64
+	msgsRecv uint64
+}
+
+func NewClient(serverIp string, serverPort int) *Client {
+	// 创建客户端对象
+	client := &Client{
+		ServerIp:   serverIp,
+		ServerPort: serverPort,
+		flag:       999, // 瞎起的, 不为0就行
+		acked:      make(map[string]bool),
+		input:      bufio.NewReader(os.Stdin),
+	}
+
+	// 链接server
+	conn, err := net.Dial("tcp", net.JoinHostPort(serverIp, strconv.Itoa(serverPort)))
+	if err != nil {
+		complain("net.Dail error:", err)
+		return nil
+	}
+	client.conn = &meteredConn{Conn: conn}
+	client.reader = bufio.NewReader(client.conn)
+	client.started = time.Now()
+
+	// 返回对象
+	return client
+}
+
+// 处理 server回应的消息, 按行解析后显示到标准输出, 永久阻塞监听
+func (client *Client) DealResponse() {
+	client.dealLines()
+}
+
+// 按行读取server的消息, 识别出的协议消息转换后再显示, 其余原样输出
+func (client *Client) dealLines() {
+	for {
+		line, err := client.readMessage()
+		if len(line) > 0 {
+			line = strings.TrimSuffix(line, "\n")
+			atomic.AddUint64(&client.msgsRecv, 1)
+			client.debugFrame("<-", line)
+			if client.log != nil {
+				client.log.Write(logRecv, line)
+			}
+			client.showLine(line)
+		}
+		if err != nil {
+			client.abortTransfers()
+			return
+		}
+	}
+}
+
+// 显示一行server的消息
+func (client *Client) showLine(line string) {
+	// 控制消息由客户端自己处理, 见client_frames.go
+	if client.handleControl(line) {
+		return
+	}
+
+	// 带序号的私聊消息, 格式: pm|序号|发送方|消息内容
+	if strings.HasPrefix(line, "pm|") {
+		parts := strings.SplitN(line, "|", 4)
+		if len(parts) == 4 {
+			text := privatePrefix + parts[2] + "对您说:" + parts[3]
+			client.recordTranscript(text)
+			client.checkImportant(text)
+			client.display(text)
+			client.sendReceipt(parts[1])
+			return
+		}
+	}
+
+	client.recordTranscript(line)
+	client.checkImportant(line)
+	client.display(line)
+}
+
+// 开启 -transcript 时把收到的消息写进HTML聊天记录
+func (client *Client) recordTranscript(line string) {
+	if client.transcript != nil {
+		client.transcript.Write(transcriptLine(time.Now(), line))
+	}
+}
+
+// 显示连接时的在线用户名单
+func (client *Client) showRoster(names []string) {
+	client.display("当前在线:")
+	for _, name := range names {
+		if name == client.Name() {
+			name += " (我)"
+		}
+		client.display("  " + name)
+	}
+}
+
+// 把一条消息显示到标准输出
+func (client *Client) display(text string) {
+	if client.color {
+		text = colorize(text, client.Name())
+	}
+	if client.timestamps {
+		text = formatTimestamp(time.Now()) + text
+	}
+	var err error
+	if client.editor != nil {
+		err = client.editor.Print(text)
+	} else {
+		_, err = fmt.Println(text)
+	}
+	if err != nil {
+		client.stdoutFailed(err)
+	}
+}
+
+// 开启行编辑器, 标准输入不是终端或者设置终端失败时继续按行读取
+func (client *Client) enableEditor() {
+	if !stdinIsTTY() {
+		return
+	}
+	restore, err := enableCbreak()
+	if err != nil {
+		return
+	}
+	path := inputHistoryPath()
+	client.editor = NewLineEditor(os.Stdin, os.Stdout, "> ", loadInputHistory(path))
+	client.editor.onHistory = func(lines []string) {
+		saveInputHistory(path, lines)
+	}
+	client.editor.complete = client.complete
+
+	// 开启括号粘贴, 恢复终端时一起关闭
+	os.Stdout.WriteString(bracketedPasteOn)
+	client.restoreTerm = func() {
+		os.Stdout.WriteString(bracketedPasteOff)
+		restore()
+	}
+
+	// 先要一份在线用户名单, 用于Tab补全
+	client.send("who|json\n")
+}
+
+// 读取用户输入的一行, 标准输入结束时返回"exit"; 粘贴了多行时只取第一行, 见readInput
+func (client *Client) readLine() string {
+	line, _, _ := strings.Cut(client.readInput(), "\n")
+	return line
+}
+
+// 读取用户的一次输入, 终端里粘贴了多行时返回的内容里有换行(见client_paste.go), 标准输入结束时返回"exit"
+// 任何输入都会清空未读提醒, /unread、/stats、/help 和 /whoami 在任何时候都可以使用
+func (client *Client) readInput() string {
+	for {
+		var line string
+		var err error
+		if client.editor != nil {
+			line, err = client.editor.ReadLine()
+		} else {
+			line, err = client.input.ReadString('\n')
+		}
+
+		if err != nil && line == "" {
+			client.inputClosed = true
+			return "exit"
+		}
+		line = strings.TrimRight(line, "\r\n")
+
+		client.clearUnread()
+		if strings.Contains(line, "\n") {
+			return line
+		}
+		if line == "/unread" {
+			client.replayImportant()
+			continue
+		}
+		if line == "/stats" {
+			client.showStats()
+			continue
+		}
+		if line == "/help" {
+			// 命令列表由服务器按当前用户的权限生成, 客户端只补充本地的命令
+			client.send("help\n")
+			client.display("客户端命令: /help /whoami /ping /unread /stats /msg 用户名 内容 /paste /send 用户名 路径 /accept 编号 /decline 编号 /transfers /alias")
+			continue
+		}
+		if line == "/ping" {
+			client.ping()
+			continue
+		}
+		if line == "/whoami" {
+			client.send("whoami\n")
+			continue
+		}
+		if line == "/alias" || strings.HasPrefix(line, "/alias ") {
+			client.aliasCommand(strings.TrimPrefix(line, "/alias"))
+			continue
+		}
+		if line == "/transfers" {
+			client.showTransfers()
+			continue
+		}
+		if args, ok := strings.CutPrefix(line, "/send "); ok {
+			client.sendFile(args)
+			continue
+		}
+		if id, ok := strings.CutPrefix(line, "/accept "); ok {
+			client.acceptFile(strings.TrimSpace(id))
+			continue
+		}
+		if id, ok := strings.CutPrefix(line, "/decline "); ok {
+			client.declineFile(strings.TrimSpace(id))
+			continue
+		}
+		return line
+	}
+}
+
+// 退出前恢复终端设置, 写完本地记录
+func (client *Client) Close() {
+	client.abortTransfers()
+	if client.restoreTerm != nil {
+		client.restoreTerm()
+	}
+	if client.log != nil {
+		client.log.Close()
+	}
+	if client.transcript != nil {
+		client.closeTranscript()
+	}
+	if client.trace != nil {
+		client.trace.Close()
+	}
+}
+
+// 显示消息时的时间前缀
+func formatTimestamp(t time.Time) string {
+	return "[" + t.Format("15:04:05") + "] "
+}
+
+// 给server发送一条消息, 开启本地记录时同时记下来
+func (client *Client) send(msg string) error {
+	client.debugFrame("->", msg)
+	client.writeLock.Lock()
+	err := client.write(client.encodeMessage(msg))
+	client.writeLock.Unlock()
+	if err == nil {
+		atomic.AddUint64(&client.msgsSent, 1)
+	}
+	if err == nil && client.log != nil {
+		client.log.Write(logSent, strings.TrimRight(msg, "\n"))
+	}
+	return err
+}
+
+// 告诉server这条私聊消息已经显示过了, 同一条消息只发一次
+func (client *Client) sendReceipt(seq string) {
+	if client.acked[seq] {
+		return
+	}
+	client.acked[seq] = true
+
+	err := client.send("read|" + seq + "\n")
+	if err != nil {
+		client.sendFailed(err)
+	}
+}
+
+func (client *Client) menu() bool {
+	prompt("1.公聊模式")
+	prompt("2.私聊模式")
+	prompt("3.更新用户名")
+	prompt("0.退出")
+
+	flag, err := strconv.Atoi(strings.TrimSpace(client.readLine()))
+	if client.inputClosed {
+		flag = 0
+	} else if err != nil {
+		flag = -1
+	}
+
+	if flag >= 0 && flag <= 3 {
+		client.flag = flag
+		return true
+	} else {
+		prompt(">>>请输入合法范围内的数字<<<")
+		return false
+	}
+}
+
+// 查询在线用户
+func (client *Client) SelectUsers() {
+	sendMsg := "who\n"
+	err := client.send(sendMsg)
+	if err != nil {
+		client.sendFailed(err)
+		return
+	}
+	// 顺便刷新名单, 选好私聊对象后按连接编号发送
+	client.send("who|json\n")
+}
+
+// 读取私聊对象, 行编辑器开启时可以用Tab补全用户名
+// 名单由之前的SelectUsers刷新
+func (client *Client) readTarget() string {
+	client.pickingTarget = true
+	defer func() { client.pickingTarget = false }()
+
+	return client.readLine()
+}
+
+// 私聊模式
+func (client *Client) PrivateChat() {
+	var remoteName string
+	var chatMsg string
+
+	client.SelectUsers()
+	prompt(">>>>请输入聊天对象[用户名], exit退出:")
+	remoteName = client.readTarget()
+
+	for remoteName != "exit" {
+		// 选好对象后按连接编号发送, 对方中途改名也不会发错人
+		address := client.roster.Address(remoteName)
+
+		prompt(">>>>请输入消息内容, exit退出:")
+		chatMsg = client.readChat()
+
+		for chatMsg != "exit" {
+			// 消息不为空则发送, 只有空白的也不发; 粘贴的多行确认后一行一条发送
+			if strings.Contains(chatMsg, "\n") {
+				if lines := client.confirmPaste(chatMsg); lines != nil {
+					privateLine := func(line string) string { return client.privateLine(address, line) }
+					if err := client.sendPastedLines(privateLine, lines); err != nil {
+						client.sendFailed(err)
+						break
+					}
+				}
+			} else if strings.TrimSpace(chatMsg) != "" {
+				sendMsg := client.privateLine(address, chatMsg)
+				err := client.send(sendMsg)
+				if err != nil {
+					client.sendFailed(err)
+					break
+				}
+			}
+
+			prompt(">>>>请输入消息内容, exit退出:")
+			chatMsg = client.readChat()
+		}
+
+		client.SelectUsers()
+		prompt(">>>>请输入聊天对象[用户名], exit退出:")
+		remoteName = client.readTarget()
+	}
+}
+
+// 公聊模式
+func (client *Client) PublicChat() {
+	// 提示用户输入消息
+	prompt(">>>>请输入聊天内容, exit退出")
+	chatMsg := client.readChat()
+
+	for chatMsg != "exit" {
+		// 粘贴的多行确认后作为一条多行消息发送, 见client_paste.go
+		if strings.Contains(chatMsg, "\n") {
+			if err := client.sendPaste(chatMsg); err != nil {
+				client.sendFailed(err)
+				break
+			}
+			prompt(">>>>请输入聊天内容, exit退出")
+			chatMsg = client.readChat()
+			continue
+		}
+
+		// 发给服务器
+
+		// 消息不为空则发送, 只有空白的也不发, "/msg 用户名 内容" 或 "/to 用户名 内容" 发送私聊
+		// 以 \ 结尾或者输入 /paste 时接着输入多行消息
+		if strings.TrimSpace(chatMsg) != "" {
+			var sendMsg string
+			if to, content, ok := parseMsgCommand(chatMsg); ok {
+				sendMsg = client.privateLine(client.roster.Address(to), content)
+			} else if chatMsg == "/paste" || strings.HasSuffix(chatMsg, `\`) {
+				// 多行消息, 见client_multiline.go
+				if lines := client.readMultiline(chatMsg); len(lines) > 0 {
+					sendMsg = client.multilineMessage(lines)
+				}
+			} else {
+				// 以命令开头的消息加上say|, 见client_say.go; 服务器支持消息ID时带上ID, 见client_outbox.go
+				sendMsg, _ = client.chatLine(chatMsg)
+			}
+			if sendMsg != "" {
+				if err := client.send(sendMsg); err != nil {
+					client.sendFailed(err)
+					break
+				}
+			}
+		}
+
+		prompt(">>>>请输入聊天内容, exit退出")
+		chatMsg = client.readChat()
+	}
+
+	//发送服务器
+
+}
+
+// 解析 "/msg 用户名 内容" 和 "/to 用户名 内容"
+func parseMsgCommand(line string) (string, string, bool) {
+	for _, prefix := range []string{"/msg ", "/to "} {
+		if strings.HasPrefix(line, prefix) {
+			parts := strings.SplitN(strings.TrimSpace(line[len(prefix):]), " ", 2)
+			if len(parts) == 2 && parts[0] != "" && parts[1] != "" {
+				return parts[0], parts[1], true
+			}
+		}
+	}
+	return "", "", false
+}
+
+func (client *Client) UpdateName() bool {
+
+	prompt(">>>>>请输入用户名:")
+	name := client.readLine()
+	if client.inputClosed {
+		return false
+	}
+
+	r, err := client.rename(name)
+	if err != nil {
+		fmt.Println("conn.Write err:", err)
+		return false
+	}
+	if !r.ok {
+		complain(">>>>> 改名失败:", r.reason)
+		return false
+	}
+	fmt.Println(">>>>> 您已经更新用户名:" + client.Name())
+	return true
+}
+func (client *Client) Run() {
+	for client.flag != 0 {
+		for client.menu() != true {
+
+		}
+		// 根据不停的模式处理不同的业务
+		switch client.flag {
+		case 1:
+			// 公聊模式
+			prompt("公聊模式选择...")
+			client.PublicChat()
+			break
+		case 2:
+			// 私聊模式
+			prompt("私聊模式选择...")
+			client.PrivateChat()
+			break
+
+		case 3:
+			// 更新用户名
+			prompt("更新用户名选择...")
+			client.UpdateName()
+			break
+
+		}
+	}
+}
+
+var serverIp string
+var srcerPort int
+var receipts bool
+var timestamps bool
+var logFile string
+var transcriptFile string
+var colorMode string
+var userName string
+var profileName string
+var saveProfileName string
+var listProfiles bool
+var notify bool
+var noRoster bool
+var debugProtocol bool
+var noHandshake bool
+var frameMode string
+var downloadDir string
+var traceFile string
+var compress bool
+var watchdogInterval time.Duration
+var quiet bool
+var sendText string
+
+// 连接后最多等多久在线用户名单
+const joinRosterTimeout = 2 * time.Second
+
+//./client -ip 127.0.0.1 -port 8888
+//./client -profile work
+
+func init() {
+	// flag库起绑定参数的作用, 通俗来说，在命令行输入命令，后面可以带上 -xxx xx 这样的参数。
+	// StringVar定义了一个有指定名字，默认值，和用法说明的string标签。参数p指向一个存储标签解析值的string变量
+	// ip 指定参数名 应用的时候 在命令行输入 -ip xxx
+	// "127.0.0.1" 如果没有指定ip的值，那么Args的内容默认是"127.0.0.1"
+	// "设置服务器IP地址(默认是127.0.0.1)" 用法说明字符串
+	flag.StringVar(&serverIp, "ip", "127.0.0.1", "设置服务器IP地址(默认是127.0.0.1)")
+	flag.IntVar(&srcerPort, "port", 8888, "设置服务器的端口(默认是8888)")
+	flag.BoolVar(&receipts, "receipts", false, "开启私聊已读回执(默认关闭)")
+	flag.BoolVar(&timestamps, "timestamps", false, "显示消息时加上本地收到的时间")
+	flag.StringVar(&logFile, "log", "", "把收到和发出的消息追加记录到这个文件")
+	flag.StringVar(&transcriptFile, "transcript", "", "把收到的消息同时写成一个HTML文件, 可以用浏览器打开, 已有的文件会被覆盖")
+	flag.StringVar(&colorMode, "color", "auto", "彩色输出: never、auto(标准输出是终端并且没有设置NO_COLOR时)、always")
+	flag.StringVar(&userName, "name", "", "连接后使用的用户名")
+	flag.StringVar(&profileName, "profile", "", "使用 ~/.im/config.json 中保存的服务器配置, 命令行参数优先")
+	flag.StringVar(&saveProfileName, "save-profile", "", "把当前的 -ip -port -name 保存为服务器配置")
+	flag.BoolVar(&listProfiles, "profiles", false, "列出保存过的服务器配置")
+	flag.BoolVar(&notify, "notify", false, "收到私聊或提到自己的消息时响铃, 并在输入提示中显示未读数量")
+	flag.BoolVar(&noHandshake, "no-handshake", false, "连接后不等服务器的hello, 连接老版本的服务器时使用")
+	flag.StringVar(&frameMode, "frame", "line", "消息的分帧方式: line(每条一行) 或 len(长度前缀, 需要服务器支持)")
+	flag.BoolVar(&compress, "compress", false, "开启deflate压缩, 适合延迟高、带宽小的网络, 需要服务器支持")
+	flag.BoolVar(&debugProtocol, "debug-protocol", false, "把收发的原始消息打印到标准错误, 排查问题时使用")
+	flag.StringVar(&traceFile, "trace", "", "把连接上收发的每个字节加上时间记到这个文件, - 表示标准错误")
+	flag.StringVar(&downloadDir, "download-dir", defaultDownloadDir, "收到的文件保存在这个目录, 不存在时自动创建")
+	flag.BoolVar(&noRoster, "no-roster", false, "连接后不显示当前在线用户, 适合脚本使用")
+	flag.BoolVar(&quiet, "quiet", false, "安静模式: 不显示菜单和提示, 标准输出上只有收到的消息, 标准输入的每一行作为公聊消息发送, 适合脚本使用")
+	flag.StringVar(&sendText, "send", "", "连接后发送这条公聊消息就退出, 标准输出不是终端时自动开启 -quiet")
+	flag.DurationVar(&watchdogInterval, "watchdog", defaultWatchdog, "这么久没有收到服务器的数据时发ping试探, 2倍时提示连接可能中断, 3倍时断开, 0表示不检查")
+}
+
+func main() {
+	// 命令行解析
+	flag.Parse()
+	if sendText != "" && !stdoutIsTTY() {
+		quiet = true
+	}
+
+	if frameMode != "line" && frameMode != "len" {
+		fmt.Println(">>>>> -frame 只能是 line 或 len")
+		return
+	}
+	if frameMode == "len" && noHandshake {
+		fmt.Println(">>>>> -frame len 需要握手, 不能和 -no-handshake 一起使用")
+		return
+	}
+	if compress && noHandshake {
+		fmt.Println(">>>>> -compress 需要握手, 不能和 -no-handshake 一起使用")
+		return
+	}
+	if colorMode != "never" && colorMode != "auto" && colorMode != "always" {
+		fmt.Println(">>>>> -color 只能是 never、auto 或 always")
+		return
+	}
+
+	configPath := clientConfigPath()
+	if listProfiles {
+		if err := printProfiles(configPath); err != nil {
+			fmt.Println(">>>>> 读取配置失败:", err)
+		}
+		return
+	}
+	if profileName != "" {
+		if err := useProfile(configPath, profileName); err != nil {
+			fmt.Println(">>>>> 读取配置失败:", err)
+			return
+		}
+	}
+	if saveProfileName != "" {
+		if err := saveProfile(configPath, saveProfileName); err != nil {
+			fmt.Println(">>>>> 保存配置失败:", err)
+			return
+		}
+		fmt.Println(">>>>> 已保存配置", saveProfileName)
+	}
+
+	client := NewClient(serverIp, srcerPort)
+	if client == nil {
+		complain(">>>>> 链接服务器失败")
+		os.Exit(exitConnect)
+	}
+
+	if traceFile != "" {
+		trace, err := client.enableTrace(traceFile)
+		if err != nil {
+			complain(">>>>> 打开 -trace 文件失败:", err)
+			return
+		}
+		client.trace = trace
+	}
+	client.timestamps = timestamps
+	if cfg, err := loadClientConfig(configPath); err == nil {
+		client.aliases = cfg.Aliases
+	} else if configPath != "" {
+		complain(">>>>> 读取快捷回复失败:", err)
+	}
+	client.files.dir = downloadDir
+	client.debugProtocol = debugProtocol
+	if !noHandshake && !client.handshake() {
+		complain(">>>>> 目标不是 IM 服务器或协议版本不兼容(连接老版本的服务器时请加 -no-handshake)")
+		os.Exit(exitConnect)
+	}
+	if summary := helloSummary(client.hello); summary != "" {
+		prompt(">>>>> " + summary)
+	}
+	if frameMode == "len" {
+		if !client.serverSupportsFrames() {
+			complain(">>>>> 服务器不支持 -frame len")
+			os.Exit(1)
+		}
+		if err := client.enableFrames(); err != nil {
+			complain("conn.Write err:", err)
+			os.Exit(exitSend)
+		}
+	}
+	if compress {
+		if !client.serverSupportsCompress() {
+			complain(">>>>> 服务器不支持 -compress")
+			os.Exit(1)
+		}
+		if err := client.enableCompress(); err != nil {
+			complain("conn.Write err:", err)
+			os.Exit(exitSend)
+		}
+	}
+	client.notify = notify
+	client.color = colorEnabled(colorMode, stdoutIsTTY(), os.Getenv("NO_COLOR") != "")
+	if logFile != "" {
+		l, err := openMsgLog(logFile)
+		if err != nil {
+			complain(">>>>> 打开记录文件失败:", err)
+			return
+		}
+		client.log = l
+	}
+	if transcriptFile != "" {
+		title := "聊天记录 " + net.JoinHostPort(serverIp, strconv.Itoa(srcerPort))
+		if name := client.hello["name"]; name != "" {
+			title = "聊天记录 " + name
+		}
+		tr, err := transcript.Open(transcriptFile, title+" "+time.Now().Format("2006-01-02 15:04"))
+		if err != nil {
+			complain(">>>>> 打开 -transcript 文件失败:", err)
+			return
+		}
+		client.transcript = tr
+	}
+	client.enableEditor()
+	defer client.Close()
+
+	// 标准输出是管道时, 读的一方退出后写输出返回错误, 由stdoutFailed退出, 不被SIGPIPE直接杀掉
+	signal.Ignore(syscall.SIGPIPE)
+
+	// Ctrl+C 退出时也要恢复终端, 写完本地记录
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	go func() {
+		<-sig
+		client.Close()
+		os.Exit(0)
+	}()
+
+	// 告诉server本客户端支持的能力, 已读回执默认关闭, 只有开启时才发送
+	caps := "rename-reply,errors"
+	if receipts {
+		client.receipts = true
+		caps += ",receipts"
+	}
+	if client.hello["dedupe"] == "msg-id" {
+		client.outbox.enable(time.Now())
+		caps += ",msg-id"
+	}
+	if err := client.send("caps|" + caps + "\n"); err != nil {
+		client.sendFailed(err)
+	}
+
+	// 指定了用户名时连接后直接改名, server确认后才更新用户名, 见handleRenameReply
+	if userName != "" {
+		client.send("rename|" + userName + "\n")
+	}
+
+	// 只发一条消息时不用等别的
+	if sendText != "" {
+		if code := client.sendOnce(sendText); code != 0 {
+			client.Close()
+			os.Exit(code)
+		}
+		return
+	}
+
+	// 连接后先看看谁在线, 要在DealResponse之前设置好, 安静模式下不显示
+	var joinRoster chan struct{}
+	if !noRoster && !quiet {
+		joinRoster = make(chan struct{})
+		client.joinRoster = joinRoster
+		client.send("who|json\n")
+	}
+
+	// 单独开启一个goroutine去处理server的回执消息
+	// 不写在 client.Run()里是因为没有一个方式能Read
+	responseDone := make(chan struct{})
+	go func() {
+		defer close(responseDone)
+		client.DealResponse()
+		client.serverClosed()
+	}()
+
+	// 重发没有确认的消息, 见client_outbox.go
+	if client.outbox.on() {
+		go client.retryOutbox(responseDone)
+	}
+
+	// 检查连接是否还通, 见client_watchdog.go
+	if watchdogInterval > 0 && client.serverSupportsHeartbeat() {
+		go client.watchdog(watchdogInterval, responseDone)
+	}
+
+	prompt(">>>>>链接服务器成功.....")
+
+	// 等名单显示完再显示菜单, server没有回复时不一直等
+	if joinRoster != nil {
+		select {
+		case <-joinRoster:
+		case <-time.After(joinRosterTimeout):
+		}
+	}
+
+	// 安静模式下没有菜单, 标准输入的每一行都作为公聊消息
+	if quiet {
+		client.PublicChat()
+		return
+	}
+
+	// 启动客户端的业务
+	client.Run()
+
+}
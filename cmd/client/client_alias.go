@@ -0,0 +1,172 @@
+// 常用回复的快捷方式: 聊天时输入 !名字 发送保存好的内容, 例如 !brb 发送 "马上回来，请稍等"
+// 内容里可以用 {1} {2} 引用 !名字 后面用空格分开的参数, 最后一个参数包括后面全部的内容
+// 只有整条消息以 ! 开头并且名字保存过时才替换, 其他消息原样发送
+// /alias 或 /alias list 列出全部, /alias add 名字 内容 添加, /alias remove 名字 删除, 保存在 ~/.im/config.json
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 快捷方式的前缀
+const aliasSigil = "!"
+
+// 快捷方式的名字只能是字母、数字、-和_
+var aliasNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,32}$`)
+
+// 内容里引用参数的位置, 例如 {1}
+var aliasArgPattern = regexp.MustCompile(`\{([1-9])\}`)
+
+// 内容里引用的最大的参数序号, 没有引用时返回0
+func aliasArity(template string) int {
+	n := 0
+	for _, m := range aliasArgPattern.FindAllStringSubmatch(template, -1) {
+		if i, _ := strconv.Atoi(m[1]); i > n {
+			n = i
+		}
+	}
+	return n
+}
+
+// 替换一条消息里的快捷方式, 不是 !名字 开头或者名字没有保存过时ok为false, 原样发送
+// 内容里没有引用参数时, 后面的参数接在内容后面; 引用了参数时参数不够返回错误
+func expandAlias(aliases map[string]string, line string) (string, bool, error) {
+	rest, ok := strings.CutPrefix(line, aliasSigil)
+	if !ok {
+		return "", false, nil
+	}
+	name, args, _ := strings.Cut(rest, " ")
+	template, ok := aliases[name]
+	if !ok {
+		return "", false, nil
+	}
+	args = strings.TrimSpace(args)
+
+	n := aliasArity(template)
+	if n == 0 {
+		if args != "" {
+			return template + " " + args, true, nil
+		}
+		return template, true, nil
+	}
+
+	var values []string
+	if args != "" {
+		values = strings.SplitN(args, " ", n)
+	}
+	if len(values) < n {
+		return "", true, fmt.Errorf("!%s 需要 %d 个参数", name, n)
+	}
+	expanded := aliasArgPattern.ReplaceAllStringFunc(template, func(m string) string {
+		i, _ := strconv.Atoi(m[1 : len(m)-1])
+		return strings.TrimSpace(values[i-1])
+	})
+	return expanded, true, nil
+}
+
+// 读取一条要发出的聊天消息, 替换其中的快捷方式; 参数不够时提示后重新读
+func (client *Client) readChat() string {
+	for {
+		line := client.readInput()
+		expanded, ok, err := expandAlias(client.aliases, line)
+		if err != nil {
+			client.display(">>>>> " + err.Error())
+			continue
+		}
+		if ok {
+			return expanded
+		}
+		return line
+	}
+}
+
+// 处理 /alias 命令
+func (client *Client) aliasCommand(args string) {
+	sub, rest, _ := strings.Cut(strings.TrimSpace(args), " ")
+	switch sub {
+	case "", "list":
+		client.listAliases()
+	case "add":
+		name, template, _ := strings.Cut(strings.TrimSpace(rest), " ")
+		if err := client.addAlias(name, strings.TrimSpace(template)); err != nil {
+			client.display(">>>>> " + err.Error())
+			return
+		}
+		client.display(">>>>> 已保存 " + aliasSigil + name)
+	case "remove":
+		name := strings.TrimSpace(rest)
+		if err := client.removeAlias(name); err != nil {
+			client.display(">>>>> " + err.Error())
+			return
+		}
+		client.display(">>>>> 已删除 " + aliasSigil + name)
+	default:
+		client.display(">>>>> 用法: /alias list, /alias add 名字 内容, /alias remove 名字")
+	}
+}
+
+func (client *Client) listAliases() {
+	if len(client.aliases) == 0 {
+		client.display(">>>>> 还没有快捷回复, 可以用 /alias add 名字 内容 添加")
+		return
+	}
+	names := make([]string, 0, len(client.aliases))
+	for name := range client.aliases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		client.display("  " + aliasSigil + name + " → " + client.aliases[name])
+	}
+}
+
+func (client *Client) addAlias(name string, template string) error {
+	if !aliasNamePattern.MatchString(name) {
+		return errors.New("名字只能是1到32个字母、数字、-或_")
+	}
+	if template == "" {
+		return errors.New("用法: /alias add 名字 内容")
+	}
+	return client.saveAliases(func(aliases map[string]string) error {
+		aliases[name] = template
+		return nil
+	})
+}
+
+func (client *Client) removeAlias(name string) error {
+	return client.saveAliases(func(aliases map[string]string) error {
+		if _, ok := aliases[name]; !ok {
+			return fmt.Errorf("没有 %s%s", aliasSigil, name)
+		}
+		delete(aliases, name)
+		return nil
+	})
+}
+
+// 修改配置文件里的快捷回复, 重新读一次文件, 不会覆盖别的客户端保存的服务器配置
+func (client *Client) saveAliases(change func(map[string]string) error) error {
+	path := clientConfigPath()
+	if path == "" {
+		return errors.New("找不到主目录, 不能保存")
+	}
+	cfg, err := loadClientConfig(path)
+	if err != nil {
+		return err
+	}
+	if cfg.Aliases == nil {
+		cfg.Aliases = make(map[string]string)
+	}
+	if err := change(cfg.Aliases); err != nil {
+		return err
+	}
+	if err := saveClientConfig(path, cfg); err != nil {
+		return err
+	}
+	client.aliases = cfg.Aliases
+	return nil
+}
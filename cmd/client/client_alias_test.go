@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bufio"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExpandAlias(t *testing.T) {
+	aliases := map[string]string{
+		"brb":  "马上回来，请稍等",
+		"tk":   "工单 {1} 已转给 {2}",
+		"late": "{2}, 我会晚到{1}分钟",
+	}
+	for _, c := range []struct {
+		line string
+		want string
+		ok   bool
+	}{
+		{"!brb", "马上回来，请稍等", true},
+		{"!brb 五分钟", "马上回来，请稍等 五分钟", true},
+		{"!tk 1024 bob", "工单 1024 已转给 bob", true},
+		{"!tk 1024 bob 和 carol", "工单 1024 已转给 bob 和 carol", true}, // 最后一个参数包括后面全部的内容
+		{"!late 10 大家", "大家, 我会晚到10分钟", true},
+
+		// 不会意外替换
+		{"我 !brb 一下", "", false},
+		{" !brb", "", false},
+		{"!brbx", "", false},
+		{"!BRB", "", false},
+		{"brb", "", false},
+		{"!", "", false},
+	} {
+		got, ok, err := expandAlias(aliases, c.line)
+		if err != nil || ok != c.ok || got != c.want {
+			t.Errorf("expandAlias(%q) = %q, %v, %v", c.line, got, ok, err)
+		}
+	}
+
+	if _, ok, err := expandAlias(aliases, "!tk 1024"); !ok || err == nil || err.Error() != "!tk 需要 2 个参数" {
+		t.Errorf("参数不够: %v, %v", ok, err)
+	}
+}
+
+// 参数不够时提示后重新读, 不会把 !名字 原样发出去
+func TestReadChatAlias(t *testing.T) {
+	client := &Client{
+		aliases: map[string]string{"tk": "工单 {1} 已转给 {2}"},
+		input:   bufio.NewReader(strings.NewReader("!tk 1\n!tk 1 bob\n")),
+	}
+	var got string
+	stdout, _ := captureOutput(t, func() { got = client.readChat() })
+	if got != "工单 1 已转给 bob" || stdout != ">>>>> !tk 需要 2 个参数\n" {
+		t.Fatalf("readChat = %q, stdout = %q", got, stdout)
+	}
+}
+
+// /alias 的修改保存在配置文件里, 保留配置里的其他内容
+func TestAliasPersistence(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	path := filepath.Join(home, ".im", "config.json")
+	if err := saveClientConfig(path, &ClientConfig{Profiles: map[string]Profile{"work": {Server: "10.0.0.1", Port: 9000}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	client := &Client{}
+	stdout, _ := captureOutput(t, func() {
+		client.aliasCommand(" add brb 马上回来，请稍等")
+		client.aliasCommand(" add tk 工单 {1} 已转给 {2}")
+		client.aliasCommand(" add 中文 不行")
+		client.aliasCommand(" add empty")
+		client.aliasCommand(" remove tk")
+		client.aliasCommand(" remove tk")
+		client.aliasCommand("")
+		client.aliasCommand(" rename brb")
+	})
+	want := ">>>>> 已保存 !brb\n" +
+		">>>>> 已保存 !tk\n" +
+		">>>>> 名字只能是1到32个字母、数字、-或_\n" +
+		">>>>> 用法: /alias add 名字 内容\n" +
+		">>>>> 已删除 !tk\n" +
+		">>>>> 没有 !tk\n" +
+		"  !brb → 马上回来，请稍等\n" +
+		">>>>> 用法: /alias list, /alias add 名字 内容, /alias remove 名字\n"
+	if stdout != want {
+		t.Fatalf("stdout = %q", stdout)
+	}
+
+	cfg, err := loadClientConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Aliases) != 1 || cfg.Aliases["brb"] != "马上回来，请稍等" || cfg.Profiles["work"].Server != "10.0.0.1" {
+		t.Fatalf("config = %+v", cfg)
+	}
+}
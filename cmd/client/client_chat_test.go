@@ -0,0 +1,30 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/Achiyun/Golang-IM-System/internal/leakcheck"
+)
+
+// 公聊模式下只有空白的输入不发送
+func TestPublicChatSkipsBlank(t *testing.T) {
+	leakcheck.Check(t)
+	serverSide, clientSide := net.Pipe()
+	defer serverSide.Close()
+	client := &Client{conn: clientSide, input: bufio.NewReader(strings.NewReader("\n \n\t\nhello\nexit\n"))}
+	received := make(chan string)
+	go func() {
+		b, _ := io.ReadAll(serverSide)
+		received <- string(b)
+	}()
+
+	captureOutput(t, client.PublicChat)
+	clientSide.Close()
+	if got := <-received; got != "hello\n" {
+		t.Fatalf("发出了 %q", got)
+	}
+}
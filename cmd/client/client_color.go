@@ -0,0 +1,146 @@
+// 客户端的彩色输出
+package main
+
+import (
+	"hash/fnv"
+	"net"
+	"os"
+	"strings"
+)
+
+// 终端颜色控制码
+const (
+	colorReset   = "\033[0m"
+	colorBold    = "\033[1m"
+	colorSystem  = "\033[33m" // 系统消息: 黄色
+	colorPrivate = "\033[35m" // 私聊消息: 紫色
+)
+
+// 发送方的颜色, 同一个用户名总是得到同一种颜色
+var senderPalette = []string{
+	"\033[31m", // 红
+	"\033[32m", // 绿
+	"\033[34m", // 蓝
+	"\033[36m", // 青
+	"\033[91m", // 亮红
+	"\033[92m", // 亮绿
+	"\033[94m", // 亮蓝
+	"\033[96m", // 亮青
+}
+
+// 私聊消息的前缀, 由服务器加上, 用户发的内容不可能出现在行首
+// 只有这样开头的才是真的私聊, 消息内容里的"对您说:"不算
+const privatePrefix = "[私聊]"
+
+// 系统消息的前缀
+var systemPrefixes = []string{"[系统", "[提醒]", "[Reminder]", "[已读]"}
+
+// 根据 -color 参数决定是否输出颜色
+// auto: 标准输出是终端并且没有设置NO_COLOR环境变量时才输出颜色
+func colorEnabled(mode string, isTTY bool, noColor bool) bool {
+	switch mode {
+	case "always":
+		return true
+	case "never":
+		return false
+	default:
+		return isTTY && !noColor
+	}
+}
+
+// 标准输出是不是终端
+func stdoutIsTTY() bool {
+	info, err := os.Stdout.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// 按用户名的哈希从调色板中选一种颜色
+func senderColor(name string) string {
+	return senderPalette[senderIndex(name)]
+}
+
+// 用户名在调色板中的位置, HTML聊天记录也用它选颜色, 见client_transcript.go
+func senderIndex(name string) int {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	return int(h.Sum32() % uint32(len(senderPalette)))
+}
+
+// 给一行消息加上颜色, myName是自己的用户名, 消息内容中提到它时加粗
+func colorize(line string, myName string) string {
+	for _, prefix := range systemPrefixes {
+		if strings.HasPrefix(line, prefix) {
+			return colorSystem + line + colorReset
+		}
+	}
+
+	// 私聊消息, 格式: [私聊]用户名对您说:消息内容
+	if strings.HasPrefix(line, privatePrefix) {
+		if i := strings.Index(line, "对您说:"); i > 0 {
+			return colorPrivate + line[:i+len("对您说:")] + colorReset + highlight(line[i+len("对您说:"):], myName)
+		}
+		return colorPrivate + line + colorReset
+	}
+
+	// 公聊消息, 格式: [地址]用户名:消息内容
+	if head, name, text, ok := splitPublic(line); ok {
+		return head + senderColor(name) + name + colorReset + ":" + highlight(text, myName)
+	}
+
+	return line
+}
+
+// 把消息内容中提到的自己的用户名加粗
+func highlight(text string, myName string) string {
+	if myName == "" {
+		return text
+	}
+	return strings.ReplaceAll(text, myName, colorBold+myName+colorReset)
+}
+
+// 拆开一条公聊消息, 格式: [地址]用户名:消息内容, 不是公聊消息时返回false
+// 服务器默认不公开地址, []里是 #连接编号, 开启 -show-addr 时是地址
+func splitPublic(line string) (head string, name string, text string, ok bool) {
+	if !strings.HasPrefix(line, "[") {
+		return "", "", "", false
+	}
+	end := strings.Index(line, "]")
+	if end < 0 {
+		return "", "", "", false
+	}
+	addr := line[1:end]
+	rest := line[end+1:]
+
+	// 没改过名的用户名就是地址, 地址里本身带有':'
+	nameLen := strings.Index(rest, ":")
+	if strings.HasPrefix(rest, addr+":") {
+		nameLen = len(addr)
+	} else if n := hostPortLen(rest); n > 0 {
+		nameLen = n
+	}
+	if nameLen <= 0 {
+		return "", "", "", false
+	}
+	return line[:end+1], rest[:nameLen], rest[nameLen+1:], true
+}
+
+// rest以 IP:端口: 开头时返回 IP:端口 的长度, 否则返回0
+// []里是连接编号时, 用这个认出没改过名的用户
+func hostPortLen(rest string) int {
+	i := strings.Index(rest, ":")
+	if i < 0 {
+		return 0
+	}
+	j := strings.Index(rest[i+1:], ":")
+	if j < 0 {
+		return 0
+	}
+	host, _, err := net.SplitHostPort(rest[:i+1+j])
+	if err != nil || net.ParseIP(host) == nil {
+		return 0
+	}
+	return i + 1 + j
+}
@@ -0,0 +1,45 @@
+package main
+
+import "testing"
+
+func TestColorEnabled(t *testing.T) {
+	cases := []struct {
+		mode           string
+		isTTY, noColor bool
+		want           bool
+	}{
+		{"always", false, true, true},
+		{"never", true, false, false},
+		{"auto", true, false, true},
+		{"auto", false, false, false},
+		{"auto", true, true, false},
+	}
+	for _, c := range cases {
+		if got := colorEnabled(c.mode, c.isTTY, c.noColor); got != c.want {
+			t.Errorf("colorEnabled(%q, tty=%v, NO_COLOR=%v) = %v", c.mode, c.isTTY, c.noColor, got)
+		}
+	}
+}
+
+func TestColorize(t *testing.T) {
+	bob := senderColor("bob")
+	if senderColor("bob") != bob {
+		t.Fatal("同一个用户名的颜色不固定")
+	}
+	guest := senderColor("127.0.0.1:5000")
+	cases := []struct{ line, want string }{
+		{"[系统] 维护中", colorSystem + "[系统] 维护中" + colorReset},
+		{"[提醒] 开会", colorSystem + "[提醒] 开会" + colorReset},
+		{"[私聊]bob对您说:hi alice", colorPrivate + "[私聊]bob对您说:" + colorReset + "hi " + colorBold + "alice" + colorReset},
+		{"[#2]bob:a:b", "[#2]" + bob + "bob" + colorReset + ":a:b"},
+		{"[#3]127.0.0.1:5000:hi", "[#3]" + guest + "127.0.0.1:5000" + colorReset + ":hi"},
+		// 消息内容里的"对您说:"不是私聊
+		{"bob对您说:hi", "bob对您说:hi"},
+		{"随便一行", "随便一行"},
+	}
+	for _, c := range cases {
+		if got := colorize(c.line, "alice"); got != c.want {
+			t.Errorf("colorize(%q) = %q, want %q", c.line, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+// 客户端的在线用户名单和Tab补全
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 名单超过这个时间没有刷新, 按Tab时顺便向server要一份新的
+const rosterMaxAge = 10 * time.Second
+
+// 本地保存的在线用户名单, 来自server对 who|json 或 wholist 的回复
+type Roster struct {
+	lock    sync.RWMutex
+	names   []string
+	ids     map[string]uint64 // 用户名对应的连接编号, 只有 who|json 的回复里有
+	updated time.Time
+}
+
+// 解析 wholist| 消息, 不是名单消息时返回false
+func parseWholist(line string) ([]string, bool) {
+	if !strings.HasPrefix(line, "wholist|") {
+		return nil, false
+	}
+
+	var names []string
+	for _, name := range strings.Split(line[len("wholist|"):], ",") {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, true
+}
+
+// 解析 who|json 的回复, 一行JSON数组, 不是名单消息时返回false
+// 公聊消息以 "[地址]" 开头, 地址不会以 '{' 开头, 不会被误认为名单
+// 同时返回用户名对应的连接编号, 其他实例上的用户没有编号
+func parseWhoJSON(line string) ([]string, map[string]uint64, bool) {
+	if line != "[]" && !strings.HasPrefix(line, "[{") {
+		return nil, nil, false
+	}
+
+	var list []struct {
+		Session uint64 `json:"session"`
+		Name    string `json:"name"`
+	}
+	if err := json.Unmarshal([]byte(line), &list); err != nil {
+		return nil, nil, false
+	}
+	names := make([]string, 0, len(list))
+	ids := make(map[string]uint64)
+	for _, user := range list {
+		names = append(names, user.Name)
+		if user.Session != 0 {
+			ids[user.Name] = user.Session
+		}
+	}
+	sort.Strings(names)
+	return names, ids, true
+}
+
+// 更新名单, ids为nil时表示不知道连接编号
+func (this *Roster) Set(names []string, ids map[string]uint64) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	this.names = names
+	this.ids = ids
+	this.updated = time.Now()
+}
+
+// 私聊时使用的地址: 名单里知道连接编号时用 #编号, 对方在这之后改名也能收到
+// 名单太旧时编号可能已经失效, 这时还是按用户名发送
+func (this *Roster) Address(name string) string {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+
+	if id, ok := this.ids[name]; ok && time.Since(this.updated) <= rosterMaxAge {
+		return "#" + strconv.FormatUint(id, 10)
+	}
+	return name
+}
+
+func (this *Roster) Names() []string {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+
+	return this.names
+}
+
+// 名单是不是太久没有刷新了
+func (this *Roster) Stale() bool {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+
+	return time.Since(this.updated) > rosterMaxAge
+}
+
+// 找出以prefix开头的用户名, 不区分大小写, 按用户名排序
+func matchNames(names []string, prefix string) []string {
+	lower := strings.ToLower(prefix)
+
+	var matches []string
+	for _, name := range names {
+		if strings.HasPrefix(strings.ToLower(name), lower) {
+			matches = append(matches, name)
+		}
+	}
+	return matches
+}
+
+// 找出光标前正在输入的用户名, 返回它的起始位置
+// 三种情况需要补全: 选择私聊对象时的整行、"/msg "和"/to "后面的第一个词、消息里以@开头的词
+func completionStart(buf []rune, pos int, wholeLine bool) (int, bool) {
+	if wholeLine {
+		return 0, true
+	}
+
+	start := pos
+	for start > 0 && buf[start-1] != ' ' {
+		start--
+	}
+
+	if start < pos && buf[start] == '@' {
+		return start + 1, true
+	}
+
+	before := string(buf[:start])
+	if before == "/msg " || before == "/to " {
+		return start, true
+	}
+	return 0, false
+}
+
+// 按Tab时的补全逻辑, 返回要替换的起始位置和全部候选用户名
+func (client *Client) complete(buf []rune, pos int) (int, []string) {
+	if client.roster.Stale() {
+		client.send("who|json\n")
+	}
+
+	start, ok := completionStart(buf, pos, client.pickingTarget)
+	if !ok {
+		return 0, nil
+	}
+	return start, matchNames(client.roster.Names(), string(buf[start:pos]))
+}
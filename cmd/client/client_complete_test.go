@@ -0,0 +1,60 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestParseRoster(t *testing.T) {
+	names, ok := parseWholist("wholist|carol,,alice,bob")
+	if !ok || strings.Join(names, ",") != "alice,bob,carol" {
+		t.Fatalf("wholist = %v %v", names, ok)
+	}
+	if _, ok := parseWholist("[#1]bob:wholist|x"); ok {
+		t.Fatal("公聊消息被当成了名单")
+	}
+
+	names, ids, ok := parseWhoJSON(`[{"session":2,"name":"bob"},{"name":"alice"}]`)
+	if !ok || strings.Join(names, ",") != "alice,bob" || ids["bob"] != 2 || len(ids) != 1 {
+		t.Fatalf("who|json = %v %v %v", names, ids, ok)
+	}
+	if _, _, ok := parseWhoJSON("[#1]bob:[{"); ok {
+		t.Fatal("公聊消息被当成了名单")
+	}
+
+	var roster Roster
+	roster.Set([]string{"bob"}, map[string]uint64{"bob": 2})
+	if roster.Stale() || roster.Address("bob") != "#2" || roster.Address("carol") != "carol" {
+		t.Fatal("名单刚刚刷新过, 应该按连接编号私聊")
+	}
+}
+
+// 在输入框里按Tab, 只在私聊对象、/msg和/to后面、@开头的词里补全用户名
+func TestTabComplete(t *testing.T) {
+	client := &Client{}
+	client.roster.Set([]string{"Alice", "alan", "bob"}, nil)
+
+	for _, c := range []struct {
+		keys    string
+		picking bool
+		want    string
+	}{
+		{"/msg b\t hi\r", false, "/msg bob hi"},
+		{"/to al\t\t\r", false, "/to alan"}, // 多个候选时连续按Tab切换
+		{"hi @b\t!\r", false, "hi @bob!"},
+		{"hi b\t\r", false, "hi b"},
+		{"b\t\r", true, "bob"},
+	} {
+		client.pickingTarget = c.picking
+		editor := NewLineEditor(strings.NewReader(c.keys), io.Discard, "> ", nil)
+		editor.complete = client.complete
+		line, err := editor.ReadLine()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if line != c.want {
+			t.Errorf("keys %q = %q, want %q", c.keys, line, c.want)
+		}
+	}
+}
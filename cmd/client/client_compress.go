@@ -0,0 +1,67 @@
+// deflate压缩: -compress 时连接后发 compress|deflate, 之后发出的数据都压缩, 每条消息Flush一次
+// server先不压缩地回复 compress-ok|deflate, 读到这一条之后改成解压着读; 格式见server的compress.go
+package main
+
+import (
+	"bufio"
+	"compress/flate"
+	"sync/atomic"
+)
+
+const compressDeflate = "deflate"
+
+// 压缩的写入, 在client.writeLock里使用
+type compressWriter struct {
+	zw *flate.Writer
+}
+
+func (this *compressWriter) Write(b []byte) (int, error) {
+	n, err := this.zw.Write(b)
+	if err == nil {
+		err = this.zw.Flush()
+	}
+	return n, err
+}
+
+// server是否支持压缩, 见hello里的 compress=deflate
+func (client *Client) serverSupportsCompress() bool {
+	return client.hello["compress"] == compressDeflate
+}
+
+// 把编码好的数据写到连接上, 开启压缩后压缩并Flush; 调用时需要持有writeLock
+func (client *Client) write(b []byte) error {
+	var err error
+	if client.compress != nil {
+		_, err = client.compress.Write(b)
+	} else {
+		_, err = client.conn.Write(b)
+	}
+	return err
+}
+
+// 请求开启压缩, 之后发送的数据都压缩; server处理完这一条就解压着读, 不用等回复
+// 请求和切换在writeLock里一起完成, 别的goroutine发的消息不会夹在中间, 也不会没压缩就发出去
+func (client *Client) enableCompress() error {
+	msg := "compress|" + compressDeflate + "\n"
+	client.debugFrame("->", msg)
+
+	client.writeLock.Lock()
+	defer client.writeLock.Unlock()
+	if err := client.write(client.encodeMessage(msg)); err != nil {
+		return err
+	}
+	atomic.AddUint64(&client.msgsSent, 1)
+	// 跟server一样用BestSpeed, 交互的消息很短, 压缩率差不多
+	zw, _ := flate.NewWriter(client.conn, flate.BestSpeed)
+	client.compress = &compressWriter{zw: zw}
+	return nil
+}
+
+// 读到 compress-ok|deflate 时换成解压着读, 只在dealLines里调用; 返回这一条是不是切换的回复
+func (client *Client) switchInflate(msg string) bool {
+	if msg != "compress-ok|"+compressDeflate+"\n" {
+		return false
+	}
+	client.reader = bufio.NewReader(flate.NewReader(client.reader))
+	return true
+}
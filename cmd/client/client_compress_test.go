@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bufio"
+	"compress/flate"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Achiyun/Golang-IM-System/internal/leakcheck"
+)
+
+// 开启压缩时别的goroutine还在发消息: 每条要么在请求之前不压缩地发出, 要么在请求之后压缩着发出, 不会混在一起
+func TestEnableCompressWhileSending(t *testing.T) {
+	leakcheck.Check(t)
+	serverSide, clientSide := net.Pipe()
+	defer serverSide.Close()
+	defer clientSide.Close()
+	client := &Client{conn: clientSide}
+
+	const senders, each = 4, 50
+	got := make(chan []string, 1)
+	go func() {
+		var lines []string
+		reader := bufio.NewReader(serverSide)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				got <- lines
+				return
+			}
+			if line == "compress|"+compressDeflate+"\n" {
+				break
+			}
+			lines = append(lines, line)
+		}
+		scanner := bufio.NewScanner(flate.NewReader(reader))
+		for len(lines) < senders*each && scanner.Scan() {
+			lines = append(lines, scanner.Text()+"\n")
+		}
+		got <- lines
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < senders; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < each; j++ {
+				if err := client.send("msg-" + strconv.Itoa(i) + "-" + strconv.Itoa(j) + "\n"); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(i)
+	}
+	if err := client.enableCompress(); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+
+	lines := <-got
+	if len(lines) != senders*each {
+		t.Fatalf("收到 %d 条, 要 %d 条", len(lines), senders*each)
+	}
+	for _, line := range lines {
+		if !strings.HasPrefix(line, "msg-") || strings.Count(line, "\n") != 1 {
+			t.Fatalf("收到的消息不完整: %q", line)
+		}
+	}
+}
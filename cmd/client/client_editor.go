@@ -0,0 +1,436 @@
+// 客户端的行编辑器: 光标移动、删除、Ctrl-U/Ctrl-W 和上下键翻历史
+// 按键由一个goroutine读出来放进channel; 方向键等ESC开头的序列在锁外面读, 等后面的字符最多escapeTimeout
+// 读序列时收到的消息照常显示, 单独按一下ESC也不会把输出卡住
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// 最多保留多少条输入历史
+const maxInputHistory = 100
+
+// ESC后面的字符最多等多久, 终端发的序列是一起到的, 超过这个时间就是单独按了ESC
+const escapeTimeout = 100 * time.Millisecond
+
+var errEscapeTimeout = errors.New("escape sequence timeout")
+
+// 读到的一个按键
+type keyRead struct {
+	r   rune
+	err error
+}
+
+// 行编辑器用到的按键
+const (
+	keyCtrlA     = 0x01
+	keyCtrlD     = 0x04
+	keyCtrlE     = 0x05
+	keyBackspace = 0x7f
+	keyCtrlH     = 0x08
+	keyTab       = 0x09
+	keyCtrlU     = 0x15
+	keyCtrlW     = 0x17
+	keyEsc       = 0x1b
+)
+
+// 行编辑器, 输入需要是关闭了回显和行缓冲的终端
+type LineEditor struct {
+	in      *bufio.Reader
+	out     io.Writer
+	keys    chan keyRead // readKeys读出来的按键
+	keysOn  sync.Once
+	readErr error // 输入已经结束, 只在ReadLine里使用
+
+	lock   sync.Mutex // 保护下面的编辑状态和输出, 收到的消息和正在编辑的行共用一个终端
+	prompt string
+	buf    []rune
+	pos    int  // 光标在buf中的位置
+	active bool // 是否正在读取一行
+
+	history []string
+	histPos int    // 翻历史时的位置, 等于len(history)表示正在编辑新的一行
+	draft   string // 开始翻历史前正在编辑的内容
+
+	// 每输入一行后调用, 用来保存历史
+	onHistory func([]string)
+
+	// 按Tab时调用, 返回要替换的起始位置和候选内容, 连续按Tab依次换成下一个候选
+	complete  func(buf []rune, pos int) (int, []string)
+	tabCands  []string
+	tabIdx    int
+	tabStart  int
+	tabActive bool // 上一个按键是不是Tab
+}
+
+func NewLineEditor(in io.Reader, out io.Writer, prompt string, history []string) *LineEditor {
+	if len(history) > maxInputHistory {
+		history = history[len(history)-maxInputHistory:]
+	}
+	return &LineEditor{
+		in:      bufio.NewReader(in),
+		out:     out,
+		keys:    make(chan keyRead),
+		prompt:  prompt,
+		history: history,
+	}
+}
+
+// 读取一行输入, 输入结束时返回io.EOF
+func (this *LineEditor) ReadLine() (string, error) {
+	this.lock.Lock()
+	this.buf = this.buf[:0]
+	this.pos = 0
+	this.histPos = len(this.history)
+	this.active = true
+	this.redraw()
+	this.lock.Unlock()
+
+	for {
+		r, err := this.next()
+		if err != nil {
+			this.lock.Lock()
+			this.active = false
+			this.lock.Unlock()
+			return "", err
+		}
+
+		var line string
+		var done, eof bool
+		if r == keyEsc {
+			// 在锁外面读完整个序列, 再拿锁处理
+			seq, paste := this.readEscape()
+			this.lock.Lock()
+			line, done = this.handleEscape(seq, paste)
+			this.lock.Unlock()
+		} else {
+			this.lock.Lock()
+			line, done, eof = this.handleKey(r)
+			this.lock.Unlock()
+		}
+
+		if eof {
+			return "", io.EOF
+		}
+		if done {
+			return line, nil
+		}
+	}
+}
+
+// 处理一个按键, 调用时需要持有lock
+// 回车时返回输入的一行, 空行上按Ctrl-D表示输入结束
+func (this *LineEditor) handleKey(r rune) (line string, done bool, eof bool) {
+	if r != keyTab {
+		this.tabActive = false
+	}
+
+	switch r {
+	case '\r', '\n':
+		line = string(this.buf)
+		this.active = false
+		io.WriteString(this.out, "\r\n")
+		this.addHistory(line)
+		return line, true, false
+
+	case keyCtrlD:
+		if len(this.buf) == 0 {
+			this.active = false
+			io.WriteString(this.out, "\r\n")
+			return "", false, true
+		}
+		this.deleteAt(this.pos)
+
+	case keyBackspace, keyCtrlH:
+		if this.pos > 0 {
+			this.pos--
+			this.deleteAt(this.pos)
+		}
+
+	case keyCtrlU:
+		// 删除光标前的全部内容
+		this.buf = append(this.buf[:0], this.buf[this.pos:]...)
+		this.pos = 0
+
+	case keyCtrlW:
+		// 删除光标前的一个词
+		start := this.pos
+		for start > 0 && unicode.IsSpace(this.buf[start-1]) {
+			start--
+		}
+		for start > 0 && !unicode.IsSpace(this.buf[start-1]) {
+			start--
+		}
+		this.buf = append(this.buf[:start], this.buf[this.pos:]...)
+		this.pos = start
+
+	case keyCtrlA:
+		this.pos = 0
+
+	case keyCtrlE:
+		this.pos = len(this.buf)
+
+	case keyTab:
+		this.handleTab()
+
+	default:
+		if unicode.IsPrint(r) {
+			this.buf = append(this.buf, 0)
+			copy(this.buf[this.pos+1:], this.buf[this.pos:])
+			this.buf[this.pos] = r
+			this.pos++
+		}
+	}
+
+	this.redraw()
+	return "", false, false
+}
+
+// 在后台读按键, 输入结束后退出
+func (this *LineEditor) readKeys() {
+	for {
+		r, _, err := this.in.ReadRune()
+		this.keys <- keyRead{r: r, err: err}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// 读下一个按键, 一直等到有输入; 只在ReadLine里调用
+func (this *LineEditor) next() (rune, error) {
+	return this.nextWithin(0)
+}
+
+// 读下一个按键, timeout大于0时最多等这么久, 超时返回errEscapeTimeout, 这个按键留给下一次读
+func (this *LineEditor) nextWithin(timeout time.Duration) (rune, error) {
+	if this.readErr != nil {
+		return 0, this.readErr
+	}
+	this.keysOn.Do(func() { go this.readKeys() })
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case k := <-this.keys:
+		this.readErr = k.err
+		return k.r, k.err
+	case <-expired:
+		return 0, errEscapeTimeout
+	}
+}
+
+// 读ESC后面的序列, 不用持有lock: 返回 ESC [ 后面的部分, 例如 "A" 或 "3~"; 是粘贴开始标记 ESC [ 200 ~ 时读完并返回粘贴的内容
+// 不认识的序列或者等不到后面的字符时返回空字符串
+func (this *LineEditor) readEscape() (seq string, paste string) {
+	if r, err := this.nextWithin(escapeTimeout); err != nil || r != '[' {
+		return "", ""
+	}
+	r, err := this.nextWithin(escapeTimeout)
+	if err != nil {
+		return "", ""
+	}
+
+	switch r {
+	case 'A', 'B', 'C', 'D', 'H', 'F':
+		return string(r), ""
+	case '3': // Delete, 完整序列是 ESC [ 3 ~
+		if r, err := this.nextWithin(escapeTimeout); err == nil && r == '~' {
+			return "3~", ""
+		}
+	case '2': // 粘贴开始, 完整序列是 ESC [ 2 0 0 ~
+		rest := make([]rune, 0, 3)
+		for len(rest) < 3 {
+			r, err := this.nextWithin(escapeTimeout)
+			if err != nil {
+				return "", ""
+			}
+			rest = append(rest, r)
+		}
+		if string(rest) == "00~" {
+			return "200~", this.readPaste()
+		}
+	}
+	return "", ""
+}
+
+// 处理readEscape读到的序列, 调用时需要持有lock; 粘贴了多行时返回整段内容, 作为输入的一行
+func (this *LineEditor) handleEscape(seq string, paste string) (string, bool) {
+	this.tabActive = false
+
+	switch seq {
+	case "A": // 上
+		this.historyMove(-1)
+	case "B": // 下
+		this.historyMove(1)
+	case "C": // 右
+		if this.pos < len(this.buf) {
+			this.pos++
+		}
+	case "D": // 左
+		if this.pos > 0 {
+			this.pos--
+		}
+	case "H":
+		this.pos = 0
+	case "F":
+		this.pos = len(this.buf)
+	case "3~":
+		this.deleteAt(this.pos)
+	case "200~":
+		if this.insertPaste(paste) {
+			// 粘贴了多行, 整段作为输入的内容, 见client_paste.go
+			return string(this.buf), true
+		}
+	}
+	this.redraw()
+	return "", false
+}
+
+// Tab补全, 只有一个候选时直接补全, 有多个候选时连续按Tab依次切换
+func (this *LineEditor) handleTab() {
+	if this.complete == nil {
+		return
+	}
+
+	if this.tabActive && len(this.tabCands) > 1 {
+		this.tabIdx = (this.tabIdx + 1) % len(this.tabCands)
+	} else {
+		start, cands := this.complete(this.buf, this.pos)
+		if len(cands) == 0 {
+			return
+		}
+		this.tabCands = cands
+		this.tabIdx = 0
+		this.tabStart = start
+		this.tabActive = true
+	}
+
+	cand := []rune(this.tabCands[this.tabIdx])
+	rest := append([]rune(nil), this.buf[this.pos:]...)
+	this.buf = append(append(this.buf[:this.tabStart], cand...), rest...)
+	this.pos = this.tabStart + len(cand)
+}
+
+// 删除光标处的一个字符
+func (this *LineEditor) deleteAt(i int) {
+	if i < len(this.buf) {
+		this.buf = append(this.buf[:i], this.buf[i+1:]...)
+	}
+}
+
+// 上下翻历史, step为-1是更早的一条
+func (this *LineEditor) historyMove(step int) {
+	n := this.histPos + step
+	if n < 0 || n > len(this.history) {
+		return
+	}
+	if this.histPos == len(this.history) {
+		this.draft = string(this.buf)
+	}
+	this.histPos = n
+
+	if n == len(this.history) {
+		this.buf = []rune(this.draft)
+	} else {
+		this.buf = []rune(this.history[n])
+	}
+	this.pos = len(this.buf)
+}
+
+// 记录一条输入历史, 空行和跟上一条相同的不记录
+func (this *LineEditor) addHistory(line string) {
+	if strings.TrimSpace(line) == "" {
+		return
+	}
+	if len(this.history) > 0 && this.history[len(this.history)-1] == line {
+		return
+	}
+
+	this.history = append(this.history, line)
+	if len(this.history) > maxInputHistory {
+		this.history = this.history[len(this.history)-maxInputHistory:]
+	}
+	if this.onHistory != nil {
+		this.onHistory(append([]string(nil), this.history...))
+	}
+}
+
+// 重新显示正在编辑的一行, 并把光标放回原来的位置, 调用时需要持有lock
+func (this *LineEditor) redraw() {
+	var sb strings.Builder
+	sb.WriteString("\r\033[K")
+	sb.WriteString(this.prompt)
+	sb.WriteString(string(this.buf))
+	if back := textWidth(this.buf[this.pos:]); back > 0 {
+		sb.WriteString("\033[" + strconv.Itoa(back) + "D")
+	}
+	io.WriteString(this.out, sb.String())
+}
+
+// 在正在编辑的一行上方显示一条消息, 不打乱已经输入的内容; 返回写输出时的错误
+func (this *LineEditor) Print(text string) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if !this.active {
+		_, err := io.WriteString(this.out, text+"\r\n")
+		return err
+	}
+	_, err := io.WriteString(this.out, "\r\033[K"+text+"\r\n")
+	this.redraw()
+	return err
+}
+
+// 修改输入提示, 正在输入时立即重新显示
+func (this *LineEditor) SetPrompt(prompt string) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	this.prompt = prompt
+	if this.active {
+		this.redraw()
+	}
+}
+
+// 终端响铃
+func (this *LineEditor) Bell() {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	io.WriteString(this.out, "\a")
+}
+
+// 终端上的显示宽度, 中文等宽字符占两列
+func textWidth(rs []rune) int {
+	w := 0
+	for _, r := range rs {
+		if isWide(r) {
+			w += 2
+		} else {
+			w++
+		}
+	}
+	return w
+}
+
+func isWide(r rune) bool {
+	return r >= 0x1100 && (r <= 0x115f ||
+		(r >= 0x2e80 && r <= 0xa4cf) ||
+		(r >= 0xac00 && r <= 0xd7a3) ||
+		(r >= 0xf900 && r <= 0xfaff) ||
+		(r >= 0xfe30 && r <= 0xfe4f) ||
+		(r >= 0xff00 && r <= 0xff60) ||
+		(r >= 0xffe0 && r <= 0xffe6))
+}
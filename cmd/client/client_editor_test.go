@@ -0,0 +1,101 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 把按键喂给编辑器, 读出一行
+func editLine(t *testing.T, keys string, history []string) (string, *LineEditor) {
+	t.Helper()
+	editor := NewLineEditor(strings.NewReader(keys), io.Discard, "> ", history)
+	line, err := editor.ReadLine()
+	if err != nil {
+		t.Fatalf("ReadLine(%q): %v", keys, err)
+	}
+	return line, editor
+}
+
+func TestEditorKeys(t *testing.T) {
+	for _, c := range []struct {
+		keys string
+		want string
+	}{
+		{"hello\r", "hello"},
+		{"helo\x1b[Dl\r", "hello"},                   // 左移一格再输入
+		{"abc\x7f\x7fd\r", "ad"},                     // 退格
+		{"abc\x1b[H\x1b[3~\r", "bc"},                 // Home 再 Delete
+		{"abc\x01x\x05y\r", "xabcy"},                 // Ctrl-A Ctrl-E
+		{"one two\x17three\r", "one three"},          // Ctrl-W 删掉一个词
+		{"one two\x1b[D\x1b[D\x15\r", "wo"},          // Ctrl-U 删掉光标前的全部
+		{"ab\x1b[Xc\r", "abc"},                       // 不认识的序列丢掉
+		{"ab\x1b[200~pasted\x1b[201~\r", "abpasted"}, // 粘贴一行跟输入一样
+	} {
+		if line, _ := editLine(t, c.keys, nil); line != c.want {
+			t.Errorf("keys %q = %q, want %q", c.keys, line, c.want)
+		}
+	}
+}
+
+func TestEditorPasteLines(t *testing.T) {
+	line, _ := editLine(t, "\x1b[200~one\r\ntwo\x1b[201~", nil)
+	if line != "one\ntwo" {
+		t.Fatalf("paste = %q", line)
+	}
+}
+
+func TestEditorHistory(t *testing.T) {
+	line, editor := editLine(t, "\x1b[A\x1b[A\r", []string{"first", "second"})
+	if line != "first" {
+		t.Fatalf("up up = %q", line)
+	}
+	// 翻回来时恢复正在编辑的内容
+	if line, _ := editLine(t, "draft\x1b[A\x1b[B\r", editor.history); line != "draft" {
+		t.Fatalf("up down = %q", line)
+	}
+}
+
+func TestEditorCtrlDEOF(t *testing.T) {
+	editor := NewLineEditor(strings.NewReader("\x04"), io.Discard, "> ", nil)
+	if _, err := editor.ReadLine(); err != io.EOF {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+// 单独按了ESC, 等后面的字符时收到的消息照常显示
+func TestEditorEscapeDoesNotBlockPrint(t *testing.T) {
+	in, keys := io.Pipe()
+	defer keys.Close()
+	editor := NewLineEditor(in, io.Discard, "> ", nil)
+	result := make(chan string, 1)
+	go func() {
+		line, _ := editor.ReadLine()
+		result <- line
+	}()
+
+	keys.Write([]byte{keyEsc})
+	printed := make(chan struct{})
+	go func() {
+		editor.Print("incoming")
+		close(printed)
+	}()
+	select {
+	case <-printed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Print在等ESC后面的字符时卡住了")
+	}
+
+	// 超时以后ESC丢掉, 后面的按键照常输入
+	time.Sleep(2 * escapeTimeout)
+	keys.Write([]byte("ok\r"))
+	select {
+	case line := <-result:
+		if line != "ok" {
+			t.Fatalf("line = %q", line)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("ReadLine没有返回")
+	}
+}
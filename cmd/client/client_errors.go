@@ -0,0 +1,91 @@
+// server的错误回复: 声明了 errors 能力后收到 err|编号|提示文字, 见server的errors.go
+// 客户端按编号判断是什么错误, 显示的还是提示文字
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// 错误编号对应的名字, 老版本的server没有在hello里发错误表时用; 不认识的编号也照样显示提示文字
+var serverErrorNames = map[int]string{
+	100: "ERR_USAGE",
+	101: "ERR_EMPTY_MESSAGE",
+	102: "ERR_TOO_LARGE",
+	103: "ERR_BAD_DATA",
+	104: "ERR_NO_SUCH_USER",
+	105: "ERR_NAME_TAKEN",
+	106: "ERR_BAD_NAME",
+	107: "ERR_PERMISSION",
+	108: "ERR_RATE_LIMITED",
+	109: "ERR_LOCKED_DOWN",
+	110: "ERR_NOT_ALLOWED",
+	111: "ERR_NOT_FOUND",
+	112: "ERR_EXPIRED",
+	113: "ERR_ALREADY",
+	114: "ERR_UNAVAILABLE",
+	115: "ERR_USER_OFFLINE",
+}
+
+// server回复的一个错误
+type serverError struct {
+	Code int
+	Text string
+}
+
+func (this serverError) Error() string {
+	return this.Text
+}
+
+// 错误的名字, 不认识的编号返回 ERR_编号
+func (this serverError) Name() string {
+	if name, ok := serverErrorNames[this.Code]; ok {
+		return name
+	}
+	return "ERR_" + strconv.Itoa(this.Code)
+}
+
+// 解析hello里的 errors=编号:名字,编号:名字; 格式不对的项跳过
+func parseErrorTable(s string) map[int]string {
+	names := make(map[int]string)
+	for _, entry := range strings.Split(s, ",") {
+		num, name, ok := strings.Cut(entry, ":")
+		code, err := strconv.Atoi(num)
+		if ok && err == nil && name != "" {
+			names[code] = name
+		}
+	}
+	return names
+}
+
+// 错误的名字, 优先用server在hello里发来的错误表
+func (client *Client) errorName(e serverError) string {
+	if name, ok := client.errorNames[e.Code]; ok {
+		return name
+	}
+	return e.Name()
+}
+
+// 解析 err|编号|提示文字
+func parseServerError(line string) (serverError, bool) {
+	rest, ok := strings.CutPrefix(line, "err|")
+	if !ok {
+		return serverError{}, false
+	}
+	num, text, ok := strings.Cut(rest, "|")
+	code, err := strconv.Atoi(num)
+	if !ok || err != nil {
+		return serverError{}, false
+	}
+	return serverError{Code: code, Text: text}, true
+}
+
+// 显示server的错误, 安静模式下写到标准错误; 开启 -debug-protocol 时在标准错误上打印错误的名字
+func (client *Client) handleServerError(e serverError) {
+	if client.debugProtocol {
+		fmt.Fprintln(os.Stderr, "[protocol] error", e.Code, client.errorName(e))
+	}
+	client.notice(e.Text)
+}
@@ -0,0 +1,21 @@
+package main
+
+import "testing"
+
+// 错误的名字优先用hello里的错误表, 老版本的server没有发时用自己的
+func TestErrorNames(t *testing.T) {
+	client := &Client{errorNames: parseErrorTable("104:ERR_NO_SUCH_USER,200:ERR_NEW,bad,:x")}
+	for _, c := range []struct {
+		code int
+		want string
+	}{
+		{104, "ERR_NO_SUCH_USER"},
+		{200, "ERR_NEW"},
+		{115, "ERR_USER_OFFLINE"},
+		{999, "ERR_999"},
+	} {
+		if got := client.errorName(serverError{Code: c.code}); got != c.want {
+			t.Errorf("errorName(%d) = %s, want %s", c.code, got, c.want)
+		}
+	}
+}
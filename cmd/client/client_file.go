@@ -0,0 +1,460 @@
+// 跟其他用户传文件, 协议见server的filexfer.go
+// /send 用户名 路径 发送文件, 对方同意后分块发送; 收到别人的文件时用 /accept 编号 或 /decline 编号 回复
+// 收到的文件保存在 -download-dir 目录里, 文件名不能带目录, 已经有同名文件时改名为 "名字 (1).后缀"
+// /transfers 列出本次连接的全部传输; 没有传完就断开时删掉写了一半的文件
+// 发送时按hello里的 read-rate 和 file-rate 放慢, 不会因为超出入站流量限制被server断开
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 默认的下载目录
+const defaultDownloadDir = "downloads"
+
+// 一块最多多少字节, 跟server一样
+const maxFileChunk = 8 * 1024
+
+// 同名文件最多试几个编号
+const maxNameCollisions = 1000
+
+// 每过多少百分比显示一次进度
+const progressStep = 10
+
+// 发文件最多用掉连接入站流量限制的多少, 剩下的留给聊天消息
+const fileRateShare = 0.75
+
+// 老版本的server不在hello里说速度限制, 按它的默认值发送
+const legacyReadRate = 8 * 1024
+
+// 传输的状态
+const (
+	transferOffered   = "等待回复"
+	transferActive    = "传输中"
+	transferDone      = "已完成"
+	transferDeclined  = "已拒绝"
+	transferExpired   = "已作废"
+	transferFailed    = "失败"
+	transferCancelled = "连接断开"
+)
+
+// 一个传输, 发出的和收到的都用这个
+type transfer struct {
+	id       string
+	outgoing bool
+	peer     string
+	name     string
+	size     int64
+	done     int64 // 已经发出或者收到的字节数
+	status   string
+	reason   string   // 失败的原因
+	path     string   // 发出的文件是本地路径, 收到的文件是保存的路径
+	file     *os.File // 收到的文件同意之后才打开
+	shown    int      // 已经显示过的进度
+}
+
+// 本次连接的全部传输
+type transfers struct {
+	lock    sync.Mutex
+	dir     string               // 下载目录
+	pending map[string]*transfer // 发出请求还没有收到编号的文件, key是文件名
+	byID    map[string]*transfer
+	list    []*transfer // 按开始的顺序, /transfers 用
+}
+
+// 懒初始化, 调用时需要持有lock
+func (this *transfers) init() {
+	if this.byID == nil {
+		this.pending = make(map[string]*transfer)
+		this.byID = make(map[string]*transfer)
+	}
+	if this.dir == "" {
+		this.dir = defaultDownloadDir
+	}
+}
+
+func (this *transfers) get(id string) (*transfer, bool) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	this.init()
+	tr, ok := this.byID[id]
+	return tr, ok
+}
+
+// 收到的文件名只能是一个名字, 不能带目录, 防止写到下载目录外面
+func safeFileName(name string) bool {
+	return name != "" && name != "." && name != ".." &&
+		!strings.ContainsAny(name, `/\`+"\x00") && filepath.Base(name) == name
+}
+
+// 第n个同名文件的名字: foo.png, foo (1).png, foo (2).png ...
+func collisionName(name string, n int) string {
+	if n == 0 {
+		return name
+	}
+	ext := filepath.Ext(name)
+	if ext == name {
+		// .bashrc 这样的名字没有后缀
+		ext = ""
+	}
+	return fmt.Sprintf("%s (%d)%s", strings.TrimSuffix(name, ext), n, ext)
+}
+
+// 在下载目录里新建文件, 不覆盖已有的文件, 返回打开的文件和实际的路径
+func createDownload(dir, name string) (*os.File, string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, "", err
+	}
+	for n := 0; n < maxNameCollisions; n++ {
+		path := filepath.Join(dir, collisionName(name, n))
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			return f, path, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, "", err
+		}
+	}
+	return nil, "", fmt.Errorf("%s 的同名文件太多", name)
+}
+
+// 进度的百分比, 大小为0时算作已经完成
+func progressPercent(done, size int64) int {
+	if size <= 0 || done >= size {
+		return 100
+	}
+	return int(done * 100 / size)
+}
+
+// 记录新的进度, 跨过一个progressStep时返回要显示的百分比, 不需要显示时返回-1; 调用时需要持有lock
+func (this *transfer) advance(n int64) int {
+	this.done += n
+	pct := progressPercent(this.done, this.size)
+	if pct >= 100 || pct/progressStep <= this.shown/progressStep {
+		return -1
+	}
+	this.shown = pct
+	return pct
+}
+
+// 发送文件: /send 用户名 路径
+func (client *Client) sendFile(args string) {
+	to, path, ok := strings.Cut(strings.TrimSpace(args), " ")
+	path = strings.TrimSpace(path)
+	if !ok || to == "" || path == "" {
+		client.display(">>>>> 用法: /send 用户名 路径")
+		return
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		client.display(">>>>> 读取文件失败: " + err.Error())
+		return
+	}
+	if !info.Mode().IsRegular() {
+		client.display(">>>>> 只能发送普通文件")
+		return
+	}
+	tr := &transfer{
+		outgoing: true,
+		peer:     to,
+		name:     filepath.Base(path),
+		size:     info.Size(),
+		status:   transferOffered,
+		path:     path,
+	}
+
+	client.files.lock.Lock()
+	client.files.init()
+	client.files.pending[tr.name] = tr
+	client.files.lock.Unlock()
+
+	client.send("file|" + client.roster.Address(to) + "|" + tr.name + "|" + strconv.FormatInt(tr.size, 10) + "\n")
+}
+
+// 同意接收: /accept 编号
+func (client *Client) acceptFile(id string) {
+	tr, ok := client.files.get(id)
+	if !ok || tr.outgoing || tr.status != transferOffered {
+		client.display(">>>>> 没有编号为" + id + "的文件")
+		return
+	}
+
+	f, path, err := createDownload(client.files.dir, tr.name)
+	if err != nil {
+		client.display(">>>>> 无法保存文件: " + err.Error())
+		return
+	}
+
+	client.files.lock.Lock()
+	tr.file, tr.path, tr.status = f, path, transferActive
+	client.files.lock.Unlock()
+	client.send("accept|" + id + "\n")
+}
+
+// 拒绝接收: /decline 编号
+func (client *Client) declineFile(id string) {
+	tr, ok := client.files.get(id)
+	if !ok || tr.outgoing || tr.status != transferOffered {
+		client.display(">>>>> 没有编号为" + id + "的文件")
+		return
+	}
+	client.files.lock.Lock()
+	tr.status = transferDeclined
+	client.files.lock.Unlock()
+	client.send("decline|" + id + "\n")
+}
+
+// 发文件的速度: 连接上每秒最多发多少字节和每个文件每秒最多发多少字节(解码后), 0表示不限制
+func (client *Client) fileRates() (float64, float64) {
+	readRate, err := strconv.Atoi(client.hello["read-rate"])
+	if err != nil {
+		readRate = legacyReadRate
+	}
+	fileRate, _ := strconv.Atoi(client.hello["file-rate"])
+	return float64(readRate) * fileRateShare, float64(fileRate)
+}
+
+// 从开始发送过了elapsed, 已经发了wire字节的消息和content字节的内容, 还要等多久才能发下一块
+func paceDelay(elapsed time.Duration, wire, content int64, wireRate, contentRate float64) time.Duration {
+	var wait time.Duration
+	if wireRate > 0 {
+		wait = time.Duration(float64(wire) / wireRate * float64(time.Second))
+	}
+	if contentRate > 0 {
+		if d := time.Duration(float64(content) / contentRate * float64(time.Second)); d > wait {
+			wait = d
+		}
+	}
+	return wait - elapsed
+}
+
+// 对方同意后分块发送文件内容, 按server的速度限制放慢
+func (client *Client) streamFile(tr *transfer) {
+	f, err := os.Open(tr.path)
+	if err != nil {
+		client.display(">>>>> 读取文件失败: " + err.Error())
+		return
+	}
+	defer f.Close()
+
+	wireRate, contentRate := client.fileRates()
+	start := time.Now()
+	var wire, content int64
+	buf := make([]byte, maxFileChunk)
+	for {
+		n, err := f.Read(buf)
+		if n > 0 {
+			if d := paceDelay(time.Since(start), wire, content, wireRate, contentRate); d > 0 {
+				time.Sleep(d)
+			}
+			msg := "chunk|" + tr.id + "|" + base64.StdEncoding.EncodeToString(buf[:n]) + "\n"
+			if werr := client.send(msg); werr != nil {
+				return
+			}
+			wire += int64(len(msg))
+			content += int64(n)
+			client.files.lock.Lock()
+			pct := tr.advance(int64(n))
+			client.files.lock.Unlock()
+			if pct >= 0 {
+				client.display(fmt.Sprintf(">>>>> 发送中 %s %d%%", tr.name, pct))
+			}
+		}
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			client.display(">>>>> 读取文件失败: " + err.Error())
+			return
+		}
+	}
+}
+
+// 结束一个传输, 收到的文件没有收完时删掉写了一半的文件; 调用时需要持有lock
+func (this *transfer) finish(status, reason string) {
+	this.status, this.reason = status, reason
+	if this.file == nil {
+		return
+	}
+	this.file.Close()
+	this.file = nil
+	if status != transferDone {
+		os.Remove(this.path)
+	}
+}
+
+// 连接断开或者退出时结束还没完成的传输
+func (client *Client) abortTransfers() {
+	client.files.lock.Lock()
+	defer client.files.lock.Unlock()
+
+	for _, tr := range client.files.list {
+		if tr.status == transferOffered || tr.status == transferActive {
+			tr.finish(transferCancelled, "")
+		}
+	}
+}
+
+// 列出本次连接的全部传输: /transfers
+func (client *Client) showTransfers() {
+	client.files.lock.Lock()
+	lines := make([]string, 0, len(client.files.list))
+	for _, tr := range client.files.list {
+		dir := "<- " + tr.peer
+		if tr.outgoing {
+			dir = "-> " + tr.peer
+		}
+		status := tr.status
+		if tr.status == transferActive {
+			status += fmt.Sprintf(" %d%%", progressPercent(tr.done, tr.size))
+		}
+		if tr.reason != "" {
+			status += ": " + tr.reason
+		}
+		if tr.status == transferCancelled && !tr.outgoing && tr.path != "" {
+			status += ", 已删除不完整的文件"
+		}
+		lines = append(lines, fmt.Sprintf("  %s %s %s (%d 字节) %s", tr.id, dir, tr.name, tr.size, status))
+	}
+	client.files.lock.Unlock()
+
+	if len(lines) == 0 {
+		client.display(">>>>> 没有传输过文件")
+		return
+	}
+	client.display(">>>>> 文件传输:\n" + strings.Join(lines, "\n"))
+}
+
+// 处理server发来的传文件消息, 不是这类消息时返回false
+func (client *Client) handleFileLine(line string) bool {
+	kind, rest, ok := strings.Cut(line, "|")
+	if !ok {
+		return false
+	}
+	parts := strings.Split(rest, "|")
+	id := parts[0]
+
+	switch kind {
+	case "file-offered":
+		// file-offered|编号|文件名
+		if len(parts) != 2 {
+			return false
+		}
+		client.files.lock.Lock()
+		client.files.init()
+		if tr, ok := client.files.pending[parts[1]]; ok {
+			delete(client.files.pending, parts[1])
+			tr.id = id
+			client.files.byID[id] = tr
+			client.files.list = append(client.files.list, tr)
+		}
+		client.files.lock.Unlock()
+		client.display(">>>>> 已发出文件 " + parts[1] + ", 等待对方接收")
+	case "file-offer":
+		// file-offer|编号|发送方|文件名|字节数
+		if len(parts) != 4 {
+			return false
+		}
+		size, err := strconv.ParseInt(parts[3], 10, 64)
+		if err != nil {
+			return false
+		}
+		if !safeFileName(parts[2]) {
+			client.display(">>>>> " + parts[1] + " 发来的文件名不安全, 已拒绝: " + strconv.Quote(parts[2]))
+			client.send("decline|" + id + "\n")
+			return true
+		}
+		tr := &transfer{id: id, peer: parts[1], name: parts[2], size: size, status: transferOffered}
+		client.files.lock.Lock()
+		client.files.init()
+		client.files.byID[id] = tr
+		client.files.list = append(client.files.list, tr)
+		client.files.lock.Unlock()
+		client.display(fmt.Sprintf(">>>>> %s 想发给您文件 %s (%d 字节), 输入 /accept %s 接收, /decline %s 拒绝",
+			parts[1], parts[2], size, id, id))
+	case "file-accepted":
+		tr, ok := client.files.get(id)
+		if ok && tr.outgoing && tr.status == transferOffered {
+			client.files.lock.Lock()
+			tr.status = transferActive
+			client.files.lock.Unlock()
+			go client.streamFile(tr)
+		}
+	case "chunk":
+		// chunk|编号|base64内容
+		if len(parts) != 2 {
+			return false
+		}
+		tr, ok := client.files.get(id)
+		if !ok || tr.outgoing {
+			return true
+		}
+		data, err := base64.StdEncoding.DecodeString(parts[1])
+		client.files.lock.Lock()
+		pct := -1
+		if tr.file == nil {
+			err = nil
+		} else if err == nil {
+			if _, err = tr.file.Write(data); err == nil {
+				pct = tr.advance(int64(len(data)))
+			}
+		}
+		if err != nil {
+			tr.finish(transferFailed, err.Error())
+		}
+		client.files.lock.Unlock()
+		if err != nil {
+			client.display(">>>>> 保存文件失败: " + err.Error())
+		}
+		if pct >= 0 {
+			client.display(fmt.Sprintf(">>>>> 接收中 %s %d%%", tr.name, pct))
+		}
+	case "file-done":
+		tr, ok := client.files.get(id)
+		if !ok {
+			return true
+		}
+		client.files.lock.Lock()
+		tr.done = tr.size
+		tr.finish(transferDone, "")
+		client.files.lock.Unlock()
+		if tr.outgoing {
+			client.display(">>>>> 文件 " + tr.name + " 发送完成")
+		} else {
+			client.display(">>>>> 已收到文件 " + tr.path)
+		}
+	case "file-declined", "file-expired", "file-error":
+		tr, ok := client.files.get(id)
+		if !ok {
+			return true
+		}
+		status, reason := map[string]string{"file-declined": transferDeclined, "file-expired": transferExpired}[kind], ""
+		if kind == "file-error" {
+			status = transferFailed
+			if len(parts) > 1 {
+				reason = parts[1]
+			}
+		}
+		client.files.lock.Lock()
+		tr.finish(status, reason)
+		client.files.lock.Unlock()
+		msg := ">>>>> 文件 " + tr.name + " " + status
+		if reason != "" {
+			msg += ": " + reason
+		}
+		client.display(msg)
+	default:
+		return false
+	}
+	return true
+}
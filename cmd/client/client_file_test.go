@@ -0,0 +1,77 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSafeFileName(t *testing.T) {
+	for name, want := range map[string]bool{
+		"a.txt":         true,
+		"photo (1).png": true,
+		"":              false,
+		".":             false,
+		"..":            false,
+		"../etc/passwd": false,
+		`..\boot.ini`:   false,
+		"a/b":           false,
+		"/etc/passwd":   false,
+		"a\x00b":        false,
+	} {
+		if got := safeFileName(name); got != want {
+			t.Errorf("safeFileName(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestCreateDownloadKeepsExisting(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a.txt"), []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, path, err := createDownload(dir, "a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if path != filepath.Join(dir, "a (1).txt") {
+		t.Fatalf("path = %s", path)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "a.txt")); string(data) != "old" {
+		t.Fatal("已有的文件被覆盖了")
+	}
+}
+
+func TestPaceDelay(t *testing.T) {
+	// 第一块马上发
+	if d := paceDelay(0, 0, 0, 6000, 0); d != 0 {
+		t.Fatalf("first chunk delay = %s", d)
+	}
+	// 按连接的速度: 已经发了6000字节, 要等到第1秒
+	if d := paceDelay(200*time.Millisecond, 6000, 4000, 6000, 0); d != 800*time.Millisecond {
+		t.Fatalf("wire delay = %s", d)
+	}
+	// 文件的速度更慢时按文件的速度
+	if d := paceDelay(0, 6000, 4000, 6000, 2000); d != 2*time.Second {
+		t.Fatalf("content delay = %s", d)
+	}
+	// 已经落后了不用等
+	if d := paceDelay(5*time.Second, 6000, 4000, 6000, 2000); d > 0 {
+		t.Fatalf("late delay = %s", d)
+	}
+}
+
+func TestFileRatesFromHello(t *testing.T) {
+	client := &Client{hello: map[string]string{"read-rate": "8192", "file-rate": "0"}}
+	wire, content := client.fileRates()
+	if wire != 8192*fileRateShare || content != 0 {
+		t.Fatalf("rates = %v %v", wire, content)
+	}
+	// 老版本的server没有说, 按它的默认值
+	client.hello = map[string]string{}
+	if wire, _ := client.fileRates(); wire != legacyReadRate*fileRateShare {
+		t.Fatalf("legacy rate = %v", wire)
+	}
+}
@@ -0,0 +1,118 @@
+// server发来的控制消息: 由客户端自己处理, 不显示给用户
+// 不认识的消息原样显示, 新版server增加的消息老客户端也能看到
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// 一种控制消息, match返回true表示这一行已经处理完
+type controlFrame struct {
+	name  string
+	match func(client *Client, line string) bool
+}
+
+// 按顺序匹配的控制消息
+var controlFrames = []controlFrame{
+	{"ping", func(client *Client, line string) bool {
+		// 心跳, 马上回复
+		if line != "ping" {
+			return false
+		}
+		client.send("pong\n")
+		return true
+	}},
+	{"pong", func(client *Client, line string) bool {
+		// /ping 的回复, 见client_ping.go; 看门狗的ping收到就行, 见client_watchdog.go
+		if line == "pong|"+watchdogToken || strings.HasPrefix(line, "pong|"+watchdogToken+"|") {
+			return true
+		}
+		rtt, offset, ok := parsePong(line, time.Now())
+		if ok {
+			client.showPong(rtt, offset)
+		}
+		return ok
+	}},
+	{"hello", func(client *Client, line string) bool {
+		// 用 -no-handshake 连接新版本的服务器时也不显示hello
+		_, ok := parseHello(line)
+		return ok
+	}},
+	{"wholist", func(client *Client, line string) bool {
+		// 在线用户名单只用于补全
+		names, ok := parseWholist(line)
+		if ok {
+			client.roster.Set(names, nil)
+		}
+		return ok
+	}},
+	{"who|json", func(client *Client, line string) bool {
+		names, ids, ok := parseWhoJSON(line)
+		if !ok {
+			return false
+		}
+		client.roster.Set(names, ids)
+		if client.joinRoster != nil {
+			client.showRoster(names)
+			close(client.joinRoster)
+			client.joinRoster = nil
+		}
+		return true
+	}},
+	{"rename-ok/rename-err", func(client *Client, line string) bool {
+		r, ok := parseRenameReply(line)
+		if ok {
+			client.handleRenameReply(r)
+		}
+		return ok
+	}},
+	{"legacy rename", func(client *Client, line string) bool {
+		// 老版本的server只回复提示文字, 交给在等的改名, 照常显示, 见client_rename.go
+		client.handleLegacyRenameReply(line)
+		return false
+	}},
+	{"ack/nak", func(client *Client, line string) bool {
+		// 带ID的消息的确认, 见client_outbox.go
+		id, ok := parseOutboxReply(line)
+		if ok {
+			client.outbox.done(id)
+		}
+		return ok
+	}},
+	{"file", func(client *Client, line string) bool {
+		return client.handleFileLine(line)
+	}},
+	{"err", func(client *Client, line string) bool {
+		e, ok := parseServerError(line)
+		if ok {
+			client.handleServerError(e)
+		}
+		return ok
+	}},
+	{"who-begin/who-end", func(client *Client, line string) bool {
+		return client.collectWho(line)
+	}},
+}
+
+// 处理控制消息, 返回true表示不需要再显示
+func (client *Client) handleControl(line string) bool {
+	for _, f := range controlFrames {
+		if f.match(client, line) {
+			if client.debugProtocol {
+				fmt.Fprintln(os.Stderr, "[protocol] handled as", f.name)
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// 开启 -debug-protocol 时把收发的原始消息打印到标准错误
+func (client *Client) debugFrame(dir string, line string) {
+	if client.debugProtocol {
+		fmt.Fprintf(os.Stderr, "[protocol] %s %q\n", dir, strings.TrimRight(line, "\n"))
+	}
+}
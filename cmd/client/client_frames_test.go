@@ -0,0 +1,36 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"testing"
+)
+
+// 控制消息由客户端自己处理, 不认识的消息原样显示; -debug-protocol 时处理结果写到标准错误
+func TestControlFramesFiltered(t *testing.T) {
+	serverSide, clientSide := net.Pipe()
+	defer serverSide.Close()
+	defer clientSide.Close()
+	client := &Client{conn: clientSide, name: "alice", debugProtocol: true}
+	replies := make(chan string, 1)
+	go func() {
+		line, _ := bufio.NewReader(serverSide).ReadString('\n')
+		replies <- line
+	}()
+
+	stdout, stderr := captureOutput(t, func() {
+		for _, line := range []string{"ping", "hello|proto=1", "wholist|alice,bob", "future|frame", "[#2]bob:ping"} {
+			client.showLine(line)
+		}
+	})
+	if stdout != "future|frame\n[#2]bob:ping\n" {
+		t.Fatalf("stdout = %q", stdout)
+	}
+	if got := <-replies; got != "pong\n" {
+		t.Fatalf("心跳的回复 = %q", got)
+	}
+	want := "[protocol] -> \"pong\"\n[protocol] handled as ping\n[protocol] handled as hello\n[protocol] handled as wholist\n"
+	if stderr != want {
+		t.Fatalf("stderr = %q", stderr)
+	}
+}
@@ -0,0 +1,101 @@
+// 长度前缀的分帧: -frame len 时连接后发 frame|len, 之后每条消息都是4字节大端长度 + 内容
+// server先用换行回复 frame-ok|len, 读到这一行之后改成按帧读; 格式跟server的framing.go一样
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+	"sync/atomic"
+)
+
+// 分帧方式
+const (
+	frameLine = iota // 换行结束
+	frameLen         // 长度前缀
+)
+
+// 一帧最多多少字节, 跟server一样
+const maxFrameSize = 64 * 1024
+
+var errFrameTooLarge = errors.New("frame too large")
+
+// 读一个长度前缀的帧
+func readFrame(r *bufio.Reader) (string, error) {
+	var head [4]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return "", err
+	}
+	n := binary.BigEndian.Uint32(head[:])
+	if n > maxFrameSize {
+		return "", errFrameTooLarge
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return "", err
+	}
+	return string(payload), nil
+}
+
+// server是否支持长度前缀的分帧, 见hello里的 frame=len
+func (client *Client) serverSupportsFrames() bool {
+	for _, f := range strings.Split(client.hello["frame"], ",") {
+		if f == "len" {
+			return true
+		}
+	}
+	return false
+}
+
+// 请求改用长度前缀的分帧, 之后发送的消息都按帧编码
+// server处理完这一行就按帧读, 所以不用等回复就可以切换
+func (client *Client) enableFrames() error {
+	if err := client.send("frame|len\n"); err != nil {
+		return err
+	}
+	atomic.StoreInt32(&client.sendFraming, frameLen)
+	return nil
+}
+
+// 按当前的分帧方式编码要发给server的内容, 一次发的几行各是一帧
+func (client *Client) encodeMessage(msg string) []byte {
+	if atomic.LoadInt32(&client.sendFraming) != frameLen {
+		return []byte(msg)
+	}
+	var buf []byte
+	for _, line := range strings.SplitAfter(msg, "\n") {
+		if line == "" {
+			continue
+		}
+		line = strings.TrimSuffix(line, "\n")
+		buf = binary.BigEndian.AppendUint32(buf, uint32(len(line)))
+		buf = append(buf, line...)
+	}
+	return buf
+}
+
+// 按当前的分帧方式读一条server的消息, 只在dealLines里调用
+// 按行读到 frame-ok|len 后改成按帧读, 读到 compress-ok|deflate 后改成解压着读(见client_compress.go), 这两条都不显示
+func (client *Client) readMessage() (string, error) {
+	if client.recvFraming == frameLen {
+		payload, err := readFrame(client.reader)
+		if err != nil {
+			return "", err
+		}
+		if client.switchInflate(payload + "\n") {
+			return client.readMessage()
+		}
+		return payload + "\n", nil
+	}
+	line, err := client.reader.ReadString('\n')
+	if line == "frame-ok|len\n" {
+		client.recvFraming = frameLen
+		return client.readMessage()
+	}
+	if client.switchInflate(line) {
+		return client.readMessage()
+	}
+	return line, err
+}
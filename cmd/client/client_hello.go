@@ -0,0 +1,96 @@
+// 连接后先等服务器的第一行 hello|proto=协议版本, 确认连上的是IM服务器
+// 连错端口(例如HTTP或SSH)时不会进入菜单, 也不会把收到的乱码显示出来
+// 老版本的服务器没有hello, 用 -no-handshake 跳过检查
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 客户端支持的协议版本
+const clientProtocol = 1
+
+// 最多等多久hello
+const handshakeTimeout = 3 * time.Second
+
+// 解析hello, 返回其中的 键=值, 不是hello时返回false
+func parseHello(line string) (map[string]string, bool) {
+	rest, ok := strings.CutPrefix(line, "hello|")
+	if !ok {
+		return nil, false
+	}
+	fields := make(map[string]string)
+	for _, f := range strings.Split(rest, "|") {
+		k, v, ok := strings.Cut(f, "=")
+		if !ok || k == "" {
+			return nil, false
+		}
+		fields[k] = v
+	}
+	return fields, true
+}
+
+// 等服务器的hello并检查协议版本, 不是IM服务器或者版本不兼容时返回false
+func (client *Client) handshake() bool {
+	client.conn.SetReadDeadline(time.Now().Add(handshakeTimeout))
+	defer client.conn.SetReadDeadline(time.Time{})
+
+	// 用ReadSlice, 对方一直不发换行时最多读满缓冲区就放弃
+	line, err := client.reader.ReadSlice('\n')
+	if err != nil {
+		return false
+	}
+	text := strings.TrimSuffix(string(line), "\n")
+	client.debugFrame("<-", text)
+
+	fields, ok := parseHello(text)
+	if !ok {
+		return false
+	}
+	client.hello = fields
+	client.errorNames = parseErrorTable(fields["errors"])
+	proto, err := strconv.Atoi(fields["proto"])
+	return err == nil && proto == clientProtocol
+}
+
+// 连接后显示的服务器信息: 已连接到 名字 (v1.2.3, 运行 3d4h); 老版本的服务器没有这些信息时返回空字符串
+func helloSummary(fields map[string]string) string {
+	name := fields["name"]
+	var details []string
+	if v := fields["version"]; v != "" {
+		if v[0] >= '0' && v[0] <= '9' {
+			v = "v" + v
+		}
+		details = append(details, v)
+	}
+	if secs, err := strconv.ParseInt(fields["uptime"], 10, 64); err == nil && secs >= 0 {
+		details = append(details, "运行 "+formatUptime(time.Duration(secs)*time.Second))
+	}
+	if name == "" && len(details) == 0 {
+		return ""
+	}
+	if name == "" {
+		name = "服务器"
+	}
+	if len(details) == 0 {
+		return "已连接到 " + name
+	}
+	return "已连接到 " + name + " (" + strings.Join(details, ", ") + ")"
+}
+
+// 运行时间只显示最大的两个单位: 3d4h、5h12m、7m30s、42s
+func formatUptime(d time.Duration) string {
+	secs := int64(d / time.Second)
+	days, hours, mins := secs/86400, secs/3600%24, secs/60%60
+	switch {
+	case days > 0:
+		return strconv.FormatInt(days, 10) + "d" + strconv.FormatInt(hours, 10) + "h"
+	case hours > 0:
+		return strconv.FormatInt(hours, 10) + "h" + strconv.FormatInt(mins, 10) + "m"
+	case mins > 0:
+		return strconv.FormatInt(mins, 10) + "m" + strconv.FormatInt(secs%60, 10) + "s"
+	}
+	return strconv.FormatInt(secs, 10) + "s"
+}
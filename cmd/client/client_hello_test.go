@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseHello(t *testing.T) {
+	fields, ok := parseHello("hello|proto=1|name=lobby|errors=100:ERR_USAGE")
+	if !ok || fields["proto"] != "1" || fields["name"] != "lobby" || fields["errors"] != "100:ERR_USAGE" {
+		t.Fatalf("parseHello = %v, %v", fields, ok)
+	}
+	for _, bad := range []string{"HTTP/1.1 400 Bad Request", "hello", "hello|proto", "hello|=1", "SSH-2.0-OpenSSH_9.6"} {
+		if _, ok := parseHello(bad); ok {
+			t.Errorf("parseHello(%q) 当作了hello", bad)
+		}
+	}
+}
+
+// 连上的不是IM服务器或者协议版本不同时握手失败
+func TestHandshake(t *testing.T) {
+	for _, c := range []struct {
+		name  string
+		first string
+		ok    bool
+	}{
+		{"im server", "hello|proto=1|name=lobby\n", true},
+		{"newer protocol", "hello|proto=2\n", false},
+		{"http", "HTTP/1.1 400 Bad Request\r\n\r\n", false},
+		{"ssh", "SSH-2.0-OpenSSH_9.6\r\n", false},
+		{"no newline", strings.Repeat("x", 8192), false},
+	} {
+		serverSide, clientSide := net.Pipe()
+		go func() {
+			serverSide.Write([]byte(c.first))
+			serverSide.Close()
+		}()
+		client := &Client{conn: clientSide, reader: bufio.NewReader(clientSide)}
+		if got := client.handshake(); got != c.ok {
+			t.Errorf("%s: handshake = %v", c.name, got)
+		}
+		if c.ok && client.hello["name"] != "lobby" {
+			t.Errorf("%s: hello = %v", c.name, client.hello)
+		}
+		clientSide.Close()
+	}
+}
+
+func TestHelloSummary(t *testing.T) {
+	for _, c := range []struct {
+		fields map[string]string
+		want   string
+	}{
+		{map[string]string{"name": "lobby", "version": "1.2.3", "uptime": "273600"}, "已连接到 lobby (v1.2.3, 运行 3d4h)"},
+		{map[string]string{"name": "lobby", "version": "dev", "uptime": "42"}, "已连接到 lobby (dev, 运行 42s)"},
+		{map[string]string{"version": "1.2.3"}, "已连接到 服务器 (v1.2.3)"},
+		{map[string]string{"name": "lobby", "uptime": "-1"}, "已连接到 lobby"},
+		{map[string]string{"proto": "1"}, ""}, // 老版本的服务器
+	} {
+		if got := helloSummary(c.fields); got != c.want {
+			t.Errorf("helloSummary(%v) = %q, want %q", c.fields, got, c.want)
+		}
+	}
+}
+
+func TestFormatUptime(t *testing.T) {
+	for d, want := range map[time.Duration]string{
+		0:                              "0s",
+		42 * time.Second:               "42s",
+		7*time.Minute + 30*time.Second: "7m30s",
+		5*time.Hour + 12*time.Minute + 9*time.Second: "5h12m",
+		3*24*time.Hour + 4*time.Hour:                 "3d4h",
+		400 * 24 * time.Hour:                         "400d0h",
+	} {
+		if got := formatUptime(d); got != want {
+			t.Errorf("formatUptime(%v) = %q, want %q", d, got, want)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+// 客户端的本地消息记录
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// 本地记录的方向标记
+const (
+	logRecv = "<-" // 收到的消息
+	logSent = "->" // 发出的消息
+)
+
+// 写入文件前最多缓存多少行, 写文件跟不上时丢弃新的记录, 不影响消息显示
+const msgLogQueue = 1024
+
+// 把收到和发出的消息追加到本地文件, 由单独的goroutine写文件
+type msgLog struct {
+	file  *os.File
+	lines chan string
+	done  chan struct{}
+
+	lock    sync.Mutex
+	closed  bool
+	dropped int // 因为队列满被丢弃的行数
+}
+
+func openMsgLog(path string) (*msgLog, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &msgLog{
+		file:  file,
+		lines: make(chan string, msgLogQueue),
+		done:  make(chan struct{}),
+	}
+	go l.run()
+
+	return l, nil
+}
+
+// 格式化一行记录: 时间 方向 消息
+func formatLogLine(t time.Time, dir string, msg string) string {
+	return t.Format("2006-01-02 15:04:05") + " " + dir + " " + msg + "\n"
+}
+
+// 记录一条消息, 不会阻塞调用方
+func (this *msgLog) Write(dir string, msg string) {
+	line := formatLogLine(time.Now(), dir, msg)
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if this.closed {
+		return
+	}
+	select {
+	case this.lines <- line:
+	default:
+		this.dropped++
+	}
+}
+
+func (this *msgLog) run() {
+	w := bufio.NewWriter(this.file)
+	for line := range this.lines {
+		w.WriteString(line)
+
+		// 队列空了就刷到文件里, 避免异常退出时丢掉太多
+		if len(this.lines) == 0 {
+			w.Flush()
+		}
+	}
+	w.Flush()
+	close(this.done)
+}
+
+// 写完剩下的记录后关闭文件, 可以重复调用
+func (this *msgLog) Close() {
+	this.lock.Lock()
+	if this.closed {
+		this.lock.Unlock()
+		return
+	}
+	this.closed = true
+	close(this.lines)
+	dropped := this.dropped
+	this.lock.Unlock()
+
+	<-this.done
+	this.file.Close()
+
+	if dropped > 0 {
+		fmt.Fprintln(os.Stderr, "本地记录丢弃了", dropped, "条消息")
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogFormat(t *testing.T) {
+	at := time.Date(2026, 1, 2, 15, 4, 5, 0, time.Local)
+	if got := formatTimestamp(at); got != "[15:04:05] " {
+		t.Fatalf("timestamp = %q", got)
+	}
+	if got := formatLogLine(at, logSent, "hi"); got != "2026-01-02 15:04:05 -> hi\n" {
+		t.Fatalf("log line = %q", got)
+	}
+}
+
+// 发出和收到的消息都带方向记到文件里, Close时写完剩下的
+func TestMsgLogWritesBothDirections(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "im.log")
+	l, err := openMsgLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverSide, clientSide := net.Pipe()
+	defer serverSide.Close()
+	defer clientSide.Close()
+	go io.Copy(io.Discard, serverSide)
+	client := &Client{conn: clientSide, log: l}
+
+	if err := client.send("hello\n"); err != nil {
+		t.Fatal(err)
+	}
+	l.Write(logRecv, "[#1]bob:hi")
+	l.Close()
+	l.Close()
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+	if len(lines) != 2 || !strings.HasSuffix(lines[0], " -> hello") || !strings.HasSuffix(lines[1], " <- [#1]bob:hi") {
+		t.Fatalf("记录 = %q", b)
+	}
+}
+
+// 写文件跟不上时丢弃记录, 不阻塞消息的显示
+func TestMsgLogDoesNotBlock(t *testing.T) {
+	l := &msgLog{lines: make(chan string)}
+	done := make(chan struct{})
+	go func() {
+		l.Write(logRecv, "one")
+		l.Write(logRecv, "two")
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Write阻塞了")
+	}
+	if l.dropped != 2 {
+		t.Fatalf("dropped = %d", l.dropped)
+	}
+}
@@ -0,0 +1,57 @@
+// 输入多行消息: 一行以 \ 结尾时接着输入下一行, 或者输入 /paste 之后一直读到只有 . 的一行
+// 发送时用 ml|内容, 换行写成 \n, 反斜杠写成 \\, 见server的multiline.go
+package main
+
+import "strings"
+
+// 多行消息最多几行、几个字节, 跟server一样
+const (
+	maxMultilineLines = 20
+	maxMultilineBytes = 4000
+)
+
+// 转义多行消息
+func escapeMultiline(lines []string) string {
+	escaped := make([]string, len(lines))
+	for i, line := range lines {
+		escaped[i] = strings.ReplaceAll(line, `\`, `\\`)
+	}
+	return strings.Join(escaped, `\n`)
+}
+
+// 读完一条可能有多行的消息, first是已经读到的第一行
+// 返回的行数大于1时按多行消息发送; 输入结束时返回已经读到的行
+func (client *Client) readMultiline(first string) []string {
+	if first == "/paste" {
+		client.display(">>>>粘贴多行消息, 只有 . 的一行结束")
+		var lines []string
+		for len(lines) < maxMultilineLines {
+			line := client.readLine()
+			if line == "." || client.inputClosed {
+				break
+			}
+			lines = append(lines, line)
+		}
+		return lines
+	}
+
+	lines := []string{first}
+	for len(lines) < maxMultilineLines && strings.HasSuffix(lines[len(lines)-1], `\`) {
+		last := len(lines) - 1
+		lines[last] = strings.TrimSuffix(lines[last], `\`)
+		next := client.readLine()
+		if client.inputClosed {
+			break
+		}
+		lines = append(lines, next)
+	}
+	return lines
+}
+
+// 按行数选择发送的格式: 一行时跟普通公聊一样(以命令开头时加上say|), 多行时用 ml|
+func (client *Client) multilineMessage(lines []string) string {
+	if len(lines) == 1 {
+		return client.sayLine(lines[0]) + "\n"
+	}
+	return "ml|" + escapeMultiline(lines) + "\n"
+}
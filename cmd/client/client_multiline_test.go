@@ -0,0 +1,13 @@
+package main
+
+import "testing"
+
+func TestMultilineMessage(t *testing.T) {
+	client := &Client{}
+	if got := client.multilineMessage([]string{`C:\tmp`, "第二行"}); got != `ml|C:\\tmp\n第二行`+"\n" {
+		t.Fatalf("多行 = %q", got)
+	}
+	if got := client.multilineMessage([]string{"hello"}); got != "hello\n" {
+		t.Fatalf("一行 = %q", got)
+	}
+}
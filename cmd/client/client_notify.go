@@ -0,0 +1,121 @@
+// 客户端的重要消息提醒: 私聊和提到自己的消息会响铃并计入未读
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// 最多保留多少条重要消息, /unread 时重新显示
+const maxImportant = 20
+
+// 终端响铃
+const bell = "\a"
+
+// 最近的重要消息和未读数量
+type Important struct {
+	lock   sync.Mutex
+	lines  []string
+	unread int
+}
+
+// 记录一条重要消息, 返回当前的未读数量
+func (this *Important) Add(line string) int {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if len(this.lines) >= maxImportant {
+		this.lines = this.lines[1:]
+	}
+	this.lines = append(this.lines, line)
+	this.unread++
+	return this.unread
+}
+
+// 用户有输入时清空未读数量
+func (this *Important) Clear() {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	this.unread = 0
+}
+
+func (this *Important) Unread() int {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	return this.unread
+}
+
+// 按时间顺序返回最近的重要消息
+func (this *Important) Recent() []string {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	return append([]string(nil), this.lines...)
+}
+
+// 是不是需要提醒的消息: 私聊消息, 或者别人的公聊消息里提到了自己的用户名
+func isImportant(line string, myName string) bool {
+	if strings.HasPrefix(line, privatePrefix) {
+		return true
+	}
+
+	// 公聊消息, 格式: [地址]用户名:消息内容, 只看消息内容部分
+	if myName == "" {
+		return false
+	}
+	_, name, text, ok := splitPublic(line)
+	if !ok || name == myName {
+		return false
+	}
+	return strings.Contains(strings.ToLower(text), strings.ToLower(myName))
+}
+
+// 带未读数量的输入提示, 例如 "[3!] > "
+func unreadPrompt(n int) string {
+	if n == 0 {
+		return "> "
+	}
+	return "[" + strconv.Itoa(n) + "!] > "
+}
+
+// 收到一条消息时检查是否需要提醒
+func (client *Client) checkImportant(line string) {
+	if !client.notify || !isImportant(line, client.Name()) {
+		return
+	}
+
+	n := client.important.Add(line)
+	if client.editor != nil {
+		client.editor.SetPrompt(unreadPrompt(n))
+		client.editor.Bell()
+		return
+	}
+	fmt.Print(bell)
+}
+
+// 清空未读数量
+func (client *Client) clearUnread() {
+	if client.important.Unread() == 0 {
+		return
+	}
+	client.important.Clear()
+	if client.editor != nil {
+		client.editor.SetPrompt(unreadPrompt(0))
+	}
+}
+
+// 重新显示最近的重要消息, 对应 /unread 命令
+func (client *Client) replayImportant() {
+	lines := client.important.Recent()
+	if len(lines) == 0 {
+		client.display("没有重要消息")
+		return
+	}
+	for _, line := range lines {
+		client.display(line)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestIsImportant(t *testing.T) {
+	for _, c := range []struct {
+		line string
+		want bool
+	}{
+		{"[私聊]bob对您说:hi", true},
+		{"[#2]bob:hey ALICE", true},
+		{"[#1]alice:I am alice", false}, // 自己说的不算
+		{"[#2]bob:hi all", false},
+		{"bob对您说:alice", false},
+	} {
+		if got := isImportant(c.line, "alice"); got != c.want {
+			t.Errorf("isImportant(%q) = %v", c.line, got)
+		}
+	}
+	if unreadPrompt(0) != "> " || unreadPrompt(3) != "[3!] > " {
+		t.Fatal("unreadPrompt")
+	}
+}
+
+// 没有行编辑器时直接响铃, 有输入时清空未读, /unread 只保留最近的maxImportant条
+func TestNotifyUnread(t *testing.T) {
+	client := &Client{name: "alice", notify: true}
+	stdout, _ := captureOutput(t, func() {
+		client.checkImportant("[#2]bob:hi all")
+		client.checkImportant("[私聊]bob对您说:在吗")
+	})
+	if stdout != bell || client.important.Unread() != 1 {
+		t.Fatalf("stdout=%q unread=%d", stdout, client.important.Unread())
+	}
+	client.clearUnread()
+	if client.important.Unread() != 0 {
+		t.Fatal("有输入以后还有未读")
+	}
+
+	for i := 0; i < maxImportant; i++ {
+		client.important.Add("[私聊]bob对您说:" + strconv.Itoa(i))
+	}
+	stdout, _ = captureOutput(t, client.replayImportant)
+	want := ""
+	for i := 0; i < maxImportant; i++ {
+		want += "[私聊]bob对您说:" + strconv.Itoa(i) + "\n"
+	}
+	if stdout != want {
+		t.Fatalf("unread = %q", stdout)
+	}
+}
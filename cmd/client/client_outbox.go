@@ -0,0 +1,156 @@
+// 发件箱: 服务器支持消息ID(hello里有 dedupe=msg-id)时, 公聊和私聊都带上客户端生成的消息ID, 放进发件箱等服务器确认
+// 公聊用 pub|消息ID|内容, 私聊用 to|用户名|内容|消息ID; 收到 ack|消息ID 或者 nak|消息ID(没发出去, 服务器已经回复了原因) 时从发件箱去掉
+// outboxRetry 没有确认就用同一个ID重发, 服务器按ID去重, 网络抖一下重发也不会发出两条; 发了outboxMaxTries次还没有确认时提示用户
+package main
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 多久没有收到确认就重发
+const outboxRetry = 5 * time.Second
+
+// 一条消息最多发几次
+const outboxMaxTries = 3
+
+// 发件箱里的一条消息
+type outboxEntry struct {
+	line   string    // 发送的一行, 重发时原样再发
+	text   string    // 消息内容, 放弃时提示用户
+	sentAt time.Time // 最后一次发送的时间
+	tries  int
+}
+
+// 等待确认的消息, key是消息ID
+type outbox struct {
+	lock    sync.Mutex
+	enabled bool   // 服务器支持消息ID, 连接时设置
+	prefix  string // 本次连接的ID前缀, 跟以前的连接不会重复
+	next    uint64
+	pending map[string]*outboxEntry
+}
+
+// 服务器支持消息ID时开启, 要在声明能力之前调用
+func (this *outbox) enable(now time.Time) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	this.enabled = true
+	this.prefix = strconv.FormatInt(now.UnixNano(), 36)
+	this.pending = make(map[string]*outboxEntry)
+}
+
+// 是否开启了发件箱
+func (this *outbox) on() bool {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	return this.enabled
+}
+
+// 生成一个消息ID, 用format拼出要发送的一行, 放进发件箱
+func (this *outbox) add(text string, now time.Time, format func(id string) string) string {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	this.next++
+	id := this.prefix + "-" + strconv.FormatUint(this.next, 10)
+	line := format(id)
+	this.pending[id] = &outboxEntry{line: line, text: text, sentAt: now, tries: 1}
+	return line
+}
+
+// 收到确认, 不是发件箱里的ID时返回false
+func (this *outbox) done(id string) bool {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if _, ok := this.pending[id]; !ok {
+		return false
+	}
+	delete(this.pending, id)
+	return true
+}
+
+// 到了重发时间的消息: 返回要重发的行和放弃的消息内容
+func (this *outbox) due(now time.Time) ([]string, []string) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	var resend, failed []string
+	for id, e := range this.pending {
+		if now.Sub(e.sentAt) < outboxRetry {
+			continue
+		}
+		if e.tries >= outboxMaxTries {
+			failed = append(failed, e.text)
+			delete(this.pending, id)
+			continue
+		}
+		e.tries++
+		e.sentAt = now
+		resend = append(resend, e.line)
+	}
+	return resend, failed
+}
+
+// 解析 ack|消息ID 和 nak|消息ID
+func parseOutboxReply(line string) (string, bool) {
+	for _, prefix := range []string{"ack|", "nak|"} {
+		if id, ok := strings.CutPrefix(line, prefix); ok && id != "" {
+			return id, true
+		}
+	}
+	return "", false
+}
+
+// 公聊消息要发送的一行(带换行): 开启了发件箱时用 pub|消息ID|内容, 内容不会被当作命令, 不用加 say|
+// "/命令" 和不能发送的内容跟publicLine一样处理
+func (client *Client) chatLine(msg string) (string, bool) {
+	if raw, ok := strings.CutPrefix(msg, "/"); (ok && isServerCommand(raw)) || !client.outbox.on() {
+		return client.publicLine(msg)
+	}
+	if _, ok := client.publicLine(msg); !ok {
+		return "", false
+	}
+	return client.outbox.add(msg, time.Now(), func(id string) string {
+		return "pub|" + id + "|" + msg + "\n"
+	}), true
+}
+
+// 私聊要发送的一行(带换行), 开启了发件箱时在最后加上消息ID
+// 声明了 msg-id 能力以后, 内容里有 | 的私聊必须带ID, 不然最后一段会被当作ID
+func (client *Client) privateLine(address, content string) string {
+	if !client.outbox.on() {
+		return "to|" + address + "|" + content + "\n"
+	}
+	return client.outbox.add(content, time.Now(), func(id string) string {
+		return "to|" + address + "|" + content + "|" + id + "\n"
+	})
+}
+
+// 定期重发没有确认的消息, 连接关闭后退出
+func (client *Client) retryOutbox(done <-chan struct{}) {
+	ticker := time.NewTicker(outboxRetry / 5)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			resend, failed := client.outbox.due(now)
+			for _, line := range resend {
+				if err := client.send(line); err != nil {
+					return
+				}
+			}
+			for _, text := range failed {
+				client.notice(">>>>> 没有收到服务器的确认, 这条消息可能没有发出去: " + text)
+			}
+		}
+	}
+}
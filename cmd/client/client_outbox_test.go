@@ -0,0 +1,76 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOutboxRetryAndGiveUp(t *testing.T) {
+	var box outbox
+	start := time.Unix(1000, 0)
+	box.enable(start)
+	line := box.add("hi", start, func(id string) string { return "pub|" + id + "|hi\n" })
+	id := strings.Split(line, "|")[1]
+
+	if resend, _ := box.due(start.Add(outboxRetry - time.Second)); len(resend) != 0 {
+		t.Fatal("还没到时间就重发了")
+	}
+	now := start
+	for try := 2; try <= outboxMaxTries; try++ {
+		now = now.Add(outboxRetry)
+		resend, failed := box.due(now)
+		if len(resend) != 1 || resend[0] != line || len(failed) != 0 {
+			t.Fatalf("第%d次: resend=%q failed=%q", try, resend, failed)
+		}
+	}
+	// 用光次数后放弃, 提示用户
+	resend, failed := box.due(now.Add(outboxRetry))
+	if len(resend) != 0 || len(failed) != 1 || failed[0] != "hi" {
+		t.Fatalf("resend=%q failed=%q", resend, failed)
+	}
+	if box.done(id) {
+		t.Fatal("放弃的消息还在发件箱里")
+	}
+}
+
+func TestOutboxAck(t *testing.T) {
+	var box outbox
+	now := time.Unix(1000, 0)
+	box.enable(now)
+	line := box.add("hi", now, func(id string) string { return "to|bob|a|b|" + id + "\n" })
+	id := strings.TrimSuffix(line[strings.LastIndex(line, "|")+1:], "\n")
+
+	got, ok := parseOutboxReply("ack|" + id)
+	if !ok || got != id || !box.done(got) {
+		t.Fatalf("ack %q not accepted", id)
+	}
+	if resend, failed := box.due(now.Add(time.Hour)); len(resend)+len(failed) != 0 {
+		t.Fatal("确认过的消息还会重发")
+	}
+	if _, ok := parseOutboxReply("nak|"); ok {
+		t.Fatal("空的ID")
+	}
+}
+
+func TestChatLineWithOutbox(t *testing.T) {
+	client := &Client{hello: map[string]string{"escape": "say"}}
+	// 服务器不支持消息ID时跟以前一样
+	if line, _ := client.chatLine("who"); line != "say|who\n" {
+		t.Fatalf("legacy line = %q", line)
+	}
+	if line := client.privateLine("#3", "a|b"); line != "to|#3|a|b\n" {
+		t.Fatalf("legacy private = %q", line)
+	}
+
+	client.outbox.enable(time.Unix(1000, 0))
+	if line, _ := client.chatLine("who"); !strings.HasPrefix(line, "pub|") || !strings.HasSuffix(line, "|who\n") {
+		t.Fatalf("pub line = %q", line)
+	}
+	if line, _ := client.chatLine("/who"); line != "who\n" {
+		t.Fatalf("command line = %q", line)
+	}
+	if line := client.privateLine("#3", "a|b"); !strings.HasPrefix(line, "to|#3|a|b|") {
+		t.Fatalf("private line = %q", line)
+	}
+}
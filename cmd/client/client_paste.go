@@ -0,0 +1,122 @@
+// 终端的括号粘贴(bracketed paste): 开启后终端把粘贴的内容放在 ESC[200~ 和 ESC[201~ 中间发过来
+// 粘贴一行时跟手动输入一样放进正在编辑的一行; 粘贴多行时先确认, 不会一行一条地刷屏
+// 公聊里粘贴的多行作为一条多行消息(ml|)发送, 太长或者在私聊里时一行一条, 每条之间间隔pasteLineInterval
+// 标准输入不是终端时没有行编辑器, 不受影响
+package main
+
+import (
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 开启和关闭括号粘贴的终端序列
+const (
+	bracketedPasteOn  = "\033[?2004h"
+	bracketedPasteOff = "\033[?2004l"
+)
+
+// 粘贴内容的结束标记, 开始标记 ESC[200~ 由readEscape识别
+const pasteEnd = "\033[201~"
+
+// 一次粘贴最多保留多少字符, 多出来的丢掉
+const maxPasteRunes = 64 * 1024
+
+// 一行一条发送时每条之间的间隔, 避免触发服务器的限速
+const pasteLineInterval = 300 * time.Millisecond
+
+// 读到粘贴结束标记为止, 返回粘贴的内容, 换行统一成\n; 在readEscape里调用, 不持有lock
+// 输入在粘贴中间结束时返回已经读到的部分
+func (this *LineEditor) readPaste() string {
+	var sb strings.Builder
+	n := 0
+	for {
+		r, err := this.next()
+		if err != nil {
+			break
+		}
+		if n < maxPasteRunes+len(pasteEnd) {
+			sb.WriteRune(r)
+			n++
+		}
+		if r == '~' && strings.HasSuffix(sb.String(), pasteEnd) {
+			break
+		}
+	}
+	return normalizePaste(strings.TrimSuffix(sb.String(), pasteEnd))
+}
+
+// 换行统一成\n, 去掉结尾的空行和不可打印的控制字符(除了换行和Tab)
+func normalizePaste(text string) string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = strings.ReplaceAll(text, "\r", "\n")
+	text = strings.Map(func(r rune) rune {
+		if r == '\t' {
+			return ' '
+		}
+		if (r != '\n' && r < 0x20) || r == keyBackspace {
+			return -1
+		}
+		return r
+	}, text)
+	return strings.TrimRight(text, "\n")
+}
+
+// 把粘贴的内容插到光标处; 只有一行时继续编辑, 返回false; 有多行时这一行输入结束, 返回true
+// 调用时需要持有lock
+func (this *LineEditor) insertPaste(text string) bool {
+	rs := []rune(text)
+	rest := append([]rune(nil), this.buf[this.pos:]...)
+	this.buf = append(append(this.buf[:this.pos], rs...), rest...)
+	this.pos += len(rs)
+	if !strings.Contains(text, "\n") {
+		return false
+	}
+	this.active = false
+	// 多行的内容不进输入历史, 换行显示成 ↵ 只占一行
+	io.WriteString(this.out, "\r\033[K"+this.prompt+strings.ReplaceAll(string(this.buf), "\n", "↵")+"\r\n")
+	return true
+}
+
+// 粘贴了多行时先确认, 返回要发送的几行; 取消时返回nil
+func (client *Client) confirmPaste(text string) []string {
+	lines := strings.Split(text, "\n")
+	client.display(">>>>> 即将发送 " + strconv.Itoa(len(lines)) + " 行，确认? (y/n)")
+	answer := strings.ToLower(strings.TrimSpace(client.readLine()))
+	if answer != "y" && answer != "yes" {
+		client.display(">>>>> 已取消")
+		return nil
+	}
+	return lines
+}
+
+// 一行一条地发送粘贴的内容, format把一行内容变成要发送的一行(带换行), 空行不发
+func (client *Client) sendPastedLines(format func(line string) string, lines []string) error {
+	first := true
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if !first {
+			time.Sleep(pasteLineInterval)
+		}
+		first = false
+		if err := client.send(format(line)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 公聊里粘贴了多行: 确认后作为一条多行消息发送, 超过多行消息的限制时一行一条发送
+func (client *Client) sendPaste(text string) error {
+	lines := client.confirmPaste(text)
+	if lines == nil {
+		return nil
+	}
+	if len(lines) <= maxMultilineLines && len(escapeMultiline(lines)) <= maxMultilineBytes {
+		return client.send(client.multilineMessage(lines))
+	}
+	return client.sendPastedLines(func(line string) string { return client.sayLine(line) + "\n" }, lines)
+}
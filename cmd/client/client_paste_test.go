@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestNormalizePaste(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"a\r\nb\rc", "a\nb\nc"},
+		{"a\tb", "a b"},
+		{"a\x1b[31mb\x07\x7f", "a[31mb"},
+		{"hello\n\n\r\n", "hello"},
+		{"\n第一行\n\n第三行", "\n第一行\n\n第三行"},
+	}
+	for _, tt := range tests {
+		if got := normalizePaste(tt.in); got != tt.want {
+			t.Errorf("normalizePaste(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+// 粘贴时回答answer, 返回发给server的内容和显示的内容
+func pasteWith(t *testing.T, answer, text string) (sent, stdout string) {
+	serverSide, clientSide := net.Pipe()
+	defer serverSide.Close()
+	client := &Client{conn: clientSide, input: bufio.NewReader(strings.NewReader(answer))}
+	received := make(chan string)
+	go func() {
+		b, _ := io.ReadAll(serverSide)
+		received <- string(b)
+	}()
+
+	stdout, _ = captureOutput(t, func() {
+		if err := client.sendPaste(text); err != nil {
+			t.Error(err)
+		}
+	})
+	clientSide.Close()
+	return <-received, stdout
+}
+
+// 确认后作为一条多行消息发送, 回答别的都是取消
+func TestPasteConfirm(t *testing.T) {
+	sent, stdout := pasteWith(t, "Y\n", "第一行\n第二行")
+	if sent != `ml|第一行\n第二行`+"\n" {
+		t.Fatalf("发出了 %q", sent)
+	}
+	if stdout != ">>>>> 即将发送 2 行，确认? (y/n)\n" {
+		t.Fatalf("stdout = %q", stdout)
+	}
+
+	for _, answer := range []string{"n\n", "\n", "ok\n", ""} {
+		sent, stdout := pasteWith(t, answer, "第一行\n第二行")
+		if sent != "" {
+			t.Fatalf("回答 %q 时发出了 %q", answer, sent)
+		}
+		if !strings.HasSuffix(stdout, ">>>>> 已取消\n") {
+			t.Fatalf("回答 %q 时 stdout = %q", answer, stdout)
+		}
+	}
+}
+
+// 超过多行消息的限制时一行一条发送, 空行不发
+func TestPasteTooLong(t *testing.T) {
+	long := strings.Repeat("长", maxMultilineBytes/3)
+	sent, _ := pasteWith(t, "yes\n", long+"\n\n"+long)
+	if sent != long+"\n"+long+"\n" {
+		t.Fatalf("发出了 %d 字节: %.40q", len(sent), sent)
+	}
+}
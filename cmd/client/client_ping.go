@@ -0,0 +1,38 @@
+// /ping 测量到服务器的延迟: 发 ping|本地时间, 收到 pong|本地时间|服务器时间 后显示往返时间和时钟偏差
+// 时钟偏差按服务器在往返的正中间回复估算, 网络两个方向不对称时会有误差
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 发送 ping|本地时间(Unix毫秒)
+func (client *Client) ping() {
+	if err := client.send("ping|" + strconv.FormatInt(time.Now().UnixMilli(), 10) + "\n"); err != nil {
+		client.display(">>>>> 发送失败: " + err.Error())
+	}
+}
+
+// 解析 pong|本地时间|服务器时间, 返回往返时间和服务器时钟比本地快多少
+func parsePong(line string, now time.Time) (rtt time.Duration, offset time.Duration, ok bool) {
+	parts := strings.Split(line, "|")
+	if len(parts) != 3 || parts[0] != "pong" {
+		return 0, 0, false
+	}
+	sent, err1 := strconv.ParseInt(parts[1], 10, 64)
+	server, err2 := strconv.ParseInt(parts[2], 10, 64)
+	if err1 != nil || err2 != nil {
+		return 0, 0, false
+	}
+	rtt = time.Duration(now.UnixMilli()-sent) * time.Millisecond
+	offset = time.Duration(server-sent)*time.Millisecond - rtt/2
+	return rtt, offset, true
+}
+
+// 显示一次ping的结果
+func (client *Client) showPong(rtt time.Duration, offset time.Duration) {
+	client.display(fmt.Sprintf(">>>>> 延迟: %dms (时钟偏差约 %+dms)", rtt.Milliseconds(), offset.Milliseconds()))
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Achiyun/Golang-IM-System/internal/leakcheck"
+)
+
+// 服务器在往返的正中间回复时, 偏差就是服务器时间减去中间时刻
+func TestParsePong(t *testing.T) {
+	now := time.UnixMilli(1767366245300)
+	tests := []struct {
+		line        string
+		rtt, offset time.Duration
+	}{
+		{"pong|1767366245000|1767366245150", 300 * time.Millisecond, 0},
+		{"pong|1767366245000|1767366247150", 300 * time.Millisecond, 2 * time.Second},
+		{"pong|1767366245000|1767366244100", 300 * time.Millisecond, -1050 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		rtt, offset, ok := parsePong(tt.line, now)
+		if !ok || rtt != tt.rtt || offset != tt.offset {
+			t.Errorf("parsePong(%q) = %v, %v, %v", tt.line, rtt, offset, ok)
+		}
+	}
+
+	for _, line := range []string{"pong", "pong|1", "pong|a|1", "pong|1|b", "pong|1|2|3", "ping|1|2"} {
+		if _, _, ok := parsePong(line, now); ok {
+			t.Errorf("parsePong(%q) 应该失败", line)
+		}
+	}
+}
+
+// /ping 发出本地时间, 收到的pong显示成延迟, 看门狗的pong不显示
+func TestPingRoundTrip(t *testing.T) {
+	leakcheck.Check(t)
+	serverSide, clientSide := net.Pipe()
+	defer serverSide.Close()
+	defer clientSide.Close()
+	client := &Client{conn: clientSide}
+	sent := make(chan string, 1)
+	go func() {
+		line, _ := bufio.NewReader(serverSide).ReadString('\n')
+		sent <- line
+	}()
+
+	before := time.Now().UnixMilli()
+	client.ping()
+	line := strings.TrimSuffix(<-sent, "\n")
+	token, ok := strings.CutPrefix(line, "ping|")
+	ms, err := strconv.ParseInt(token, 10, 64)
+	if !ok || err != nil || ms < before || ms > time.Now().UnixMilli() {
+		t.Fatalf("发出了 %q", line)
+	}
+
+	stdout, _ := captureOutput(t, func() {
+		client.showLine("pong|" + watchdogToken + "|1")
+		client.showLine("pong|" + token + "|" + token)
+	})
+	if !regexp.MustCompile(`^>>>>> 延迟: \d+ms \(时钟偏差约 [+-]\d+ms\)\n$`).MatchString(stdout) {
+		t.Fatalf("stdout = %q", stdout)
+	}
+}
@@ -0,0 +1,169 @@
+// 客户端的服务器配置: ~/.im/config.json 里按名字保存多个服务器
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+// 一个服务器的配置
+type Profile struct {
+	Server      string `json:"server"`
+	Port        int    `json:"port"`
+	Name        string `json:"name,omitempty"`
+	TLS         bool   `json:"tls,omitempty"`
+	Fingerprint string `json:"fingerprint,omitempty"`
+}
+
+// 客户端的配置文件
+type ClientConfig struct {
+	Profiles map[string]Profile `json:"profiles"`
+	Aliases  map[string]string  `json:"aliases,omitempty"` // 快捷回复, 见client_alias.go
+}
+
+// 配置文件的路径, 找不到主目录时返回空字符串
+func clientConfigPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".im", "config.json")
+}
+
+// 读取配置文件, 文件不存在时返回空配置
+func loadClientConfig(path string) (*ClientConfig, error) {
+	cfg := &ClientConfig{Profiles: make(map[string]Profile)}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, err
+	}
+	if cfg.Profiles == nil {
+		cfg.Profiles = make(map[string]Profile)
+	}
+	return cfg, nil
+}
+
+// 保存配置文件, 里面可能有服务器指纹等信息, 只有自己可以读写
+func saveClientConfig(path string, cfg *ClientConfig) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// 先写临时文件再改名, 写到一半出错时不会破坏原来的配置
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0600); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp, 0600); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// 按名字排序的全部配置名
+func (this *ClientConfig) Names() []string {
+	names := make([]string, 0, len(this.Profiles))
+	for name := range this.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// 命令行上明确给出的参数
+func flagsSet() map[string]bool {
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	return set
+}
+
+// 使用一个保存过的服务器配置, 命令行上明确给出的参数优先
+func useProfile(path string, name string) error {
+	cfg, err := loadClientConfig(path)
+	if err != nil {
+		return err
+	}
+	p, ok := cfg.Profiles[name]
+	if !ok {
+		return fmt.Errorf("没有名为 %s 的配置", name)
+	}
+	if p.TLS {
+		return fmt.Errorf("配置 %s 要求TLS, 当前客户端还不支持TLS连接", name)
+	}
+
+	set := flagsSet()
+	if !set["ip"] && p.Server != "" {
+		serverIp = p.Server
+	}
+	if !set["port"] && p.Port != 0 {
+		srcerPort = p.Port
+	}
+	if !set["name"] && p.Name != "" {
+		userName = p.Name
+	}
+	return nil
+}
+
+// 把当前的参数保存为一个服务器配置, 同名的配置会被覆盖
+func saveProfile(path string, name string) error {
+	cfg, err := loadClientConfig(path)
+	if err != nil {
+		return err
+	}
+
+	p := cfg.Profiles[name]
+	p.Server = serverIp
+	p.Port = srcerPort
+	p.Name = userName
+	cfg.Profiles[name] = p
+
+	return saveClientConfig(path, cfg)
+}
+
+// 列出全部保存过的服务器配置
+func printProfiles(path string) error {
+	cfg, err := loadClientConfig(path)
+	if err != nil {
+		return err
+	}
+	if len(cfg.Profiles) == 0 {
+		fmt.Println("还没有保存过服务器配置, 可以用 -save-profile 名字 保存当前参数")
+		return nil
+	}
+
+	for _, name := range cfg.Names() {
+		p := cfg.Profiles[name]
+		line := fmt.Sprintf("%s\t%s", name, net.JoinHostPort(p.Server, strconv.Itoa(p.Port)))
+		if p.Name != "" {
+			line += "\t用户名:" + p.Name
+		}
+		if p.TLS {
+			line += "\tTLS"
+		}
+		fmt.Println(line)
+	}
+	return nil
+}
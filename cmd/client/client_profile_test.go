@@ -0,0 +1,86 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// 测试结束时恢复连接参数
+func keepConnFlags(t *testing.T) {
+	ip, port, name := serverIp, srcerPort, userName
+	t.Cleanup(func() { serverIp, srcerPort, userName = ip, port, name })
+}
+
+// 保存当前参数时保留配置里原有的其他字段, 文件只有自己可以读写
+func TestSaveProfile(t *testing.T) {
+	keepConnFlags(t)
+	path := filepath.Join(t.TempDir(), ".im", "config.json")
+	cfg := &ClientConfig{
+		Profiles: map[string]Profile{"work": {Server: "old", Port: 1, Fingerprint: "ab:cd"}},
+		Aliases:  map[string]string{"brb": "马上回来"},
+	}
+	if err := saveClientConfig(path, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	serverIp, srcerPort, userName = "10.0.0.1", 9000, "alice"
+	if err := saveProfile(path, "work"); err != nil {
+		t.Fatal(err)
+	}
+	if err := saveProfile(path, "home"); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := loadClientConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Profile{Server: "10.0.0.1", Port: 9000, Name: "alice", Fingerprint: "ab:cd"}
+	if got.Profiles["work"] != want || got.Aliases["brb"] != "马上回来" {
+		t.Fatalf("config = %+v", got)
+	}
+	if names := strings.Join(got.Names(), ","); names != "home,work" {
+		t.Fatalf("names = %s", names)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("mode = %v %v", info.Mode(), err)
+	}
+}
+
+// 命令行上明确给出的参数优先于配置
+func TestUseProfile(t *testing.T) {
+	keepConnFlags(t)
+	path := filepath.Join(t.TempDir(), "config.json")
+	cfg := &ClientConfig{Profiles: map[string]Profile{
+		"work":   {Server: "10.0.0.1", Port: 9000, Name: "alice"},
+		"secure": {Server: "10.0.0.2", Port: 9001, TLS: true},
+	}}
+	if err := saveClientConfig(path, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := flag.Set("port", strconv.Itoa(srcerPort)); err != nil {
+		t.Fatal(err)
+	}
+	port := srcerPort
+	if err := useProfile(path, "work"); err != nil {
+		t.Fatal(err)
+	}
+	if serverIp != "10.0.0.1" || userName != "alice" || srcerPort != port {
+		t.Fatalf("ip=%s name=%s port=%d", serverIp, userName, srcerPort)
+	}
+
+	if err := useProfile(path, "secure"); err == nil {
+		t.Fatal("要求TLS的配置也用上了")
+	}
+	if err := useProfile(path, "missing"); err == nil {
+		t.Fatal("不存在的配置没有报错")
+	}
+	if cfg, err := loadClientConfig(filepath.Join(t.TempDir(), "none.json")); err != nil || len(cfg.Profiles) != 0 {
+		t.Fatalf("没有配置文件时 = %+v %v", cfg, err)
+	}
+}
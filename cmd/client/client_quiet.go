@@ -0,0 +1,110 @@
+// 安静模式: 在脚本和管道里使用客户端时, 菜单、提示和连接成功之类的信息会混进输出
+// -quiet 时标准输出上只有收到的消息, 错误写到标准错误, 失败时按原因用不同的退出码
+// 不显示菜单, 标准输入的每一行都作为公聊消息发出(跟公聊模式一样, 可以用 /msg 等命令), 标准输入结束后退出
+// -send 内容 连接后发一条公聊消息就退出, 标准输出不是终端时自动开启安静模式; 服务器回复了错误时退出码是 exitSend
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// 退出码, 1是标准输出写失败, 见client_stdout.go
+const (
+	exitConnect      = 2 // 连不上服务器
+	exitSend         = 3 // 发送失败
+	exitDisconnected = 4 // 服务器断开了连接, 例如被踢掉
+)
+
+// 菜单和提示, 安静模式下不显示
+func prompt(a ...interface{}) {
+	if !quiet {
+		fmt.Println(a...)
+	}
+}
+
+// 错误信息, 安静模式下写到标准错误
+func complain(a ...interface{}) {
+	if quiet {
+		fmt.Fprintln(os.Stderr, a...)
+		return
+	}
+	fmt.Println(a...)
+}
+
+// 客户端自己的提示, 跟收到的消息一样显示; 安静模式下写到标准错误, 不混进收到的消息
+func (client *Client) notice(text string) {
+	if quiet {
+		complain(text)
+		return
+	}
+	client.display(text)
+}
+
+// 发送失败, 安静模式下直接退出
+func (client *Client) sendFailed(err error) {
+	complain("conn Write err:", err)
+	if quiet {
+		client.Close()
+		os.Exit(exitSend)
+	}
+}
+
+// 服务器断开了连接, 安静模式下退出, 交互模式下跟以前一样留在菜单里
+func (client *Client) serverClosed() {
+	if !quiet {
+		return
+	}
+	complain(">>>>> 服务器断开了连接")
+	client.Close()
+	os.Exit(exitDisconnected)
+}
+
+// -send 最多等多久服务器的回复
+const sendReplyWait = 2 * time.Second
+
+// -send 在消息后面发的ping, 收到回复说明消息已经处理完了
+const sendDoneToken = "send"
+
+// -send: 发一条公聊消息, 以命令开头的同样加上say|, 见client_say.go; 返回退出码, 0表示成功
+// 连接后已经声明了 errors 能力, 服务器拒绝时回复 err|编号|提示文字; 支持 ping 的服务器在消息后面跟一个ping, 收到pong就不用等满
+// 老版本的服务器不回复, 等满 sendReplyWait 没有收到错误就算发出去了
+func (client *Client) sendOnce(text string) int {
+	msg, ok := client.publicLine(text)
+	if !ok {
+		return exitSend
+	}
+	if client.serverSupportsHeartbeat() {
+		msg += "ping|" + sendDoneToken + "\n"
+	}
+	if err := client.send(msg); err != nil {
+		complain("conn Write err:", err)
+		return exitSend
+	}
+
+	client.conn.SetReadDeadline(time.Now().Add(sendReplyWait))
+	defer client.conn.SetReadDeadline(time.Time{})
+	for {
+		line, err := client.readMessage()
+		line = strings.TrimSuffix(line, "\n")
+		if e, ok := parseServerError(line); ok {
+			complain(e.Text)
+			return exitSend
+		}
+		if strings.HasPrefix(line, "pong|"+sendDoneToken+"|") {
+			return 0
+		}
+		var timeout net.Error
+		if errors.As(err, &timeout) && timeout.Timeout() {
+			return 0
+		}
+		if err != nil {
+			complain(">>>>> 服务器断开了连接")
+			return exitDisconnected
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Achiyun/Golang-IM-System/internal/leakcheck"
+)
+
+var update = flag.Bool("update", false, "重新生成testdata里的golden文件")
+
+// 运行f, 返回它写到标准输出和标准错误的内容
+func captureOutput(t *testing.T, f func()) (string, string) {
+	t.Helper()
+	stdout, stderr := os.Stdout, os.Stderr
+	outR, outW, _ := os.Pipe()
+	errR, errW, _ := os.Pipe()
+	os.Stdout, os.Stderr = outW, errW
+	outC, errC := make(chan string), make(chan string)
+	go func() { b, _ := io.ReadAll(outR); outC <- string(b) }()
+	go func() { b, _ := io.ReadAll(errR); errC <- string(b) }()
+	defer func() { os.Stdout, os.Stderr = stdout, stderr }()
+	f()
+	outW.Close()
+	errW.Close()
+	return <-outC, <-errC
+}
+
+// 跟testdata里的golden文件逐字节比较, 加上 -update 时重新生成
+func checkGolden(t *testing.T, name string, got string) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(path, []byte(got), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != string(want) {
+		t.Fatalf("%s 不一致:\n--- got ---\n%s--- want ---\n%s", name, got, want)
+	}
+}
+
+func setQuiet(t *testing.T, on bool) {
+	old := quiet
+	quiet = on
+	t.Cleanup(func() { quiet = old })
+}
+
+// 脚本里用的一段会话: 标准输出上只有收到的消息, 控制消息不显示, 错误写到标准错误
+func TestQuietSessionGolden(t *testing.T) {
+	leakcheck.Check(t)
+	setQuiet(t, true)
+	serverSide, clientSide := net.Pipe()
+	defer serverSide.Close()
+	defer clientSide.Close()
+	go io.Copy(io.Discard, serverSide)
+	client := &Client{conn: clientSide, name: "alice", acked: make(map[string]bool)}
+
+	script := []string{
+		"hello|proto=1|frame=len|heartbeat=ping",
+		"rename-ok|alice",
+		"[#1]alice:已上线",
+		"[#2]bob:大家好",
+		"pm|1|bob|在吗",
+		"ack|m1",
+		"err|109|全员禁言中, 暂时不能发言",
+		"wholist|alice,bob",
+		"[#2]bob:下线",
+	}
+	stdout, stderr := captureOutput(t, func() {
+		for _, line := range script {
+			client.showLine(line)
+		}
+	})
+	checkGolden(t, "quiet_session.golden", stdout)
+	if stderr != "全员禁言中, 暂时不能发言\n" {
+		t.Fatalf("stderr = %q", stderr)
+	}
+}
+
+// 假的服务器: 读到消息后按reply回复, reply为空时不回复
+func sendWith(t *testing.T, hello map[string]string, reply func(line string) string) (int, string, string) {
+	t.Helper()
+	leakcheck.Check(t)
+	setQuiet(t, true)
+	serverSide, clientSide := net.Pipe()
+	defer serverSide.Close()
+	defer clientSide.Close()
+	client := &Client{conn: clientSide, reader: bufio.NewReader(clientSide), hello: hello}
+	go func() {
+		scanner := bufio.NewScanner(serverSide)
+		for scanner.Scan() {
+			if r := reply(scanner.Text()); r != "" {
+				io.WriteString(serverSide, r)
+			}
+		}
+	}()
+	var code int
+	stdout, stderr := captureOutput(t, func() { code = client.sendOnce("hi") })
+	return code, stdout, stderr
+}
+
+func TestSendOnceDone(t *testing.T) {
+	code, stdout, stderr := sendWith(t, map[string]string{"heartbeat": "ping"}, func(line string) string {
+		if strings.HasPrefix(line, "ping|") {
+			return "[#1]guest-1:hi\npong|" + sendDoneToken + "|1\n"
+		}
+		return ""
+	})
+	if code != 0 || stdout != "" || stderr != "" {
+		t.Fatalf("code=%d stdout=%q stderr=%q", code, stdout, stderr)
+	}
+}
+
+// 服务器回复了错误: 提示文字写到标准错误, 退出码是 exitSend
+func TestSendOnceServerError(t *testing.T) {
+	code, stdout, stderr := sendWith(t, map[string]string{"heartbeat": "ping"}, func(line string) string {
+		if line == "hi" {
+			return "err|109|全员禁言中, 暂时不能发言\n"
+		}
+		return ""
+	})
+	if code != exitSend || stdout != "" || stderr != "全员禁言中, 暂时不能发言\n" {
+		t.Fatalf("code=%d stdout=%q stderr=%q", code, stdout, stderr)
+	}
+}
+
+// 老版本的服务器不回复, 等满以后算发出去了
+func TestSendOnceLegacyServer(t *testing.T) {
+	code, _, stderr := sendWith(t, nil, func(string) string { return "" })
+	if code != 0 || stderr != "" {
+		t.Fatalf("code=%d stderr=%q", code, stderr)
+	}
+}
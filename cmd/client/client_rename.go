@@ -0,0 +1,155 @@
+// 改名要等server的结果: 成功时server回复 rename-ok|新用户名, 失败时回复 rename-err|原因
+// 老版本的server不认识 rename-reply 能力, 只会回复提示文字; 有改名在等时按legacyRenameReplies认出这些文字, 认不出时等到超时后保留原来的用户名
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// 改名最多等多久server的回复
+const renameTimeout = 3 * time.Second
+
+// server对一次改名的回复
+type renameResult struct {
+	name   string // 成功时是新用户名
+	reason string // 失败时是原因
+	ok     bool
+}
+
+// 老版本的server改名的回复文字(中文和英文), 成功时后面跟着新用户名
+var legacyRenameReplies = struct {
+	done  []string
+	taken []string
+}{
+	done:  []string{"您已经更新用户名:", "Your name is now:"},
+	taken: []string{"当前用户名被使用", "That name is already taken"},
+}
+
+// 正在等待的改名, 由读server消息的goroutine填入结果
+type renameWaiter struct {
+	lock    sync.Mutex
+	pending chan renameResult // 没有在等时为nil
+}
+
+// 开始等待一次改名的结果, 要在发送rename之前调用
+func (this *renameWaiter) start() chan renameResult {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	this.pending = make(chan renameResult, 1)
+	return this.pending
+}
+
+// 不再等待, 之后到达的结果按没有人在等处理
+func (this *renameWaiter) stop() {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	this.pending = nil
+}
+
+// 把结果交给正在等的改名, 没有人在等时返回false
+func (this *renameWaiter) deliver(r renameResult) bool {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if this.pending == nil {
+		return false
+	}
+	this.pending <- r
+	this.pending = nil
+	return true
+}
+
+// 是否有改名在等结果
+func (this *renameWaiter) waiting() bool {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	return this.pending != nil
+}
+
+// 解析改名的回复, 不是改名的回复时返回false
+func parseRenameReply(line string) (renameResult, bool) {
+	if name, ok := strings.CutPrefix(line, "rename-ok|"); ok {
+		return renameResult{name: name, ok: true}, true
+	}
+	if reason, ok := strings.CutPrefix(line, "rename-err|"); ok {
+		return renameResult{reason: reason}, true
+	}
+	return renameResult{}, false
+}
+
+// 老版本的server回复的提示文字, 不是改名的回复时返回false
+func parseLegacyRenameReply(line string) (renameResult, bool) {
+	for _, prefix := range legacyRenameReplies.done {
+		if name, ok := strings.CutPrefix(line, prefix); ok && name != "" {
+			return renameResult{name: name, ok: true}, true
+		}
+	}
+	for _, text := range legacyRenameReplies.taken {
+		if line == text {
+			return renameResult{reason: text}, true
+		}
+	}
+	return renameResult{}, false
+}
+
+// 有改名在等时认出老版本server的回复并交给它, 这一行照常显示
+func (client *Client) handleLegacyRenameReply(line string) {
+	if !client.renames.waiting() {
+		return
+	}
+	if r, ok := parseLegacyRenameReply(line); ok {
+		client.renames.deliver(r)
+	}
+}
+
+// 处理改名的回复: 有人在等时交给它, 否则(例如连接时 -name 的改名)直接生效
+func (client *Client) handleRenameReply(r renameResult) {
+	if client.renames.deliver(r) {
+		return
+	}
+	if r.ok {
+		client.setName(r.name)
+		return
+	}
+	client.display(">>>>> 改名失败: " + r.reason)
+}
+
+// 当前的用户名
+func (client *Client) Name() string {
+	client.nameLock.RLock()
+	defer client.nameLock.RUnlock()
+
+	return client.name
+}
+
+func (client *Client) setName(name string) {
+	client.nameLock.Lock()
+	defer client.nameLock.Unlock()
+
+	client.name = name
+}
+
+// 改名并等待server的结果, 只有成功时才更新用户名
+func (client *Client) rename(name string) (renameResult, error) {
+	result := client.renames.start()
+	if err := client.send("rename|" + name + "\n"); err != nil {
+		client.renames.stop()
+		return renameResult{}, err
+	}
+
+	select {
+	case r := <-result:
+		if r.ok {
+			client.setName(r.name)
+		}
+		return r, nil
+	case <-time.After(renameTimeout):
+		client.renames.stop()
+		return renameResult{reason: "没有收到服务器的回复"}, nil
+	}
+}
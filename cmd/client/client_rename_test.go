@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"testing"
+)
+
+// 收到rename请求后像server一样回复reply, 回复交给handleControl, 跟dealLines里一样
+func renameWith(t *testing.T, client *Client, name string, reply string) renameResult {
+	t.Helper()
+	serverSide, clientSide := net.Pipe()
+	defer serverSide.Close()
+	defer clientSide.Close()
+	client.conn = clientSide
+
+	go func() {
+		line, err := bufio.NewReader(serverSide).ReadString('\n')
+		if err != nil || line != "rename|"+name+"\n" || reply == "" {
+			return
+		}
+		client.handleControl(reply)
+	}()
+	r, err := client.rename(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestRenameAccepted(t *testing.T) {
+	client := &Client{name: "guest-1"}
+	r := renameWith(t, client, "bob", "rename-ok|bob")
+	if !r.ok || client.Name() != "bob" {
+		t.Fatalf("result=%+v name=%s", r, client.Name())
+	}
+}
+
+func TestRenameRejected(t *testing.T) {
+	client := &Client{name: "guest-1"}
+	r := renameWith(t, client, "bob", "rename-err|当前用户名被使用")
+	if r.ok || r.reason != "当前用户名被使用" || client.Name() != "guest-1" {
+		t.Fatalf("result=%+v name=%s", r, client.Name())
+	}
+}
+
+// 老版本的server只回复提示文字
+func TestRenameLegacyServer(t *testing.T) {
+	client := &Client{name: "guest-1"}
+	if r := renameWith(t, client, "bob", "您已经更新用户名:bob"); !r.ok || client.Name() != "bob" {
+		t.Fatalf("legacy ok: result=%+v name=%s", r, client.Name())
+	}
+	if r := renameWith(t, client, "alice", "当前用户名被使用"); r.ok || client.Name() != "bob" {
+		t.Fatalf("legacy taken: result=%+v name=%s", r, client.Name())
+	}
+	// 没有改名在等时提示文字只是显示, 不改用户名
+	if client.handleControl("您已经更新用户名:carol"); client.Name() != "bob" {
+		t.Fatalf("name = %s", client.Name())
+	}
+}
+
+func TestRenameTimeout(t *testing.T) {
+	client := &Client{name: "guest-1"}
+	if r := renameWith(t, client, "bob", ""); r.ok || client.Name() != "guest-1" {
+		t.Fatalf("result=%+v name=%s", r, client.Name())
+	}
+}
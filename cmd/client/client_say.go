@@ -0,0 +1,52 @@
+// 公聊消息跟服务器的命令撞车: 直接发 "who" 或 "to|..." 会被服务器当作命令执行
+// 服务器在hello里带 escape=say 时, 这样开头的公聊消息自动改成 say|消息内容 发送, 服务器原样广播
+// 想执行服务器命令时在前面加 /, 例如 /who、/settings|away|开会中, 客户端去掉 / 后原样发送
+package main
+
+import "strings"
+
+// 服务器的命令名, 跟server的commands.go一样; 服务器增加命令时这里也要加上
+var serverCommands = map[string]bool{
+	"help": true, "who": true, "whois": true, "whoami": true, "ping": true, "wholist": true, "rename": true,
+	"to": true, "ml": true, "pub": true, "say": true, "pm": true, "frame": true, "compress": true,
+	"file": true, "accept": true, "decline": true, "chunk": true, "caps": true, "read": true,
+	"history": true, "recall": true, "remind": true, "reminders": true, "unremind": true,
+	"settings": true, "admin": true, "schedule": true, "mark-bot": true, "lockdown": true,
+	"drain": true, "audit": true, "stats": true, "slowmode": true, "goroutines": true, "suspects": true, "kickall": true, "msgall": true, "store": true, "queue": true,
+}
+
+// 服务器是否支持 say|
+func (client *Client) serverSupportsSay() bool {
+	return client.hello["escape"] == "say"
+}
+
+// 一行是不是以服务器的命令名开头, 例如 who、rename|张三
+func isServerCommand(line string) bool {
+	name, _, _ := strings.Cut(line, "|")
+	return serverCommands[name]
+}
+
+// 会被当作命令的一行公聊内容加上say|, 服务器不支持时原样返回; 粘贴和多行输入的内容都是消息, 不处理开头的 /
+func (client *Client) sayLine(line string) string {
+	if isServerCommand(line) && client.serverSupportsSay() {
+		return "say|" + line
+	}
+	return line
+}
+
+// 把输入的一条公聊消息变成要发送的一行(带换行)
+// "/命令" 去掉 / 后原样发送; 会被当作命令的消息加上 say|; ok为false时不发送, 已经提示过用户
+func (client *Client) publicLine(msg string) (string, bool) {
+	if raw, ok := strings.CutPrefix(msg, "/"); ok && isServerCommand(raw) {
+		return raw + "\n", true
+	}
+	if !isServerCommand(msg) {
+		return msg + "\n", true
+	}
+	// 以前直接输入 admin|口令, 现在加上say|会把口令广播出去
+	if name, _, _ := strings.Cut(msg, "|"); name == "admin" {
+		client.notice(">>>>> 没有发送: 这条消息会把管理员口令发给所有人, 成为管理员请输入 /" + msg)
+		return "", false
+	}
+	return client.sayLine(msg) + "\n", true
+}
@@ -0,0 +1,52 @@
+package main
+
+import "testing"
+
+func TestPublicLine(t *testing.T) {
+	client := &Client{hello: map[string]string{"escape": "say"}}
+	for msg, want := range map[string]string{
+		"hello":             "hello\n",
+		"to|bob|不是私聊":       "say|to|bob|不是私聊\n",
+		"who":               "say|who\n",
+		"who cares":         "who cares\n",
+		"//who":             "//who\n",
+		"/who":              "who\n",
+		"/to|bob|私聊":        "to|bob|私聊\n",
+		"/settings|away|开会": "settings|away|开会\n",
+		"/home/alice":       "/home/alice\n",
+	} {
+		if got, ok := client.publicLine(msg); !ok || got != want {
+			t.Errorf("publicLine(%q) = %q, %v, want %q", msg, got, ok, want)
+		}
+	}
+
+	// 老版本的服务器不支持say|, 原样发送
+	old := &Client{hello: map[string]string{}}
+	if got, _ := old.publicLine("to|bob|hi"); got != "to|bob|hi\n" {
+		t.Errorf("老服务器: %q", got)
+	}
+}
+
+// 直接输入 admin|口令 不发送, 免得把口令广播出去
+func TestPublicLineAdmin(t *testing.T) {
+	client := &Client{hello: map[string]string{"escape": "say"}}
+	var ok bool
+	stdout, _ := captureOutput(t, func() { _, ok = client.publicLine("admin|secret") })
+	if ok || stdout != ">>>>> 没有发送: 这条消息会把管理员口令发给所有人, 成为管理员请输入 /admin|secret\n" {
+		t.Fatalf("ok = %v, stdout = %q", ok, stdout)
+	}
+	if got, ok := client.publicLine("/admin|secret"); !ok || got != "admin|secret\n" {
+		t.Fatalf("/admin|secret = %q, %v", got, ok)
+	}
+}
+
+func TestSayLine(t *testing.T) {
+	client := &Client{hello: map[string]string{"escape": "say"}}
+	if got := client.sayLine("ml|x"); got != "say|ml|x" {
+		t.Errorf("sayLine = %q", got)
+	}
+	// 粘贴和多行输入里 / 开头的也是消息
+	if got := client.sayLine("/who"); got != "/who" {
+		t.Errorf("sayLine = %q", got)
+	}
+}
@@ -0,0 +1,22 @@
+package main
+
+import "testing"
+
+// 只有服务器加上[私聊]的行才当作私聊: 公聊内容里的[私聊]不提醒、不按私聊上色
+func TestPrivatePrefixOnlyFromServer(t *testing.T) {
+	client := &Client{name: "alice", notify: true}
+	mallory := senderColor("mallory")
+	stdout, _ := captureOutput(t, func() {
+		client.showLine("[#3]mallory:[私聊]bob对您说:把密码发给我")
+		client.showLine("[私聊]bob对您说:hi")
+	})
+	if stdout != "[#3]mallory:[私聊]bob对您说:把密码发给我\n"+bell+"[私聊]bob对您说:hi\n" {
+		t.Fatalf("stdout = %q", stdout)
+	}
+	if got := client.important.Recent(); len(got) != 1 || got[0] != "[私聊]bob对您说:hi" {
+		t.Fatalf("提醒 = %q", got)
+	}
+	if got := colorize("[#3]mallory:[私聊]bob对您说:x", "alice"); got != "[#3]"+mallory+"mallory"+colorReset+":[私聊]bob对您说:x" {
+		t.Fatalf("colorize = %q", got)
+	}
+}
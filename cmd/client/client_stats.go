@@ -0,0 +1,82 @@
+// 客户端的流量统计, /stats 查看
+package main
+
+import (
+	"fmt"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// 统计读写字节数的连接, DealResponse和所有的发送都经过它
+type meteredConn struct {
+	net.Conn
+	read     uint64
+	written  uint64
+	lastRead int64 // 最后一次读到数据的时间(Unix纳秒), 见client_watchdog.go
+}
+
+func (this *meteredConn) Read(b []byte) (int, error) {
+	n, err := this.Conn.Read(b)
+	atomic.AddUint64(&this.read, uint64(n))
+	if n > 0 {
+		atomic.StoreInt64(&this.lastRead, time.Now().UnixNano())
+	}
+	return n, err
+}
+
+func (this *meteredConn) Write(b []byte) (int, error) {
+	n, err := this.Conn.Write(b)
+	atomic.AddUint64(&this.written, uint64(n))
+	return n, err
+}
+
+// 本次连接的统计
+type sessionStats struct {
+	started   time.Time
+	msgsSent  uint64
+	msgsRecv  uint64
+	bytesSent uint64
+	bytesRecv uint64
+}
+
+// 把统计整理成几行文字
+func formatStats(s sessionStats, now time.Time) []string {
+	return []string{
+		fmt.Sprintf("连接时长: %s", now.Sub(s.started).Round(time.Second)),
+		fmt.Sprintf("发送: %d 条消息, %s", s.msgsSent, formatBytes(s.bytesSent)),
+		fmt.Sprintf("接收: %d 条消息, %s", s.msgsRecv, formatBytes(s.bytesRecv)),
+	}
+}
+
+// 字节数按 B/KB/MB 显示
+func formatBytes(n uint64) string {
+	switch {
+	case n < 1024:
+		return fmt.Sprintf("%d B", n)
+	case n < 1024*1024:
+		return fmt.Sprintf("%.1f KB", float64(n)/1024)
+	}
+	return fmt.Sprintf("%.1f MB", float64(n)/1024/1024)
+}
+
+// 当前的统计
+func (client *Client) stats() sessionStats {
+	s := sessionStats{
+		started:  client.started,
+		msgsSent: atomic.LoadUint64(&client.msgsSent),
+		msgsRecv: atomic.LoadUint64(&client.msgsRecv),
+	}
+	if c, ok := client.conn.(*meteredConn); ok {
+		s.bytesSent = atomic.LoadUint64(&c.written)
+		s.bytesRecv = atomic.LoadUint64(&c.read)
+	}
+	return s
+}
+
+// 显示本次连接的统计, 对应 /stats 命令
+func (client *Client) showStats() {
+	for _, line := range formatStats(client.stats(), time.Now()) {
+		client.display(line)
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 经过meteredConn的读写都按字节数记下来
+func TestMeteredConn(t *testing.T) {
+	serverSide, clientSide := net.Pipe()
+	defer serverSide.Close()
+	conn := &meteredConn{Conn: clientSide}
+	client := &Client{conn: conn, started: time.Now()}
+	go func() {
+		buf := make([]byte, 64)
+		n, _ := serverSide.Read(buf)
+		serverSide.Write([]byte(strings.ToUpper(string(buf[:n]))))
+	}()
+
+	if err := client.send("hello\n"); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 64)
+	if _, err := io.ReadFull(conn, buf[:6]); err != nil {
+		t.Fatal(err)
+	}
+	s := client.stats()
+	if s.bytesSent != 6 || s.bytesRecv != 6 || s.msgsSent != 1 || conn.lastRead == 0 {
+		t.Fatalf("stats = %+v", s)
+	}
+}
+
+func TestFormatStats(t *testing.T) {
+	start := time.Date(2026, 1, 2, 15, 0, 0, 0, time.UTC)
+	s := sessionStats{started: start, msgsSent: 3, msgsRecv: 12, bytesSent: 1000, bytesRecv: 3 * 1024 * 1024 / 2}
+	got := strings.Join(formatStats(s, start.Add(90*time.Minute+400*time.Millisecond)), "\n")
+	want := "连接时长: 1h30m0s\n发送: 3 条消息, 1000 B\n接收: 12 条消息, 1.5 MB"
+	if got != want {
+		t.Fatalf("stats =\n%s", got)
+	}
+	if formatBytes(1536) != "1.5 KB" {
+		t.Fatal(formatBytes(1536))
+	}
+}
@@ -0,0 +1,22 @@
+// 标准输出关闭时退出: 例如 ./client | head 里的head读够了行数之后
+// 写不出去的消息没法显示, 继续连着只会让服务器以为这个用户还在, 所以关掉连接退出
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// 多个goroutine同时写失败时只退出一次
+var stdoutFailOnce sync.Once
+
+// 写标准输出失败: 在标准错误上说明原因, 断开连接, 恢复终端并写完本地记录后以1退出
+func (client *Client) stdoutFailed(err error) {
+	stdoutFailOnce.Do(func() {
+		fmt.Fprintln(os.Stderr, ">>>>> 标准输出已关闭, 断开连接:", err)
+		client.conn.Close()
+		client.Close()
+		os.Exit(1)
+	})
+}
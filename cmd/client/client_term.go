@@ -0,0 +1,78 @@
+// 客户端的终端设置和输入历史文件
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// 输入历史文件的文件名, 放在用户主目录下
+const inputHistoryFile = ".im_history"
+
+// 标准输入是不是终端
+func stdinIsTTY() bool {
+	info, err := os.Stdin.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// 执行stty修改终端设置
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+	return strings.TrimSpace(string(out)), err
+}
+
+// 让终端逐个字符把按键交给程序并关闭回显, 由行编辑器负责显示
+// 保留信号和输出换行处理, Ctrl+C 依然可以退出; 返回的函数恢复原来的设置
+func enableCbreak() (func(), error) {
+	saved, err := stty("-g")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := stty("-icanon", "-echo", "min", "1"); err != nil {
+		return nil, err
+	}
+	return func() { stty(saved) }, nil
+}
+
+// 输入历史文件的路径, 找不到主目录时返回空字符串
+func inputHistoryPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, inputHistoryFile)
+}
+
+// 读取输入历史, 文件不存在时返回空
+func loadInputHistory(path string) []string {
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+
+	var lines []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// 保存输入历史, 只有自己可以读写
+func saveInputHistory(path string, lines []string) {
+	if path == "" {
+		return
+	}
+	os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0600)
+}
@@ -0,0 +1,102 @@
+// -trace 文件: 把连接上收发的每个字节记到文件里, 开发机器人或者排查问题时使用, "-" 表示标准错误
+// 每次Read/Write记一行: 时间 方向 内容, <- 是收到的, -> 是发出的
+// 可打印的字符原样记录, 换行记成 \n, 反斜杠记成 \\, 其他字节记成 \x1b 这样的十六进制
+// 跟 -debug-protocol 不一样, 这里记的是原始字节, 按帧分帧时的长度前缀也能看到
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// 记录收发字节的连接, 只在调用返回之后记录, 不改变读写本身(一次读到多少就记多少)
+type traceConn struct {
+	net.Conn
+	lock sync.Mutex // 读和写在不同的goroutine里, 一次写完整的一行
+	out  io.Writer
+}
+
+func (this *traceConn) Read(b []byte) (int, error) {
+	n, err := this.Conn.Read(b)
+	if n > 0 {
+		this.record("<-", b[:n])
+	}
+	return n, err
+}
+
+func (this *traceConn) Write(b []byte) (int, error) {
+	n, err := this.Conn.Write(b)
+	if n > 0 {
+		this.record("->", b[:n])
+	}
+	return n, err
+}
+
+func (this *traceConn) record(dir string, b []byte) {
+	line := traceLine(time.Now(), dir, b)
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	io.WriteString(this.out, line)
+}
+
+// 一次读写记成一行
+func traceLine(now time.Time, dir string, b []byte) string {
+	return now.Format("15:04:05.000") + " " + dir + " " + escapeTrace(b) + "\n"
+}
+
+// 转义不可打印的字节, 结果只有一行
+func escapeTrace(b []byte) string {
+	var sb strings.Builder
+	for len(b) > 0 {
+		r, size := utf8.DecodeRune(b)
+		switch {
+		case r == '\n':
+			sb.WriteString(`\n`)
+		case r == '\\':
+			sb.WriteString(`\\`)
+		case r == utf8.RuneError && size == 1, !unicode.IsPrint(r) && r != ' ':
+			for _, c := range b[:size] {
+				fmt.Fprintf(&sb, `\x%02x`, c)
+			}
+		default:
+			sb.Write(b[:size])
+		}
+		b = b[size:]
+	}
+	return sb.String()
+}
+
+// 开启 -trace, 要在读写连接之前调用; 返回的Closer在退出时关闭文件
+func (client *Client) enableTrace(path string) (io.Closer, error) {
+	var out io.WriteCloser = nopCloser{os.Stderr}
+	if path != "-" {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			return nil, err
+		}
+		out = file
+	}
+
+	// 放在meteredConn下面, /stats 的统计不受影响
+	if m, ok := client.conn.(*meteredConn); ok {
+		m.Conn = &traceConn{Conn: m.Conn, out: out}
+	} else {
+		client.conn = &traceConn{Conn: client.conn, out: out}
+	}
+	return out, nil
+}
+
+// 标准错误不用关闭
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEscapeTrace(t *testing.T) {
+	for in, want := range map[string]string{
+		"hello|proto=1\n":  `hello|proto=1\n`,
+		"你好 \\ 世界":         `你好 \\ 世界`,
+		"\x00\x00\x00\x05": `\x00\x00\x00\x05`,
+		"\x1b[31m红\r\n":    `\x1b[31m红\x0d\n`,
+		"\xff\xfe":         `\xff\xfe`,
+	} {
+		if got := escapeTrace([]byte(in)); got != want {
+			t.Errorf("escapeTrace(%q) = %q, want %q", in, got, want)
+		}
+	}
+	at := time.Date(2026, 1, 2, 15, 4, 5, 6e6, time.UTC)
+	if got := traceLine(at, "<-", []byte("hi\n")); got != `15:04:05.006 <- hi\n`+"\n" {
+		t.Fatalf("traceLine = %q", got)
+	}
+}
+
+// 收发的原始字节都记到文件里, 放在meteredConn下面
+func TestEnableTrace(t *testing.T) {
+	serverSide, clientSide := net.Pipe()
+	defer serverSide.Close()
+	path := filepath.Join(t.TempDir(), "trace.log")
+	client := &Client{conn: &meteredConn{Conn: clientSide}}
+	closer, err := client.enableTrace(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go serverSide.Write([]byte("hello|proto=1\n"))
+	buf := make([]byte, 64)
+	n, _ := client.conn.Read(buf)
+	go io.ReadFull(serverSide, make([]byte, 6))
+	client.conn.Write([]byte("who\r\n\x00"))
+	closer.Close()
+
+	data, _ := os.ReadFile(path)
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if n != 14 || len(lines) != 2 || !strings.HasSuffix(lines[0], ` <- hello|proto=1\n`) || !strings.HasSuffix(lines[1], ` -> who\x0d\n\x00`) {
+		t.Fatalf("trace:\n%s", data)
+	}
+	if _, ok := client.conn.(*meteredConn).Conn.(*traceConn); !ok {
+		t.Fatal("trace 没有放在meteredConn下面")
+	}
+}
@@ -0,0 +1,35 @@
+// -transcript: 把收到的消息同时写成一个HTML文件, 渲染和写文件见transcript包
+// 这里按跟colorize一样的规则判断每一行是系统消息、私聊还是谁发的公聊
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Achiyun/Golang-IM-System/cmd/client/transcript"
+)
+
+// 把收到的一行消息变成HTML聊天记录的一行
+func transcriptLine(t time.Time, line string) transcript.Line {
+	for _, prefix := range systemPrefixes {
+		if strings.HasPrefix(line, prefix) {
+			return transcript.Line{Time: t, Kind: transcript.System, Text: line}
+		}
+	}
+	if strings.HasPrefix(line, privatePrefix) {
+		return transcript.Line{Time: t, Kind: transcript.Private, Text: line}
+	}
+	if head, name, text, ok := splitPublic(line); ok {
+		return transcript.Line{Time: t, Kind: transcript.Public, Text: head, Name: name, Body: text, Color: senderIndex(name)}
+	}
+	return transcript.Line{Time: t, Kind: transcript.Plain, Text: line}
+}
+
+// 写完HTML聊天记录的结尾
+func (client *Client) closeTranscript() {
+	if dropped := client.transcript.Close(); dropped > 0 {
+		fmt.Fprintln(os.Stderr, "HTML聊天记录丢弃了", dropped, "条消息")
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Achiyun/Golang-IM-System/cmd/client/transcript"
+)
+
+// 按跟终端颜色一样的规则分类, 公聊的发送方颜色跟终端一样
+func TestTranscriptLine(t *testing.T) {
+	now := time.Now()
+	for _, c := range []struct {
+		line string
+		want transcript.Line
+	}{
+		{"[系统] 维护", transcript.Line{Kind: transcript.System, Text: "[系统] 维护"}},
+		{"[已读] bob 已读", transcript.Line{Kind: transcript.System, Text: "[已读] bob 已读"}},
+		{"[私聊]bob对您说:hi", transcript.Line{Kind: transcript.Private, Text: "[私聊]bob对您说:hi"}},
+		{"[#2]bob:a:b", transcript.Line{Kind: transcript.Public, Text: "[#2]", Name: "bob", Body: "a:b", Color: senderIndex("bob")}},
+		{"当前在线 2 人:", transcript.Line{Kind: transcript.Plain, Text: "当前在线 2 人:"}},
+	} {
+		c.want.Time = now
+		if got := transcriptLine(now, c.line); got != c.want {
+			t.Errorf("transcriptLine(%q) = %+v, want %+v", c.line, got, c.want)
+		}
+	}
+	if len(transcript.Palette) != len(senderPalette) {
+		t.Fatalf("网页有 %d 种颜色, 终端有 %d 种", len(transcript.Palette), len(senderPalette))
+	}
+}
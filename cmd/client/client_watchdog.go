@@ -0,0 +1,85 @@
+// 连接看门狗: 网络中间断了但TCP连接没有被重置时, 客户端会一直以为还连着, 发的消息都没有送到
+// 每检查一次看看最后一次收到数据是多久以前, 收到任何数据(包括pong)都算
+// 超过 -watchdog 没有数据时悄悄发一个ping试探; 超过2倍时提示连接可能已中断并再发一个ping;
+// 再过一个 -watchdog 还是没有数据就当作已经断开, 关闭连接, 按断线处理; 不会自动重连, 提示用户重新启动客户端
+// 需要服务器支持ping(hello里有 heartbeat=ping), 连接老版本的服务器时不检查
+package main
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// 默认的检查间隔, 0表示不检查
+const defaultWatchdog = 30 * time.Second
+
+// 看门狗发的ping用这个token, 回复不显示延迟
+const watchdogToken = "watchdog"
+
+// 服务器是否支持用ping试探
+func (client *Client) serverSupportsHeartbeat() bool {
+	return client.hello["heartbeat"] == "ping"
+}
+
+// 最后一次从服务器收到数据的时间
+func (client *Client) lastRecv() time.Time {
+	if c, ok := client.conn.(*meteredConn); ok {
+		if ns := atomic.LoadInt64(&c.lastRead); ns != 0 {
+			return time.Unix(0, ns)
+		}
+	}
+	return client.started
+}
+
+// 看门狗的状态
+const (
+	watchdogOK      = iota // 最近收到过数据
+	watchdogProbing        // 发了ping, 等回复
+	watchdogWarned         // 已经提示过连接可能中断
+)
+
+// 按没有收到数据的时长决定下一步, interval是 -watchdog
+func watchdogState(silence time.Duration, interval time.Duration) int {
+	switch {
+	case silence >= 3*interval:
+		return -1 // 当作已经断开
+	case silence >= 2*interval:
+		return watchdogWarned
+	case silence >= interval:
+		return watchdogProbing
+	}
+	return watchdogOK
+}
+
+// 定期检查连接, 连接关闭或者判断已经断开后退出
+func (client *Client) watchdog(interval time.Duration, done <-chan struct{}) {
+	// 检查间隔是 -watchdog 的1/4, 所以判断断开最多晚1/4
+	ticker := time.NewTicker(interval / 4)
+	defer ticker.Stop()
+
+	state := watchdogOK
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			next := watchdogState(now.Sub(client.lastRecv()), interval)
+			switch {
+			case next == -1:
+				client.display(">>>>> ⚠ 服务器 " + (3 * interval).String() + " 没有回应, 已断开连接, 不会自动重连, 请重新启动客户端")
+				client.conn.Close()
+				return
+			case next == state:
+				continue
+			case next == watchdogProbing:
+				client.send("ping|" + watchdogToken + "\n")
+			case next == watchdogWarned:
+				client.display(">>>>> ⚠ 与服务器的连接可能已中断")
+				client.send("ping|" + watchdogToken + "\n")
+			case next == watchdogOK && state == watchdogWarned:
+				client.display(">>>>> 与服务器的连接已恢复")
+			}
+			state = next
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWatchdogState(t *testing.T) {
+	interval := 30 * time.Second
+	for _, c := range []struct {
+		silence time.Duration
+		want    int
+	}{
+		{0, watchdogOK},
+		{29 * time.Second, watchdogOK},
+		{30 * time.Second, watchdogProbing},
+		{60 * time.Second, watchdogWarned},
+		{89 * time.Second, watchdogWarned},
+		{90 * time.Second, -1},
+	} {
+		if got := watchdogState(c.silence, interval); got != c.want {
+			t.Errorf("watchdogState(%s) = %d, want %d", c.silence, got, c.want)
+		}
+	}
+}
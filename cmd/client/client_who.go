@@ -0,0 +1,98 @@
+// 客户端显示who的回复: 收集 who-begin 和 who-end 之间的列表, 整理成表格
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// who列表中的一个用户, 原始格式: {地址}用户名:在线... 或 {地址}用户名:离开(原因)...
+type whoLine struct {
+	Name   string
+	Addr   string
+	Status string
+}
+
+// 解析who列表中的一行, 格式不对时返回false
+func parseWhoLine(line string) (whoLine, bool) {
+	if !strings.HasPrefix(line, "{") {
+		return whoLine{}, false
+	}
+	end := strings.Index(line, "}")
+	if end < 0 {
+		return whoLine{}, false
+	}
+	rest := line[end+1:]
+	// 用户名和离开原因里都可能有':', 按状态的固定写法查找
+	i := strings.LastIndex(rest, ":在线...")
+	if i < 0 {
+		i = strings.LastIndex(rest, ":离开(")
+	}
+	if i < 0 {
+		return whoLine{}, false
+	}
+	return whoLine{
+		Name:   rest[:i],
+		Addr:   line[1:end],
+		Status: strings.TrimSuffix(rest[i+1:], "..."),
+	}, true
+}
+
+// 按显示宽度补齐空格, 中文占两列
+func padRight(s string, width int) string {
+	if w := textWidth([]rune(s)); w < width {
+		return s + strings.Repeat(" ", width-w)
+	}
+	return s
+}
+
+// 把who列表整理成表格, 每行一个字符串
+func formatWhoTable(users []whoLine, myName string) []string {
+	header := whoLine{Name: "用户名", Addr: "地址", Status: "状态"}
+	nameWidth, addrWidth := textWidth([]rune(header.Name)), textWidth([]rune(header.Addr))
+	for _, u := range users {
+		if w := textWidth([]rune(u.Name)); w > nameWidth {
+			nameWidth = w
+		}
+		if w := textWidth([]rune(u.Addr)); w > addrWidth {
+			addrWidth = w
+		}
+	}
+
+	sort.Slice(users, func(i, j int) bool { return users[i].Name < users[j].Name })
+
+	lines := []string{"当前在线 " + strconv.Itoa(len(users)) + " 人:"}
+	for _, u := range append([]whoLine{header}, users...) {
+		status := u.Status
+		if u.Name == myName {
+			status += " (我)"
+		}
+		lines = append(lines, "  "+padRight(u.Name, nameWidth)+"  "+padRight(u.Addr, addrWidth)+"  "+status)
+	}
+	return lines
+}
+
+// 处理who相关的行, 返回true表示这一行已经处理, 不需要再显示
+// 列表中间夹着的其他消息照常显示
+func (client *Client) collectWho(line string) bool {
+	if line == "who-begin" {
+		client.whoUsers = []whoLine{}
+		return true
+	}
+	if client.whoUsers == nil {
+		return false
+	}
+	if strings.HasPrefix(line, "who-end|") {
+		for _, text := range formatWhoTable(client.whoUsers, client.Name()) {
+			client.display(text)
+		}
+		client.whoUsers = nil
+		return true
+	}
+	if u, ok := parseWhoLine(line); ok {
+		client.whoUsers = append(client.whoUsers, u)
+		return true
+	}
+	return false
+}
@@ -0,0 +1,43 @@
+package main
+
+import "testing"
+
+// 连接后的第一份 who|json 显示成在线名单, 之后的回复只更新补全用的名单
+func TestJoinRoster(t *testing.T) {
+	joined := make(chan struct{})
+	client := &Client{name: "alice", joinRoster: joined}
+	reply := `[{"session":1,"name":"alice"},{"session":2,"name":"bob"}]`
+
+	stdout, _ := captureOutput(t, func() {
+		client.showLine(reply)
+		client.showLine(reply)
+	})
+	if stdout != "当前在线:\n  alice (我)\n  bob\n" {
+		t.Fatalf("stdout = %q", stdout)
+	}
+	select {
+	case <-joined:
+	default:
+		t.Fatal("显示名单以后没有通知")
+	}
+	if client.roster.Address("bob") != "#2" {
+		t.Fatal("名单没有更新")
+	}
+}
+
+func TestWhoTable(t *testing.T) {
+	client := &Client{name: "alice"}
+	stdout, _ := captureOutput(t, func() {
+		for _, line := range []string{"who-begin", "{#2}bob:离开(午饭)...", "[#3]carol:hi", "{#1}alice:在线...", "who-end|2"} {
+			client.showLine(line)
+		}
+	})
+	want := "[#3]carol:hi\n" +
+		"当前在线 2 人:\n" +
+		"  用户名  地址  状态\n" +
+		"  alice   #1    在线 (我)\n" +
+		"  bob     #2    离开(午饭)\n"
+	if stdout != want {
+		t.Fatalf("stdout = %q", stdout)
+	}
+}
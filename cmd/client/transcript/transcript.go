@@ -0,0 +1,191 @@
+// Package transcript 把聊天消息写成一个HTML文件, 方便分享聊天记录
+// 文件不依赖外部的样式和脚本, 直接用浏览器打开; 消息内容、用户名和标题都经过转义
+// 写的过程中定期刷到文件里, 浏览器也能打开, Close时补上结尾
+// 哪一行是系统消息、私聊还是谁发的公聊由调用方判断, 这里只负责渲染
+package transcript
+
+import (
+	"bufio"
+	"fmt"
+	"html"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// 写入文件前最多缓存多少行, 写文件跟不上时丢弃
+const queueSize = 1024
+
+// 多久刷一次文件
+const flushInterval = 2 * time.Second
+
+// 消息的种类, 决定显示的样式
+type Kind int
+
+const (
+	Plain   Kind = iota // 其他消息, 原样显示
+	System              // 系统消息
+	Private             // 私聊
+	Public              // 公聊, 发送方按Color上色
+)
+
+// 发送方的网页颜色, 跟客户端终端的调色板一一对应
+var Palette = []string{
+	"#c62828", // 红
+	"#2e7d32", // 绿
+	"#1565c0", // 蓝
+	"#00838f", // 青
+	"#ff5252", // 亮红
+	"#00c853", // 亮绿
+	"#448aff", // 亮蓝
+	"#00b8d4", // 亮青
+}
+
+// 一条消息
+type Line struct {
+	Time time.Time
+	Kind Kind
+	Text string // 整行内容; 公聊时是发送方前面的部分, 例如 [#3]
+
+	// 只有公聊用到
+	Name  string // 发送方
+	Body  string // 发送方后面的消息内容
+	Color int    // 发送方在Palette中的位置
+}
+
+const head = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>%s</title>
+<style>
+body { font-family: monospace; margin: 1em 2em; color: #222; background: #fff; }
+div { white-space: pre-wrap; margin: 2px 0; }
+.time { color: #999; }
+.sys { color: #b8860b; }
+.pm { color: #8e24aa; }
+</style>
+</head>
+<body>
+<h3>%s</h3>
+`
+
+const tail = "</body>\n</html>\n"
+
+// 文件的开头, 标题会被转义
+func Head(title string) string {
+	title = html.EscapeString(title)
+	return fmt.Sprintf(head, title, title)
+}
+
+// 把一条消息转换成HTML的一行
+func Render(l Line) string {
+	stamp := `<span class="time">` + l.Time.Format("15:04:05") + `</span> `
+	switch l.Kind {
+	case System:
+		return `<div class="sys">` + stamp + html.EscapeString(l.Text) + "</div>\n"
+	case Private:
+		return `<div class="pm">` + stamp + html.EscapeString(l.Text) + "</div>\n"
+	case Public:
+		color := Palette[l.Color%len(Palette)]
+		return "<div>" + stamp + html.EscapeString(l.Text) +
+			`<b style="color: ` + color + `">` + html.EscapeString(l.Name) + "</b>:" +
+			html.EscapeString(l.Body) + "</div>\n"
+	}
+	return "<div>" + stamp + html.EscapeString(l.Text) + "</div>\n"
+}
+
+// HTML聊天记录, 由单独的goroutine写文件
+type Writer struct {
+	out   io.WriteCloser
+	lines chan string
+	done  chan struct{}
+
+	lock    sync.Mutex
+	closed  bool
+	dropped int // 因为队列满被丢弃的行数
+}
+
+// 创建HTML文件并写好开头, 已经存在的文件会被覆盖
+func Open(path string, title string) (*Writer, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, err
+	}
+	w, err := NewWriter(file, title)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// 在out上写聊天记录, Close时关闭out
+func NewWriter(out io.WriteCloser, title string) (*Writer, error) {
+	if _, err := io.WriteString(out, Head(title)); err != nil {
+		return nil, err
+	}
+	w := &Writer{
+		out:   out,
+		lines: make(chan string, queueSize),
+		done:  make(chan struct{}),
+	}
+	go w.run()
+	return w, nil
+}
+
+// 记录一条消息, 不会阻塞调用方
+func (this *Writer) Write(l Line) {
+	row := Render(l)
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if this.closed {
+		return
+	}
+	select {
+	case this.lines <- row:
+	default:
+		this.dropped++
+	}
+}
+
+func (this *Writer) run() {
+	w := bufio.NewWriter(this.out)
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case row, ok := <-this.lines:
+			if !ok {
+				w.WriteString(tail)
+				w.Flush()
+				close(this.done)
+				return
+			}
+			w.WriteString(row)
+		case <-ticker.C:
+			w.Flush()
+		}
+	}
+}
+
+// 写完剩下的消息和结尾后关闭文件, 返回因为队列满丢弃了多少条; 可以重复调用, 之后的调用返回0
+func (this *Writer) Close() int {
+	this.lock.Lock()
+	if this.closed {
+		this.lock.Unlock()
+		return 0
+	}
+	this.closed = true
+	close(this.lines)
+	dropped := this.dropped
+	this.lock.Unlock()
+
+	<-this.done
+	this.out.Close()
+	return dropped
+}
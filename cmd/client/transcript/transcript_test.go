@@ -0,0 +1,148 @@
+package transcript
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Achiyun/Golang-IM-System/internal/leakcheck"
+)
+
+var update = flag.Bool("update", false, "重新生成testdata里的golden文件")
+
+// 跟testdata里的golden文件逐字节比较, 加上 -update 时重新生成
+func checkGolden(t *testing.T, name string, got string) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(path, []byte(got), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != string(want) {
+		t.Fatalf("%s 不一致:\n--- got ---\n%s--- want ---\n%s", name, got, want)
+	}
+}
+
+// 写一个聊天记录文件, 返回文件的内容
+func writeTranscript(t *testing.T, title string, lines []Line) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "chat.html")
+	w, err := Open(path, title)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, l := range lines {
+		w.Write(l)
+	}
+	if dropped := w.Close(); dropped != 0 {
+		t.Fatalf("丢弃了 %d 条", dropped)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+var start = time.Date(2026, 10, 15, 9, 30, 0, 0, time.UTC)
+
+func TestSession(t *testing.T) {
+	got := writeTranscript(t, "聊天记录 lobby 2026-10-15 09:30", []Line{
+		{Time: start, Kind: System, Text: "[系统] 维护 十分钟后开始"},
+		{Time: start.Add(time.Second), Kind: Public, Text: "[#2]", Name: "bob", Body: "大家好", Color: 1},
+		{Time: start.Add(2 * time.Second), Kind: Public, Text: "[#3]", Name: "carol", Body: "hi bob", Color: 6},
+		{Time: start.Add(3 * time.Second), Kind: Private, Text: "[私聊]bob对您说:午饭?"},
+		{Time: start.Add(4 * time.Second), Kind: Plain, Text: "当前在线 3 人:"},
+	})
+	checkGolden(t, "session.html", got)
+}
+
+// 用户能控制的内容都经过转义, 写不进标签和脚本
+func TestEscape(t *testing.T) {
+	got := writeTranscript(t, `<script>alert("title")</script>`, []Line{
+		{Time: start, Kind: Public, Text: `[#2]`, Name: `"><img src=x onerror=alert(1)>`, Body: `<script>alert('xss')</script>`, Color: 0},
+		{Time: start, Kind: Private, Text: `[私聊]eve对您说:</div><a href="javascript:alert(1)">点我</a>`},
+		{Time: start, Kind: System, Text: `[系统] <b>&amp;</b>`},
+		{Time: start, Kind: Plain, Text: `<style>body{display:none}</style>`},
+	})
+	checkGolden(t, "escape.html", got)
+
+	body := strings.TrimPrefix(got, Head(`<script>alert("title")</script>`))
+	for _, bad := range []string{"<script", "<img", "<a ", "<style", "<b>"} {
+		if strings.Contains(body, bad) {
+			t.Errorf("聊天记录里出现了 %q", bad)
+		}
+	}
+	if strings.Contains(Head(`<script>alert("title")</script>`), "<script") {
+		t.Error("标题没有转义")
+	}
+}
+
+// 写完开头以后卡住的输出, release以后才写得进去
+type stalledFile struct {
+	strings.Builder
+	release chan struct{}
+	writes  int
+	closed  bool
+}
+
+func (this *stalledFile) Write(p []byte) (int, error) {
+	if this.writes++; this.writes > 1 {
+		<-this.release
+	}
+	return this.Builder.Write(p)
+}
+
+func (this *stalledFile) Close() error {
+	this.closed = true
+	return nil
+}
+
+// 文件写不动时Write不阻塞, 丢掉的条数由Close返回; Close以后再写和再关闭都没有影响
+func TestWriterDrops(t *testing.T) {
+	leakcheck.Check(t)
+	out := &stalledFile{release: make(chan struct{})}
+	w, err := NewWriter(out, "stalled")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const total = 2000
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < total; i++ {
+			w.Write(Line{Time: start, Text: "line"})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Write阻塞了")
+	}
+
+	close(out.release)
+	dropped := w.Close()
+	if dropped == 0 {
+		t.Fatal("没有丢弃")
+	}
+	if rows := strings.Count(out.String(), "<div>"); rows+dropped != total {
+		t.Fatalf("写了 %d 条, 丢弃 %d 条, 一共 %d 条", rows, dropped, total)
+	}
+	if !out.closed || !strings.HasSuffix(out.String(), tail) {
+		t.Fatal("没有写完结尾并关闭")
+	}
+
+	w.Write(Line{Time: start, Text: "after close"})
+	if w.Close() != 0 || strings.Contains(out.String(), "after close") {
+		t.Fatal("关闭以后还在写")
+	}
+}
@@ -0,0 +1,159 @@
+// 客户端可以发送的命令, 格式: 命令名 或 命令名|参数
+// DoMessage 按这张表分发, help 也按这张表生成, 新增命令只需要在这里加一项
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// 命令的参数要求
+const (
+	argNone     = iota // 不带参数, 如 who
+	argOptional        // 可以带参数, 如 settings 和 settings|away|开会中
+	argRequired        // 必须带参数, 如 rename|张三
+)
+
+type command struct {
+	Name  string
+	Usage string // 用法, 显示在help里, 不翻译
+	Arg   int
+	Admin bool // 只有管理员能用, 不是管理员时help里不显示, 权限仍由处理函数检查
+
+	// 不算用户活跃, 例如客户端看门狗自动发的ping; 不然开着客户端不说话的用户永远不会被提醒和踢掉
+	NoActivity bool
+
+	// Admin的命令是否对所有人开放, 为nil表示不开放
+	Public func(s *Server) bool
+
+	// 功能是否开启, 为nil表示一直开启, 没开启时help里不显示
+	Enabled func(s *Server) bool
+
+	Run func(u *User, arg string)
+}
+
+// 全部命令, 按help里显示的顺序排列
+// 说明在catalog里: help.命令名 是一行的简介, help.命令名.long 是 help|命令名 显示的详细说明
+var commands []command
+
+func init() {
+	commands = []command{
+		{Name: "help", Usage: "help[|<command>]", Arg: argOptional, Run: (*User).DoHelp},
+		{Name: "who", Usage: "who[|json|idle]", Arg: argOptional, Run: func(u *User, arg string) {
+			switch arg {
+			case "json":
+				u.DoWhoJSON()
+			case "idle":
+				u.DoWhoIdle()
+			default:
+				u.DoWho()
+			}
+		}},
+		{Name: "whois", Usage: "whois|<name>", Arg: argRequired, Run: (*User).DoWhois},
+		{Name: "whoami", Usage: "whoami", Arg: argNone, Run: func(u *User, arg string) { u.DoWhoami() }},
+		{Name: "ping", Usage: "ping|<token>", Arg: argRequired, NoActivity: true, Run: (*User).DoPing},
+		{Name: "wholist", Usage: "wholist", Arg: argNone, Run: func(u *User, arg string) { u.DoWholist() }},
+		{Name: "rename", Usage: "rename|<name>", Arg: argRequired, Run: (*User).DoRename},
+		{Name: "to", Usage: "to|<name>|<text>[|<id>]", Arg: argRequired, Run: (*User).DoPrivate},
+		{Name: "ml", Usage: "ml|<line>\\n<line>", Arg: argRequired, Run: (*User).DoMultiline},
+		{Name: "pub", Usage: "pub|<id>|<text>", Arg: argRequired, Run: (*User).DoPublish},
+		{Name: "say", Usage: "say|<text>", Arg: argRequired, Run: func(u *User, arg string) { u.DoPublic(arg) }},
+		{Name: "pm", Usage: "pm|allowlist|allow|deny|<value>", Arg: argRequired, Run: (*User).DoPM},
+		{Name: "frame", Usage: "frame|len", Arg: argRequired, Run: (*User).DoFrame},
+		{Name: "compress", Usage: "compress|deflate", Arg: argRequired, Run: (*User).DoCompress},
+		{Name: "file", Usage: "file|<name>|<file>|<size>", Arg: argRequired, Run: (*User).DoFile},
+		{Name: "accept", Usage: "accept|<id>", Arg: argRequired, Run: (*User).DoAccept},
+		{Name: "decline", Usage: "decline|<id>", Arg: argRequired, Run: (*User).DoDecline},
+		{Name: "chunk", Usage: "chunk|<id>|<base64>", Arg: argRequired, Run: (*User).DoChunk},
+		{Name: "caps", Usage: "caps|<cap>,...", Arg: argRequired, Run: (*User).DoCaps},
+		{Name: "read", Usage: "read|<seq>", Arg: argRequired, Run: (*User).DoRead},
+		{Name: "history", Usage: "history|<n>", Arg: argRequired, Run: (*User).DoHistory},
+		{Name: "recall", Usage: "recall[|<seq>]", Arg: argOptional, Run: (*User).DoRecall},
+		{Name: "remind", Usage: "remind|<delay>|<text>", Arg: argRequired, Run: func(u *User, arg string) { u.DoRemind(arg, false) }},
+		{Name: "reminders", Usage: "reminders", Arg: argNone, Run: func(u *User, arg string) { u.DoReminders() }},
+		{Name: "unremind", Usage: "unremind|<id>", Arg: argRequired, Run: (*User).DoUnremind},
+		{Name: "settings", Usage: "settings[|<key>|<value>]", Arg: argOptional, Run: (*User).DoSettings},
+		{Name: "admin", Usage: "admin|<token>", Arg: argRequired, Run: (*User).DoAdmin,
+			Enabled: func(s *Server) bool { return s.AdminToken != "" }},
+		{Name: "schedule", Usage: "schedule|<delay>|<text>", Arg: argRequired, Admin: true, Run: func(u *User, arg string) { u.DoRemind(arg, true) }},
+		{Name: "mark-bot", Usage: "mark-bot|<name>", Arg: argRequired, Admin: true, Run: (*User).DoMarkBot},
+		{Name: "kickall", Usage: "kickall|<glob>[|confirm]", Arg: argRequired, Admin: true, Run: (*User).DoKickAll},
+		{Name: "msgall", Usage: "msgall|<glob>|<text>[|confirm]", Arg: argRequired, Admin: true, Run: (*User).DoMsgAll},
+		{Name: "lockdown", Usage: "lockdown|on|off", Arg: argRequired, Admin: true, Run: (*User).DoLockdown},
+		{Name: "drain", Usage: "drain|on|off", Arg: argRequired, Admin: true, Run: (*User).DoDrain},
+		{Name: "audit", Usage: "audit|tail|<n>", Arg: argRequired, Admin: true, Run: (*User).DoAudit},
+		{Name: "stats", Usage: "stats[|json]", Arg: argOptional, Admin: true, Run: (*User).DoStats,
+			Public: func(s *Server) bool { return s.PublicStats }},
+		{Name: "slowmode", Usage: "slowmode|<seconds>", Arg: argRequired, Admin: true, Run: (*User).DoSlowMode},
+		{Name: "goroutines", Usage: "goroutines", Arg: argNone, Admin: true, Run: func(u *User, arg string) { u.DoGoroutines() }},
+		{Name: "queue", Usage: "queue|<name>[|flush|kick]", Arg: argRequired, Admin: true, Run: func(u *User, arg string) { u.DoQueue(arg) }},
+		{Name: "store", Usage: "store|stats|purge", Arg: argRequired, Admin: true, Run: func(u *User, arg string) { u.DoStore(arg) }},
+		{Name: "suspects", Usage: "suspects[|clear|IP]", Arg: argOptional, Admin: true, Run: func(u *User, arg string) { u.DoSuspects(arg) }},
+	}
+}
+
+// 按消息找到对应的命令和参数, 不是命令时返回false, 作为公聊消息处理
+func findCommand(msg string) (*command, string, bool) {
+	name, arg, hasArg := strings.Cut(msg, "|")
+	for i := range commands {
+		cmd := &commands[i]
+		if cmd.Name != name {
+			continue
+		}
+		switch cmd.Arg {
+		case argNone:
+			return cmd, "", !hasArg
+		case argRequired:
+			return cmd, arg, hasArg && arg != ""
+		default:
+			return cmd, arg, !hasArg || arg != ""
+		}
+	}
+	return nil, "", false
+}
+
+// 当前用户能看到的命令
+func (this *User) availableCommands() []*command {
+	list := make([]*command, 0, len(commands))
+	for i := range commands {
+		cmd := &commands[i]
+		if cmd.Admin && !this.IsAdmin() && (cmd.Public == nil || !cmd.Public(this.server)) {
+			continue
+		}
+		if cmd.Enabled != nil && !cmd.Enabled(this.server) {
+			continue
+		}
+		list = append(list, cmd)
+	}
+	return list
+}
+
+// 命令列表, 消息格式: help 列出能用的命令, help|命令名 显示一条命令的详细说明
+// 只回复给发送的用户
+func (this *User) DoHelp(arg string) {
+	list := this.availableCommands()
+
+	if arg != "" {
+		for _, cmd := range list {
+			if cmd.Name == arg {
+				this.SendMsg(cmd.Usage + "\n")
+				this.SendMsg(t(this, "help."+cmd.Name+".long") + "\n")
+				return
+			}
+		}
+		this.protocolError(ErrNotFound, "help.unknown", arg)
+		return
+	}
+
+	width := 0
+	for _, cmd := range list {
+		if len(cmd.Usage) > width {
+			width = len(cmd.Usage)
+		}
+	}
+	this.SendMsg(t(this, "help.header") + "\n")
+	for _, cmd := range list {
+		this.SendMsg(fmt.Sprintf("  %-*s  %s\n", width, cmd.Usage, t(this, "help."+cmd.Name)))
+	}
+	this.SendMsg(t(this, "help.footer") + "\n")
+}
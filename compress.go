@@ -0,0 +1,175 @@
+// 按连接开启的deflate压缩, 适合延迟高、带宽小的网络
+// 客户端发 compress|deflate 之后就开始压缩自己发出的数据; 服务器先不压缩地回复 compress-ok|deflate, 之后发出的数据也压缩
+// 每条消息写完都Flush一次, 对方马上就能解出完整的一条, 不会为了压缩率攒数据
+// 压缩在分帧之下: 按帧分帧时压缩的是帧(包括长度前缀); 同一个服务器上压缩和不压缩的连接可以混用
+// 服务器在hello里用 compress=deflate 告诉客户端支持压缩
+// 每个压缩的连接要几百KB的压缩缓冲, 最多 -max-compressed 个连接同时开启, 0表示不支持压缩; 用BestSpeed, 交互的消息很短, 压缩率差不多
+package main
+
+import (
+	"bufio"
+	"compress/flate"
+	"io"
+	"sync/atomic"
+)
+
+const compressDeflate = "deflate"
+
+// 默认最多多少个连接同时开启压缩
+const defaultMaxCompressed = 100
+
+// 按连接的压缩状态和统计
+type compressState struct {
+	zw      *flate.Writer // 开启压缩后的写入, 在writeLock里使用
+	enabled int32         // 已经开启压缩, 用atomic读, 不用等writeLock
+
+	switchIn int32 // 收到了 compress|deflate, 读消息的goroutine处理完这一行后换成解压
+
+	// 压缩前后的字节数, 用atomic读写
+	rawOut, wireOut uint64
+	rawIn, wireIn   uint64
+}
+
+// 统计写到连接上的压缩后字节数
+type countingWriter struct {
+	w io.Writer
+	n *uint64
+}
+
+func (this countingWriter) Write(b []byte) (int, error) {
+	n, err := this.w.Write(b)
+	atomic.AddUint64(this.n, uint64(n))
+	return n, err
+}
+
+// 统计解压时读到的压缩字节数; 实现了ReadByte, flate不会再包一层缓冲多读后面的数据
+type countingByteReader struct {
+	r *bufio.Reader
+	n *uint64
+}
+
+func (this countingByteReader) Read(b []byte) (int, error) {
+	n, err := this.r.Read(b)
+	atomic.AddUint64(this.n, uint64(n))
+	return n, err
+}
+
+func (this countingByteReader) ReadByte() (byte, error) {
+	c, err := this.r.ReadByte()
+	if err == nil {
+		atomic.AddUint64(this.n, 1)
+	}
+	return c, err
+}
+
+// 统计解压后的字节数
+type countingReader struct {
+	r io.Reader
+	n *uint64
+}
+
+func (this countingReader) Read(b []byte) (int, error) {
+	n, err := this.r.Read(b)
+	atomic.AddUint64(this.n, uint64(n))
+	return n, err
+}
+
+// 写一段数据到连接上, 开启压缩后压缩并立即Flush; 调用时需要持有writeLock
+func (this *User) writeRaw(b []byte) error {
+	if this.compress.zw == nil {
+		_, err := this.conn.Write(b)
+		return err
+	}
+	atomic.AddUint64(&this.compress.rawOut, uint64(len(b)))
+	if _, err := this.compress.zw.Write(b); err != nil {
+		return err
+	}
+	return this.compress.zw.Flush()
+}
+
+// 还能不能再开启一个压缩的连接, hello里据此决定带不带 compress=deflate
+func (this *Server) compressAvailable() bool {
+	return int(atomic.LoadInt32(&this.compressed)) < this.MaxCompressed
+}
+
+// 占一个压缩的名额, 已经有MaxCompressed个连接开启了压缩时返回false
+func (this *Server) reserveCompress() bool {
+	for {
+		n := atomic.LoadInt32(&this.compressed)
+		if int(n) >= this.MaxCompressed {
+			return false
+		}
+		if atomic.CompareAndSwapInt32(&this.compressed, n, n+1) {
+			return true
+		}
+	}
+}
+
+// 下线时让出压缩的名额
+func (this *User) releaseCompress() {
+	if atomic.LoadInt32(&this.compress.enabled) == 1 {
+		atomic.AddInt32(&this.server.compressed, -1)
+	}
+}
+
+// 开启压缩, 消息格式: compress|deflate; 开启后这个连接不能再关闭压缩
+func (this *User) DoCompress(arg string) {
+	if arg != compressDeflate {
+		this.errorReply(ErrUsage, "compress.usage")
+		return
+	}
+
+	// 只有读消息的goroutine会开启压缩, 不用加锁判断
+	if atomic.LoadInt32(&this.compress.enabled) == 1 {
+		return
+	}
+	// 客户端发完这一条就开始压缩, 不能开启时后面的数据没法读, 回复原因后断开
+	if !this.server.reserveCompress() {
+		this.errorReply(ErrUnavailable, "compress.unavailable")
+		this.disconnect()
+		return
+	}
+
+	// 回复和切换要在写锁里一起完成, 不能让别的消息夹在中间
+	this.writeLock.Lock()
+	defer this.writeLock.Unlock()
+
+	if _, err := this.conn.Write(this.encodeMessage("compress-ok|" + compressDeflate + "\n")); err != nil {
+		atomic.AddInt32(&this.server.compressed, -1)
+		this.markBroken(err)
+		return
+	}
+	this.compress.zw, _ = flate.NewWriter(countingWriter{w: this.conn, n: &this.compress.wireOut}, flate.BestSpeed)
+	atomic.StoreInt32(&this.compress.enabled, 1)
+	atomic.StoreInt32(&this.compress.switchIn, 1)
+}
+
+// 读消息的goroutine每处理完一行调用一次: 刚开启压缩时返回解压后的reader, 否则返回原来的reader
+// 解压后的数据也按 -read-rate 限速, 压缩得很小的数据不能绕过限制
+func (this *User) inflateReader(reader *bufio.Reader) *bufio.Reader {
+	if !atomic.CompareAndSwapInt32(&this.compress.switchIn, 1, 0) {
+		return reader
+	}
+	var r io.Reader = flate.NewReader(countingByteReader{r: reader, n: &this.compress.wireIn})
+	r = countingReader{r: r, n: &this.compress.rawIn}
+	if this.server.ReadRate > 0 {
+		r = newLimitedReader(r, this.server.Clock, this.server.ReadRate, this.server.ReadBurst)
+	}
+	return bufio.NewReader(r)
+}
+
+// 压缩节省了多少比例的流量(0-100), 没有开启压缩时返回false
+func (this *User) CompressSavings() (in int, out int, ok bool) {
+	if atomic.LoadInt32(&this.compress.enabled) == 0 {
+		return 0, 0, false
+	}
+	saved := func(raw, wire uint64) int {
+		if raw == 0 || wire >= raw {
+			return 0
+		}
+		return int((raw - wire) * 100 / raw)
+	}
+	in = saved(atomic.LoadUint64(&this.compress.rawIn), atomic.LoadUint64(&this.compress.wireIn))
+	out = saved(atomic.LoadUint64(&this.compress.rawOut), atomic.LoadUint64(&this.compress.wireOut))
+	return in, out, true
+}
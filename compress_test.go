@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bufio"
+	"compress/flate"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 一个开启了压缩的连接: 发出的数据压缩, 收到compress-ok以后解压着读
+type compressedConn struct {
+	t    *testing.T
+	conn net.Conn
+	zw   *flate.Writer
+	wire *readCounter
+	*lineWatcher
+}
+
+// 统计从连接上读到的字节数
+type readCounter struct {
+	net.Conn
+	n int64
+}
+
+func (this *readCounter) Read(b []byte) (int, error) {
+	n, err := this.Conn.Read(b)
+	atomic.AddInt64(&this.n, int64(n))
+	return n, err
+}
+
+// 连上服务器, 改名以后开启压缩
+func dialCompressed(t *testing.T, server *Server, name string) *compressedConn {
+	t.Helper()
+	conn, err := net.Dial("tcp", server.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	wire := &readCounter{Conn: conn}
+	reader := bufio.NewReader(wire)
+	readLine := func(want string) {
+		conn.SetReadDeadline(time.Now().Add(e2eWait))
+		defer conn.SetReadDeadline(time.Time{})
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				t.Fatalf("%s: 没有读到 %q: %v", name, want, err)
+			}
+			if strings.Contains(line, want) {
+				return
+			}
+		}
+	}
+	readLine("compress=deflate")
+	conn.Write([]byte("rename|" + name + "\ncompress|deflate\n"))
+	readLine("compress-ok|deflate")
+
+	zw, _ := flate.NewWriter(conn, flate.BestSpeed)
+	return &compressedConn{t: t, conn: conn, zw: zw, wire: wire,
+		lineWatcher: watchLines(t, name, flate.NewReader(reader))}
+}
+
+func (this *compressedConn) send(line string) {
+	this.t.Helper()
+	if _, err := this.zw.Write([]byte(line + "\n")); err != nil {
+		this.t.Fatal(err)
+	}
+	if err := this.zw.Flush(); err != nil {
+		this.t.Fatal(err)
+	}
+}
+
+func TestCompressRoundTrip(t *testing.T) {
+	server := startTestServer(t, WithReadRate(0, 0))
+	alice := dialCompressed(t, server, "alice")
+	bob := dialTest(t, server, "bob")
+
+	// 压缩和不压缩的连接收到的是同样的内容
+	payload := strings.Repeat("compress me please ", 100)
+	before := atomic.LoadInt64(&alice.wire.n)
+	alice.send(payload)
+	alice.waitFor(payload)
+	bob.waitFor(payload)
+	if got := atomic.LoadInt64(&alice.wire.n) - before; got >= int64(len(payload)/4) {
+		t.Fatalf("压缩后读到 %d 字节, 原文 %d 字节", got, len(payload))
+	}
+
+	in, out, ok := onlineUser(t, server, "alice").CompressSavings()
+	if !ok || in < 50 || out < 50 {
+		t.Fatalf("savings in=%d out=%d ok=%v", in, out, ok)
+	}
+	if _, _, ok := onlineUser(t, server, "bob").CompressSavings(); ok {
+		t.Fatal("没有开启压缩的连接也有压缩统计")
+	}
+}
+
+func TestCompressCap(t *testing.T) {
+	server := startTestServer(t, WithMaxCompressed(1))
+	alice := dialCompressed(t, server, "alice")
+
+	// 名额用完了: hello里不说支持压缩, 请求压缩时回复错误并断开
+	bob := dialTest(t, server, "bob")
+	if bob.saw("compress=deflate") {
+		t.Fatal("名额用完了hello里还有compress=deflate")
+	}
+	bob.send("caps|errors", "compress|deflate")
+	bob.waitFor("err|" + strconv.Itoa(ErrUnavailable.Num) + "|")
+	bob.waitClosed()
+
+	// 压缩的连接下线以后让出名额
+	alice.conn.Close()
+	eventually(t, "压缩的名额没有让出来", server.compressAvailable)
+	dialCompressed(t, server, "carol")
+}
+
+func TestCompressDisabled(t *testing.T) {
+	server := startTestServer(t, WithMaxCompressed(0))
+	alice := dialTest(t, server, "alice")
+	if alice.saw("compress=deflate") {
+		t.Fatal("-max-compressed 0 时hello里还有compress=deflate")
+	}
+}
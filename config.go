@@ -0,0 +1,215 @@
+// 启动前检查命令行参数: 一次列出全部问题, 而不是等到用到的时候才报错
+// -validate 只做检查, 不启动服务器
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// 检查全部参数, 返回的错误里每行一个问题
+func checkConfig() error {
+	var errs []error
+	add := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if _, ok := catalog[lang]; !ok {
+		add("-lang 只能是 %s", strings.Join(languages(), "/"))
+	}
+
+	// 端口
+	ports := map[string]int{"-port": serverPort, "-grpc-port": grpcPort, "-health-port": healthPort}
+	for _, name := range []string{"-port", "-grpc-port", "-health-port"} {
+		if p := ports[name]; p < 0 || p > 65535 {
+			add("%s 必须在0到65535之间", name)
+		}
+	}
+	if grpcPort != 0 && grpcPort == serverPort {
+		add("-grpc-port 不能和 -port 相同")
+	}
+	if healthPort != 0 && healthPort == serverPort {
+		add("-health-port 不能和 -port 相同")
+	}
+	if healthPort != 0 && healthPort == grpcPort {
+		add("-health-port 不能和 -grpc-port 相同")
+	}
+	if grpcPort != 0 && apiToken == "" {
+		add("-grpc-port 需要同时设置 -api-token")
+	}
+
+	if !validServerName(serverName) {
+		add("-server-name 不能包含 | 和换行")
+	}
+
+	// 数值
+	if historySize < 0 {
+		add("-history 不能小于0")
+	}
+	if renameLimit < 0 {
+		add("-rename-limit 不能小于0")
+	}
+	if idleTimeout < 0 {
+		add("-idle-timeout 不能小于0")
+	}
+	if idleWarn < 0 || idleWarn >= 1 {
+		add("-idle-warn 要在0到1之间(不包括1)")
+	}
+	if repeatWindow < 0 {
+		add("-repeat-window 不能小于0")
+	}
+	if suspectWindow < 0 {
+		add("-suspect-window 不能小于0")
+	}
+	if suspectLogins < 0 {
+		add("-suspect-logins 不能小于0")
+	}
+	if suspectCommands < 0 {
+		add("-suspect-commands 不能小于0")
+	}
+	if suspectBlock < 0 {
+		add("-suspect-block 不能小于0")
+	}
+	if nameWarnWindow < 0 {
+		add("-name-warn-window 不能小于0")
+	}
+	if storeTTL < 0 {
+		add("-store-ttl 不能小于0")
+	}
+	if storeMaxNames < 0 {
+		add("-store-max-names 不能小于0")
+	}
+	if renameInterval < 0 {
+		add("-rename-interval 不能小于0")
+	}
+	if pmInboundLimit < 0 {
+		add("-pm-inbound-limit 不能小于0")
+	}
+	if maxFileSize < 1 {
+		add("-max-file-size 不能小于1")
+	}
+	if fileRate < 0 {
+		add("-file-rate 不能小于0")
+	}
+	if maxCompressed < 0 {
+		add("-max-compressed 不能小于0")
+	}
+	if readRate < 0 {
+		add("-read-rate 不能小于0")
+	}
+	if readRate > 0 && readBurst < 1 {
+		add("-read-burst 不能小于1")
+	}
+	if presenceWindow < 0 {
+		add("-presence-window 不能小于0")
+	}
+	if presenceThreshold < 0 {
+		add("-presence-threshold 不能小于0")
+	}
+	if statusInterval < 0 {
+		add("-status-interval 不能小于0")
+	}
+
+	if broadcastFormat != "" {
+		if _, err := ParseLineFormat(broadcastFormat); err != nil {
+			add("-broadcast-format: %v", err)
+		}
+	}
+
+	if greetingFile != "" {
+		if _, err := loadGreeting(greetingFile); err != nil {
+			add("-greeting: %v", err)
+		}
+	}
+
+	if _, err := parseListenAddrs(extraListen); err != nil {
+		add("-listen 不正确: %v", err)
+	}
+	if addrs, err := parseListenAddrs(tlsListen); err != nil {
+		add("-tls-listen 不正确: %v", err)
+	} else if len(addrs) > 0 {
+		if tlsCert == "" || tlsKey == "" {
+			add("-tls-listen 需要 -tls-cert 和 -tls-key")
+		} else if _, err := tls.LoadX509KeyPair(tlsCert, tlsKey); err != nil {
+			add("-tls-cert/-tls-key 不能使用: %v", err)
+		}
+	} else if tlsCert != "" || tlsKey != "" {
+		add("-tls-cert 和 -tls-key 只在设置了 -tls-listen 时使用")
+	}
+	if proxyTrusted != "" && !proxyProtocol {
+		add("-proxy-trusted 需要同时设置 -proxy-protocol")
+	}
+	if _, err := ParseTrustedProxies(proxyTrusted); err != nil {
+		add("-proxy-trusted: %v", err)
+	}
+
+	if redisAddr != "" {
+		if _, _, err := net.SplitHostPort(redisAddr); err != nil {
+			add("-redis 应该是 主机:端口: %v", err)
+		}
+	} else if instanceName != "" {
+		add("-instance 需要同时设置 -redis")
+	}
+	if strings.Contains(instanceName, "|") {
+		add("-instance 不能包含 '|'")
+	}
+
+	// 文件: 写的文件所在的目录要存在, 读的文件存在时要能解析
+	if auditLog != "" {
+		if err := checkDir(auditLog); err != nil {
+			add("-audit-log: %v", err)
+		}
+	}
+	if prefsFile != "" && storePath != prefsFile {
+		add("-prefs-file 不能和 -store 一起使用")
+	}
+	switch storeKind {
+	case "":
+		if storePath != "" {
+			add("-store-path 需要同时设置 -store")
+		}
+	case "file":
+		if storePath == "" {
+			add("-store file 需要用 -store-path 指定文件")
+		} else if err := checkDir(storePath); err != nil {
+			add("-store-path: %v", err)
+		} else if _, err := NewFileStore(storePath); err != nil {
+			add("-store-path: %v", err)
+		}
+	case "mem":
+	default:
+		// sqlite等实现在打开时检查, 这里只检查名字
+		if _, ok := storeOpeners[storeKind]; !ok {
+			_, err := OpenStore(storeKind, storePath)
+			add("%v", err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// 检查文件所在的目录是否存在
+func checkDir(path string) error {
+	dir := filepath.Dir(path)
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s 不是目录", dir)
+	}
+	return nil
+}
+
+// 输出检查出来的全部问题
+func printConfigErrors(err error) {
+	fmt.Println("参数有误:")
+	for _, line := range strings.Split(err.Error(), "\n") {
+		fmt.Println("  -", line)
+	}
+}
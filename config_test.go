@@ -0,0 +1,74 @@
+package main
+
+import (
+	"flag"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// 设置命令行参数, 测试结束时恢复原来的值
+func setFlags(t *testing.T, values map[string]string) {
+	t.Helper()
+	for name, value := range values {
+		f := flag.Lookup(name)
+		if f == nil {
+			t.Fatalf("没有 -%s 参数", name)
+		}
+		old := f.Value.String()
+		t.Cleanup(func() { f.Value.Set(old) })
+		if err := f.Value.Set(value); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCheckConfigDefaults(t *testing.T) {
+	if err := checkConfig(); err != nil {
+		t.Fatalf("默认参数有误: %v", err)
+	}
+}
+
+// 一次列出全部问题
+func TestCheckConfigAllProblems(t *testing.T) {
+	setFlags(t, map[string]string{
+		"lang":             "fr",
+		"grpc-port":        "8888",
+		"health-port":      "70000",
+		"history":          "-1",
+		"broadcast-format": "{name}",
+		"proxy-trusted":    "10.0.0.0/99",
+		"instance":         "a",
+		"audit-log":        filepath.Join(t.TempDir(), "missing", "audit.log"),
+	})
+	err := checkConfig()
+	if err == nil {
+		t.Fatal("没有报错")
+	}
+	problems := strings.Split(err.Error(), "\n")
+	for _, want := range []string{
+		"-lang 只能是",
+		"-health-port 必须在0到65535之间",
+		"-grpc-port 不能和 -port 相同",
+		"-grpc-port 需要同时设置 -api-token",
+		"-history 不能小于0",
+		"-broadcast-format:",
+		"-proxy-trusted 需要同时设置 -proxy-protocol",
+		"-proxy-trusted:",
+		"-instance 需要同时设置 -redis",
+		"-audit-log:",
+	} {
+		found := false
+		for _, p := range problems {
+			if strings.HasPrefix(p, want) {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("没有报告 %q, 全部问题:\n%s", want, err)
+		}
+	}
+	if len(problems) != 10 {
+		t.Errorf("报告了 %d 个问题:\n%s", len(problems), err)
+	}
+}
@@ -0,0 +1,98 @@
+// 消息去重: 客户端重试时可能把同一条消息发两次, 带上自己生成的消息ID就不会重复发出去
+// 消息格式: pub|消息ID|消息内容 和 to|用户名|消息内容|消息ID; 私聊的内容里可以有|, 只有声明了 msg-id 能力时最后一段才是消息ID
+// 服务器按连接记住最近的消息ID, 重复的只回一个确认 ack|消息ID, 不再转发; 不带ID的消息跟以前一样
+// 声明了 msg-id 能力的客户端发送失败时在错误之后收到 nak|消息ID, 不用再重发; hello里的 dedupe=msg-id 表示服务器支持
+package main
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+)
+
+// 每个连接记住最近多少个消息ID, 超过时忘掉最早的
+const dedupeSize = 64
+
+// 消息ID最长多少字节
+const maxMsgID = 64
+
+// 最近发送成功的消息ID, 最近最少使用的先淘汰
+type msgIDCache struct {
+	size  int
+	order *list.List // 最近的在前面
+	ids   map[string]*list.Element
+	lock  sync.Mutex
+}
+
+func newMsgIDCache(size int) *msgIDCache {
+	return &msgIDCache{
+		size:  size,
+		order: list.New(),
+		ids:   make(map[string]*list.Element),
+	}
+}
+
+// 是否已经见过这个ID, 见过时把它移到最前面
+func (this *msgIDCache) Seen(id string) bool {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	e, ok := this.ids[id]
+	if ok {
+		this.order.MoveToFront(e)
+	}
+	return ok
+}
+
+// 记住一个ID, 满了就淘汰最久没用过的
+func (this *msgIDCache) Add(id string) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if e, ok := this.ids[id]; ok {
+		this.order.MoveToFront(e)
+		return
+	}
+	this.ids[id] = this.order.PushFront(id)
+	if this.order.Len() > this.size {
+		oldest := this.order.Back()
+		this.order.Remove(oldest)
+		delete(this.ids, oldest.Value.(string))
+	}
+}
+
+// 消息ID不能为空, 不能太长, 不能包含空白
+func validMsgID(id string) bool {
+	return id != "" && len(id) <= maxMsgID && !strings.ContainsAny(id, " \t\r\n")
+}
+
+// 确认收到带ID的消息
+func (this *User) ackMsgID(id string) {
+	this.SendMsg("ack|" + id + "\n")
+}
+
+// 带ID的消息没有发出去, 只告诉声明了 msg-id 能力的客户端, 老客户端只收到错误
+func (this *User) nakMsgID(id string) {
+	if this.HasCap(capMsgID) {
+		this.SendMsg("nak|" + id + "\n")
+	}
+}
+
+// 带ID的公聊消息, 消息格式: pub|消息ID|消息内容
+func (this *User) DoPublish(arg string) {
+	id, text, ok := strings.Cut(arg, "|")
+	if !ok || !validMsgID(id) || text == "" {
+		this.errorReply(ErrUsage, "pub.usage")
+		return
+	}
+	if this.sentIDs.Seen(id) {
+		this.ackMsgID(id)
+		return
+	}
+	if this.DoPublic(text) {
+		this.sentIDs.Add(id)
+		this.ackMsgID(id)
+		return
+	}
+	this.nakMsgID(id)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// 发一条标记消息并等到to收到, 之前发的消息一定已经到了
+func syncMarker(t *testing.T, from, to *testConn, marker string) {
+	t.Helper()
+	from.send("say|" + marker)
+	to.waitFor(marker)
+}
+
+// 已经读过的行里有几行包含want
+func countSeen(c *testConn, want string) int {
+	n := 0
+	for _, line := range c.seen {
+		if strings.Contains(line, want) {
+			n++
+		}
+	}
+	return n
+}
+
+func TestDedupePublish(t *testing.T) {
+	server := startTestServer(t)
+	alice := dialTest(t, server, "alice")
+	bob := dialTest(t, server, "bob")
+
+	alice.send("pub|m1|dedupe-me")
+	alice.waitFor("ack|m1")
+	alice.send("pub|m1|dedupe-me")
+	alice.waitFor("ack|m1")
+	syncMarker(t, alice, bob, "marker")
+	if n := countSeen(bob, "dedupe-me"); n != 1 {
+		t.Fatalf("bob 收到 %d 次", n)
+	}
+}
+
+func TestPrivateLegacyKeepsPipes(t *testing.T) {
+	server := startTestServer(t)
+	alice := dialTest(t, server, "alice")
+	bob := dialTest(t, server, "bob")
+
+	// 没有声明 msg-id 时内容里的|原样发出去, 不当作消息ID
+	alice.send("to|bob|a|b", "to|bob|a|b")
+	bob.waitFor(privateLine("alice", "a|b"))
+	bob.waitFor(privateLine("alice", "a|b"))
+}
+
+func TestPrivateMsgID(t *testing.T) {
+	server := startTestServer(t)
+	alice := dialTest(t, server, "alice")
+	bob := dialTest(t, server, "bob")
+	alice.send("caps|msg-id")
+
+	alice.send("to|bob|a|b|p1")
+	alice.waitFor("ack|p1")
+	alice.send("to|bob|a|b|p1")
+	alice.waitFor("ack|p1")
+	alice.send("to|bob|plain")
+	bob.waitFor(privateLine("alice", "plain"))
+	if n := countSeen(bob, privateLine("alice", "a|b")); n != 1 {
+		t.Fatalf("bob 收到 %d 次", n)
+	}
+
+	// 没发出去时先回复错误再回复nak
+	alice.send("to|nobody|hi|p2")
+	alice.waitFor("nak|p2")
+	if alice.saw("ack|p2") {
+		t.Fatal("没发出去的消息也确认了")
+	}
+}
+
+func TestMsgIDCacheEviction(t *testing.T) {
+	cache := newMsgIDCache(3)
+	for i := 0; i < 3; i++ {
+		cache.Add("id" + strconv.Itoa(i))
+	}
+	// 用过的id0移到前面, 加id3时淘汰最久没用的id1
+	if !cache.Seen("id0") {
+		t.Fatal("id0 应该还在")
+	}
+	cache.Add("id3")
+	for id, want := range map[string]bool{"id0": true, "id1": false, "id2": true, "id3": true} {
+		if got := cache.Seen(id); got != want {
+			t.Errorf("Seen(%s) = %v, want %v", id, got, want)
+		}
+	}
+}
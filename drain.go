@@ -0,0 +1,77 @@
+// 维护模式: 重启前先不再接受新连接, 已经在线的用户照常聊天, 等大家自己离开
+// 管理员发送 drain|on 或 drain|off, 或者给进程发 SIGUSR1 切换(只在unix上, 见drain_unix.go)
+// 开启 -health-port 时, 负载均衡可以通过 /healthz 知道不要再把新连接转过来
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sync/atomic"
+)
+
+// 是否处于维护模式
+func (this *Server) Draining() bool {
+	return atomic.LoadInt32(&this.draining) == 1
+}
+
+// 开启或关闭维护模式, 状态有变化时广播通知, 返回是否有变化
+func (this *Server) SetDrain(on bool) bool {
+	var from, to int32 = 0, 1
+	if !on {
+		from, to = 1, 0
+	}
+	if !atomic.CompareAndSwapInt32(&this.draining, from, to) {
+		return false
+	}
+
+	fmt.Printf("drain=%v online=%d\n", on, this.OnlineCount())
+	if on {
+		this.Announce(t(nil, "drain.on"))
+	} else {
+		this.Announce(t(nil, "drain.off"))
+	}
+	return true
+}
+
+// 维护模式下拒绝新连接: 告诉对方原因后直接关闭; 先发hello, 客户端才会显示原因
+func (this *Server) rejectDraining(conn net.Conn) {
+	conn.Write([]byte(this.helloLine() + "\n" + systemPrefix + t(nil, "drain.reject") + "\n"))
+	conn.Close()
+}
+
+// 健康检查: 正常时返回200, 维护模式下返回503
+func (this *Server) ServeHealth(w http.ResponseWriter, r *http.Request) {
+	if this.Draining() {
+		http.Error(w, "draining", http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+// 在addr上开启健康检查接口, 一直阻塞到出错为止
+func (this *Server) ListenHealth(addr string) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", this.ServeHealth)
+	return http.ListenAndServe(addr, mux)
+}
+
+// 开启或关闭维护模式, 消息格式: drain|on 或 drain|off, 只有管理员可以设置
+func (this *User) DoDrain(arg string) {
+	if !this.IsAdmin() {
+		this.server.audit(this, "drain", "", arg, auditDenied)
+		this.errorReply(ErrPermission, "drain.admin_only")
+		return
+	}
+	if arg != "on" && arg != "off" {
+		this.server.audit(this, "drain", "", arg, auditInvalid)
+		this.errorReply(ErrUsage, "drain.usage")
+		return
+	}
+	if !this.server.SetDrain(arg == "on") {
+		this.server.audit(this, "drain", "", arg, auditInvalid)
+		this.errorReply(ErrAlready, "drain.already", arg)
+		return
+	}
+	this.server.audit(this, "drain", "", arg, auditOK)
+}
@@ -0,0 +1,6 @@
+//go:build !unix
+
+package main
+
+// 没有SIGUSR1的系统上只能用 drain|on 和 drain|off 切换维护模式
+func (this *Server) handleDrainSignal() {}
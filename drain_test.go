@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Achiyun/Golang-IM-System/internal/leakcheck"
+)
+
+func healthStatus(server *Server) int {
+	rec := httptest.NewRecorder()
+	server.ServeHealth(rec, httptest.NewRequest("GET", "/healthz", nil))
+	return rec.Code
+}
+
+// 维护模式下新连接收到原因后被关闭, 已经在线的用户照常聊天, 健康检查返回503
+func TestDrain(t *testing.T) {
+	leakcheck.Check(t)
+	server := startTestServer(t, WithAdminToken("secret"))
+	alice := dialTest(t, server, "alice")
+	admin := dialAdmin(t, server, "admin")
+
+	alice.send("drain|on")
+	alice.waitFor(text("drain.admin_only"))
+	if healthStatus(server) != http.StatusOK {
+		t.Fatal("正常时健康检查不是200")
+	}
+
+	admin.send("drain|on")
+	alice.waitFor(text("drain.on"))
+	admin.send("drain|on")
+	admin.waitFor(text("drain.already", "on"))
+	if healthStatus(server) != http.StatusServiceUnavailable {
+		t.Fatal("维护模式下健康检查不是503")
+	}
+
+	late := dialTest(t, server, "")
+	late.waitFor(systemPrefix + text("drain.reject"))
+	late.waitClosed()
+
+	alice.send("still here")
+	admin.waitFor("alice:still here")
+
+	admin.send("drain|off")
+	alice.waitFor(text("drain.off"))
+	dialTest(t, server, "bob")
+	if healthStatus(server) != http.StatusOK {
+		t.Fatal("关闭维护模式后健康检查不是200")
+	}
+}
@@ -0,0 +1,20 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// 收到SIGUSR1时切换维护模式
+func (this *Server) handleDrainSignal() {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGUSR1)
+	for range sig {
+		on := !this.Draining()
+		this.SetDrain(on)
+		this.audit(nil, "drain", "", onOff(on), auditOK)
+	}
+}
@@ -0,0 +1,265 @@
+// 端到端测试: 编译服务端和客户端, 服务器监听随机端口, 通过标准输入输出驱动两个客户端
+// 等待都是按事件的: 一行一行读输出直到出现想要的内容, 超过期限就失败, 不用sleep
+package main
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Achiyun/Golang-IM-System/internal/leakcheck"
+)
+
+// 等一行输出最多等多久
+const e2eWait = 10 * time.Second
+
+// 编译好的服务端和客户端, 同一次 go test 里只编译一次
+var e2eBinaries struct {
+	dir    string
+	server string
+	client string
+	err    error
+}
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	if e2eBinaries.dir != "" {
+		os.RemoveAll(e2eBinaries.dir)
+	}
+	os.Exit(code)
+}
+
+// 编译服务端和客户端, 只在第一次调用时编译
+func buildBinaries(t *testing.T) (string, string) {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("-short 时不跑端到端测试")
+	}
+	if e2eBinaries.dir == "" && e2eBinaries.err == nil {
+		dir, err := os.MkdirTemp("", "im-e2e-")
+		if err != nil {
+			t.Fatal(err)
+		}
+		e2eBinaries.dir = dir
+		e2eBinaries.server = filepath.Join(dir, "server")
+		e2eBinaries.client = filepath.Join(dir, "client")
+		for out, pkg := range map[string]string{e2eBinaries.server: ".", e2eBinaries.client: "./cmd/client"} {
+			cmd := exec.Command("go", "build", "-o", out, pkg)
+			if output, err := cmd.CombinedOutput(); err != nil {
+				e2eBinaries.err = err
+				t.Fatalf("go build %s: %v\n%s", pkg, err, output)
+			}
+		}
+	}
+	if e2eBinaries.err != nil {
+		t.Fatal("编译失败:", e2eBinaries.err)
+	}
+	return e2eBinaries.server, e2eBinaries.client
+}
+
+// 一个进程的输出, 按行读进channel, 读完时关闭
+type lineWatcher struct {
+	t     *testing.T
+	name  string
+	lines chan string
+	seen  []string // 已经读过的行, 失败时打印出来
+}
+
+func watchLines(t *testing.T, name string, r io.Reader) *lineWatcher {
+	w := &lineWatcher{t: t, name: name, lines: make(chan string, 1024)}
+	go func() {
+		defer close(w.lines)
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			w.lines <- scanner.Text()
+		}
+	}()
+	return w
+}
+
+// 一直读到有一行包含want, 返回这一行; 超过期限或者输出结束时测试失败
+func (this *lineWatcher) waitFor(want string) string {
+	this.t.Helper()
+	deadline := time.NewTimer(e2eWait)
+	defer deadline.Stop()
+	for {
+		select {
+		case line, ok := <-this.lines:
+			if !ok {
+				this.t.Fatalf("%s: 输出已经结束, 没有等到 %q, 读到的输出:\n%s", this.name, want, strings.Join(this.seen, "\n"))
+			}
+			this.seen = append(this.seen, line)
+			if strings.Contains(line, want) {
+				return line
+			}
+		case <-deadline.C:
+			this.t.Fatalf("%s: %s内没有等到 %q, 读到的输出:\n%s", this.name, e2eWait, want, strings.Join(this.seen, "\n"))
+		}
+	}
+}
+
+// 一个在跑的进程
+type e2eProcess struct {
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+	out   *lineWatcher
+}
+
+func startProcess(t *testing.T, name string, path string, env []string, args ...string) *e2eProcess {
+	t.Helper()
+	cmd := exec.Command(path, args...)
+	cmd.Env = append(os.Environ(), env...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd.Stderr = cmd.Stdout
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	p := &e2eProcess{cmd: cmd, stdin: stdin, out: watchLines(t, name, stdout)}
+	t.Cleanup(func() {
+		p.cmd.Process.Kill()
+		p.cmd.Wait()
+	})
+	return p
+}
+
+// 往标准输入写几行
+func (this *e2eProcess) input(t *testing.T, lines ...string) {
+	t.Helper()
+	if _, err := io.WriteString(this.stdin, strings.Join(lines, "\n")+"\n"); err != nil {
+		t.Fatal("write stdin:", err)
+	}
+}
+
+// 启动服务器, 返回进程和实际监听的端口
+func startServer(t *testing.T, args ...string) (*e2eProcess, string) {
+	t.Helper()
+	serverBin, _ := buildBinaries(t)
+	args = append([]string{"-ip", "127.0.0.1", "-port", "0", "-presence-window", "0"}, args...)
+	server := startProcess(t, "server", serverBin, nil, args...)
+	line := server.out.waitFor("server started")
+	m := regexp.MustCompile(` addr=127\.0\.0\.1:(\d+) `).FindStringSubmatch(line)
+	if m == nil {
+		t.Fatalf("启动日志里没有地址: %s", line)
+	}
+	return server, m[1]
+}
+
+// 连上服务器的客户端, 用自己的HOME, 不读写真实的配置
+func startClient(t *testing.T, name string, port string) *e2eProcess {
+	t.Helper()
+	_, clientBin := buildBinaries(t)
+	home := t.TempDir()
+	return startProcess(t, name, clientBin, []string{"HOME=" + home},
+		"-ip", "127.0.0.1", "-port", port, "-no-roster", "-color", "never", "-watchdog", "0")
+}
+
+func TestEndToEndChat(t *testing.T) {
+	leakcheck.Check(t)
+	server, port := startServer(t)
+
+	alice := startClient(t, "alice", port)
+	server.out.waitFor("online name=")
+	bob := startClient(t, "bob", port)
+	server.out.waitFor("online name=")
+
+	// 改名
+	alice.input(t, "3", "alice")
+	alice.out.waitFor("您已经更新用户名:alice")
+	server.out.waitFor("new=alice")
+	bob.input(t, "3", "bob")
+	bob.out.waitFor("您已经更新用户名:bob")
+	server.out.waitFor("new=bob")
+
+	// 公聊
+	alice.input(t, "1", "hello everyone", "exit")
+	bob.out.waitFor("hello everyone")
+
+	// 私聊, 进入私聊模式时先显示在线用户
+	alice.input(t, "2")
+	alice.out.waitFor("当前在线 2 人:")
+	alice.out.waitFor("bob")
+	alice.input(t, "bob", "just for you", "exit", "exit")
+	line := bob.out.waitFor("just for you")
+	if !strings.Contains(line, "alice对您说:just for you") {
+		t.Fatalf("私聊显示不对: %q", line)
+	}
+
+	// who
+	bob.input(t, "2")
+	bob.out.waitFor("当前在线 2 人:")
+	bob.out.waitFor("alice")
+	bob.input(t, "exit")
+
+	// 退出
+	alice.input(t, "0")
+	server.out.waitFor("offline name=alice")
+	if err := alice.cmd.Wait(); err != nil {
+		t.Fatal("alice退出:", err)
+	}
+	bob.stdin.Close()
+	server.out.waitFor("offline name=bob")
+}
+
+// 客户端的标准输出是管道, 读的一方中途退出(例如 ./client | head): 客户端断开连接, 在标准错误上说明原因后以1退出
+func TestEndToEndStdoutClosed(t *testing.T) {
+	leakcheck.Check(t)
+	server, port := startServer(t)
+	_, clientBin := buildBinaries(t)
+
+	cmd := exec.Command(clientBin, "-ip", "127.0.0.1", "-port", port, "-no-roster", "-color", "never", "-watchdog", "0")
+	cmd.Env = append(os.Environ(), "HOME="+t.TempDir())
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdin.Close()
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cmd.Process.Kill() })
+	line := server.out.waitFor("online name=")
+	name := regexp.MustCompile(`name=(\S+)`).FindStringSubmatch(line)[1]
+
+	// 读到上线以后关掉读的一方
+	head := watchLines(t, "head", stdout)
+	head.waitFor("已上线")
+	stdout.Close()
+
+	bob := startClient(t, "bob", port)
+	server.out.waitFor("online name=")
+	bob.input(t, "1", "anyone there?")
+
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	select {
+	case err := <-exited:
+		if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.ExitCode() != 1 {
+			t.Fatalf("客户端退出: %v", err)
+		}
+	case <-time.After(e2eWait):
+		t.Fatal("标准输出关闭以后客户端没有退出")
+	}
+	if !strings.Contains(stderr.String(), ">>>>> 标准输出已关闭, 断开连接:") {
+		t.Fatalf("stderr = %q", stderr.String())
+	}
+	server.out.waitFor("offline name=" + name)
+}
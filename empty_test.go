@@ -0,0 +1,25 @@
+package main
+
+import "testing"
+
+// 空消息和只有空白的消息不广播也不回复, 连续emptyHintAfter条才提示一次
+func TestEmptyMessagesDropped(t *testing.T) {
+	server := startTestServer(t)
+	alice := dialTest(t, server, "alice")
+	bob := dialTest(t, server, "bob")
+
+	alice.send("", " ", "\t")
+	alice.waitFor(text("user.empty"))
+	alice.send("\r", "", "sync-empty")
+	bob.waitFor("alice:sync-empty")
+	alice.waitFor("alice:sync-empty")
+
+	if n := alice.count(text("user.empty")); n != 1 {
+		t.Fatalf("提示了 %d 次", n)
+	}
+	for _, line := range bob.seen {
+		if line == "[#1]alice:" || line == "[#1]alice: " || line == "[#1]alice:\t" {
+			t.Fatalf("空消息被广播了: %q", line)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+// 错误编号: 程序按编号判断出了什么错, 不用解析会变的提示文字
+// 声明了 errors 能力(caps|errors)的客户端收到 err|编号|提示文字, 其他客户端跟以前一样只收到提示文字
+// 服务器回复给用户的错误都经过errorReply, 编号和名字一旦发布就不再修改, 新的错误只能新加编号
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// 一种错误, Num和Name都是稳定的, 客户端可以用任意一个判断
+type errCode struct {
+	Num  int
+	Name string
+}
+
+var (
+	ErrUsage        = errCode{100, "ERR_USAGE"}         // 命令格式或参数不对
+	ErrEmptyMessage = errCode{101, "ERR_EMPTY_MESSAGE"} // 消息为空
+	ErrTooLarge     = errCode{102, "ERR_TOO_LARGE"}     // 消息、文件或帧太大
+	ErrBadData      = errCode{103, "ERR_BAD_DATA"}      // 数据格式不对, 例如帧里有换行、文件块不是base64
+	ErrNoSuchUser   = errCode{104, "ERR_NO_SUCH_USER"}  // 用户不存在或者不在线
+	ErrNameTaken    = errCode{105, "ERR_NAME_TAKEN"}    // 用户名已经有人用了
+	ErrBadName      = errCode{106, "ERR_BAD_NAME"}      // 用户名不合法
+	ErrPermission   = errCode{107, "ERR_PERMISSION"}    // 没有权限, 例如不是管理员
+	ErrRateLimited  = errCode{108, "ERR_RATE_LIMITED"}  // 太频繁, 稍后再试
+	ErrLockedDown   = errCode{109, "ERR_LOCKED_DOWN"}   // 全员禁言中
+	ErrNotAllowed   = errCode{110, "ERR_NOT_ALLOWED"}   // 对方不接受, 例如私聊白名单
+	ErrNotFound     = errCode{111, "ERR_NOT_FOUND"}     // 要操作的东西不存在, 例如文件编号、定时消息编号
+	ErrExpired      = errCode{112, "ERR_EXPIRED"}       // 已经过了可以操作的时间
+	ErrAlready      = errCode{113, "ERR_ALREADY"}       // 已经是这个状态了
+	ErrUnavailable  = errCode{114, "ERR_UNAVAILABLE"}   // 服务器暂时没法完成, 例如集群广播失败
+	ErrUserOffline  = errCode{115, "ERR_USER_OFFLINE"}  // 以前有人用过这个名字, 现在不在线
+)
+
+// 全部错误, 按编号排列
+var errCodes = []errCode{
+	ErrUsage, ErrEmptyMessage, ErrTooLarge, ErrBadData, ErrNoSuchUser, ErrNameTaken, ErrBadName, ErrPermission,
+	ErrRateLimited, ErrLockedDown, ErrNotAllowed, ErrNotFound, ErrExpired, ErrAlready, ErrUnavailable,
+	ErrUserOffline,
+}
+
+// 回复给某个用户的错误, 提示文字按用户的语言生成
+type replyError struct {
+	code errCode
+	text string
+}
+
+func (this *replyError) Error() string {
+	return this.text
+}
+
+// 生成一个错误, key是catalog里的提示文字
+func (this *User) newError(code errCode, key string, args ...interface{}) *replyError {
+	return &replyError{code: code, text: t(this, key, args...)}
+}
+
+// 错误发给用户的一行(不带换行): 声明了errors能力时是 err|编号|提示文字, 否则只有提示文字
+func (this *replyError) line(withCode bool) string {
+	if withCode {
+		return "err|" + strconv.Itoa(this.code.Num) + "|" + this.text
+	}
+	return this.text
+}
+
+// 把错误发给用户, 按客户端声明的能力选择格式
+func (this *User) sendError(e *replyError) {
+	this.SendMsg(e.line(this.HasCap(capErrors)) + "\n")
+}
+
+// 回复一个错误, 服务器发给用户的错误都用它
+func (this *User) errorReply(code errCode, key string, args ...interface{}) {
+	this.sendError(this.newError(code, key, args...))
+}
+
+// 回复一个协议错误并记一次失败, 见suspects.go: 只用于不认识的命令和格式不对的帧
+// 参数写错(例如 history|abc、设置的值不对)用errorReply, 不算失败, 不然打错几次字就会被封
+func (this *User) protocolError(code errCode, key string, args ...interface{}) {
+	this.errorReply(code, key, args...)
+	this.failed(failCommand)
+}
+
+// 回复errorT生成的错误, 例如设置项的Validate和Scheduler.Add返回的, 编号由调用方决定
+func (this *User) errorReplyFor(code errCode, err error) {
+	if le, ok := err.(*localizedError); ok {
+		this.errorReply(code, le.id, le.args...)
+		return
+	}
+	this.sendError(&replyError{code: code, text: err.Error()})
+}
+
+// hello里的错误表: 100:ERR_USAGE,101:ERR_EMPTY_MESSAGE,...; 客户端不用自己维护一份, 见hello.go
+func errorTable() string {
+	entries := make([]string, len(errCodes))
+	for i, c := range errCodes {
+		entries[i] = strconv.Itoa(c.Num) + ":" + c.Name
+	}
+	return strings.Join(entries, ",")
+}
+
+// 启动时检查错误表: 编号和名字都不能重复
+func init() {
+	nums := make(map[int]bool)
+	names := make(map[string]bool)
+	for _, c := range errCodes {
+		if nums[c.Num] || names[c.Name] {
+			panic(fmt.Sprintf("duplicate error code %d %s", c.Num, c.Name))
+		}
+		nums[c.Num], names[c.Name] = true, true
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// 每种错误的一个例子: 提示文字的key和参数
+var errorExamples = map[errCode]struct {
+	key  string
+	args []interface{}
+}{
+	ErrUsage:        {"pm.allow_usage", nil},
+	ErrEmptyMessage: {"ml.empty", nil},
+	ErrTooLarge:     {"file.too_large", []interface{}{1048576}},
+	ErrBadData:      {"settings.pm_allow_bar", nil},
+	ErrNoSuchUser:   {"user.not_found", nil},
+	ErrNameTaken:    {"rename.taken", nil},
+	ErrBadName:      {"rename.bracket", nil},
+	ErrPermission:   {"admin.wrong_token", nil},
+	ErrRateLimited:  {"schedule.too_many", []interface{}{maxRemindersPerUser}},
+	ErrLockedDown:   {"lockdown.notice", nil},
+	ErrNotAllowed:   {"file.self", nil},
+	ErrNotFound:     {"file.unknown", []interface{}{"f1"}},
+	ErrExpired:      {"bulk.confirm_stale", nil},
+	ErrAlready:      {"drain.already", nil},
+	ErrUnavailable:  {"compress.unavailable", nil},
+	ErrUserOffline:  {"pm.offline", []interface{}{"bob"}},
+}
+
+// errors.go里声明的每个错误都在errCodes里, 编号从100开始连续, 名字都以ERR_开头
+func TestErrorCodes(t *testing.T) {
+	src, err := os.ReadFile("errors.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	declared := regexp.MustCompile(`(?m)^\s+Err\w+\s+= errCode\{`).FindAll(src, -1)
+	if len(declared) != len(errCodes) {
+		t.Fatalf("声明了 %d 个错误, errCodes里有 %d 个", len(declared), len(errCodes))
+	}
+	for i, code := range errCodes {
+		if code.Num != 100+i || !strings.HasPrefix(code.Name, "ERR_") {
+			t.Errorf("第%d个错误是 %d %s", i, code.Num, code.Name)
+		}
+		if _, ok := errorExamples[code]; !ok {
+			t.Errorf("%s 没有例子", code.Name)
+		}
+	}
+	checkGolden(t, "error_table.golden", errorTable()+"\n")
+}
+
+// 每种错误的两种格式: 只有提示文字, 和声明了errors能力时的 err|编号|提示文字
+func TestErrorRendering(t *testing.T) {
+	var b strings.Builder
+	for _, code := range errCodes {
+		ex := errorExamples[code]
+		e := &replyError{code: code, text: text(ex.key, ex.args...)}
+		fmt.Fprintf(&b, "%s\n%s\n%s\n\n", code.Name, e.line(false), e.line(true))
+	}
+	checkGolden(t, "errors.golden", b.String())
+}
+
+// pm|和schedule的错误也带编号, 没有声明errors能力时跟以前一样只有提示文字
+func TestErrorReplyCodes(t *testing.T) {
+	server := startTestServer(t)
+	alice := dialTest(t, server, "alice")
+	bob := dialTest(t, server, "bob")
+	alice.send("caps|errors")
+
+	alice.send("pm|allowlist|maybe")
+	alice.waitFor("err|" + strconv.Itoa(ErrUsage.Num) + "|" + text("settings.on_off"))
+	alice.send("pm|allow|a|b")
+	alice.waitFor("err|" + strconv.Itoa(ErrBadData.Num) + "|" + text("settings.pm_allow_bar"))
+	for i := 0; i < maxRemindersPerUser; i++ {
+		alice.send(fmt.Sprintf("remind|1h|r%d", i))
+	}
+	alice.send("remind|1h|one too many")
+	alice.waitFor("err|" + strconv.Itoa(ErrRateLimited.Num) + "|" + text("schedule.too_many", maxRemindersPerUser))
+
+	bob.send("pm|allowlist|maybe")
+	if line := bob.waitFor(text("settings.on_off")); line != text("settings.on_off") {
+		t.Fatalf("没有声明errors能力时收到 %q", line)
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestFanoutHistogram(t *testing.T) {
+	var h fanoutHistogram
+	for _, d := range []time.Duration{0, time.Millisecond, 2 * time.Millisecond, 50 * time.Millisecond, time.Second, time.Minute} {
+		h.observe(d)
+	}
+	if got := h.String(); got != "1ms:2,10ms:1,100ms:1,1s:1,inf:1" {
+		t.Fatalf("fanout = %s", got)
+	}
+}
+
+// 一条广播发给n个本地用户的开销, 用户的连接另一头一直在读
+func BenchmarkBroadcastFanout(b *testing.B) {
+	for _, n := range []int{10, 100, 1000} {
+		b.Run(strconv.Itoa(n), func(b *testing.B) {
+			server := NewServer("127.0.0.1", 0)
+			for i := 0; i < n; i++ {
+				serverSide, clientSide := net.Pipe()
+				go io.Copy(io.Discard, clientSide)
+				b.Cleanup(func() { clientSide.Close() })
+				name := fmt.Sprintf("user%d", i)
+				user := NewUser(serverSide, server)
+				user.setName(name)
+				server.OnlineMap[name] = user
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				server.deliverLocal("[#1]alice:hello")
+			}
+		})
+	}
+}
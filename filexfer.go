@@ -0,0 +1,334 @@
+// 用户之间传小文件, 服务器只转发, 不保存文件内容
+// 发送方: file|接收方|文件名|字节数 发起, 收到 file-offered|编号|文件名
+// 接收方: 收到 file-offer|编号|发送方|文件名|字节数, 回复 accept|编号 或 decline|编号
+// 接收方同意后发送方收到 file-accepted|编号, 然后发 chunk|编号|base64内容, 服务器原样转给接收方
+// 收满字节数后双方收到 file-done|编号; 60秒内没有回复的请求和同意后30秒没有收到内容的传输作废, 双方收到 file-expired|编号
+// 每个文件每秒最多转发 -file-rate 字节, 发得快了先放慢, 一直超出readGrace就取消; 每个人同时最多发起maxPendingOffers个
+// 传输还受每个连接的入站流量限制(-read-rate), hello里的 read-rate 和 file-rate 告诉客户端按多快发送
+package main
+
+import (
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 默认单个文件最大1MB
+const defaultMaxFileSize = 1 << 20
+
+// 多久没有回复的请求作废
+const fileOfferTimeout = 60 * time.Second
+
+// 同意以后多久没有收到内容的传输作废
+const fileStallTimeout = 30 * time.Second
+
+// 多久检查一次要作废的传输
+const fileJanitorInterval = 5 * time.Second
+
+// 默认每个文件每秒最多转发32KB, 最多一次攒两块
+const defaultFileRate = 32 * 1024
+const fileBurst = 2 * maxFileChunk
+
+// 每个人同时最多发起几个传输
+const maxPendingOffers = 5
+
+// 一块最多多少字节(解码后)
+const maxFileChunk = 8 * 1024
+
+// 文件名最长多少字节
+const maxFileName = 255
+
+// 一个传输中的文件
+type fileOffer struct {
+	id       string
+	from     *User
+	to       *User
+	name     string
+	size     int64
+	sent     int64
+	accepted bool
+
+	created    time.Time // 发起的时间
+	lastActive time.Time // 同意或者最后一次收到内容的时间
+	tokens     float64   // 转发速度的令牌桶, 小于0表示已经预订了以后的额度
+	filled     time.Time // 令牌桶上一次补充的时间
+}
+
+// 全部传输中的文件, key是编号
+type offerTable struct {
+	lock   sync.Mutex
+	offers map[string]*fileOffer
+}
+
+func newOfferTable() *offerTable {
+	return &offerTable{offers: make(map[string]*fileOffer)}
+}
+
+func (this *offerTable) Add(o *fileOffer) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	this.offers[o.id] = o
+}
+
+// 删除并返回一个文件, 已经删除过时返回false
+func (this *offerTable) Remove(id string) (*fileOffer, bool) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	o, ok := this.offers[id]
+	delete(this.offers, id)
+	return o, ok
+}
+
+// 一个人发起的还没有结束的传输数
+func (this *offerTable) countFrom(user *User) int {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	n := 0
+	for _, o := range this.offers {
+		if o.from == user {
+			n++
+		}
+	}
+	return n
+}
+
+// 删除并返回到期的传输: 没有回复超过fileOfferTimeout的, 同意后超过fileStallTimeout没有收到内容的
+func (this *offerTable) expire(now time.Time) []*fileOffer {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	var expired []*fileOffer
+	for id, o := range this.offers {
+		if (!o.accepted && now.Sub(o.created) >= fileOfferTimeout) ||
+			(o.accepted && now.Sub(o.lastActive) >= fileStallTimeout) {
+			expired = append(expired, o)
+			delete(this.offers, id)
+		}
+	}
+	return expired
+}
+
+// 删除跟某个用户有关的全部文件, 用户下线时调用
+func (this *offerTable) RemoveUser(user *User) []*fileOffer {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	var removed []*fileOffer
+	for id, o := range this.offers {
+		if o.from == user || o.to == user {
+			removed = append(removed, o)
+			delete(this.offers, id)
+		}
+	}
+	return removed
+}
+
+// 文件名只能是一个名字, 不能带目录, 接收方的客户端也会再检查一次
+func validFileName(name string) bool {
+	return name != "" && len(name) <= maxFileName && name != "." && name != ".." &&
+		!strings.ContainsAny(name, "/\\|\x00\r\n")
+}
+
+// 发起传文件, 消息格式: file|接收方|文件名|字节数
+func (this *User) DoFile(arg string) {
+	parts := strings.Split(arg, "|")
+	if len(parts) != 3 {
+		this.errorReply(ErrUsage, "file.usage")
+		return
+	}
+	name := parts[1]
+	size, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil || size <= 0 || !validFileName(name) {
+		this.errorReply(ErrUsage, "file.usage")
+		return
+	}
+	if size > this.server.MaxFileSize {
+		this.errorReply(ErrTooLarge, "file.too_large", this.server.MaxFileSize)
+		return
+	}
+
+	to, ok := this.server.lookupUser(parts[0])
+	if !ok {
+		this.errorReply(ErrNoSuchUser, "user.not_found")
+		return
+	}
+	if to == this {
+		this.errorReply(ErrNotAllowed, "file.self")
+		return
+	}
+	if !to.checkAllowList(this) {
+		this.errorReply(ErrNotAllowed, "pm.not_allowed")
+		return
+	}
+	if this.server.offers.countFrom(this) >= maxPendingOffers {
+		this.errorReply(ErrRateLimited, "file.too_many", maxPendingOffers)
+		return
+	}
+
+	o := &fileOffer{
+		id:      "f" + strconv.FormatUint(this.server.nextSeq(), 10),
+		from:    this,
+		to:      to,
+		name:    name,
+		size:    size,
+		created: this.server.Clock.Now(),
+		tokens:  fileBurst,
+	}
+	this.server.offers.Add(o)
+
+	to.SendMsg("file-offer|" + o.id + "|" + this.Name() + "|" + name + "|" + strconv.FormatInt(size, 10) + "\n")
+	this.SendMsg("file-offered|" + o.id + "|" + name + "\n")
+}
+
+// 定时作废到期的传输, 通知双方; 全部传输共用一个goroutine
+func (this *Server) offerJanitor(ticker Ticker) {
+	defer ticker.Stop()
+
+	for {
+		var now time.Time
+		select {
+		case now = <-ticker.C():
+		case <-this.stopped:
+			return
+		}
+		for _, o := range this.offers.expire(now) {
+			o.from.SendMsg("file-expired|" + o.id + "\n")
+			o.to.SendMsg("file-expired|" + o.id + "\n")
+		}
+	}
+}
+
+// 接收方同意或者拒绝, 消息格式: accept|编号 或 decline|编号
+func (this *User) answerOffer(id string, accept bool) {
+	this.server.offers.lock.Lock()
+	o, ok := this.server.offers.offers[id]
+	if ok && (o.to != this || o.accepted) {
+		ok = false
+	}
+	if ok && accept {
+		o.accepted = true
+		o.lastActive = this.server.Clock.Now()
+		o.filled = o.lastActive
+	}
+	if ok && !accept {
+		delete(this.server.offers.offers, id)
+	}
+	this.server.offers.lock.Unlock()
+
+	if !ok {
+		this.errorReply(ErrNotFound, "file.unknown", id)
+		return
+	}
+	if accept {
+		o.from.SendMsg("file-accepted|" + id + "\n")
+	} else {
+		o.from.SendMsg("file-declined|" + id + "\n")
+	}
+}
+
+func (this *User) DoAccept(arg string) {
+	this.answerOffer(arg, true)
+}
+
+func (this *User) DoDecline(arg string) {
+	this.answerOffer(arg, false)
+}
+
+// 发送方发来的一块文件内容, 消息格式: chunk|编号|base64内容
+func (this *User) DoChunk(arg string) {
+	id, data, _ := strings.Cut(arg, "|")
+	raw, err := base64.StdEncoding.DecodeString(data)
+	if err != nil || len(raw) == 0 || len(raw) > maxFileChunk {
+		this.protocolError(ErrBadData, "file.bad_chunk", maxFileChunk)
+		return
+	}
+
+	// 超出转发速度时先等一会儿, 要等太久的取消传输
+	switch delay, ok := this.server.reserveChunk(this, id, len(raw)); {
+	case !ok:
+		this.errorReply(ErrNotFound, "file.unknown", id)
+		return
+	case delay > readGrace:
+		if o, ok := this.server.offers.Remove(id); ok {
+			this.SendMsg("file-error|" + id + "|" + t(this, "file.too_fast") + "\n")
+			o.to.SendMsg("file-error|" + id + "|" + t(o.to, "file.too_fast") + "\n")
+		}
+		return
+	case delay > 0:
+		<-this.server.Clock.After(delay)
+	}
+
+	this.server.offers.lock.Lock()
+	o, ok := this.server.offers.offers[id]
+	if ok && (o.from != this || !o.accepted) {
+		ok = false
+	}
+	tooLarge, done := false, false
+	if ok {
+		o.sent += int64(len(raw))
+		o.lastActive = this.server.Clock.Now()
+		tooLarge = o.sent > o.size
+		done = o.sent == o.size
+		if tooLarge || done {
+			delete(this.server.offers.offers, id)
+		}
+	}
+	this.server.offers.lock.Unlock()
+
+	switch {
+	case !ok:
+		this.errorReply(ErrNotFound, "file.unknown", id)
+	case tooLarge:
+		// 比说好的大, 接收方收到的部分也不能用了
+		this.SendMsg("file-error|" + id + "|" + t(this, "file.overflow") + "\n")
+		o.to.SendMsg("file-error|" + id + "|" + t(o.to, "file.overflow") + "\n")
+	default:
+		o.to.SendMsg("chunk|" + id + "|" + data + "\n")
+		if done {
+			this.SendMsg("file-done|" + id + "\n")
+			o.to.SendMsg("file-done|" + id + "\n")
+		}
+	}
+}
+
+// 从传输的令牌桶里预订n字节, 返回要等多久才能转发; 没有这个传输或者不是发送方时返回false
+// 预订以后令牌可以是负的, 下一块接着往后等, 连续发得太快时等待的时间越来越长
+func (this *Server) reserveChunk(from *User, id string, n int) (time.Duration, bool) {
+	this.offers.lock.Lock()
+	defer this.offers.lock.Unlock()
+
+	o, ok := this.offers.offers[id]
+	if !ok || o.from != from || !o.accepted {
+		return 0, false
+	}
+	if this.FileRate <= 0 {
+		return 0, true
+	}
+	now := this.Clock.Now()
+	o.tokens += now.Sub(o.filled).Seconds() * float64(this.FileRate)
+	if o.tokens > fileBurst {
+		o.tokens = fileBurst
+	}
+	o.filled = now
+	o.tokens -= float64(n)
+	if o.tokens >= 0 {
+		return 0, true
+	}
+	return time.Duration(-o.tokens / float64(this.FileRate) * float64(time.Second)), true
+}
+
+// 用户下线时取消跟他有关的传输, 通知另一方
+func (this *User) cancelOffers() {
+	for _, o := range this.server.offers.RemoveUser(this) {
+		other := o.to
+		if other == this {
+			other = o.from
+		}
+		other.SendMsg("file-error|" + o.id + "|" + t(other, "file.peer_left") + "\n")
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 发起一个传输, 返回编号
+func offerFile(t *testing.T, from, to *testConn, toName string, name string, size int) string {
+	t.Helper()
+	from.send("file|" + toName + "|" + name + "|" + strconv.Itoa(size))
+	line := to.waitFor("file-offer|")
+	id := strings.Split(line, "|")[1]
+	from.waitFor("file-offered|" + id + "|" + name)
+	return id
+}
+
+func chunkLine(id string, data []byte) string {
+	return "chunk|" + id + "|" + base64.StdEncoding.EncodeToString(data)
+}
+
+func TestFileAccept(t *testing.T) {
+	server := startTestServer(t)
+	alice := dialTest(t, server, "alice")
+	bob := dialTest(t, server, "bob")
+
+	id := offerFile(t, alice, bob, "bob", "a.txt", 5)
+	bob.send("accept|" + id)
+	alice.waitFor("file-accepted|" + id)
+
+	alice.send(chunkLine(id, []byte("hello")))
+	bob.waitFor(chunkLine(id, []byte("hello")))
+	bob.waitFor("file-done|" + id)
+	alice.waitFor("file-done|" + id)
+}
+
+func TestFileDecline(t *testing.T) {
+	server := startTestServer(t)
+	alice := dialTest(t, server, "alice")
+	bob := dialTest(t, server, "bob")
+
+	id := offerFile(t, alice, bob, "bob", "a.txt", 5)
+	bob.send("decline|" + id)
+	alice.waitFor("file-declined|" + id)
+
+	// 拒绝以后再发内容是未知的编号
+	alice.send("caps|errors", chunkLine(id, []byte("hello")))
+	alice.waitFor("err|111|")
+}
+
+func TestFileOfferExpires(t *testing.T) {
+	clock := NewFakeClock(testEpoch)
+	server := startTestServer(t, WithClock(clock))
+	alice := dialTest(t, server, "alice")
+	bob := dialTest(t, server, "bob")
+
+	id := offerFile(t, alice, bob, "bob", "a.txt", 5)
+	clock.Advance(fileOfferTimeout - time.Second)
+	clock.Advance(fileJanitorInterval)
+	alice.waitFor("file-expired|" + id)
+	bob.waitFor("file-expired|" + id)
+}
+
+func TestFileStalledTransferExpires(t *testing.T) {
+	clock := NewFakeClock(testEpoch)
+	server := startTestServer(t, WithClock(clock))
+	alice := dialTest(t, server, "alice")
+	bob := dialTest(t, server, "bob")
+
+	id := offerFile(t, alice, bob, "bob", "a.txt", 10)
+	bob.send("accept|" + id)
+	alice.waitFor("file-accepted|" + id)
+	alice.send(chunkLine(id, []byte("hello")))
+	bob.waitFor("chunk|" + id)
+
+	// 同意以后的传输不按fileOfferTimeout作废, 停下来fileStallTimeout才作废
+	clock.Advance(fileStallTimeout - fileJanitorInterval)
+	if server.offers.countFrom(onlineUser(t, server, "alice")) != 1 {
+		t.Fatal("还在传的文件被作废了")
+	}
+	clock.Advance(fileJanitorInterval)
+	alice.waitFor("file-expired|" + id)
+	bob.waitFor("file-expired|" + id)
+}
+
+func TestFileSizeCap(t *testing.T) {
+	server := startTestServer(t, WithMaxFileSize(10))
+	alice := dialTest(t, server, "alice")
+	bob := dialTest(t, server, "bob")
+	alice.send("caps|errors")
+
+	alice.send("file|bob|big.bin|11")
+	alice.waitFor("err|102|")
+
+	// 发的内容比说好的大时双方都取消
+	id := offerFile(t, alice, bob, "bob", "a.txt", 3)
+	bob.send("accept|" + id)
+	alice.waitFor("file-accepted|" + id)
+	alice.send(chunkLine(id, []byte("toolong")))
+	alice.waitFor("file-error|" + id)
+	bob.waitFor("file-error|" + id)
+}
+
+func TestFileHostileName(t *testing.T) {
+	server := startTestServer(t)
+	alice := dialTest(t, server, "alice")
+	dialTest(t, server, "bob")
+	alice.send("caps|errors")
+
+	for _, name := range []string{"../etc/passwd", `..\boot.ini`, "..", "a/b"} {
+		alice.send("file|bob|" + name + "|5")
+		alice.waitFor("err|100|")
+	}
+}
+
+func TestFilePendingCap(t *testing.T) {
+	server := startTestServer(t)
+	alice := dialTest(t, server, "alice")
+	bob := dialTest(t, server, "bob")
+
+	for i := 0; i < maxPendingOffers; i++ {
+		offerFile(t, alice, bob, "bob", "f"+strconv.Itoa(i), 5)
+	}
+	alice.send("caps|errors", "file|bob|one-more|5")
+	alice.waitFor("err|108|")
+}
+
+func TestFileRateLimit(t *testing.T) {
+	clock := NewFakeClock(testEpoch)
+	server := startTestServer(t, WithClock(clock), WithFileRate(maxFileChunk), WithReadRate(0, 0))
+	alice := dialTest(t, server, "alice")
+	bob := dialTest(t, server, "bob")
+
+	id := offerFile(t, alice, bob, "bob", "a.bin", 3*maxFileChunk)
+	bob.send("accept|" + id)
+	alice.waitFor("file-accepted|" + id)
+
+	// 前两块在fileBurst里马上转发, 第三块要等1秒
+	block := make([]byte, maxFileChunk)
+	waiters := clock.Waiters()
+	alice.send(chunkLine(id, block), chunkLine(id, block), chunkLine(id, block))
+	bob.waitFor("chunk|" + id)
+	bob.waitFor("chunk|" + id)
+	deadline := time.Now().Add(e2eWait)
+	for clock.Waiters() == waiters {
+		if time.Now().After(deadline) {
+			t.Fatal("第三块没有等待")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	clock.Advance(time.Second)
+	bob.waitFor("chunk|" + id)
+	bob.waitFor("file-done|" + id)
+}
+
+func TestFileTooFastCancels(t *testing.T) {
+	server := startTestServer(t, WithFileRate(1), WithReadRate(0, 0))
+	alice := dialTest(t, server, "alice")
+	bob := dialTest(t, server, "bob")
+
+	id := offerFile(t, alice, bob, "bob", "a.bin", 3*maxFileChunk)
+	bob.send("accept|" + id)
+	alice.waitFor("file-accepted|" + id)
+
+	block := make([]byte, maxFileChunk)
+	alice.send(chunkLine(id, block), chunkLine(id, block), chunkLine(id, block))
+	alice.waitFor("file-error|" + id + "|" + text("file.too_fast"))
+	bob.waitFor("file-error|" + id)
+}
+
+// 按默认的入站流量限制传一个比read-burst大的文件, 发送方按hello里的速度发送, 不会被当作刷屏断开
+func TestFileUnderDefaultReadRate(t *testing.T) {
+	clock := NewFakeClock(testEpoch)
+	server := startTestServer(t, WithClock(clock))
+	alice := dialTest(t, server, "alice")
+	bob := dialTest(t, server, "bob")
+
+	size := 2 * defaultReadBurst
+	id := offerFile(t, alice, bob, "bob", "big.bin", size)
+	bob.send("accept|" + id)
+	alice.waitFor("file-accepted|" + id)
+
+	// 每发一块把时钟拨过这一块在连接上占用的时间, 跟客户端的streamFile一样按read-rate的3/4发送
+	wireRate := float64(defaultReadRate) * 0.75
+	block := make([]byte, maxFileChunk)
+	for sent := 0; sent < size; sent += maxFileChunk {
+		line := chunkLine(id, block)
+		alice.send(line)
+		bob.waitFor("chunk|" + id)
+		clock.Advance(time.Duration(float64(len(line)+1) / wireRate * float64(time.Second)))
+	}
+	bob.waitFor("file-done|" + id)
+	alice.waitFor("file-done|" + id)
+}
@@ -0,0 +1,136 @@
+// 广播消息的格式模板, 由 -broadcast-format 设置
+// 模板里的 {time} {id} {name} {addr} {msg} 会替换成对应的内容, {{ 和 }} 表示括号本身
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 默认格式: [#连接编号]用户名:消息内容, 不让其他用户看到地址
+// 跟 [地址]用户名:消息内容 的结构一样, 客户端按同样的方法解析
+const defaultLineFormat = "[#{id}]{name}:{msg}"
+
+// 开启 -show-addr 时的默认格式, 也就是以前的格式: [地址]用户名:消息内容
+const addrLineFormat = "[{addr}]{name}:{msg}"
+
+// 可以直接用名字指定的格式
+var lineFormatPresets = map[string]string{
+	"default": defaultLineFormat,
+	"addr":    addrLineFormat,
+	"noaddr":  "{name}:{msg}", // 只有用户名
+}
+
+// 模板里可以用的占位符
+var lineFormatFields = map[string]bool{"time": true, "id": true, "name": true, "addr": true, "msg": true}
+
+// 解析好的模板, 启动时解析一次, 之后每条广播直接拼接
+type LineFormat struct {
+	source string // 原始模板, 启动日志里显示
+	parts  []formatPart
+}
+
+// 模板的一段, field为空时是原样输出的text
+type formatPart struct {
+	text  string
+	field string
+}
+
+// 解析模板, 也可以是 lineFormatPresets 里的名字
+// 有不认识的占位符、括号不成对、没有 {msg} 或者以 {msg} 开头时返回错误
+// 以 {msg} 开头时用户可以发一条 "[私聊]..." 或者 "[系统]..." 冒充别的消息, 所以前面要有文字或者服务器填的内容
+func ParseLineFormat(s string) (*LineFormat, error) {
+	if preset, ok := lineFormatPresets[s]; ok {
+		s = preset
+	}
+
+	parts, err := parseTemplate(s, lineFormatFields)
+	if err != nil {
+		return nil, err
+	}
+	hasMsg := false
+	for _, p := range parts {
+		if p.field == "msg" {
+			hasMsg = true
+		}
+	}
+	if !hasMsg {
+		return nil, errors.New("模板里没有 {msg}")
+	}
+	// 开头只有空白时看下一段
+	first := parts[0]
+	if first.field == "" && strings.TrimSpace(first.text) == "" && len(parts) > 1 {
+		first = parts[1]
+	}
+	if first.field == "msg" {
+		return nil, errors.New("模板不能以 {msg} 开头")
+	}
+	return &LineFormat{source: s, parts: parts}, nil
+}
+
+// 把模板拆成原样输出的文字和占位符, fields是可以用的占位符; 欢迎语的模板也用它, 见greeting.go
+func parseTemplate(s string, fields map[string]bool) ([]formatPart, error) {
+	var parts []formatPart
+	var text strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch {
+		case strings.HasPrefix(s[i:], "{{"):
+			text.WriteByte('{')
+			i++
+		case strings.HasPrefix(s[i:], "}}"):
+			text.WriteByte('}')
+			i++
+		case s[i] == '{':
+			end := strings.IndexByte(s[i:], '}')
+			if end < 0 {
+				return nil, errors.New("括号没有闭合: " + s[i:])
+			}
+			field := s[i+1 : i+end]
+			if !fields[field] {
+				return nil, errors.New("不认识的占位符: {" + field + "}")
+			}
+			if text.Len() > 0 {
+				parts = append(parts, formatPart{text: text.String()})
+				text.Reset()
+			}
+			parts = append(parts, formatPart{field: field})
+			i += end
+		case s[i] == '}':
+			return nil, errors.New("多余的右括号, 请写成 }}")
+		default:
+			text.WriteByte(s[i])
+		}
+	}
+	if text.Len() > 0 {
+		parts = append(parts, formatPart{text: text.String()})
+	}
+	return parts, nil
+}
+
+func (this *LineFormat) String() string {
+	return this.source
+}
+
+// 按模板生成一行广播消息
+func (this *LineFormat) Render(user *User, msg string, now time.Time) string {
+	var b strings.Builder
+	for _, p := range this.parts {
+		switch p.field {
+		case "":
+			b.WriteString(p.text)
+		case "time":
+			b.WriteString(now.Format("15:04:05"))
+		case "id":
+			b.WriteString(strconv.FormatUint(user.ID, 10))
+		case "name":
+			b.WriteString(user.DisplayName())
+		case "addr":
+			b.WriteString(user.Addr)
+		case "msg":
+			b.WriteString(msg)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestParseLineFormat(t *testing.T) {
+	for _, c := range []struct{ format, want string }{
+		{"default", defaultLineFormat},
+		{"addr", addrLineFormat},
+		{"noaddr", "{name}:{msg}"},
+		{"{time} <{name}> {msg}", "{time} <{name}> {msg}"},
+	} {
+		f, err := ParseLineFormat(c.format)
+		if err != nil || f.String() != c.want {
+			t.Errorf("ParseLineFormat(%q) = %v, %v", c.format, f, err)
+		}
+	}
+	for _, bad := range []string{"{name}", "{who}:{msg}", "{msg", "{msg}}x}", "}{msg}"} {
+		if _, err := ParseLineFormat(bad); err == nil {
+			t.Errorf("ParseLineFormat(%q) 没有报错", bad)
+		}
+	}
+}
+
+// 以 {msg} 开头的模板可以冒充私聊和系统消息, 不允许
+func TestParseLineFormatLeadingMsg(t *testing.T) {
+	for _, bad := range []string{"{msg}", "{msg} <{name}>", "  {msg} ({id})"} {
+		if _, err := ParseLineFormat(bad); err == nil {
+			t.Errorf("ParseLineFormat(%q) 没有报错", bad)
+		}
+	}
+	for _, good := range []string{"> {msg}", "{id} {msg}", "{time}{msg}"} {
+		if _, err := ParseLineFormat(good); err != nil {
+			t.Errorf("ParseLineFormat(%q) = %v", good, err)
+		}
+	}
+}
+
+// 广播按模板生成, {{ 和 }} 输出括号本身
+func TestBroadcastFormat(t *testing.T) {
+	clock := NewFakeClock(testEpoch)
+	format, err := ParseLineFormat("{{{time}}} #{id} {name}@{addr}: {msg}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := startTestServer(t, WithClock(clock), WithLineFormat(format))
+	alice := dialTest(t, server, "alice")
+	user := onlineUser(t, server, "alice")
+
+	clock.Advance(time.Minute)
+	alice.send("hi")
+	alice.waitFor(fmt.Sprintf("{15:05:05} #%d alice@%s: hi", user.ID, user.Addr))
+}
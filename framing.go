@@ -0,0 +1,96 @@
+// 消息的分帧方式: 默认每条消息一行, 以换行结束
+// 客户端发 frame|len 之后改成长度前缀: 4字节大端长度 + 这么多字节的UTF-8内容, 内容里可以有换行
+// 服务器先用换行回复 frame-ok|len, 之后双方收发的都是长度前缀的帧, 帧里的命令跟以前完全一样
+// 服务器在hello里用 frame=len 告诉客户端支持这种方式
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+	"sync/atomic"
+)
+
+// 分帧方式
+const (
+	frameLine = iota // 换行结束
+	frameLen         // 长度前缀
+)
+
+// 一帧最多多少字节, 超过时断开连接, 之后的数据已经没法分帧了
+const maxFrameSize = 64 * 1024
+
+var errFrameTooLarge = errors.New("frame too large")
+
+// 读一个长度前缀的帧
+func readFrame(r *bufio.Reader) (string, error) {
+	var head [4]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return "", err
+	}
+	n := binary.BigEndian.Uint32(head[:])
+	if n > maxFrameSize {
+		return "", errFrameTooLarge
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return "", err
+	}
+	return string(payload), nil
+}
+
+// 把一帧追加到buf后面
+func appendFrame(buf []byte, payload string) []byte {
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(payload)))
+	return append(buf, payload...)
+}
+
+// 按当前的分帧方式读一条消息, 返回的内容以换行结束, 跟按行读的一样
+// 帧里的命令跟按行时一样都是一行, 多行消息用 ml| 转义(见multiline.go)
+// 带换行的帧丢掉并提示, 否则转发给按行读的客户端时会变成好几条消息
+func (this *User) readMessage(r *bufio.Reader) (string, error) {
+	if atomic.LoadInt32(&this.framing) != frameLen {
+		return r.ReadString('\n')
+	}
+	for {
+		payload, err := readFrame(r)
+		if err != nil {
+			return "", err
+		}
+		if !strings.ContainsAny(payload, "\r\n") {
+			return payload + "\n", nil
+		}
+		this.protocolError(ErrBadData, "frame.newline")
+	}
+}
+
+// 按当前的分帧方式编码要发给客户端的内容, 一次发的是一帧, 多行消息的几行也在同一帧里
+func (this *User) encodeMessage(msg string) []byte {
+	if atomic.LoadInt32(&this.framing) != frameLen {
+		return []byte(msg)
+	}
+	return appendFrame(nil, strings.TrimSuffix(msg, "\n"))
+}
+
+// 切换分帧方式, 消息格式: frame|len; 切换后这个连接不能再换回按行
+func (this *User) DoFrame(arg string) {
+	if arg != "len" {
+		this.errorReply(ErrUsage, "frame.usage")
+		return
+	}
+	if atomic.LoadInt32(&this.framing) == frameLen {
+		return
+	}
+
+	// 回复和切换要在写锁里一起完成, 不能让别的消息夹在中间
+	this.writeLock.Lock()
+	defer this.writeLock.Unlock()
+
+	if err := this.writeRaw([]byte("frame-ok|len\n")); err != nil {
+		this.markBroken(err)
+		return
+	}
+	atomic.StoreInt32(&this.framing, frameLen)
+}
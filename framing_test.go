@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 切换成长度前缀以后的连接, 直接读写帧
+type frameConn struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// 连上服务器, 读掉hello, 发 frame|len 并等到 frame-ok|len
+func dialFramed(t *testing.T, server *Server) *frameConn {
+	t.Helper()
+	conn, err := net.Dial("tcp", server.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(e2eWait))
+	c := &frameConn{t: t, conn: conn, reader: bufio.NewReader(conn)}
+	hello, _ := c.reader.ReadString('\n')
+	if !strings.Contains(hello, "|frame=len|") {
+		t.Fatalf("hello = %q", hello)
+	}
+	conn.Write([]byte("frame|len\n"))
+	for {
+		line, err := c.reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if line == "frame-ok|len\n" {
+			return c
+		}
+	}
+}
+
+func (this *frameConn) send(payload string) {
+	this.conn.Write(appendFrame(nil, payload))
+}
+
+// 读帧直到内容里有want
+func (this *frameConn) waitFor(want string) string {
+	this.t.Helper()
+	for {
+		payload, err := readFrame(this.reader)
+		if err != nil {
+			this.t.Fatalf("没有等到 %q: %v", want, err)
+		}
+		if strings.Contains(payload, want) {
+			return payload
+		}
+	}
+}
+
+// 帧里的命令跟按行时一样, 按行的客户端和按帧的客户端可以一起聊天
+func TestFramedConnection(t *testing.T) {
+	server := startTestServer(t)
+	bob := dialTest(t, server, "bob")
+	alice := dialFramed(t, server)
+
+	alice.send("rename|alice")
+	alice.waitFor(text("rename.done", "alice"))
+	alice.send("hi from frames")
+	bob.waitFor("alice:hi from frames")
+	bob.send("hi from lines")
+	alice.waitFor("bob:hi from lines")
+
+	// 带换行的帧会变成好几行, 丢掉
+	alice.send("line one\nline two")
+	alice.waitFor(text("frame.newline"))
+	bob.send("sync-bob")
+	bob.waitFor("sync-bob")
+	if bob.saw("line two") {
+		t.Fatal("带换行的帧转发出去了")
+	}
+
+	alice.send("frame|line")
+	alice.waitFor(text("frame.usage"))
+}
+
+// 帧太长时提示后断开
+func TestFrameTooLarge(t *testing.T) {
+	server := startTestServer(t, WithReadRate(0, 0))
+	alice := dialFramed(t, server)
+	var head [4]byte
+	binary.BigEndian.PutUint32(head[:], maxFrameSize+1)
+	alice.conn.Write(head[:])
+	alice.waitFor(text("frame.too_large", maxFrameSize))
+	if _, err := readFrame(alice.reader); err == nil {
+		t.Fatal("帧太长以后连接没有断开")
+	}
+}
@@ -0,0 +1,5 @@
+module github.com/Achiyun/Golang-IM-System
+
+go 1.24
+
+require github.com/mattn/go-sqlite3 v1.14.52
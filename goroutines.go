@@ -0,0 +1,109 @@
+// 排查goroutine泄漏: goroutines 命令按函数统计当前的goroutine数, 只有管理员可以使用
+// 每个goroutine算在它的调用栈里第一个本程序(package main)的函数上, 没有时算在创建它的函数上
+// 上线下线的人数不变而某一行一直变多, 通常就是那里泄漏了
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// 最多显示多少行, 剩下的合并成一行
+const maxGoroutineGroups = 30
+
+// 一组goroutine
+type goroutineGroup struct {
+	fn    string
+	count int
+}
+
+// 取全部goroutine的调用栈, 缓冲区不够时加倍
+func allStacks() []byte {
+	buf := make([]byte, 64*1024)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			return buf[:n]
+		}
+		buf = make([]byte, len(buf)*2)
+	}
+}
+
+// 一个goroutine应该算在哪个函数上, stack是 runtime.Stack 输出里的一段
+func goroutineFunc(stack string) string {
+	lines := strings.Split(stack, "\n")
+	first, creator := "", ""
+	// 第一行是 goroutine 编号 [状态]:, 之后函数和文件位置交替出现
+	for _, line := range lines[1:] {
+		if line == "" || strings.HasPrefix(line, "\t") {
+			continue
+		}
+		if fn, ok := strings.CutPrefix(line, "created by "); ok {
+			creator, _, _ = strings.Cut(fn, " in goroutine ")
+			continue
+		}
+		fn := line
+		if i := strings.LastIndex(fn, "("); i > 0 {
+			fn = fn[:i]
+		}
+		if first == "" {
+			first = fn
+		}
+		if strings.HasPrefix(fn, "main.") {
+			return fn
+		}
+	}
+	if creator != "" {
+		return "created by " + creator
+	}
+	return first
+}
+
+// 按函数统计goroutine, 多的在前面
+func goroutineGroups() []goroutineGroup {
+	counts := make(map[string]int)
+	for _, stack := range strings.Split(strings.TrimSpace(string(allStacks())), "\n\n") {
+		counts[goroutineFunc(stack)]++
+	}
+
+	groups := make([]goroutineGroup, 0, len(counts))
+	for fn, n := range counts {
+		groups = append(groups, goroutineGroup{fn: fn, count: n})
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].count != groups[j].count {
+			return groups[i].count > groups[j].count
+		}
+		return groups[i].fn < groups[j].fn
+	})
+	return groups
+}
+
+// 按函数列出当前的goroutine数, 消息格式: goroutines, 只回复给发送的用户
+func (this *User) DoGoroutines() {
+	if !this.IsAdmin() {
+		this.errorReply(ErrPermission, "goroutines.admin_only")
+		return
+	}
+
+	groups := goroutineGroups()
+	total := 0
+	for _, g := range groups {
+		total += g.count
+	}
+	this.SendMsg(fmt.Sprintf("goroutines total=%d online=%d\n", total, this.server.OnlineCount()))
+
+	rest := 0
+	for i, g := range groups {
+		if i >= maxGoroutineGroups {
+			rest += g.count
+			continue
+		}
+		this.SendMsg(fmt.Sprintf("%6d %s\n", g.count, g.fn))
+	}
+	if rest > 0 {
+		this.SendMsg(fmt.Sprintf("%6d %s\n", rest, t(this, "goroutines.other", len(groups)-maxGoroutineGroups)))
+	}
+}
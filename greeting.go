@@ -0,0 +1,101 @@
+// 用户上线后单独发给他的欢迎语, 例如群规和当天的安排
+// -greeting 文件 是模板, 每次有人上线时重新读取, 修改后不用重启; 可以用 {name} {id} {online} {server} {date} {time}, {{ 和 }} 表示括号本身
+// 部署时也可以设置 Server.Greeter, 返回的内容同样只发给新用户; 模板和Greeter都设置时先发模板
+// 欢迎语出错只记日志, 不影响上线
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// 生成发给新用户的欢迎语, 返回空字符串时不发
+type Greeter func(user *User) (string, error)
+
+// 欢迎语模板里可以用的占位符
+var greetingFields = map[string]bool{"name": true, "id": true, "online": true, "server": true, "date": true, "time": true}
+
+// 欢迎语文件最多多少字节, 防止配错文件时把大文件发给每个人
+const maxGreetingSize = 8 * 1024
+
+// 读取并解析欢迎语模板, 启动前检查参数时也用它
+func loadGreeting(path string) ([]formatPart, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxGreetingSize {
+		return nil, fmt.Errorf("文件超过 %d 字节", maxGreetingSize)
+	}
+	return parseTemplate(strings.TrimRight(string(data), "\r\n"), greetingFields)
+}
+
+// 按模板生成发给某个用户的欢迎语
+func (this *Server) renderGreeting(parts []formatPart, user *User) string {
+	now := this.Clock.Now()
+	var b strings.Builder
+	for _, p := range parts {
+		switch p.field {
+		case "":
+			b.WriteString(p.text)
+		case "name":
+			b.WriteString(user.Name())
+		case "id":
+			b.WriteString(strconv.FormatUint(user.ID, 10))
+		case "online":
+			b.WriteString(strconv.Itoa(this.OnlineCount()))
+		case "server":
+			b.WriteString(this.Name)
+		case "date":
+			b.WriteString(now.Format("2006-01-02"))
+		case "time":
+			b.WriteString(now.Format("15:04:05"))
+		}
+	}
+	return b.String()
+}
+
+// 上线后发送欢迎语, Greeter在单独的goroutine里运行, 慢了也不会耽误上线
+func (this *User) greet() {
+	if path := this.server.GreetingFile; path != "" {
+		parts, err := loadGreeting(path)
+		if err != nil {
+			fmt.Printf("session=#%d greeting err: %v\n", this.ID, err)
+		} else {
+			this.sendGreeting(this.server.renderGreeting(parts, this))
+		}
+	}
+
+	if greeter := this.server.Greeter; greeter != nil {
+		go this.runGreeter(greeter)
+	}
+}
+
+// 运行部署方设置的Greeter, 返回错误或者panic时只记日志
+func (this *User) runGreeter(greeter Greeter) {
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Printf("session=#%d greeter panic: %v\n", this.ID, r)
+		}
+	}()
+
+	text, err := greeter(this)
+	if err != nil {
+		fmt.Printf("session=#%d greeter err: %v\n", this.ID, err)
+		return
+	}
+	this.sendGreeting(text)
+}
+
+// 一行一条地发送欢迎语, 空行也照样发送, 开头和结尾的空行去掉
+func (this *User) sendGreeting(text string) {
+	text = strings.Trim(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	if text == "" {
+		return
+	}
+	for _, line := range strings.Split(text, "\n") {
+		this.SendMsg(line + "\n")
+	}
+}
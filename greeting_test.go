@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// 模板只发给新上线的用户, 每次上线时重新读取文件
+func TestGreetingTemplate(t *testing.T) {
+	clock := NewFakeClock(time.Date(2026, 10, 15, 9, 30, 0, 0, time.Local))
+	path := filepath.Join(t.TempDir(), "greeting.txt")
+	os.WriteFile(path, []byte("欢迎 {name} (#{id}) 来到 {server}, 现在 {online} 人在线\r\n\n{date} {time} {{群规}}\n"), 0644)
+	server := startTestServer(t, WithClock(clock), WithName("lobby"), WithGreeting(path, nil))
+
+	first := dialTest(t, server, "")
+	first.waitFor("欢迎 guest-1 (#1) 来到 lobby, 现在 1 人在线")
+	if line := first.waitFor("2026-10-15"); line != "2026-10-15 09:30:00 {群规}" {
+		t.Fatalf("欢迎语 = %q", line)
+	}
+
+	os.WriteFile(path, []byte("今天的安排: 十点开会\n"), 0644)
+	second := dialTest(t, server, "")
+	second.waitFor("今天的安排: 十点开会")
+
+	first.send("sync-first")
+	first.waitFor("sync-first")
+	if first.saw("今天的安排") {
+		t.Fatal("别的用户也收到了欢迎语")
+	}
+}
+
+// 模板读不到或者有错, Greeter返回错误或者panic, 都不影响上线
+func TestGreetingFailures(t *testing.T) {
+	dir := t.TempDir()
+	bad := filepath.Join(dir, "bad.txt")
+	os.WriteFile(bad, []byte("欢迎 {nickname}"), 0644)
+
+	greeters := map[string]Greeter{
+		"error": func(user *User) (string, error) { return "", errors.New("hook down") },
+		"panic": func(user *User) (string, error) { panic("hook bug") },
+	}
+	for _, file := range []string{bad, filepath.Join(dir, "missing.txt")} {
+		for name, greeter := range greeters {
+			server := startTestServer(t, WithGreeting(file, greeter))
+			alice := dialTest(t, server, "alice")
+			alice.send("hello-" + name)
+			alice.waitFor("alice:hello-" + name)
+			if alice.saw("欢迎") {
+				t.Fatalf("%s %s: 出错时发了欢迎语", file, name)
+			}
+		}
+	}
+}
+
+// 模板和Greeter都设置时都发, Greeter返回空字符串时不发
+func TestGreeter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "greeting.txt")
+	os.WriteFile(path, []byte("请先看置顶的群规"), 0644)
+	greeter := func(user *User) (string, error) {
+		if user.ID == 2 {
+			return "", nil
+		}
+		return "\n你好 " + user.Name() + "\n第二行\n\n", nil
+	}
+	server := startTestServer(t, WithGreeting(path, greeter))
+
+	alice := dialTest(t, server, "")
+	alice.waitFor("请先看置顶的群规")
+	alice.waitFor("你好 guest-1")
+	alice.waitFor("第二行")
+
+	bob := dialTest(t, server, "")
+	bob.waitFor("请先看置顶的群规")
+	bob.send("sync-bob")
+	bob.waitFor("sync-bob")
+	if bob.saw("你好") {
+		t.Fatal("Greeter返回空字符串时也发了")
+	}
+}
@@ -0,0 +1,426 @@
+// 给程序使用的gRPC接口, 接口定义见 proto/chat.proto
+// 只用标准库实现: 通过不加密的HTTP/2收发gRPC请求, protobuf只实现了用到的字符串和嵌套消息字段
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 请求消息的最大长度
+const grpcMaxMessage = 1 << 20
+
+// 每个订阅最多缓存多少条还没发出去的消息, 满了之后丢弃新消息
+const grpcStreamQueue = 256
+
+// gRPC的状态码, 只列出用到的
+const (
+	grpcOK                 = 0
+	grpcInvalidArgument    = 3
+	grpcNotFound           = 5
+	grpcAlreadyExists      = 6
+	grpcPermissionDenied   = 7
+	grpcResourceExhausted  = 8
+	grpcFailedPrecondition = 9
+	grpcUnimplemented      = 12
+	grpcInternal           = 13
+	grpcUnavailable        = 14
+	grpcUnauthenticated    = 16
+)
+
+// 带状态码的gRPC错误
+type grpcError struct {
+	code int
+	msg  string
+}
+
+func (e *grpcError) Error() string { return e.msg }
+
+func grpcErrorf(code int, format string, args ...interface{}) error {
+	return &grpcError{code: code, msg: fmt.Sprintf(format, args...)}
+}
+
+// 把回复给用户的错误换成gRPC的状态码, 提示文字不变
+func grpcReplyError(e *replyError) error {
+	code := grpcInternal
+	switch e.code {
+	case ErrUsage, ErrEmptyMessage, ErrTooLarge, ErrBadData, ErrBadName:
+		code = grpcInvalidArgument
+	case ErrNoSuchUser, ErrUserOffline, ErrNotFound:
+		code = grpcNotFound
+	case ErrPermission, ErrNotAllowed:
+		code = grpcPermissionDenied
+	case ErrRateLimited:
+		code = grpcResourceExhausted
+	case ErrLockedDown, ErrExpired, ErrAlready:
+		code = grpcFailedPrecondition
+	case ErrNameTaken:
+		code = grpcAlreadyExists
+	case ErrUnavailable:
+		code = grpcUnavailable
+	}
+	return grpcErrorf(code, "%s", e.text)
+}
+
+// 监听gRPC请求, 所有请求都需要带上 x-api-token
+type GRPCServer struct {
+	server *Server
+	token  string
+}
+
+func NewGRPCServer(server *Server, token string) *GRPCServer {
+	return &GRPCServer{server: server, token: token}
+}
+
+// 开始监听, 一直阻塞到出错为止
+func (this *GRPCServer) ListenAndServe(addr string) error {
+	var protocols http.Protocols
+	protocols.SetUnencryptedHTTP2(true)
+	srv := &http.Server{
+		Addr:      addr,
+		Handler:   this,
+		Protocols: &protocols,
+	}
+	return srv.ListenAndServe()
+}
+
+func (this *GRPCServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		http.Error(w, "grpc only", http.StatusUnsupportedMediaType)
+		return
+	}
+	w.Header().Set("Content-Type", "application/grpc")
+
+	token := r.Header.Get("X-Api-Token")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(this.token)) != 1 {
+		writeGRPCStatus(w, grpcErrorf(grpcUnauthenticated, "invalid api token"))
+		return
+	}
+
+	var err error
+	switch r.URL.Path {
+	case "/im.ChatService/SendPublic":
+		err = this.sendPublic(w, r)
+	case "/im.ChatService/SendPrivate":
+		err = this.sendPrivate(w, r)
+	case "/im.ChatService/ListOnline":
+		err = this.listOnline(w, r)
+	case "/im.ChatService/Subscribe":
+		err = this.subscribe(w, r)
+	default:
+		err = grpcErrorf(grpcUnimplemented, "unknown method %s", r.URL.Path)
+	}
+	writeGRPCStatus(w, err)
+}
+
+// 找到一个通过Subscribe上线的机器人, 只能以这些身份发消息
+func (this *GRPCServer) bot(name string) (*User, error) {
+	this.server.mapLock.RLock()
+	user, ok := this.server.OnlineMap[name]
+	this.server.mapLock.RUnlock()
+	if !ok {
+		return nil, grpcErrorf(grpcFailedPrecondition, "%s 没有订阅", name)
+	}
+	if _, ok := user.conn.(*virtualConn); !ok {
+		return nil, grpcErrorf(grpcFailedPrecondition, "%s 不是通过gRPC上线的", name)
+	}
+	return user, nil
+}
+
+func (this *GRPCServer) sendPublic(w http.ResponseWriter, r *http.Request) error {
+	req, err := readGRPCRequest(r.Body)
+	if err != nil {
+		return err
+	}
+	text := req.String(2)
+	if text == "" {
+		return grpcErrorf(grpcInvalidArgument, "text is empty")
+	}
+	user, err := this.bot(req.String(1))
+	if err != nil {
+		return err
+	}
+	// 跟TCP用户一样经过全员禁言、重复消息和慢速模式的检查
+	if e, _ := user.publish(text); e != nil {
+		return grpcReplyError(e)
+	}
+	return writeGRPCMessage(w, nil)
+}
+
+func (this *GRPCServer) sendPrivate(w http.ResponseWriter, r *http.Request) error {
+	req, err := readGRPCRequest(r.Body)
+	if err != nil {
+		return err
+	}
+	to, text := req.String(2), req.String(3)
+	if to == "" || text == "" || strings.Contains(to, "|") {
+		return grpcErrorf(grpcInvalidArgument, "to or text is invalid")
+	}
+	user, err := this.bot(req.String(1))
+	if err != nil {
+		return err
+	}
+	// 直接发送, 内容原样发出; 白名单、对方收私聊太频繁等原因换成对应的状态码
+	if e := user.sendPrivate(to, text); e != nil {
+		return grpcReplyError(e)
+	}
+	return writeGRPCMessage(w, nil)
+}
+
+func (this *GRPCServer) listOnline(w http.ResponseWriter, r *http.Request) error {
+	if _, err := readGRPCRequest(r.Body); err != nil {
+		return err
+	}
+
+	// 跟who一样, 没有开启 -show-addr 时不返回地址
+	showAddr := this.server.ShowAddr
+	onlineUser := func(name, addr string) []byte {
+		var u []byte
+		u = appendPBString(u, 1, name)
+		if showAddr {
+			u = appendPBString(u, 2, addr)
+		}
+		return u
+	}
+
+	var reply []byte
+	this.server.mapLock.RLock()
+	for name, user := range this.server.OnlineMap {
+		reply = appendPBBytes(reply, 1, onlineUser(name, user.Addr))
+	}
+	this.server.mapLock.RUnlock()
+
+	if this.server.cluster != nil {
+		for name, p := range this.server.cluster.RemoteUsers() {
+			reply = appendPBBytes(reply, 1, onlineUser(name, p.Addr))
+		}
+	}
+	return writeGRPCMessage(w, reply)
+}
+
+// 以一个虚拟用户上线, 把这个用户收到的每条消息作为一个Event发给调用方, 调用方断开后下线
+func (this *GRPCServer) subscribe(w http.ResponseWriter, r *http.Request) error {
+	req, err := readGRPCRequest(r.Body)
+	if err != nil {
+		return err
+	}
+	name := req.String(1)
+	if name == "" || strings.Contains(name, "|") || spoofsPrefix(name) {
+		return grpcErrorf(grpcInvalidArgument, "name is invalid")
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return grpcErrorf(grpcInternal, "streaming unsupported")
+	}
+
+	this.server.mapLock.RLock()
+	_, exists := this.server.OnlineMap[name]
+	this.server.mapLock.RUnlock()
+	if !exists && this.server.cluster != nil {
+		_, exists = this.server.cluster.RemoteUsers()[name]
+	}
+	if exists {
+		return grpcErrorf(grpcAlreadyExists, "当前用户名被使用")
+	}
+
+	conn := newVirtualConn(name)
+	user := NewUser(conn, this.server)
+	user.setName(name)
+	user.Transport = "grpc"
+	user.setCap(capBot)
+	user.Online()
+	defer func() {
+		user.Offline()
+		conn.Close()
+	}()
+
+	// 先把响应头发出去, 调用方马上就能知道订阅成功了
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case msg := <-conn.out:
+			if err := writeGRPCMessage(w, appendPBString(nil, 1, msg)); err != nil {
+				return err
+			}
+			flusher.Flush()
+		case <-conn.closed:
+			return nil
+		case <-r.Context().Done():
+			return nil
+		}
+	}
+}
+
+// 读取一元请求中的唯一一条消息
+func readGRPCRequest(body io.Reader) (pbMessage, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(body, header[:]); err != nil {
+		return nil, grpcErrorf(grpcInvalidArgument, "read request: %v", err)
+	}
+	if header[0] != 0 {
+		return nil, grpcErrorf(grpcUnimplemented, "compressed messages are not supported")
+	}
+	n := binary.BigEndian.Uint32(header[1:])
+	if n > grpcMaxMessage {
+		return nil, grpcErrorf(grpcInvalidArgument, "message too large")
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(body, data); err != nil {
+		return nil, grpcErrorf(grpcInvalidArgument, "read request: %v", err)
+	}
+	msg, err := parsePB(data)
+	if err != nil {
+		return nil, grpcErrorf(grpcInvalidArgument, "decode request: %v", err)
+	}
+	return msg, nil
+}
+
+// 写一条带长度前缀的响应消息
+func writeGRPCMessage(w http.ResponseWriter, msg []byte) error {
+	frame := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(msg)))
+	_, err := w.Write(append(frame, msg...))
+	return err
+}
+
+// 在trailer里写上状态码
+func writeGRPCStatus(w http.ResponseWriter, err error) {
+	code, msg := grpcOK, ""
+	if err != nil {
+		code, msg = grpcInternal, err.Error()
+		var gerr *grpcError
+		if errors.As(err, &gerr) {
+			code = gerr.code
+		}
+	}
+	w.Header().Set(http.TrailerPrefix+"Grpc-Status", strconv.Itoa(code))
+	if msg != "" {
+		w.Header().Set(http.TrailerPrefix+"Grpc-Message", msg)
+	}
+}
+
+// 解析后的protobuf消息, 只保留长度前缀类型的字段, key是字段编号
+type pbMessage map[int][]byte
+
+func (m pbMessage) String(field int) string {
+	return string(m[field])
+}
+
+func parsePB(data []byte) (pbMessage, error) {
+	msg := make(pbMessage)
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return nil, errors.New("bad field key")
+		}
+		data = data[n:]
+		field, wire := int(key>>3), key&7
+
+		switch wire {
+		case 0: // varint, 用不到, 跳过
+			_, n = binary.Uvarint(data)
+			if n <= 0 {
+				return nil, errors.New("bad varint")
+			}
+			data = data[n:]
+		case 1: // 64位定长
+			if len(data) < 8 {
+				return nil, errors.New("truncated field")
+			}
+			data = data[8:]
+		case 5: // 32位定长
+			if len(data) < 4 {
+				return nil, errors.New("truncated field")
+			}
+			data = data[4:]
+		case 2: // 长度前缀: 字符串、字节和嵌套消息
+			size, n := binary.Uvarint(data)
+			if n <= 0 || size > uint64(len(data)-n) {
+				return nil, errors.New("truncated field")
+			}
+			msg[field] = data[n : n+int(size)]
+			data = data[n+int(size):]
+		default:
+			return nil, fmt.Errorf("unsupported wire type %d", wire)
+		}
+	}
+	return msg, nil
+}
+
+func appendPBBytes(buf []byte, field int, value []byte) []byte {
+	buf = binary.AppendUvarint(buf, uint64(field)<<3|2)
+	buf = binary.AppendUvarint(buf, uint64(len(value)))
+	return append(buf, value...)
+}
+
+func appendPBString(buf []byte, field int, value string) []byte {
+	if value == "" {
+		return buf
+	}
+	return appendPBBytes(buf, field, []byte(value))
+}
+
+// 通过gRPC订阅的虚拟用户使用的连接, 写入的每条消息会发给订阅方
+type virtualConn struct {
+	addr virtualAddr
+	out  chan string
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+func newVirtualConn(name string) *virtualConn {
+	return &virtualConn{
+		addr:   virtualAddr("grpc:" + name),
+		out:    make(chan string, grpcStreamQueue),
+		closed: make(chan struct{}),
+	}
+}
+
+func (this *virtualConn) Write(b []byte) (int, error) {
+	select {
+	case <-this.closed:
+		return 0, net.ErrClosed
+	default:
+	}
+
+	select {
+	case this.out <- strings.TrimSuffix(string(b), "\n"):
+	default:
+		fmt.Println("grpc stream queue full, message dropped:", this.addr)
+	}
+	return len(b), nil
+}
+
+// 虚拟用户没有输入, 消息都通过SendPublic和SendPrivate发送
+func (this *virtualConn) Read(b []byte) (int, error) {
+	<-this.closed
+	return 0, io.EOF
+}
+
+func (this *virtualConn) Close() error {
+	this.closeOnce.Do(func() { close(this.closed) })
+	return nil
+}
+
+func (this *virtualConn) LocalAddr() net.Addr                { return this.addr }
+func (this *virtualConn) RemoteAddr() net.Addr               { return this.addr }
+func (this *virtualConn) SetDeadline(t time.Time) error      { return nil }
+func (this *virtualConn) SetReadDeadline(t time.Time) error  { return nil }
+func (this *virtualConn) SetWriteDeadline(t time.Time) error { return nil }
+
+type virtualAddr string
+
+func (a virtualAddr) Network() string { return "grpc" }
+func (a virtualAddr) String() string  { return string(a) }
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testAPIToken = "test-token"
+
+// 在h2c上启动gRPC接口, 返回它的地址和客户端
+func startTestGRPC(t *testing.T, server *Server) (string, *http.Client) {
+	t.Helper()
+	ts := httptest.NewUnstartedServer(NewGRPCServer(server, testAPIToken))
+	var protocols http.Protocols
+	protocols.SetUnencryptedHTTP2(true)
+	ts.Config.Protocols = &protocols
+	ts.Start()
+	t.Cleanup(ts.Close)
+
+	transport := &http.Transport{Protocols: &protocols}
+	t.Cleanup(transport.CloseIdleConnections)
+	return ts.URL, &http.Client{Transport: transport}
+}
+
+func grpcRequest(ctx context.Context, url, method string, msg []byte) *http.Request {
+	var body bytes.Buffer
+	var header [5]byte
+	binary.BigEndian.PutUint32(header[1:], uint32(len(msg)))
+	body.Write(header[:])
+	body.Write(msg)
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, url+"/im.ChatService/"+method, &body)
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("X-Api-Token", testAPIToken)
+	return req
+}
+
+// 调用一元方法, 返回状态码和回复的消息
+func grpcCall(t *testing.T, url string, client *http.Client, method string, msg []byte) (int, pbMessage) {
+	t.Helper()
+	resp, err := client.Do(grpcRequest(context.Background(), url, method, msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, err := strconv.Atoi(resp.Trailer.Get("Grpc-Status"))
+	if err != nil {
+		t.Fatalf("%s: 没有Grpc-Status: %v", method, err)
+	}
+	var reply pbMessage
+	if len(data) >= 5 {
+		if reply, err = parsePB(data[5:]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return code, reply
+}
+
+// 以name订阅, 等到机器人上线; 测试结束时断开
+func subscribeBot(t *testing.T, url string, client *http.Client, server *Server, name string) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	resp, err := client.Do(grpcRequest(ctx, url, "Subscribe", appendPBString(nil, 1, name)))
+	if err != nil {
+		cancel()
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cancel()
+		resp.Body.Close()
+	})
+	deadline := time.Now().Add(e2eWait)
+	for {
+		if _, ok := server.lookupUser(name); ok {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s 没有上线", name)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestGRPCListOnlineHidesAddr(t *testing.T) {
+	for _, showAddr := range []bool{false, true} {
+		server := startTestServer(t, WithShowAddr(showAddr))
+		url, client := startTestGRPC(t, server)
+		alice := dialTest(t, server, "alice")
+		addr := alice.conn.LocalAddr().String()
+
+		code, reply := grpcCall(t, url, client, "ListOnline", nil)
+		if code != grpcOK {
+			t.Fatalf("ListOnline status = %d", code)
+		}
+		user, err := parsePB(reply[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.String(1) != "alice" {
+			t.Fatalf("name = %q", user.String(1))
+		}
+		if got := user.String(2); (got == addr) != showAddr || (!showAddr && got != "") {
+			t.Fatalf("showAddr=%v addr = %q", showAddr, got)
+		}
+	}
+}
+
+func TestGRPCSendPrivate(t *testing.T) {
+	server := startTestServer(t)
+	url, client := startTestGRPC(t, server)
+	subscribeBot(t, url, client, server, "bot")
+	alice := dialTest(t, server, "alice")
+
+	msg := func(to, text string) []byte {
+		return appendPBString(appendPBString(appendPBString(nil, 1, "bot"), 2, to), 3, text)
+	}
+	if code, _ := grpcCall(t, url, client, "SendPrivate", msg("alice", "a|b")); code != grpcOK {
+		t.Fatalf("SendPrivate status = %d", code)
+	}
+	alice.waitFor("a|b")
+
+	if code, _ := grpcCall(t, url, client, "SendPrivate", msg("nobody", "hi")); code != grpcNotFound {
+		t.Fatalf("unknown user status = %d", code)
+	}
+
+	// 开了白名单的用户不收没有允许过的机器人的私聊
+	alice.send("pm|allowlist|on")
+	alice.waitFor(text("pm.allowlist_on"))
+	if code, _ := grpcCall(t, url, client, "SendPrivate", msg("alice", "hi")); code != grpcPermissionDenied {
+		t.Fatalf("allowlist status = %d", code)
+	}
+}
+
+func TestGRPCSendPublicChecks(t *testing.T) {
+	server := startTestServer(t)
+	url, client := startTestGRPC(t, server)
+	subscribeBot(t, url, client, server, "bot")
+	alice := dialTest(t, server, "alice")
+
+	msg := appendPBString(appendPBString(nil, 1, "bot"), 2, "grpc-public")
+	if code, _ := grpcCall(t, url, client, "SendPublic", msg); code != grpcOK {
+		t.Fatalf("SendPublic status = %d", code)
+	}
+	alice.waitFor("grpc-public")
+
+	atomic.AddUint64(&server.lockdown, 1)
+	if code, _ := grpcCall(t, url, client, "SendPublic", msg); code != grpcFailedPrecondition {
+		t.Fatalf("lockdown status = %d", code)
+	}
+}
+
+func TestGRPCRejectsBadToken(t *testing.T) {
+	server := startTestServer(t)
+	url, client := startTestGRPC(t, server)
+	req := grpcRequest(context.Background(), url, "ListOnline", nil)
+	req.Header.Set("X-Api-Token", "wrong")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if code := resp.Trailer.Get("Grpc-Status"); code != strconv.Itoa(grpcUnauthenticated) {
+		t.Fatalf("status = %s", code)
+	}
+}
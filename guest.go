@@ -0,0 +1,42 @@
+// 新连接的默认用户名, 以前直接用 IP:端口, 会把地址广播给所有人
+// 默认是 guest-编号, 部署时可以换掉 Server.GuestName, 地址只保存在 User.Addr 里
+package main
+
+import (
+	"strconv"
+	"sync/atomic"
+)
+
+// 生成第n个默认用户名, n从1开始递增; 生成的名字已经有人用时会换下一个n
+type GuestNamer func(n uint64) string
+
+// 默认的命名方式: guest-1, guest-2 ...
+func defaultGuestName(n uint64) string {
+	return "guest-" + strconv.FormatUint(n, 10)
+}
+
+// 最多试几次, 自定义的命名方式一直重复时不会卡住
+const maxGuestNameTries = 1000
+
+// 给新用户分配一个没人用的默认用户名, 调用时需要持有mapLock; remote是集群里别的实例上的用户
+// 名字被占用(比如有人改名成了 guest-5)时跳过, 一直分配不到时用连接编号
+func (this *Server) guestNameLocked(user *User, remote map[string]Presence) string {
+	namer := this.GuestName
+	if namer == nil {
+		namer = defaultGuestName
+	}
+	for i := 0; i < maxGuestNameTries; i++ {
+		name := namer(atomic.AddUint64(&this.guests, 1))
+		if name == "" || spoofsPrefix(name) || name[0] == '#' {
+			continue
+		}
+		if _, ok := this.OnlineMap[name]; ok {
+			continue
+		}
+		if _, ok := remote[name]; ok {
+			continue
+		}
+		return name
+	}
+	return defaultGuestName(user.ID) + "-" + strconv.FormatUint(atomic.AddUint64(&this.guests, 1), 10)
+}
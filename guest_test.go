@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// 同时上线的连接拿到不同的guest名字, 被别人占用的名字跳过
+func TestGuestNamesUnique(t *testing.T) {
+	server := startTestServer(t, WithRenameLimit(0, 0))
+	alice := dialTest(t, server, "")
+	alice.waitFor(text("user.guest_hint", "guest-1"))
+	alice.send("rename|guest-2")
+	alice.waitFor(text("rename.done", "guest-2"))
+
+	const n = 10
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			dialTest(t, server, "")
+		}()
+	}
+	wg.Wait()
+	eventually(t, "没有全部上线", func() bool { return server.OnlineCount() == n+1 })
+
+	server.mapLock.RLock()
+	defer server.mapLock.RUnlock()
+	for i := 3; i <= n+2; i++ {
+		name := fmt.Sprintf("guest-%d", i)
+		if _, ok := server.OnlineMap[name]; !ok {
+			t.Errorf("没有 %s", name)
+		}
+	}
+}
+
+// 可以换成自己的起名方式, 不能用的名字跳过
+func TestGuestNamer(t *testing.T) {
+	namer := func(n uint64) string {
+		if n == 1 {
+			return "[bad]"
+		}
+		return fmt.Sprintf("访客%d", n)
+	}
+	server := startTestServer(t, WithGuestNamer(namer))
+	c := dialTest(t, server, "")
+	c.send("whoami")
+	if line := c.waitFor("whoami "); !strings.HasPrefix(line, "whoami name=访客2 ") {
+		t.Fatalf("whoami = %q", line)
+	}
+}
+
+// 还在用guest名字的用户不保存设置
+func TestGuestPrefsNotSaved(t *testing.T) {
+	store := NewMemStore()
+	server := startTestServer(t, WithStore(store))
+	c := dialTest(t, server, "")
+	c.send("settings|away|午饭")
+	c.waitFor(text("settings.set", "away", "午饭"))
+	c.conn.Close()
+	eventually(t, "没有下线", func() bool { return server.OnlineCount() == 0 })
+	if prefs, _ := store.LoadPrefs("guest-1"); len(prefs) != 0 {
+		t.Fatalf("保存了guest的设置: %v", prefs)
+	}
+}
@@ -0,0 +1,52 @@
+// 连接后服务器发的第一行: hello|proto=协议版本|frame=len|..., 客户端据此确认连上的是IM服务器
+// frame=len 表示支持长度前缀的分帧, 见framing.go; compress=deflate 表示支持压缩并且还有名额, 见compress.go
+// escape=say 表示支持 say|内容: 内容原样作为公聊发出, 不当作命令, 用来发 who、to|... 这样开头的消息
+// dedupe=msg-id 表示支持带消息ID的 pub 和 to, 重复的不再转发, 见dedupe.go
+// heartbeat=ping 表示支持 ping|token, 客户端可以用它检查连接是否还通, 见ping.go
+// read-rate 和 file-rate 是每个连接和每个文件每秒最多收多少字节, 0表示不限制; 客户端传文件时按这个速度发送, 见filexfer.go
+// errors 是全部错误编号和名字: 编号:名字,编号:名字, 见errors.go
+// name、version、uptime 是服务器的名字(-server-name)、版本和运行了多少秒, 客户端连接后显示
+// 之后增加的信息也按 |键=值 追加在后面, 客户端不认识的键直接忽略
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// 协议版本, 不兼容的改动才加一
+const protocolVersion = 1
+
+// 服务器的版本, 发布时用 go build -ldflags "-X main.version=1.2.3" 设置
+var version = "dev"
+
+// 连接后的第一行
+func (this *Server) helloLine() string {
+	fields := []string{
+		"hello",
+		"proto=" + strconv.Itoa(protocolVersion),
+		"frame=len",
+	}
+	// 压缩的名额用完或者没有开启时不说支持压缩, 见compress.go
+	if this.compressAvailable() {
+		fields = append(fields, "compress="+compressDeflate)
+	}
+	fields = append(fields,
+		"escape=say",
+		"heartbeat=ping",
+		"dedupe=msg-id",
+		"errors="+errorTable(),
+		"version="+version,
+		"uptime="+strconv.FormatInt(int64(this.Uptime().Seconds()), 10),
+	)
+	fields = append(fields, "read-rate="+strconv.Itoa(this.ReadRate), "file-rate="+strconv.Itoa(this.FileRate))
+	if this.Name != "" {
+		fields = append(fields, "name="+this.Name)
+	}
+	return strings.Join(fields, "|")
+}
+
+// 服务器的名字只能用在hello的一个字段里
+func validServerName(name string) bool {
+	return !strings.ContainsAny(name, "|\r\n")
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// hello里有服务器的名字、版本和运行了多少秒
+func TestHelloIdentity(t *testing.T) {
+	clock := NewFakeClock(testEpoch)
+	server := startTestServer(t, WithClock(clock), WithName("lobby"))
+	clock.Advance(3*24*time.Hour + 4*time.Hour)
+
+	alice := dialTest(t, server, "")
+	hello := alice.seen[0]
+	for _, want := range []string{"|version=" + version + "|", "|uptime=273600|", "|name=lobby"} {
+		if !strings.Contains(hello, want) {
+			t.Errorf("hello = %q, 没有 %q", hello, want)
+		}
+	}
+
+	// 没有名字时不发name
+	if hello := startTestServer(t).helloLine(); strings.Contains(hello, "|name=") {
+		t.Errorf("hello = %q", hello)
+	}
+}
+
+// 没有用 -ldflags 设置时版本是dev, -server-name 默认是主机名
+func TestIdentityDefaults(t *testing.T) {
+	if version != "dev" {
+		t.Errorf("version = %q", version)
+	}
+	host, _ := os.Hostname()
+	if got := flag.Lookup("server-name").DefValue; got != host {
+		t.Errorf("-server-name 默认是 %q, want %q", got, host)
+	}
+	if server := NewServer("127.0.0.1", 0); server.Uptime() != 0 {
+		t.Errorf("还没有开始监听时 uptime = %v", server.Uptime())
+	}
+}
@@ -0,0 +1,142 @@
+// 测试用的服务器和连接: 在随机端口上启动一个进程内的服务器, 用原始的协议行跟它说话
+package main
+
+import (
+	"flag"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "重新生成testdata里的golden文件")
+
+// 测试时默认的开始时间, 用FakeClock时从这里开始拨
+var testEpoch = time.Date(2026, 1, 2, 15, 4, 5, 0, time.UTC)
+
+// 测试里不踢空闲的用户, 用FakeClock往前拨时也不会踢
+const testIdleTimeout = 365 * 24 * time.Hour
+
+// 在随机端口上启动服务器, 测试结束时关闭
+func startTestServer(t *testing.T, opts ...Option) *Server {
+	t.Helper()
+	opts = append([]Option{WithIdleTimeout(testIdleTimeout), WithPresence(0, 0)}, opts...)
+	server := NewServer("127.0.0.1", 0, opts...)
+	done := make(chan error, 1)
+	go func() { done <- server.Start() }()
+	t.Cleanup(func() {
+		server.Close()
+		<-done
+	})
+
+	deadline := time.Now().Add(e2eWait)
+	for server.Addr() == nil {
+		select {
+		case err := <-done:
+			t.Fatal("server start:", err)
+		default:
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("服务器没有开始监听")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return server
+}
+
+// 一个连到测试服务器的连接, 按行收发协议消息
+type testConn struct {
+	t    *testing.T
+	conn net.Conn
+	*lineWatcher
+}
+
+// 连上服务器并等到自己的上线通知, name不为空时改名并等到改名成功
+func dialTest(t *testing.T, server *Server, name string) *testConn {
+	t.Helper()
+	conn, err := net.Dial("tcp", server.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	c := &testConn{t: t, conn: conn, lineWatcher: watchLines(t, name, conn)}
+	c.waitFor("hello|")
+	if name != "" {
+		c.send("rename|" + name)
+		c.waitFor(name)
+	}
+	return c
+}
+
+// 发一行消息, 自动加上换行
+func (this *testConn) send(lines ...string) {
+	this.t.Helper()
+	if _, err := this.conn.Write([]byte(strings.Join(lines, "\n") + "\n")); err != nil {
+		this.t.Fatal("write:", err)
+	}
+}
+
+// 等到连接被服务器关闭, 返回之前没读的行
+func (this *testConn) waitClosed() []string {
+	this.t.Helper()
+	deadline := time.NewTimer(e2eWait)
+	defer deadline.Stop()
+	var rest []string
+	for {
+		select {
+		case line, ok := <-this.lines:
+			if !ok {
+				return rest
+			}
+			this.seen = append(this.seen, line)
+			rest = append(rest, line)
+		case <-deadline.C:
+			this.t.Fatalf("%s: 连接没有被关闭, 读到的输出:\n%s", this.name, strings.Join(this.seen, "\n"))
+		}
+	}
+}
+
+// 在线用户里的某个用户, 测试里直接检查服务器的状态时使用
+func onlineUser(t *testing.T, server *Server, name string) *User {
+	t.Helper()
+	user, ok := server.lookupUser(name)
+	if !ok {
+		t.Fatalf("%s 不在线", name)
+	}
+	return user
+}
+
+// 默认语言的提示文字; 测试函数里的t是*testing.T, 用这个取catalog里的文字
+func text(key string, args ...interface{}) string {
+	return t(nil, key, args...)
+}
+
+// 已经读过的行里有没有包含want的
+func (this *lineWatcher) saw(want string) bool {
+	for _, line := range this.seen {
+		if strings.Contains(line, want) {
+			return true
+		}
+	}
+	return false
+}
+
+// 跟testdata里的golden文件逐字节比较, 加上 -update 时重新生成
+func checkGolden(t *testing.T, name string, got string) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(path, []byte(got), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != string(want) {
+		t.Fatalf("%s 不一致:\n--- got ---\n%s--- want ---\n%s", name, got, want)
+	}
+}
@@ -0,0 +1,373 @@
+// 公聊消息的历史记录和撤回
+package main
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 默认保留最近多少条公聊消息
+const defaultHistorySize = 50
+
+// 发出后多长时间内可以撤回
+const recallWindow = 2 * time.Minute
+
+// 一条公聊消息
+type historyEntry struct {
+	Seq  uint64
+	From *User  // 发送消息的用户, 撤回时用来判断是不是作者
+	Line string // 广播出去的完整内容
+	Time time.Time
+}
+
+// 公聊消息的历史记录, 新上线的用户会先收到这些消息
+// 广播不等历史记录: PublicChat只是把消息放进feed, 由run这个goroutine追加
+// 消息存在一个环形缓冲里, 追加不用复制; 读的时候才在锁里复制出一个快照, 没有新消息时一直用同一个快照
+// 补发历史和 history 查询只在没有快照时拿一下锁, 不会拖慢广播
+type History struct {
+	size int
+	feed chan historyOp
+	stop chan struct{} // Close时关闭, run退出, 在等的sync马上返回
+	once sync.Once
+
+	lock  sync.Mutex     // 保护下面的环形缓冲: run追加、撤回删除和生成快照
+	ring  []historyEntry // 容量是size, 最早的一条在ring[start]
+	start int
+	count int
+
+	cached atomic.Pointer[[]historyEntry] // 上一次生成的快照, 有修改时清掉; 放进去之后不再修改
+
+	dropped uint64 // feed满了没有记下来的消息数, 用atomic读写
+}
+
+// feed里的一项: 要追加的消息, 或者等待之前的消息都追加完的标记
+type historyOp struct {
+	entry historyEntry
+	done  chan struct{}
+}
+
+// feed的容量, 满了时新消息不记入历史, 广播照常进行
+const historyFeedSize = 1024
+
+func NewHistory(size int) *History {
+	h := &History{size: size, feed: make(chan historyOp, historyFeedSize), stop: make(chan struct{})}
+	if size > 0 {
+		h.ring = make([]historyEntry, size)
+	}
+	return h
+}
+
+// 把feed里的消息追加到历史记录, 服务器启动时开始运行, Close后退出
+func (this *History) run() {
+	for {
+		select {
+		case op := <-this.feed:
+			if op.done != nil {
+				close(op.done)
+				continue
+			}
+			this.append(op.entry)
+		case <-this.stop:
+			return
+		}
+	}
+}
+
+// 停止run, 服务器停止时调用, 可以重复调用
+func (this *History) Close() {
+	this.once.Do(func() { close(this.stop) })
+}
+
+// 追加一条消息, 超出容量时覆盖最早的; 只在run里调用
+func (this *History) append(e historyEntry) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if this.count < this.size {
+		this.ring[(this.start+this.count)%this.size] = e
+		this.count++
+	} else {
+		this.ring[this.start] = e
+		this.start = (this.start + 1) % this.size
+	}
+	this.cached.Store(nil)
+}
+
+// 记下一条消息, 不会阻塞; feed满了时丢掉这一条并返回false
+func (this *History) Add(e historyEntry) bool {
+	if this.size <= 0 {
+		return true
+	}
+	select {
+	case this.feed <- historyOp{entry: e}:
+		return true
+	default:
+		atomic.AddUint64(&this.dropped, 1)
+		return false
+	}
+}
+
+// 等已经放进feed的消息都追加完, 补发历史和撤回之前调用, 保证能看到刚发的消息
+// 历史记录已经停止时马上返回
+func (this *History) sync() {
+	if this.size <= 0 {
+		return
+	}
+	done := make(chan struct{})
+	select {
+	case this.feed <- historyOp{done: done}:
+	case <-this.stop:
+		return
+	}
+	select {
+	case <-done:
+	case <-this.stop:
+	}
+}
+
+// 当前的快照, 按从旧到新的顺序, 不能修改
+func (this *History) snapshot() []historyEntry {
+	if p := this.cached.Load(); p != nil {
+		return *p
+	}
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if p := this.cached.Load(); p != nil {
+		return *p
+	}
+	entries := make([]historyEntry, this.count)
+	for i := range entries {
+		entries[i] = this.ring[(this.start+i)%this.size]
+	}
+	this.cached.Store(&entries)
+	return entries
+}
+
+// 按顺序返回当前全部的历史消息
+func (this *History) Lines() []string {
+	entries := this.snapshot()
+	lines := make([]string, 0, len(entries))
+	for _, e := range entries {
+		lines = append(lines, e.Line)
+	}
+	return lines
+}
+
+// 按顺序返回最近n条历史消息
+func (this *History) Last(n int) []string {
+	lines := this.Lines()
+	if n < len(lines) {
+		lines = lines[len(lines)-n:]
+	}
+	return lines
+}
+
+// 按顺序返回当前全部的历史消息, 每行前面带上序号
+func (this *History) SeqLines() []string {
+	entries := this.snapshot()
+	lines := make([]string, 0, len(entries))
+	for _, e := range entries {
+		lines = append(lines, "#"+strconv.FormatUint(e.Seq, 10)+" "+e.Line)
+	}
+	return lines
+}
+
+// 查找一条消息
+func (this *History) Get(seq uint64) (historyEntry, bool) {
+	for _, e := range this.snapshot() {
+		if e.Seq == seq {
+			return e, true
+		}
+	}
+	return historyEntry{}, false
+}
+
+// 删除一条消息, 返回是否找到; 后面的消息往前移一格, 撤回很少见, 不在乎复制
+func (this *History) Remove(seq uint64) bool {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	for i := 0; i < this.count; i++ {
+		if this.ring[(this.start+i)%this.size].Seq != seq {
+			continue
+		}
+		for j := i; j < this.count-1; j++ {
+			this.ring[(this.start+j)%this.size] = this.ring[(this.start+j+1)%this.size]
+		}
+		this.count--
+		this.ring[(this.start+this.count)%this.size] = historyEntry{}
+		this.cached.Store(nil)
+		return true
+	}
+	return false
+}
+
+// feed满了没有记下来的消息数
+func (this *History) Dropped() uint64 {
+	return atomic.LoadUint64(&this.dropped)
+}
+
+// 发送一条公聊消息: 记入历史后广播给全部在线用户
+func (this *Server) PublicChat(user *User, msg string) error {
+	now := this.Clock.Now()
+	e := historyEntry{
+		Seq:  this.nextSeq(),
+		From: user,
+		Line: this.LineFormat.Render(user, msg, now),
+		Time: now,
+	}
+	this.history.Add(e)
+	atomic.AddUint64(&this.stats.publicMsgs, 1)
+	user.recallable.set(e.Seq, e.Time)
+
+	return this.enqueue(e.Line)
+}
+
+// 把历史消息发给刚上线的用户
+func (this *User) ReplayHistory() {
+	this.server.history.sync()
+	for _, line := range this.server.history.Lines() {
+		this.SendMsg(line + "\n")
+	}
+}
+
+// 查看最近的公聊消息, 消息格式: history|条数, 只发给请求的用户
+func (this *User) DoHistory(arg string) {
+	n, err := strconv.Atoi(arg)
+	if err != nil || n <= 0 {
+		this.errorReply(ErrUsage, "history.usage")
+		return
+	}
+	lines := this.server.history.Last(n)
+	if len(lines) == 0 {
+		this.SendMsg(t(this, "history.none") + "\n")
+		return
+	}
+	for _, line := range lines {
+		this.SendMsg(line + "\n")
+	}
+}
+
+// 用户最后一条公聊消息的序号和时间, 自己撤回时用, 不用去历史记录里找
+// 关掉历史(-history 0)或者历史记录里已经挤掉了也能撤回; 管理员撤回时从别的goroutine清掉, 所以要加锁
+type lastPublicMsg struct {
+	lock sync.Mutex
+	seq  uint64 // 0表示没有可以撤回的消息
+	at   time.Time
+}
+
+func (this *lastPublicMsg) set(seq uint64, at time.Time) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	this.seq, this.at = seq, at
+}
+
+// 取出可以撤回的消息, window大于0时超过window的不取出, 返回的ok为false; 没有消息时seq为0
+func (this *lastPublicMsg) take(now time.Time, window time.Duration) (seq uint64, ok bool) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if this.seq == 0 {
+		return 0, false
+	}
+	if window > 0 && now.Sub(this.at) > window {
+		return this.seq, false
+	}
+	seq, this.seq = this.seq, 0
+	return seq, true
+}
+
+// 还是seq这一条时清掉, 管理员撤回了这条消息以后作者不能再撤回一次
+func (this *lastPublicMsg) clear(seq uint64) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if this.seq == seq {
+		this.seq = 0
+	}
+}
+
+// 撤回消息, 消息格式: recall 撤回自己的最后一条公聊消息, recall|序号 管理员撤回任意一条
+// 管理员可以用 recall|list 查看历史消息的序号
+func (this *User) DoRecall(arg string) {
+	if arg == "" {
+		this.recallOwn()
+		return
+	}
+	if !this.IsAdmin() {
+		this.server.audit(this, "recall", "", arg, auditDenied)
+		this.errorReply(ErrPermission, "recall.admin_only")
+		return
+	}
+	if arg == "list" {
+		for _, line := range this.server.history.SeqLines() {
+			this.SendMsg(line + "\n")
+		}
+		return
+	}
+	seq, err := strconv.ParseUint(arg, 10, 64)
+	if err != nil {
+		this.errorReply(ErrUsage, "recall.usage")
+		return
+	}
+
+	// 按序号撤回要知道作者, 只能撤回历史记录里还有的消息; 管理员不受时间窗口限制
+	this.server.history.sync()
+	e, ok := this.server.history.Get(seq)
+	if !ok || !this.server.history.Remove(seq) {
+		this.errorReply(ErrNotFound, "recall.none")
+		return
+	}
+	e.From.recallable.clear(seq)
+	if e.From != this {
+		// 撤回别人的消息是管理操作
+		this.server.audit(this, "recall", e.From.Name(), arg, auditOK)
+	}
+	this.server.announceRecall(e.From.Name(), seq)
+}
+
+// 撤回自己的最后一条公聊消息, 只能在时间窗口内撤回, 管理员不受限制
+func (this *User) recallOwn() {
+	window := recallWindow
+	if this.IsAdmin() {
+		window = 0
+	}
+	seq, ok := this.recallable.take(this.server.Clock.Now(), window)
+	if seq == 0 {
+		this.errorReply(ErrNotFound, "recall.none")
+		return
+	}
+	if !ok {
+		this.errorReply(ErrExpired, "recall.too_late", int(recallWindow.Minutes()))
+		return
+	}
+
+	// 历史记录里没有(关掉了或者已经挤掉了)也照样撤回, 通知大家
+	this.server.history.sync()
+	this.server.history.Remove(seq)
+	this.server.announceRecall(this.Name(), seq)
+}
+
+// 通知全部用户from撤回了一条消息, 声明了 recall 能力的客户端另外收到 recall|序号, 可以自己删掉那条消息
+func (this *Server) announceRecall(from string, seq uint64) {
+	this.Announce(t(nil, "recall.notice", from))
+
+	this.mapLock.RLock()
+	users := make([]*User, 0, len(this.OnlineMap))
+	for _, user := range this.OnlineMap {
+		users = append(users, user)
+	}
+	this.mapLock.RUnlock()
+
+	frame := "recall|" + strconv.FormatUint(seq, 10) + "\n"
+	for _, user := range users {
+		if user.HasCap(capRecall) {
+			user.SendMsg(frame)
+		}
+	}
+}
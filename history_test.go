@@ -0,0 +1,151 @@
+package main
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func runHistory(t testing.TB, size int) *History {
+	h := NewHistory(size)
+	go h.run()
+	t.Cleanup(h.Close)
+	return h
+}
+
+func addLines(h *History, from, to int) {
+	for i := from; i <= to; i++ {
+		h.Add(historyEntry{Seq: uint64(i), Line: "m" + strconv.Itoa(i)})
+	}
+	h.sync()
+}
+
+func equalLines(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestHistoryRing(t *testing.T) {
+	h := runHistory(t, 3)
+	addLines(h, 1, 2)
+	if got := h.Lines(); !equalLines(got, []string{"m1", "m2"}) {
+		t.Fatalf("lines = %v", got)
+	}
+	addLines(h, 3, 7)
+	if got := h.Lines(); !equalLines(got, []string{"m5", "m6", "m7"}) {
+		t.Fatalf("lines = %v", got)
+	}
+	if got := h.Last(2); !equalLines(got, []string{"m6", "m7"}) {
+		t.Fatalf("last = %v", got)
+	}
+}
+
+func TestHistoryRemove(t *testing.T) {
+	h := runHistory(t, 4)
+	addLines(h, 1, 6) // 环形缓冲已经绕过一圈: m3 m4 m5 m6
+	if !h.Remove(4) || h.Remove(4) {
+		t.Fatal("Remove")
+	}
+	if got := h.Lines(); !equalLines(got, []string{"m3", "m5", "m6"}) {
+		t.Fatalf("after remove = %v", got)
+	}
+	addLines(h, 7, 8)
+	if got := h.Lines(); !equalLines(got, []string{"m5", "m6", "m7", "m8"}) {
+		t.Fatalf("after append = %v", got)
+	}
+	if _, ok := h.Get(3); ok {
+		t.Fatal("挤出去的消息还能找到")
+	}
+}
+
+// 拿到的快照不会被之后的追加修改
+func TestHistorySnapshotStable(t *testing.T) {
+	h := runHistory(t, 3)
+	addLines(h, 1, 3)
+	before := h.Lines()
+	addLines(h, 4, 5)
+	if !equalLines(before, []string{"m1", "m2", "m3"}) {
+		t.Fatalf("snapshot changed: %v", before)
+	}
+}
+
+// 一直有新消息时, 每次读到的都是连续的一段
+func TestHistoryConsistentUnderTraffic(t *testing.T) {
+	h := runHistory(t, 50)
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 1; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			h.Add(historyEntry{Seq: uint64(i)})
+		}
+	}()
+	for n := 0; n < 2000; n++ {
+		entries := h.snapshot()
+		for i := 1; i < len(entries); i++ {
+			if entries[i].Seq != entries[i-1].Seq+1 {
+				close(stop)
+				t.Fatalf("快照不连续: %d 后面是 %d", entries[i-1].Seq, entries[i].Seq)
+			}
+		}
+	}
+	close(stop)
+	wg.Wait()
+}
+
+// 停止以后sync不会一直等
+func TestHistorySyncAfterClose(t *testing.T) {
+	h := NewHistory(10)
+	h.Close()
+	done := make(chan struct{})
+	go func() {
+		h.sync()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(e2eWait):
+		t.Fatal("sync在历史记录停止以后还在等")
+	}
+}
+
+// 追加的开销跟保留多少条没有关系
+func BenchmarkHistoryAppend(b *testing.B) {
+	for _, size := range []int{50, 1000, 10000} {
+		b.Run(strconv.Itoa(size), func(b *testing.B) {
+			h := NewHistory(size)
+			e := historyEntry{Line: "[#1]alice:hello"}
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				e.Seq = uint64(i)
+				h.append(e)
+			}
+		})
+	}
+}
+
+// 没有新消息时读快照不用复制
+func BenchmarkHistorySnapshot(b *testing.B) {
+	h := NewHistory(defaultHistorySize)
+	for i := 0; i < defaultHistorySize; i++ {
+		h.append(historyEntry{Seq: uint64(i)})
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		h.snapshot()
+	}
+}
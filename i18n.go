@@ -0,0 +1,606 @@
+// 服务端提示语的多语言支持: 所有提示语都通过t按用户的语言取出
+// 用户发的内容只作为参数传入, 不会被翻译或修改
+// 客户端需要解析的格式(私聊的"对您说:"、who列表、[系统]等前缀)不翻译
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// 服务器的默认语言, 用 -lang 设置, 广播消息也使用这个语言
+var defaultLang = "zh"
+
+// 提示语, 第一层key是语言, 第二层key是消息编号
+var catalog = map[string]map[string]string{
+	"zh": {
+		"admin.wrong_token": "管理员口令错误",
+		"admin.granted":     "您已成为管理员",
+
+		"user.online":            "已上线",
+		"user.offline":           "下线",
+		"user.not_found":         "该用户名不存在",
+		"user.not_found_suggest": "用户不存在，您是指: %s ?",
+		"pm.offline":             "%s 不在线, 消息没有送到",
+		"presence.online_many":   "另有 %d 位用户上线: %s",
+		"presence.offline_many":  "另有 %d 位用户下线: %s",
+		"user.flood":             "发送的数据太多, 连接已断开",
+		"compress.usage":         "消息格式不正确， 请使用 \"compress|deflate\"格式",
+		"compress.unavailable":   "服务器现在不能开启压缩, 请不带 -compress 重新连接",
+		"frame.usage":            "消息格式不正确， 请使用 \"frame|len\"格式",
+		"frame.too_large":        "消息超过%d字节, 连接已断开",
+		"frame.newline":          "消息里不能有换行, 已丢弃",
+		"user.guest_hint":        "您现在的用户名是 %s, 可以用 rename|新用户名 改名",
+		"user.kicked":            "您被踢了",
+		"idle.warn":              "您已闲置 %s，%s 后将被断开，发送任意消息保持在线",
+		"idle.kicked_note":       "%s 因闲置被断开",
+		"user.empty":             "请不要发送空消息",
+
+		"rename.hash":    "用户名不能以#开头",
+		"rename.bracket": "用户名不能以[或{开头",
+		"rename.taken":   "当前用户名被使用",
+		"names.recent":   "用户名 %s 最近曾被其他用户使用",
+		"welcome.back":   "欢迎回来 %s，离线期间有 %d 条发给您的私信因为您不在线没有送到、错过 %d 条公共消息（历史可用 history 查看）",
+		"rename.done":    "您已经更新用户名:%s",
+		"rename.limit":   "本次连接最多改名%d次",
+		"rename.wait":    "改名太频繁, 请%d秒后再试",
+
+		"pm.usage":          "消息格式不正确， 请使用 \"to|张三|你好啊\"格式. ",
+		"pm.empty":          "无消息内容， 请重发 ",
+		"ml.too_long":       "消息太长, 最多%d字节",
+		"ml.too_many":       "行数太多, 最多%d行",
+		"ml.empty":          "无消息内容， 请重发 ",
+		"file.usage":        "消息格式不正确， 请使用 \"file|张三|文件名|字节数\"格式, 文件名不能带目录",
+		"file.too_large":    "文件太大, 最多%d字节",
+		"file.self":         "不能给自己发文件",
+		"file.unknown":      "没有编号为%s的文件",
+		"file.bad_chunk":    "文件内容要用base64编码, 每块最多%d字节",
+		"file.overflow":     "发送的内容超过了文件大小, 传输已取消",
+		"file.peer_left":    "对方已下线, 传输已取消",
+		"file.too_many":     "同时最多发起%d个传输, 请等之前的传完或者作废",
+		"file.too_fast":     "发送得太快, 传输已取消",
+		"pub.usage":         "消息格式不正确， 请使用 \"pub|消息ID|你好啊\"格式, 消息ID不能有空格, 最长64个字符",
+		"pm.failed":         "消息发送失败, 对方的连接已断开",
+		"pm.not_allowed":    "对方只接收允许过的用户发来的私聊, 已经通知对方",
+		"pm.request":        "%[1]s 想给您发私信，使用 pm|allow|%[1]s 允许",
+		"pm.allow_usage":    "消息格式不正确, 请使用 pm|allowlist|on、pm|allowlist|off、pm|allow|用户名 或 pm|deny|用户名",
+		"pm.allowlist_on":   "已开启私聊白名单, 只接收允许过的用户发来的私聊",
+		"pm.allowlist_off":  "已关闭私聊白名单, 所有人都可以给您发私聊",
+		"pm.allowed":        "已允许 %s 给您发私聊",
+		"pm.denied":         "已把 %s 移出私聊白名单",
+		"pm.recipient_busy": "对方消息过多，请稍后再试",
+		"pm.flood_notice":   "您这一分钟收到的私聊超过了%d条, 其他人发来的私聊暂时会被拒绝",
+
+		"public.failed": "消息发送失败, 请稍后重试",
+
+		"bot.admin_only": "只有管理员可以标记机器人",
+		"bot.marked":     "已将 %s 标记为机器人",
+
+		"recall.admin_only": "只有管理员可以撤回指定序号的消息",
+		"recall.usage":      "消息格式不正确， 请使用 \"recall|序号\"格式. ",
+		"recall.none":       "没有可以撤回的消息",
+		"recall.not_own":    "只能撤回自己的消息",
+		"recall.too_late":   "消息发出已超过%d分钟, 无法撤回",
+		"recall.notice":     "%s 撤回了一条消息",
+
+		"history.usage": "消息格式不正确， 请使用 \"history|10\"格式. ",
+		"history.none":  "还没有公聊消息",
+
+		"lockdown.notice":     "全员禁言中, 公聊消息不会发出, 私聊不受影响",
+		"lockdown.admin_only": "只有管理员可以设置全员禁言",
+		"lockdown.usage":      "消息格式不正确, 请使用 lockdown|on 或 lockdown|off",
+		"lockdown.already":    "全员禁言已经是%s",
+		"lockdown.on":         "管理员开启了全员禁言",
+		"lockdown.off":        "全员禁言已解除",
+
+		"drain.admin_only": "只有管理员可以设置维护模式",
+		"drain.usage":      "消息格式不正确, 请使用 drain|on 或 drain|off",
+		"drain.already":    "维护模式已经是%s",
+		"drain.on":         "服务器即将维护, 暂停接受新连接, 已经在线的用户不受影响",
+		"drain.off":        "维护模式已结束, 恢复接受新连接",
+		"drain.reject":     "服务器维护中, 请稍后再连接",
+
+		"audit.admin_only": "只有管理员可以查看审计记录",
+		"audit.usage":      "消息格式不正确， 请使用 \"audit|tail|20\"格式. ",
+		"audit.none":       "还没有审计记录",
+
+		"slow.admin_only":  "只有管理员可以设置慢速模式",
+		"slow.no_rooms":    "当前没有房间, 请使用 slowmode|秒数 设置全局慢速模式",
+		"slow.bad_seconds": "秒数不正确, 请使用 0 到 %d 之间的整数",
+		"slow.on":          "慢速模式已开启: 每%d秒可以发一条公聊消息",
+		"slow.off":         "慢速模式已关闭",
+		"slow.wait":        "慢速模式已开启, 请%d秒后再发言",
+		"repeat.dropped":   "请勿重复发送相同消息",
+		"repeat.mute":      "您一直在重复发送相同的消息, 已被禁言%d秒",
+		"repeat.muted":     "您因为重复发送消息被禁言, 请%d秒后再发言",
+
+		"schedule.admin_only": "只有管理员可以设置定时公告",
+		"schedule.usage":      "消息格式不正确， 请使用 \"%s|10m|开会了\"格式. ",
+		"schedule.bad_delay":  "时间格式不正确， 请使用 30s、10m、1h 这样的格式, 最长24h",
+		"schedule.too_many":   "最多同时设置%d条定时消息",
+		"schedule.added":      "已设置定时消息 #%d, %s后发送",
+		"schedule.fired":      "[提醒] %s",
+		"schedule.none":       "没有待发送的定时消息",
+		"schedule.reminder":   "提醒",
+		"schedule.announce":   "公告",
+		"schedule.item":       "#%d [%s] %s后: %s",
+		"schedule.not_found":  "没有这条定时消息",
+		"schedule.cancelled":  "已取消定时消息 #%d",
+
+		"settings.line":          "%s = %s    %s",
+		"settings.unknown":       "没有这项设置: %s, 发送 settings 查看全部设置",
+		"settings.invalid":       "%s: %s",
+		"settings.set":           "已设置 %s = %s",
+		"settings.on_off":        "只能设置为 on 或 off",
+		"settings.lang":          "只能设置为 zh 或 en, 设置为空表示使用服务器默认语言",
+		"settings.away":          "离开原因最多%d个字",
+		"setting.history":        "上线时是否补发最近的公聊消息(on/off)",
+		"setting.away":           "离开原因, 会显示在who列表里, 设置为空表示回来了",
+		"setting.pm_allowlist":   "是否只接收私聊白名单里的用户发来的私聊(on/off)",
+		"setting.pm_allow":       "私聊白名单, 逗号分隔的用户名, 用 pm|allow|用户名 和 pm|deny|用户名 修改",
+		"settings.pm_allow_bar":  "用户名不能包含|",
+		"settings.pm_allow_many": "私聊白名单最多%d个用户名",
+		"setting.lang":           "系统提示使用的语言(zh/en), 设置为空表示使用服务器默认语言",
+
+		"whois.name":       "用户名: %s",
+		"whois.session":    "连接编号: #%d",
+		"whois.addr":       "地址: %s",
+		"whois.transport":  "连接方式: %s",
+		"whois.since":      "上线时间: %s",
+		"whois.away":       "离开: %s",
+		"whois.idle":       "空闲: %s",
+		"whois.bot":        "机器人: 是",
+		"whois.compress":   "压缩: 收到的节省 %d%%, 发出的节省 %d%%",
+		"whois.traffic":    "流量: 收到 %d 字节, 发出 %d 字节",
+		"whois.names":      "用过的名字: %s",
+		"whois.name_owner": "上一个使用者: #%d %s, %s前",
+		"who.idle_line":    "%s    空闲%s",
+
+		"help.header":           "可用的命令:",
+		"help.footer":           "发送 help|命令名 查看详细说明, 不是命令的内容会作为公聊消息发给所有人",
+		"help.unknown":          "没有这个命令: %s, 发送 help 查看全部命令",
+		"help.help":             "查看可用的命令",
+		"help.help.long":        "help 列出当前可以使用的命令, help|命令名 显示这条命令的详细说明",
+		"help.who":              "查看在线用户",
+		"help.who.long":         "列出当前在线的用户和状态, who|json 返回JSON格式的列表, 给程序解析使用, who|idle 按最近活跃排序并显示空闲时间",
+		"help.whois":            "查看一个用户的详细信息",
+		"help.whois.long":       "显示用户的连接编号、地址、上线时间和离开原因, 用户名也可以写成 #连接编号",
+		"help.whoami":           "查看自己当前的身份",
+		"help.whoami.long":      "返回一行 whoami name=... session=#... addr=... admin=on|off caps=... away=..., 改名后可以用来确认",
+		"help.ping":             "测量到服务器的延迟",
+		"help.ping.long":        "服务器马上回复 pong|<token>|服务器时间(Unix毫秒), 不经过广播队列; 客户端输入 /ping 显示往返时间和时钟偏差",
+		"ping.usage":            "消息格式不正确, 请使用 ping|<token>, token不能包含 | 并且不超过64字节",
+		"help.wholist":          "在线用户名单, 给程序解析使用",
+		"help.wholist.long":     "返回 wholist|张三,李四 格式的在线用户名单, 按用户名排序",
+		"help.rename":           "修改用户名",
+		"help.rename.long":      "把用户名改成<name>, 用户名不能以#、[或{开头, 也不能和其他在线用户重复, 改名的次数和间隔有限制",
+		"help.to":               "私聊",
+		"help.to.long":          "只把消息发给<name>, 用户名也可以写成 #连接编号; 消息内容里可以有|; 声明了 caps|msg-id 时最后一段是<id>, 同一个ID重复发送只会发出一次",
+		"help.ml":               "多行的公聊消息",
+		"help.ml.long":          "换行写成 \\n, 反斜杠写成 \\\\; 后面的行缩进显示, 最多20行、4000字节; 客户端里一行以 \\ 结尾或者用 /paste 输入",
+		"help.pub":              "带消息ID的公聊",
+		"help.pub.long":         "跟直接发消息一样, 但同一个<id>重复发送只会发出一次, 每次都回复 ack|<id>; 客户端重试时使用",
+		"help.say":              "原样发一条公聊",
+		"help.say.long":         "<text>原样作为公聊发出, 即使它以命令开头(例如 who 或 to|...); 客户端发这样的消息时自动加上 say|",
+		"help.pm":               "私聊白名单",
+		"help.pm.long":          "pm|allowlist|on 开启后只接收白名单里的用户发来的私聊, 其他人发私聊时您会收到一次请求提示; pm|allow|用户名 和 pm|deny|用户名 修改白名单",
+		"help.compress":         "开启deflate压缩",
+		"help.compress.long":    "发出这条之后您发的数据都要压缩, 服务器回复 compress-ok|deflate 之后发给您的数据也压缩; 每条消息写完都会Flush, 开启后不能关闭",
+		"help.frame":            "改用长度前缀的分帧",
+		"help.frame.long":       "回复 frame-ok|len 之后, 双方收发的每条消息都是4字节大端长度加内容, 内容里可以有换行; 切换后不能再换回按行",
+		"help.file":             "给<name>发一个文件",
+		"help.file.long":        "对方收到 file-offer 后用 accept 或 decline 回复, 60秒内没有回复就作废; 同意后用 chunk 发送内容, 30秒没有收到内容也作废, 服务器只转发不保存; 客户端里用 /send 用户名 路径",
+		"help.accept":           "接收别人发来的文件",
+		"help.accept.long":      "<id>是 file-offer 里的编号",
+		"help.decline":          "拒绝别人发来的文件",
+		"help.decline.long":     "<id>是 file-offer 里的编号",
+		"help.chunk":            "发送文件的一块内容",
+		"help.chunk.long":       "对方同意后发送, 每块解码后最多8KB, 收满 file 里的字节数后双方收到 file-done",
+		"help.caps":             "声明客户端支持的能力",
+		"help.caps.long":        "多个能力用逗号分隔, 如 caps|receipts 表示支持已读回执, 私聊会带上序号",
+		"help.read":             "回发私聊的已读回执",
+		"help.read.long":        "声明了receipts能力后, 读到 pm|序号|... 的私聊时回发 read|序号, 发送方会收到已读提示",
+		"help.history":          "查看最近的公聊消息",
+		"help.history.long":     "把最近<n>条公聊消息发给自己, 最多保留服务器 -history 设置的条数",
+		"help.recall":           "撤回自己的最后一条公聊消息",
+		"help.recall.long":      "撤回自己发出不久的最后一条公聊消息; 管理员可以用 recall|序号 撤回任意一条, recall|list 查看序号",
+		"help.remind":           "设置只发给自己的提醒",
+		"help.remind.long":      "<delay>后给自己发一条提醒, 时间写成 30s、10m、1h 这样的格式, 最长24h",
+		"help.reminders":        "查看待发送的定时消息",
+		"help.reminders.long":   "列出自己设置的提醒和定时公告, 包括编号和剩余时间",
+		"help.unremind":         "取消一条定时消息",
+		"help.unremind.long":    "按 reminders 里显示的编号取消一条提醒或定时公告",
+		"help.settings":         "查看和修改个人设置",
+		"help.settings.long":    "settings 列出全部设置, settings|<key>|<value> 修改一项, 设置会在下次上线时保留",
+		"help.admin":            "成为管理员",
+		"help.admin.long":       "输入服务器的管理员口令后可以使用管理员命令",
+		"help.schedule":         "设置定时公告",
+		"help.schedule.long":    "<delay>后向所有人发一条公告, 时间格式和 remind 相同",
+		"help.mark-bot":         "把用户标记为机器人",
+		"help.mark-bot.long":    "被标记的用户在who列表里带有[bot]标记, 也不会因为空闲被踢",
+		"help.lockdown":         "开启或解除全员禁言",
+		"help.lockdown.long":    "全员禁言期间公聊消息不会发出, 私聊不受影响",
+		"help.drain":            "开启或关闭维护模式",
+		"help.drain.long":       "维护模式下服务器拒绝新连接, 已经在线的用户照常聊天; 在unix上也可以给服务器进程发 SIGUSR1 切换",
+		"help.audit":            "查看管理操作的审计记录",
+		"help.audit.long":       "返回最近<n>条管理操作, 包括时间、操作人、操作、对象、参数和结果, 没有权限被拒绝的操作也会记录",
+		"help.stats":            "查看服务器的运行状态",
+		"help.stats.long":       "显示运行时间、在线人数、收到的消息数、最近一分钟每秒的消息数、因为空闲被踢的人数、goroutine数和内存; stats|json 返回一行JSON",
+		"stats.admin_only":      "只有管理员可以查看运行状态",
+		"help.goroutines":       "按函数统计当前的goroutine数",
+		"help.goroutines.long":  "每个goroutine算在调用栈里第一个本程序的函数上, 多的在前面; 在线人数不变而某一行一直变多时, 通常就是那里泄漏了",
+		"goroutines.admin_only": "只有管理员可以查看goroutine",
+		"goroutines.other":      "其他 %d 个函数",
+		"help.suspects":         "查看管理员口令或命令错误太多的IP",
+		"help.suspects.long":    "按IP统计: 窗口里错误超过次数后这个IP的消息处理变慢, 到两倍时暂时封禁; suspects|clear|IP 清掉记录并解除封禁",
+		"suspects.admin_only":   "只有管理员可以查看可疑IP",
+		"suspects.none":         "没有可疑的IP",
+		"suspects.usage":        "用法: suspects 或 suspects|clear|IP",
+		"suspects.not_found":    "没有 %s 的记录",
+		"suspects.cleared":      "已清除 %s 的记录",
+		"help.store":            "查看和清理Store里保存的数据",
+		"help.store.long":       "store|stats 显示保存的用户名个数和上一次清理的结果; store|purge 马上清理一次: 删掉超过 -store-ttl 的离线记录和名字记录, 用户名超过 -store-max-names 个时删掉最久没用过的",
+		"store.admin_only":      "只有管理员可以查看和清理Store",
+		"store.usage":           "用法: store|stats 或 store|purge",
+		"store.none":            "服务器没有设置 -store",
+		"store.failed":          "操作Store失败, 详细原因见服务器日志",
+		"store.stats":           "Store: %d 个用户名, 个人设置 %d 份, 离线记录 %d 条, 名字记录 %d 条",
+		"store.never":           "还没有清理过",
+		"store.last_purge":      "上一次清理: %s (%s 前), 删掉过期记录 %d 条, 超出上限的用户名 %d 个",
+		"store.purged":          "清理完成: 删掉过期记录 %d 条, 超出上限的用户名 %d 个",
+		"help.queue":            "查看用户的发送队列",
+		"help.queue.long":       "显示积压的消息数、队列容量、最多积压过多少、最后一次写成功的时间和写入状态; queue|用户名|flush 丢掉积压的消息, queue|用户名|kick 按慢客户端断开",
+		"queue.admin_only":      "只有管理员可以查看发送队列",
+		"queue.usage":           "用法: queue|用户名 或 queue|用户名|flush 或 queue|用户名|kick",
+		"queue.info":            "%s 的发送队列: 积压 %d/%d 条, 最多积压过 %d 条, 最后一次写成功: %s, 写入状态: %s",
+		"queue.never":           "还没有写过",
+		"queue.ago":             "%s (%s 前)",
+		"queue.flushed":         "已丢掉 %s 积压的 %d 条消息",
+		"queue.kicked":          "已按慢客户端断开 %s",
+		"suspect.blocked":       "错误次数太多, 这个地址被暂时封禁 %d 分钟",
+		"suspect.reject":        "这个地址错误次数太多, 暂时不能连接, 请稍后再试",
+		"help.kickall":          "踢掉用户名匹配的全部用户",
+		"help.kickall.long":     "* 匹配任意多个字符, ? 匹配一个字符, 例如 kickall|guest-*; 不会匹配到自己和其他管理员; 超过5个用户时先显示人数, 最后加上 |confirm 重新发送后才执行",
+		"help.msgall":           "给用户名匹配的全部用户发私聊",
+		"help.msgall.long":      "模式的写法跟kickall一样, 例如 msgall|guest-*|请先改名; 超过5个用户时最后加上 |confirm 重新发送后才执行",
+		"kickall.admin_only":    "只有管理员可以批量踢人",
+		"kickall.usage":         "用法: kickall|模式, 例如 kickall|guest-*",
+		"kickall.kicked":        "您被管理员踢出",
+		"msgall.admin_only":     "只有管理员可以群发私聊",
+		"msgall.usage":          "用法: msgall|模式|内容, 例如 msgall|guest-*|请先改名",
+		"bulk.none":             "没有用户名匹配 %s 的用户",
+		"bulk.confirm":          "匹配到 %d 个用户: %s; 确认请在命令最后加上 |confirm 重新发送",
+		"bulk.confirm_stale":    "确认无效: 没有预览过这条命令、预览已经超过30秒或者匹配的人数变了, 请按新的预览重新确认",
+		"bulk.gone":             "%s 已经不在线",
+		"bulk.kicked":           "已踢出 %s",
+		"bulk.sent":             "已发送给 %s",
+		"bulk.failed":           "发送给 %s 失败",
+		"bulk.done":             "完成: %d 个成功, %d 个失败",
+		"stats.usage":           "消息格式不正确， 请使用 \"stats\" 或 \"stats|json\"格式",
+		"help.slowmode":         "设置慢速模式",
+		"help.slowmode.long":    "每个用户每<seconds>秒只能发一条公聊消息, 设置为0表示关闭",
+	},
+	"en": {
+		"admin.wrong_token": "Wrong admin token",
+		"admin.granted":     "You are now an admin",
+
+		"user.online":            "is online",
+		"user.offline":           "went offline",
+		"user.not_found":         "No such user",
+		"user.not_found_suggest": "No such user, did you mean: %s ?",
+		"pm.offline":             "%s is offline, the message was not delivered",
+		"presence.online_many":   "%d more users came online: %s",
+		"presence.offline_many":  "%d more users went offline: %s",
+		"user.flood":             "You sent too much data, disconnecting",
+		"compress.usage":         "Invalid format, use \"compress|deflate\"",
+		"compress.unavailable":   "Compression is not available right now, reconnect without -compress",
+		"frame.usage":            "Invalid format, use \"frame|len\"",
+		"frame.too_large":        "Message is over %d bytes, disconnecting",
+		"frame.newline":          "Messages can't contain line breaks, dropped",
+		"user.guest_hint":        "Your name is %s for now, use rename|<new name> to change it",
+		"user.kicked":            "You have been kicked for being idle",
+		"idle.warn":              "You have been idle for %s and will be disconnected in %s; send anything to stay online",
+		"idle.kicked_note":       "%s was disconnected for being idle",
+		"user.empty":             "Please don't send empty messages",
+
+		"rename.hash":    "Names can't start with #",
+		"rename.bracket": "Names can't start with [ or {",
+		"rename.taken":   "That name is already taken",
+		"names.recent":   "The name %s was recently used by someone else",
+		"welcome.back":   "Welcome back %s: %d private messages to you were not delivered while you were away, and you missed %d public messages (see them with history)",
+		"rename.done":    "Your name is now:%s",
+		"rename.limit":   "You can rename at most %d times per connection",
+		"rename.wait":    "Renaming too often, try again in %d seconds",
+
+		"pm.usage":          "Invalid format, use \"to|alice|hello\". ",
+		"pm.empty":          "The message is empty, please resend ",
+		"ml.too_long":       "The message is too long, at most %d bytes",
+		"ml.too_many":       "Too many lines, at most %d",
+		"ml.empty":          "The message is empty, please resend ",
+		"file.usage":        "Invalid format, use \"file|alice|<file name>|<bytes>\"; the name can't contain directories",
+		"file.too_large":    "The file is too large, at most %d bytes",
+		"file.self":         "You can't send a file to yourself",
+		"file.unknown":      "No file with id %s",
+		"file.bad_chunk":    "File content must be base64, at most %d bytes per piece",
+		"file.overflow":     "More data than the file size was sent, transfer cancelled",
+		"file.peer_left":    "The other side went offline, transfer cancelled",
+		"file.too_many":     "At most %d transfers at a time, wait for earlier ones to finish or expire",
+		"file.too_fast":     "Sending too fast, transfer cancelled",
+		"pub.usage":         "Invalid format, use \"pub|<id>|hello\"; the ID may not contain spaces and is at most 64 characters",
+		"pm.failed":         "Message not delivered, the recipient's connection is gone",
+		"pm.not_allowed":    "The recipient only accepts private messages from approved users, they have been notified",
+		"pm.request":        "%[1]s wants to send you private messages, use pm|allow|%[1]s to allow",
+		"pm.allow_usage":    "Invalid format, use pm|allowlist|on, pm|allowlist|off, pm|allow|<name> or pm|deny|<name>",
+		"pm.allowlist_on":   "Allow-list on: only approved users can send you private messages",
+		"pm.allowlist_off":  "Allow-list off: anyone can send you private messages",
+		"pm.allowed":        "%s may now send you private messages",
+		"pm.denied":         "Removed %s from your allow-list",
+		"pm.recipient_busy": "The recipient is getting too many messages, please try again later",
+		"pm.flood_notice":   "You received more than %d private messages this minute, further messages are being refused for now",
+
+		"public.failed": "Message not sent, please try again later",
+
+		"bot.admin_only": "Only admins can mark bots",
+		"bot.marked":     "Marked %s as a bot",
+
+		"recall.admin_only": "Only admins can recall a message by number",
+		"recall.usage":      "Invalid format, use \"recall|<number>\". ",
+		"recall.none":       "Nothing to recall",
+		"recall.not_own":    "You can only recall your own messages",
+		"recall.too_late":   "The message is older than %d minutes and can't be recalled",
+		"recall.notice":     "%s recalled a message",
+
+		"history.usage": "Invalid format, use \"history|10\". ",
+		"history.none":  "No public messages yet",
+
+		"lockdown.notice":     "Lockdown is on: public messages are not delivered, private messages still work",
+		"lockdown.admin_only": "Only admins can change lockdown",
+		"lockdown.usage":      "Invalid format, use lockdown|on or lockdown|off",
+		"lockdown.already":    "Lockdown is already %s",
+		"lockdown.on":         "An admin turned on lockdown",
+		"lockdown.off":        "Lockdown lifted",
+
+		"drain.admin_only": "Only admins can change maintenance mode",
+		"drain.usage":      "Invalid format, use drain|on or drain|off",
+		"drain.already":    "Maintenance mode is already %s",
+		"drain.on":         "The server is going into maintenance: no new connections, users already online are not affected",
+		"drain.off":        "Maintenance is over, new connections are accepted again",
+		"drain.reject":     "The server is under maintenance, please connect again later",
+
+		"audit.admin_only": "Only admins can read the audit log",
+		"audit.usage":      "Invalid format, use \"audit|tail|20\". ",
+		"audit.none":       "The audit log is empty",
+
+		"slow.admin_only":  "Only admins can change slow mode",
+		"slow.no_rooms":    "There are no rooms, use slowmode|<seconds> for the global slow mode",
+		"slow.bad_seconds": "Invalid number of seconds, use an integer from 0 to %d",
+		"slow.on":          "Slow mode is on: one public message every %d seconds",
+		"slow.off":         "Slow mode is off",
+		"slow.wait":        "Slow mode is on, you can speak again in %d seconds",
+		"repeat.dropped":   "Please don't send the same message again",
+		"repeat.mute":      "You keep sending the same message and are muted for %d seconds",
+		"repeat.muted":     "You are muted for repeating messages, try again in %d seconds",
+
+		"schedule.admin_only": "Only admins can schedule announcements",
+		"schedule.usage":      "Invalid format, use \"%s|10m|text\". ",
+		"schedule.bad_delay":  "Invalid delay, use a value like 30s, 10m or 1h, at most 24h",
+		"schedule.too_many":   "At most %d pending messages at a time",
+		"schedule.added":      "Scheduled #%d, sending in %s",
+		"schedule.fired":      "[Reminder] %s",
+		"schedule.none":       "No pending messages",
+		"schedule.reminder":   "reminder",
+		"schedule.announce":   "announcement",
+		"schedule.item":       "#%d [%s] in %s: %s",
+		"schedule.not_found":  "No such scheduled message",
+		"schedule.cancelled":  "Cancelled #%d",
+
+		"settings.line":          "%s = %s    %s",
+		"settings.unknown":       "No such setting: %s, send settings to list them",
+		"settings.invalid":       "%s: %s",
+		"settings.set":           "Set %s = %s",
+		"settings.on_off":        "Must be on or off",
+		"settings.lang":          "Must be zh or en, or empty for the server default",
+		"settings.away":          "The away message can be at most %d characters",
+		"setting.history":        "Replay recent public messages when you come online (on/off)",
+		"setting.away":           "Away message shown in who, empty means you are back",
+		"setting.pm_allowlist":   "Only accept private messages from users on your allow-list (on/off)",
+		"setting.pm_allow":       "Private message allow-list, comma separated names, change it with pm|allow|<name> and pm|deny|<name>",
+		"settings.pm_allow_bar":  "Names can't contain |",
+		"settings.pm_allow_many": "The allow-list can hold at most %d names",
+		"setting.lang":           "Language for system messages (zh/en), empty means the server default",
+
+		"whois.name":       "Name: %s",
+		"whois.session":    "Session: #%d",
+		"whois.addr":       "Address: %s",
+		"whois.transport":  "Transport: %s",
+		"whois.since":      "Online since: %s",
+		"whois.away":       "Away: %s",
+		"whois.idle":       "Idle: %s",
+		"whois.bot":        "Bot: yes",
+		"whois.compress":   "Compression: %d%% saved inbound, %d%% saved outbound",
+		"whois.traffic":    "Traffic: %d bytes received, %d bytes sent",
+		"whois.names":      "Previous names: %s",
+		"whois.name_owner": "Last used by: #%d %s, %s ago",
+		"who.idle_line":    "%s    idle %s",
+
+		"help.header":           "Available commands:",
+		"help.footer":           "Send help|<command> for details, anything that is not a command is sent to everyone",
+		"help.unknown":          "No such command: %s, send help to list them",
+		"help.help":             "List available commands",
+		"help.help.long":        "help lists the commands you can use, help|<command> shows the details of one",
+		"help.who":              "List online users",
+		"help.who.long":         "Lists online users and their status, who|json returns a JSON list for programs, who|idle sorts by recent activity and shows idle time",
+		"help.whois":            "Show details about a user",
+		"help.whois.long":       "Shows a user's session number, address, online time and away message, the name can also be #<session>",
+		"help.whoami":           "Show who you are",
+		"help.whoami.long":      "Returns one line: whoami name=... session=#... addr=... admin=on|off caps=... away=..., useful to confirm a rename",
+		"help.ping":             "Measure latency to the server",
+		"help.ping.long":        "The server answers right away with pong|<token>|<server time in Unix ms>, bypassing the broadcast queue; type /ping in the client to see the round trip and clock skew",
+		"ping.usage":            "Invalid format, use ping|<token>; the token can't contain | and is at most 64 bytes",
+		"help.wholist":          "Online user names, for programs",
+		"help.wholist.long":     "Returns the online names as wholist|alice,bob, sorted by name",
+		"help.rename":           "Change your name",
+		"help.rename.long":      "Changes your name to <name>, which can't start with #, [ or { or be taken by another user, renames are rate limited",
+		"help.to":               "Private message",
+		"help.to.long":          "Sends the text to <name> only, the name can also be #<session>; the text may contain |; after caps|msg-id the last field is <id> and resending the same ID delivers only once",
+		"help.ml":               "Multi-line public message",
+		"help.ml.long":          "Write line breaks as \\n and backslashes as \\\\; continuation lines are indented, at most 20 lines and 4000 bytes; in the client end a line with \\ or use /paste",
+		"help.pub":              "Public message with a message ID",
+		"help.pub.long":         "Like a plain message, but resending the same <id> posts only once and every send is answered with ack|<id>; meant for client retries",
+		"help.say":              "Send a public message literally",
+		"help.say.long":         "Posts <text> as a public message even when it starts like a command (such as who or to|...); the client adds say| to such messages itself",
+		"help.pm":               "Private message allow-list",
+		"help.pm.long":          "pm|allowlist|on only accepts private messages from users on your list, others trigger a one-time request notice; pm|allow|<name> and pm|deny|<name> edit the list",
+		"help.compress":         "Turn on deflate compression",
+		"help.compress.long":    "Everything you send after this is compressed, and everything the server sends after its compress-ok|deflate reply is too; each message is flushed, and there is no turning it off",
+		"help.frame":            "Switch to length-prefixed framing",
+		"help.frame.long":       "After the frame-ok|len reply every message in both directions is a 4-byte big-endian length followed by the payload, which may contain newlines; there is no switching back",
+		"help.file":             "Offer a file to <name>",
+		"help.file.long":        "The receiver gets file-offer and answers with accept or decline within 60 seconds; after accept send the content with chunk (a transfer with no content for 30 seconds expires), the server relays it without storing; in the client use /send <name> <path>",
+		"help.accept":           "Accept a file offer",
+		"help.accept.long":      "<id> is the number from file-offer",
+		"help.decline":          "Decline a file offer",
+		"help.decline.long":     "<id> is the number from file-offer",
+		"help.chunk":            "Send one piece of a file",
+		"help.chunk.long":       "Sent after accept, at most 8KB per piece after decoding; both sides get file-done once the size from file is reached",
+		"help.caps":             "Declare client capabilities",
+		"help.caps.long":        "Comma separated, e.g. caps|receipts enables read receipts and numbered private messages",
+		"help.read":             "Send a read receipt",
+		"help.read.long":        "With the receipts capability, reply read|<seq> after showing pm|<seq>|..., the sender is told it was read",
+		"help.history":          "Show recent public messages",
+		"help.history.long":     "Sends you the last <n> public messages, up to the number kept by the server's -history setting",
+		"help.recall":           "Recall your last public message",
+		"help.recall.long":      "Recalls your last public message if it is recent; admins can use recall|<seq> to recall any message and recall|list to see the numbers",
+		"help.remind":           "Set a reminder for yourself",
+		"help.remind.long":      "Sends you a reminder after <delay>, written like 30s, 10m or 1h, at most 24h",
+		"help.reminders":        "List pending scheduled messages",
+		"help.reminders.long":   "Lists your reminders and announcements with their numbers and remaining time",
+		"help.unremind":         "Cancel a scheduled message",
+		"help.unremind.long":    "Cancels a reminder or announcement by the number shown in reminders",
+		"help.settings":         "Show and change your settings",
+		"help.settings.long":    "settings lists all settings, settings|<key>|<value> changes one, settings are kept for your next visit",
+		"help.admin":            "Become an admin",
+		"help.admin.long":       "Enter the server's admin token to use admin commands",
+		"help.schedule":         "Schedule an announcement",
+		"help.schedule.long":    "Sends an announcement to everyone after <delay>, same format as remind",
+		"help.mark-bot":         "Mark a user as a bot",
+		"help.mark-bot.long":    "Marked users show a [bot] tag in who and are never kicked for being idle",
+		"help.lockdown":         "Turn lockdown on or off",
+		"help.lockdown.long":    "During lockdown public messages are not delivered, private messages still work",
+		"help.drain":            "Turn maintenance mode on or off",
+		"help.drain.long":       "In maintenance mode new connections are refused while users already online keep chatting; on unix, sending SIGUSR1 to the server process toggles it too",
+		"help.audit":            "Show the audit log of admin actions",
+		"help.audit.long":       "Returns the last <n> admin actions with time, actor, action, target, parameters and result, including denied attempts",
+		"help.stats":            "Show server statistics",
+		"help.stats.long":       "Shows uptime, users online, messages received, messages per second over the last minute, idle kicks, goroutines and memory; stats|json returns one line of JSON",
+		"stats.admin_only":      "Only admins can view server statistics",
+		"help.goroutines":       "Count goroutines by function",
+		"help.goroutines.long":  "Each goroutine is counted under the first function of this program on its stack, largest first; a line that keeps growing while the number of users stays the same usually points at a leak",
+		"goroutines.admin_only": "Only admins can view goroutines",
+		"goroutines.other":      "%d other functions",
+		"help.suspects":         "List IPs with too many wrong admin tokens or malformed commands",
+		"help.suspects.long":    "Counted per IP: past the limit within the window, messages from that IP are slowed down, and at twice the limit the IP is blocked for a while; suspects|clear|IP forgets the IP and lifts the block",
+		"suspects.admin_only":   "Only admins can view suspicious IPs",
+		"suspects.none":         "No suspicious IPs",
+		"suspects.usage":        "Usage: suspects or suspects|clear|IP",
+		"suspects.not_found":    "No record for %s",
+		"suspects.cleared":      "Cleared the record for %s",
+		"help.store":            "Inspect and clean up the data kept in the store",
+		"help.store.long":       "store|stats shows how many names are stored and the result of the last purge; store|purge purges now: offline and name records older than -store-ttl are removed, and past -store-max-names names the least recently used ones are removed",
+		"store.admin_only":      "Only admins can inspect or purge the store",
+		"store.usage":           "Usage: store|stats or store|purge",
+		"store.none":            "The server has no -store configured",
+		"store.failed":          "Store operation failed, see the server log for details",
+		"store.stats":           "Store: %d names, %d preference sets, %d offline records, %d name records",
+		"store.never":           "Not purged yet",
+		"store.last_purge":      "Last purge: %s (%s ago), removed %d expired records and %d names over the limit",
+		"store.purged":          "Purge done: removed %d expired records and %d names over the limit",
+		"help.queue":            "Inspect a user's outbound queue",
+		"help.queue.long":       "Shows the queued messages, the capacity, the high-water mark, the last successful write and the writer state; queue|<name>|flush drops the backlog, queue|<name>|kick disconnects the user as a slow client",
+		"queue.admin_only":      "Only admins can inspect outbound queues",
+		"queue.usage":           "Usage: queue|<name>, queue|<name>|flush or queue|<name>|kick",
+		"queue.info":            "Outbound queue of %s: %d/%d queued, high-water mark %d, last successful write: %s, writer: %s",
+		"queue.never":           "never",
+		"queue.ago":             "%s (%s ago)",
+		"queue.flushed":         "Dropped %[2]d queued messages for %[1]s",
+		"queue.kicked":          "Disconnected %s as a slow client",
+		"suspect.blocked":       "Too many errors, this address is blocked for %d minutes",
+		"suspect.reject":        "Too many errors from this address, please try again later",
+		"help.kickall":          "Kick every user whose name matches a pattern",
+		"help.kickall.long":     "* matches any run of characters and ? matches one, e.g. kickall|guest-*; never matches yourself or other admins; with more than 5 matches only the count is shown, send it again with |confirm appended to go ahead",
+		"help.msgall":           "Send a private message to every user whose name matches a pattern",
+		"help.msgall.long":      "Patterns work as in kickall, e.g. msgall|guest-*|please pick a name; with more than 5 matches send it again with |confirm appended to go ahead",
+		"kickall.admin_only":    "Only admins can kick users in bulk",
+		"kickall.usage":         "Usage: kickall|<pattern>, e.g. kickall|guest-*",
+		"kickall.kicked":        "You have been kicked by an admin",
+		"msgall.admin_only":     "Only admins can message users in bulk",
+		"msgall.usage":          "Usage: msgall|<pattern>|<text>, e.g. msgall|guest-*|please pick a name",
+		"bulk.none":             "No user name matches %s",
+		"bulk.confirm":          "%d users match: %s; append |confirm and send again to go ahead",
+		"bulk.confirm_stale":    "Confirmation rejected: this command was not previewed, the preview is older than 30 seconds or the matches changed; confirm again against the new preview",
+		"bulk.gone":             "%s is no longer online",
+		"bulk.kicked":           "Kicked %s",
+		"bulk.sent":             "Sent to %s",
+		"bulk.failed":           "Failed to send to %s",
+		"bulk.done":             "Done: %d succeeded, %d failed",
+		"stats.usage":           "Invalid format, use \"stats\" or \"stats|json\"",
+		"help.slowmode":         "Set slow mode",
+		"help.slowmode.long":    "Each user may send one public message every <seconds> seconds, 0 turns it off",
+	},
+}
+
+// 支持的语言, 按名字排序
+func languages() []string {
+	list := make([]string, 0, len(catalog))
+	for lang := range catalog {
+		list = append(list, lang)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// 按用户的语言取出一条提示语, user为nil时使用默认语言
+// 用户的语言里没有这条时使用默认语言, 并记一条日志
+func t(user *User, id string, args ...interface{}) string {
+	lang := defaultLang
+	if user != nil {
+		if l := user.Pref("lang"); l != "" {
+			lang = l
+		}
+	}
+
+	format, ok := catalog[lang][id]
+	if !ok {
+		fmt.Println("i18n: missing", lang, id)
+		format, ok = catalog[defaultLang][id]
+		if !ok {
+			format = id
+		}
+	}
+	if len(args) == 0 {
+		return format
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+// 带消息编号的错误, 回复给用户时按用户的语言翻译
+type localizedError struct {
+	id   string
+	args []interface{}
+}
+
+func errorT(id string, args ...interface{}) error {
+	return &localizedError{id: id, args: args}
+}
+
+func (e *localizedError) Error() string {
+	return t(nil, e.id, e.args...)
+}
+
+// 把错误转换成给用户看的提示语
+func localize(user *User, err error) string {
+	if le, ok := err.(*localizedError); ok {
+		return t(user, le.id, le.args...)
+	}
+	return err.Error()
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+var verbPattern = regexp.MustCompile(`%(?:\[([0-9]+)\])?[-+# 0]*[0-9]*([a-zA-Z%])`)
+
+// 提示语里每个参数的类型, 按参数的位置, 支持 %[2]d 这样的写法
+func formatArgs(format string) map[int]string {
+	args := make(map[int]string)
+	next := 1
+	for _, m := range verbPattern.FindAllStringSubmatch(format, -1) {
+		if m[2] == "%" {
+			continue
+		}
+		if m[1] != "" {
+			next, _ = strconv.Atoi(m[1])
+		}
+		args[next] = m[2]
+		next++
+	}
+	return args
+}
+
+// 每种语言都有全部的提示语, 参数的个数和类型一样
+func TestCatalogComplete(t *testing.T) {
+	for id, format := range catalog[defaultLang] {
+		want := formatArgs(format)
+		for _, lang := range languages() {
+			other, ok := catalog[lang][id]
+			if !ok {
+				t.Errorf("%s 缺少 %s", lang, id)
+				continue
+			}
+			if got := formatArgs(other); !reflect.DeepEqual(got, want) {
+				t.Errorf("%s %s 的参数 %v, %s 是 %v", lang, id, got, defaultLang, want)
+			}
+		}
+	}
+	for _, lang := range languages() {
+		for id := range catalog[lang] {
+			if _, ok := catalog[defaultLang][id]; !ok {
+				t.Errorf("%s 多出了 %s", lang, id)
+			}
+		}
+	}
+}
+
+// 系统消息按每个用户的语言发送, 用户发的内容原样转发
+func TestUserLanguage(t *testing.T) {
+	server := startTestServer(t)
+	alice := dialTest(t, server, "alice")
+	bob := dialTest(t, server, "bob")
+	bob.send("settings|lang|en")
+	bob.waitFor(fmt.Sprintf(catalog["en"]["settings.set"], "lang", "en"))
+
+	bob.send("to|carol|hi")
+	bob.waitFor(catalog["en"]["user.not_found"])
+	alice.send("to|carol|hi")
+	alice.waitFor(catalog["zh"]["user.not_found"])
+
+	alice.send("user.not_found 已上线")
+	bob.waitFor("alice:user.not_found 已上线")
+
+	// 不认识的编号原样返回
+	if got := text("no.such.id"); got != "no.such.id" {
+		t.Fatalf("t = %q", got)
+	}
+}
@@ -0,0 +1,29 @@
+// 闲置提醒: 快到 -idle-timeout 时先提醒用户, 发任意消息就不会被踢; 真的踢掉时告诉其他人原因
+// 提醒的时间是超时时间乘以 -idle-warn, 默认0.8, 即5分钟超时时闲置4分钟提醒; 0表示不提醒
+package main
+
+import "time"
+
+// 默认在超时时间的80%时提醒
+const defaultIdleWarn = 0.8
+
+// 闲置多久时提醒, 不提醒时返回0
+func (this *Server) idleWarnAfter() time.Duration {
+	if this.IdleWarn <= 0 || this.IdleWarn >= 1 {
+		return 0
+	}
+	return time.Duration(float64(this.IdleTimeout) * this.IdleWarn)
+}
+
+// 闲置到提醒时间时提醒, warnedFor是上一次提醒时的最后活跃时间
+// 用户发了消息以后最后活跃时间变了, 再闲置到提醒时间时重新提醒; 不依赖某次检查正好看到用户在活跃
+func (this *User) checkIdleWarn(warnedFor *time.Time) {
+	after := this.server.idleWarnAfter()
+	last := this.LastActive()
+	if after <= 0 || this.server.Clock.Now().Sub(last) < after || last.Equal(*warnedFor) {
+		return
+	}
+	*warnedFor = last
+	left := this.server.IdleTimeout - after
+	this.SendMsg(t(this, "idle.warn", formatIdle(after.Round(time.Second)), formatIdle(left.Round(time.Second))) + "\n")
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Achiyun/Golang-IM-System/internal/leakcheck"
+)
+
+func TestFormatIdle(t *testing.T) {
+	for d, want := range map[time.Duration]string{
+		0:                              "0s",
+		45 * time.Second:               "45s",
+		5*time.Minute + 59*time.Second: "5m",
+		2*time.Hour + 10*time.Minute:   "2h10m",
+	} {
+		if got := formatIdle(d); got != want {
+			t.Errorf("formatIdle(%v) = %q, want %q", d, got, want)
+		}
+	}
+}
+
+// who|idle 按最近活跃排序, whois 和 who|json 里的空闲时间从最后一次发消息算起
+func TestWhoIdle(t *testing.T) {
+	clock := NewFakeClock(testEpoch)
+	server := startTestServer(t, WithClock(clock))
+	alice := dialTest(t, server, "alice")
+	bob := dialTest(t, server, "bob")
+	dialTest(t, server, "carol")
+
+	clock.Advance(90 * time.Second)
+	bob.send("hi")
+	alice.waitFor("bob:hi")
+	clock.Advance(5 * time.Second)
+
+	alice.send("who|idle", "whois|carol", "who|json")
+	alice.waitFor(`[{"session":`)
+	var idle []string
+	for _, line := range alice.seen {
+		if strings.Contains(line, text("who.idle_line", "", "")) {
+			idle = append(idle, line)
+		}
+	}
+	want := []string{
+		text("who.idle_line", "alice", "0s"),
+		text("who.idle_line", "bob", "5s"),
+		text("who.idle_line", "carol", "1m"),
+	}
+	if strings.Join(idle, ",") != strings.Join(want, ",") {
+		t.Fatalf("who|idle = %q", idle)
+	}
+	if !alice.saw(text("whois.idle", "1m")) {
+		t.Fatal("whois 没有显示空闲时间")
+	}
+	if !alice.saw(`"name":"carol"`) || !alice.saw(`"idleSeconds":95`) {
+		t.Fatal("who|json 没有空闲秒数")
+	}
+}
+
+// 定期检查空闲时间: 从最后一条消息开始算, 满超时时间才踢
+func TestIdleKickAfterLastMessage(t *testing.T) {
+	leakcheck.Check(t)
+	clock := NewFakeClock(testEpoch)
+	const timeout = 40 * time.Second
+	server := startTestServer(t, WithClock(clock), WithIdleTimeout(timeout))
+	alice := dialTest(t, server, "alice")
+	bob := dialTest(t, server, "bob")
+
+	for i := 0; i < 3; i++ {
+		clock.Advance(timeout / 4)
+	}
+	alice.send("still here")
+	bob.waitFor("alice:still here")
+	if alice.saw(text("user.kicked")) || bob.saw(text("user.kicked")) {
+		t.Fatal("没到超时时间就被踢了")
+	}
+
+	// bob到时间被踢, alice从上一条消息开始算, 还差一次检查
+	clock.Advance(timeout / 4)
+	bob.waitFor(text("user.kicked"))
+	bob.waitClosed()
+	clock.Advance(timeout / 4)
+	clock.Advance(timeout / 4)
+	alice.send("sync")
+	alice.waitFor("alice:sync")
+	if alice.saw(text("user.kicked")) {
+		t.Fatal("发过消息的用户提前被踢了")
+	}
+
+	clock.Advance(timeout)
+	alice.waitFor(text("user.kicked"))
+	alice.waitClosed()
+}
+
+// 每次拨动时钟之前先发一条消息并等到回复, 一共拨steps次, 每次拨超时时间的1/20
+func keepSending(t *testing.T, clock *FakeClock, c *testConn, msg, reply string, timeout time.Duration, steps int) {
+	t.Helper()
+	for i := 0; i < steps; i++ {
+		c.send(msg)
+		c.waitFor(reply)
+		clock.Advance(timeout / 20)
+	}
+}
+
+func TestIdlePingIsNotActivity(t *testing.T) {
+	clock := NewFakeClock(testEpoch)
+	const timeout = 100 * time.Second
+	server := startTestServer(t, WithClock(clock), WithIdleTimeout(timeout))
+	alice := dialTest(t, server, "alice")
+
+	// 看门狗的ping照常回复, 但不影响闲置提醒和踢人
+	keepSending(t, clock, alice, "ping|watchdog", "pong|watchdog", timeout, 19)
+	if !alice.saw(text("idle.warn", formatIdle(80*time.Second), formatIdle(20*time.Second))) {
+		t.Fatal("只发ping的用户没有收到闲置提醒")
+	}
+	clock.Advance(timeout / 20)
+	alice.waitFor(text("user.kicked"))
+	alice.waitClosed()
+}
+
+func TestIdleMessageIsActivity(t *testing.T) {
+	clock := NewFakeClock(testEpoch)
+	const timeout = 100 * time.Second
+	server := startTestServer(t, WithClock(clock), WithIdleTimeout(timeout))
+	alice := dialTest(t, server, "alice")
+
+	keepSending(t, clock, alice, "whoami", "alice", timeout, 30)
+	if alice.saw(text("user.kicked")) {
+		t.Fatal("一直在发消息的用户被踢掉了")
+	}
+	if _, ok := server.lookupUser("alice"); !ok {
+		t.Fatal("alice 不在线")
+	}
+}
+
+// 不会因为闲置被踢的旁观者
+func dialBot(t *testing.T, server *Server, name string) *testConn {
+	t.Helper()
+	c := dialTest(t, server, name)
+	c.send("caps|bot", "sync-"+name)
+	c.waitFor(":sync-" + name)
+	return c
+}
+
+// 拨steps次时钟, 每次拨step, 拨完用不算活跃的ping同步一下
+// 检查闲置的goroutine是单独的, ping回来时它可能还没处理完最后一次检查, 要等的提醒用waitFor
+func advanceIdle(t *testing.T, clock *FakeClock, c *testConn, step time.Duration, steps int) {
+	t.Helper()
+	for i := 0; i < steps; i++ {
+		clock.Advance(step)
+	}
+	c.send("ping|idle-sync")
+	c.waitFor("pong|idle-sync")
+}
+
+// 等到一共收到n行含有want的消息, 之前的可能已经在等别的消息时读过了
+func waitCount(c *testConn, want string, n int) {
+	c.t.Helper()
+	for c.count(want) < n {
+		c.waitFor(want)
+	}
+}
+
+// 提醒以后发了消息就不踢, 重新闲置到提醒时间再提醒一次, 到超时才踢并告诉其他人
+func TestIdleWarnThenActive(t *testing.T) {
+	clock := NewFakeClock(testEpoch)
+	const timeout = 100 * time.Second
+	server := startTestServer(t, WithClock(clock), WithIdleTimeout(timeout))
+	alice := dialTest(t, server, "alice")
+	bob := dialBot(t, server, "bob")
+	warn := text("idle.warn", formatIdle(80*time.Second), formatIdle(20*time.Second))
+	note := systemPrefix + text("idle.kicked_note", "alice")
+
+	advanceIdle(t, clock, alice, timeout/20, 15)
+	if alice.saw(warn) {
+		t.Fatal("没到提醒时间就提醒了")
+	}
+	clock.Advance(timeout / 20)
+	waitCount(alice, warn, 1)
+
+	// 发了消息, 从头开始算
+	alice.send("back")
+	bob.waitFor("alice:back")
+	advanceIdle(t, clock, alice, timeout/20, 16)
+	waitCount(alice, warn, 2)
+	advanceIdle(t, clock, alice, timeout/20, 3)
+	if alice.count(warn) != 2 || alice.saw(text("user.kicked")) || bob.saw(note) {
+		t.Fatalf("发过消息后没到超时: seen = %q", alice.seen)
+	}
+
+	clock.Advance(timeout / 20)
+	alice.waitFor(text("user.kicked"))
+	alice.waitClosed()
+	bob.waitFor(note)
+	if bob.saw(warn) {
+		t.Fatal("闲置提醒发给了别人")
+	}
+}
+
+// -idle-warn 决定提醒的时间, 0表示不提醒直接踢
+func TestIdleWarnRatio(t *testing.T) {
+	for _, ratio := range []float64{0.5, 0} {
+		clock := NewFakeClock(testEpoch)
+		const timeout = 100 * time.Second
+		server := startTestServer(t, WithClock(clock), WithIdleTimeout(timeout), WithIdleWarn(ratio))
+		alice := dialTest(t, server, "alice")
+		bob := dialBot(t, server, "bob")
+
+		advanceIdle(t, clock, alice, timeout/20, 10)
+		if ratio > 0 {
+			waitCount(alice, text("idle.warn", formatIdle(50*time.Second), formatIdle(50*time.Second)), 1)
+		}
+		advanceIdle(t, clock, alice, timeout/20, 9)
+		clock.Advance(timeout / 20)
+		alice.waitFor(text("user.kicked"))
+		bob.waitFor(systemPrefix + text("idle.kicked_note", "alice"))
+
+		prefix, _, _ := strings.Cut(text("idle.warn", "\x00", "\x00"), "\x00")
+		if n := alice.count(prefix); n != 1 && ratio > 0 || n != 0 && ratio == 0 {
+			t.Fatalf("ratio=%v 提醒了 %d 次", ratio, n)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+// Package leakcheck 在测试结束时检查goroutine泄漏, 服务器和客户端的测试都用它
+// 用法: 测试一开始调用 leakcheck.Check(t), 测试结束、其他Cleanup都执行完以后,
+// 比调用时多出来的goroutine在Wait内没有退出就算测试失败, 并打印它们的调用栈
+package leakcheck
+
+import (
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 多出来的goroutine最多等多久退出
+const Wait = 10 * time.Second
+
+// 一直都在、不算泄漏的goroutine, 例如第一次signal.Notify时启动的
+var stableGoroutines = []string{"os/signal.signal_recv", "os/signal.loop"}
+
+// Check用到的*testing.T的方法, 自己的测试里换成假的
+type T interface {
+	Helper()
+	Cleanup(func())
+	Errorf(format string, args ...interface{})
+}
+
+// 检查在测试的其他Cleanup之后进行, 所以要在启动服务器、连接和进程之前调用, 它们都关闭以后再检查
+func Check(t T) {
+	t.Helper()
+	check(t, Wait)
+}
+
+func check(t T, wait time.Duration) {
+	before := goroutineStacks()
+	t.Cleanup(func() {
+		deadline := time.Now().Add(wait)
+		for {
+			var leaked []string
+			for id, stack := range goroutineStacks() {
+				if _, ok := before[id]; ok || isStable(stack) {
+					continue
+				}
+				leaked = append(leaked, stack)
+			}
+			if len(leaked) == 0 {
+				return
+			}
+			if time.Now().After(deadline) {
+				sort.Strings(leaked)
+				t.Errorf("%s后还有 %d 个goroutine没有退出:\n\n%s", wait, len(leaked), strings.Join(leaked, "\n\n"))
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+}
+
+// 当前全部goroutine的调用栈, 按编号索引
+func goroutineStacks() map[string]string {
+	stacks := make(map[string]string)
+	for _, stack := range strings.Split(strings.TrimSpace(string(allStacks())), "\n\n") {
+		// 第一行: goroutine 编号 [状态]:
+		if id, _, ok := strings.Cut(strings.TrimPrefix(stack, "goroutine "), " "); ok {
+			stacks[id] = stack
+		}
+	}
+	return stacks
+}
+
+// 取全部goroutine的调用栈, 缓冲区不够时加倍
+func allStacks() []byte {
+	buf := make([]byte, 64*1024)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			return buf[:n]
+		}
+		buf = make([]byte, len(buf)*2)
+	}
+}
+
+func isStable(stack string) bool {
+	for _, fn := range stableGoroutines {
+		if strings.Contains(stack, fn) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,80 @@
+package leakcheck
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 假的*testing.T: 记下Cleanup和错误, 由测试自己执行Cleanup
+type fakeT struct {
+	cleanups []func()
+	errors   []string
+}
+
+func (this *fakeT) Helper() {}
+
+func (this *fakeT) Cleanup(f func()) {
+	this.cleanups = append(this.cleanups, f)
+}
+
+func (this *fakeT) Errorf(format string, args ...interface{}) {
+	this.errors = append(this.errors, fmt.Sprintf(format, args...))
+}
+
+// 按testing的顺序倒着执行Cleanup
+func (this *fakeT) finish() {
+	for i := len(this.cleanups) - 1; i >= 0; i-- {
+		this.cleanups[i]()
+	}
+}
+
+// 没有退出的goroutine报出来, 带上调用栈
+func TestCheckReportsLeak(t *testing.T) {
+	stop := make(chan struct{})
+	defer close(stop)
+
+	fake := &fakeT{}
+	check(fake, 50*time.Millisecond)
+	go leakyWorker(stop)
+	fake.finish()
+
+	if len(fake.errors) != 1 || !strings.Contains(fake.errors[0], "1 个goroutine") || !strings.Contains(fake.errors[0], "leakyWorker") {
+		t.Fatalf("errors = %q", fake.errors)
+	}
+}
+
+func leakyWorker(stop chan struct{}) {
+	<-stop
+}
+
+// 在等待时间内退出的goroutine不算泄漏, 调用前就有的也不算
+func TestCheckWaitsForExit(t *testing.T) {
+	stop := make(chan struct{})
+	defer close(stop)
+	go leakyWorker(stop)
+
+	fake := &fakeT{}
+	check(fake, Wait)
+	done := make(chan struct{})
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		close(done)
+	}()
+	fake.finish()
+
+	<-done
+	if len(fake.errors) != 0 {
+		t.Fatalf("errors = %q", fake.errors)
+	}
+}
+
+func TestIsStable(t *testing.T) {
+	if !isStable("goroutine 5 [syscall]:\nos/signal.signal_recv()") {
+		t.Fatal("signal_recv 应该算一直都在的goroutine")
+	}
+	if isStable("goroutine 6 [chan receive]:\nmain.worker()") {
+		t.Fatal("普通的goroutine也算一直都在了")
+	}
+}
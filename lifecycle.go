@@ -0,0 +1,95 @@
+// 用户的生命周期: Connecting(刚连上) → Online(在OnlineMap里) → Draining(正在下线) → Closed(已经下线)
+// 只能往后走, 每一步都在stateLock里检查和修改; 不合法的变化不执行, 只记一条日志
+// Online和Offline可以重复调用, 只有第一次生效, 所以上下线通知每个连接各只有一条
+// user.C只由Offline在把用户移出OnlineMap之后关闭, 其他地方要断开用户时调用disconnect, 由读消息的goroutine执行下线
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+type userState int32
+
+const (
+	stateConnecting userState = iota
+	stateOnline
+	stateDraining
+	stateClosed
+)
+
+func (s userState) String() string {
+	switch s {
+	case stateConnecting:
+		return "connecting"
+	case stateOnline:
+		return "online"
+	case stateDraining:
+		return "draining"
+	case stateClosed:
+		return "closed"
+	}
+	return fmt.Sprintf("state(%d)", int32(s))
+}
+
+// 合法的状态变化, 没有上线就断开的连接从Connecting直接到Closed
+var stateTransitions = map[userState][]userState{
+	stateConnecting: {stateOnline, stateClosed},
+	stateOnline:     {stateDraining},
+	stateDraining:   {stateClosed},
+}
+
+// 当前状态, 不加锁读取, 只用来判断, 要修改时用transition
+func (this *User) State() userState {
+	return userState(atomic.LoadInt32((*int32)(&this.state)))
+}
+
+// 变成to, 从当前状态不能变成to时不修改, 记一条日志并返回false
+func (this *User) transition(to userState) bool {
+	this.stateLock.Lock()
+	defer this.stateLock.Unlock()
+
+	from := this.State()
+	for _, next := range stateTransitions[from] {
+		if next == to {
+			atomic.StoreInt32((*int32)(&this.state), int32(to))
+			return true
+		}
+	}
+	fmt.Printf("session=#%d bad state transition %s -> %s\n", this.ID, from, to)
+	return false
+}
+
+// 开始下线: 在线时变成Draining并返回true, 由调用方执行下线业务
+// 没有上线过时直接变成Closed并关闭user.C; 已经在下线或者下线过时什么都不做, 所以Offline可以重复调用
+func (this *User) beginOffline() bool {
+	this.stateLock.Lock()
+	defer this.stateLock.Unlock()
+
+	switch this.State() {
+	case stateOnline:
+		atomic.StoreInt32((*int32)(&this.state), int32(stateDraining))
+		return true
+	case stateConnecting:
+		atomic.StoreInt32((*int32)(&this.state), int32(stateClosed))
+		this.closeChannel()
+	}
+	return false
+}
+
+// 断开用户的连接: 读消息的goroutine读到错误后执行Offline, 可以重复调用
+// 踢人、封禁、写失败等都用它, 不直接调用Offline, 也不碰user.C
+func (this *User) disconnect() {
+	this.conn.Close()
+}
+
+// 关闭user.C, 让ListenMessage退出; 只在用户已经不在OnlineMap里时调用, 所以不会再有新的广播发给这个用户
+// deliverLocal之前取到的快照里可能还有这个用户, 先关闭done让在等空位的广播放弃, 再在outbound.lock里关闭user.C
+func (this *User) closeChannel() {
+	close(this.outbound.done)
+
+	this.outbound.lock.Lock()
+	defer this.outbound.lock.Unlock()
+	this.outbound.closed = true
+	close(this.C)
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Achiyun/Golang-IM-System/internal/leakcheck"
+)
+
+// 只能按 Connecting → Online → Draining → Closed 往后走, 不合法的变化不修改状态
+func TestStateTransitions(t *testing.T) {
+	server := NewServer("127.0.0.1", 0)
+	serverSide, clientSide := net.Pipe()
+	defer clientSide.Close()
+	user := NewUser(serverSide, server)
+	defer user.closeChannel()
+
+	steps := []struct {
+		to   userState
+		ok   bool
+		want userState
+	}{
+		{stateDraining, false, stateConnecting},
+		{stateOnline, true, stateOnline},
+		{stateOnline, false, stateOnline},
+		{stateClosed, false, stateOnline},
+		{stateDraining, true, stateDraining},
+		{stateOnline, false, stateDraining},
+		{stateClosed, true, stateClosed},
+		{stateOnline, false, stateClosed},
+		{stateConnecting, false, stateClosed},
+	}
+	for _, s := range steps {
+		if ok := user.transition(s.to); ok != s.ok || user.State() != s.want {
+			t.Fatalf("-> %s = %v, 状态 %s; want %v, %s", s.to, ok, user.State(), s.ok, s.want)
+		}
+	}
+}
+
+// 没有上线过的连接直接变成Closed并关闭user.C; 在线的只有第一次开始下线
+func TestBeginOffline(t *testing.T) {
+	server := NewServer("127.0.0.1", 0)
+
+	serverSide, clientSide := net.Pipe()
+	defer clientSide.Close()
+	never := NewUser(serverSide, server)
+	if never.beginOffline() || never.State() != stateClosed {
+		t.Fatalf("没有上线过的连接: 状态 %s", never.State())
+	}
+	if _, open := <-never.C; open {
+		t.Fatal("user.C 没有关闭")
+	}
+	if never.beginOffline() {
+		t.Fatal("关闭后又开始下线")
+	}
+
+	serverSide, clientSide = net.Pipe()
+	defer clientSide.Close()
+	online := NewUser(serverSide, server)
+	defer online.closeChannel()
+	online.transition(stateOnline)
+	if !online.beginOffline() || online.State() != stateDraining {
+		t.Fatalf("在线的连接: 状态 %s", online.State())
+	}
+	if online.beginOffline() {
+		t.Fatal("下线中又开始下线")
+	}
+}
+
+// 同时上线、被踢、自己断开和重复调用Offline, 每个连接正好一条上线通知和一条下线通知
+func TestLifecycleStress(t *testing.T) {
+	leakcheck.Check(t)
+	server := startTestServer(t, WithAdminToken("secret"), WithRenameLimit(0, 0))
+	observer := dialTest(t, server, "observer")
+	root := dialAdmin(t, server, "root")
+	var rootLock sync.Mutex
+
+	const clients = 30
+	var wg sync.WaitGroup
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			name := fmt.Sprintf("s%d", i)
+			conn, err := net.Dial("tcp", server.Addr().String())
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer conn.Close()
+			conn.SetReadDeadline(time.Now().Add(e2eWait))
+			conn.Write([]byte("rename|" + name + "\n"))
+			reader := bufio.NewReader(conn)
+			for {
+				line, err := reader.ReadString('\n')
+				if err != nil {
+					t.Errorf("%s 没有改好名字: %v", name, err)
+					return
+				}
+				if strings.TrimSuffix(line, "\n") == text("rename.done", name) {
+					break
+				}
+			}
+
+			switch i % 3 {
+			case 0:
+				// 自己断开
+			case 1:
+				// 被管理员踢掉, 等服务器断开
+				rootLock.Lock()
+				root.conn.Write([]byte("kickall|" + name + "\n"))
+				rootLock.Unlock()
+				if _, err := io.Copy(io.Discard, reader); err != nil {
+					t.Errorf("%s 没有被踢掉: %v", name, err)
+				}
+			case 2:
+				// 读消息的goroutine之外也调用Offline
+				user, ok := server.lookupUser(name)
+				if !ok {
+					t.Errorf("%s 不在线", name)
+					return
+				}
+				var offline sync.WaitGroup
+				for j := 0; j < 3; j++ {
+					offline.Add(1)
+					go func() {
+						defer offline.Done()
+						user.Offline()
+					}()
+				}
+				offline.Wait()
+			}
+		}()
+	}
+	wg.Wait()
+
+	eventually(t, "还有连接没有下线", func() bool {
+		server.mapLock.RLock()
+		defer server.mapLock.RUnlock()
+		return len(server.OnlineMap) == 2
+	})
+	observer.send("sync-stress")
+	observer.waitFor("observer:sync-stress")
+
+	root0 := onlineUser(t, server, "root").ID
+	online := make(map[string]int)
+	offline := make(map[string]int)
+	presence := regexp.MustCompile(`^\[#(\d+)\][^:]*:(` + text("user.online") + "|" + text("user.offline") + `)$`)
+	for _, line := range observer.seen {
+		if m := presence.FindStringSubmatch(line); m != nil {
+			if m[2] == text("user.online") {
+				online[m[1]]++
+			} else {
+				offline[m[1]]++
+			}
+		}
+	}
+	for id := root0 + 1; id <= root0+clients; id++ {
+		session := fmt.Sprint(id)
+		if online[session] != 1 || offline[session] != 1 {
+			t.Errorf("#%s 上线通知 %d 条, 下线通知 %d 条", session, online[session], offline[session])
+		}
+	}
+}
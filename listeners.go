@@ -0,0 +1,149 @@
+// 同时在多个地址上监听, 例如局域网用的明文TCP和给外网用户的TLS, 所有监听共用OnlineMap和广播
+// 第一个监听总是 -ip -port 的明文TCP, 其他的用 -listen 和 -tls-listen 添加, 每个监听一个accept循环
+// 启动时全部绑定成功才开始接受连接, 任何一个失败都返回全部的错误; 停止时一起关闭
+// 每个用户记下自己是从哪种监听连上来的, whois里可以看到
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// 主监听的连接方式
+const transportTCP = "tcp"
+
+// 用TLS的监听
+const transportTLS = "tls"
+
+// 一个要监听的地址
+type ListenerConfig struct {
+	Transport string      // 连接方式的名字, 显示在whois里
+	Addr      string      // host:port, 端口为0时由系统分配
+	TLS       *tls.Config // 不为nil时在TCP上加一层TLS
+}
+
+// 一个已经绑定好的监听
+type boundListener struct {
+	transport string
+	listener  net.Listener
+}
+
+// 全部监听, Close时一起关闭
+type listenerSet struct {
+	lock   sync.Mutex
+	list   []boundListener
+	closed bool
+}
+
+// 绑定全部地址, 任何一个失败时关闭已经绑定的, 返回全部的错误
+func bindListeners(configs []ListenerConfig) ([]boundListener, error) {
+	var bound []boundListener
+	var errs []error
+	for _, c := range configs {
+		l, err := net.Listen("tcp", c.Addr)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s %s: %w", c.Transport, c.Addr, err))
+			continue
+		}
+		if c.TLS != nil {
+			l = tls.NewListener(l, c.TLS)
+		}
+		bound = append(bound, boundListener{transport: c.Transport, listener: l})
+	}
+	if len(errs) > 0 {
+		for _, b := range bound {
+			b.listener.Close()
+		}
+		return nil, errors.Join(errs...)
+	}
+	return bound, nil
+}
+
+// 主监听加上额外添加的
+func (this *Server) listenerConfigs() []ListenerConfig {
+	primary := ListenerConfig{Transport: transportTCP, Addr: net.JoinHostPort(this.Ip, strconv.Itoa(this.Port))}
+	return append([]ListenerConfig{primary}, this.Listeners...)
+}
+
+// 记下绑定好的监听, 已经Close过时返回false
+func (this *listenerSet) set(list []boundListener) bool {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if this.closed {
+		return false
+	}
+	this.list = list
+	return true
+}
+
+// 关闭全部监听, 之后Start返回; 可以重复调用
+func (this *Server) Close() error {
+	this.listeners.lock.Lock()
+	defer this.listeners.lock.Unlock()
+
+	this.listeners.closed = true
+	var errs []error
+	for _, b := range this.listeners.list {
+		if err := b.listener.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// 全部监听的实际地址, 格式: tcp=地址 tls=地址
+func (this *Server) listenAddrs() string {
+	this.listeners.lock.Lock()
+	defer this.listeners.lock.Unlock()
+
+	parts := make([]string, 0, len(this.listeners.list))
+	for _, b := range this.listeners.list {
+		parts = append(parts, b.transport+"="+b.listener.Addr().String())
+	}
+	return strings.Join(parts, ",")
+}
+
+// 一个监听的accept循环, 监听被关闭后返回
+func (this *Server) acceptLoop(b boundListener) error {
+	for {
+		// accept
+		conn, err := b.listener.Accept() // 返回链接的客户端地址
+		if errors.Is(err, net.ErrClosed) {
+			return err
+		}
+		if err != nil {
+			fmt.Println("listener accept err", b.transport, err)
+			continue
+		}
+
+		if this.Draining() {
+			go this.rejectDraining(conn)
+			continue
+		}
+
+		// do handler
+		go this.handleConn(conn, b.transport)
+	}
+}
+
+// 解析 -listen 和 -tls-listen 给出的逗号分隔的地址, 空字符串返回nil
+func parseListenAddrs(s string) ([]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var addrs []string
+	for _, addr := range strings.Split(s, ",") {
+		addr = strings.TrimSpace(addr)
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return nil, fmt.Errorf("%q: %w", addr, err)
+		}
+		addrs = append(addrs, addr)
+	}
+	return addrs, nil
+}
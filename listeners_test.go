@@ -0,0 +1,162 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 127.0.0.1的自签名证书, 返回服务器的配置和客户端信任的证书
+func selfSignedTLS(t *testing.T) (*tls.Config, *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "im-test"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}, pool
+}
+
+// 第i个监听的实际地址
+func listenerAddr(t *testing.T, server *Server, i int) string {
+	t.Helper()
+	server.listeners.lock.Lock()
+	defer server.listeners.lock.Unlock()
+	if i >= len(server.listeners.list) {
+		t.Fatalf("只有 %d 个监听", len(server.listeners.list))
+	}
+	return server.listeners.list[i].listener.Addr().String()
+}
+
+// 通过TLS监听连上来并改名
+func dialTLSTest(t *testing.T, addr string, pool *x509.CertPool, name string) *testConn {
+	t.Helper()
+	conn, err := tls.Dial("tcp", addr, &tls.Config{RootCAs: pool})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	c := &testConn{t: t, conn: conn, lineWatcher: watchLines(t, name, conn)}
+	c.waitFor("hello|")
+	c.send("rename|" + name)
+	c.waitFor(text("rename.done", name))
+	return c
+}
+
+// 明文TCP和TLS的用户在同一个聊天室里, whois显示各自的连接方式; Close时全部监听一起关闭
+func TestListenersCrossTransport(t *testing.T) {
+	serverTLS, pool := selfSignedTLS(t)
+	server := startTestServer(t, WithListener(transportTLS, "127.0.0.1:0", serverTLS))
+	alice := dialTest(t, server, "alice")
+	bob := dialTLSTest(t, listenerAddr(t, server, 1), pool, "bob")
+
+	alice.send("hi bob")
+	bob.waitFor("alice:hi bob")
+	bob.send("hi alice")
+	alice.waitFor("bob:hi alice")
+
+	alice.send("whois|bob")
+	alice.waitFor(text("whois.transport", transportTLS))
+	bob.send("whois|alice")
+	bob.waitFor(text("whois.transport", transportTCP))
+	if !strings.Contains(server.listenAddrs(), "tls="+listenerAddr(t, server, 1)) {
+		t.Fatalf("listeners = %s", server.listenAddrs())
+	}
+
+	addrs := []string{listenerAddr(t, server, 0), listenerAddr(t, server, 1)}
+	if err := server.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for _, addr := range addrs {
+		if conn, err := net.Dial("tcp", addr); err == nil {
+			conn.Close()
+			t.Fatalf("%s 还在监听", addr)
+		}
+	}
+}
+
+// 任何一个地址绑定失败时返回全部的错误, 已经绑定的也关掉
+func TestBindListenersFailure(t *testing.T) {
+	busy1, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy1.Close()
+	busy2, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy2.Close()
+	free, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	freeAddr := free.Addr().String()
+	free.Close()
+
+	_, err = bindListeners([]ListenerConfig{
+		{Transport: transportTCP, Addr: freeAddr},
+		{Transport: "lan", Addr: busy1.Addr().String()},
+		{Transport: transportTLS, Addr: busy2.Addr().String()},
+	})
+	if err == nil {
+		t.Fatal("地址被占用时没有返回错误")
+	}
+	for _, want := range []string{"lan " + busy1.Addr().String(), "tls " + busy2.Addr().String()} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("错误里没有 %q: %v", want, err)
+		}
+	}
+	again, err := net.Listen("tcp", freeAddr)
+	if err != nil {
+		t.Fatalf("绑定成功的地址没有关掉: %v", err)
+	}
+	again.Close()
+
+	// Start也返回这个错误, 不会开始接受连接
+	server := NewServer("127.0.0.1", 0, WithListener("lan", busy1.Addr().String(), nil))
+	if err := server.Start(); err == nil || !strings.Contains(err.Error(), "lan ") || server.Addr() != nil {
+		t.Fatalf("Start = %v, addr = %v", err, server.Addr())
+	}
+}
+
+func TestParseListenAddrs(t *testing.T) {
+	addrs, err := parseListenAddrs(" 0.0.0.0:8889, [::1]:8890 ")
+	if err != nil || strings.Join(addrs, ",") != "0.0.0.0:8889,[::1]:8890" {
+		t.Fatalf("parseListenAddrs = %q, %v", addrs, err)
+	}
+	if addrs, err := parseListenAddrs(""); addrs != nil || err != nil {
+		t.Fatalf("空字符串 = %q, %v", addrs, err)
+	}
+	for _, s := range []string{"8889", "0.0.0.0:8889,", "host"} {
+		if _, err := parseListenAddrs(s); err == nil {
+			t.Errorf("parseListenAddrs(%q) 应该失败", s)
+		}
+	}
+}
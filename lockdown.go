@@ -0,0 +1,54 @@
+// 全员禁言: 刷屏时管理员可以暂时只允许管理员公聊, 私聊和who不受影响
+package main
+
+import "sync/atomic"
+
+// 是否处于全员禁言
+func (this *Server) Lockdown() bool {
+	return atomic.LoadUint64(&this.lockdown)%2 == 1
+}
+
+// 非管理员在全员禁言时不能公聊, 返回错误; 每次禁言期间只提示一次, 已经提示过时第二个返回值为true
+func (this *User) lockedDown() (*replyError, bool) {
+	period := atomic.LoadUint64(&this.server.lockdown)
+	if period%2 == 0 || this.IsAdmin() {
+		return nil, false
+	}
+	noticed := this.lockdownNoticed == period
+	this.lockdownNoticed = period
+	return this.newError(ErrLockedDown, "lockdown.notice"), noticed
+}
+
+// 开启或关闭全员禁言, 消息格式: lockdown|on 或 lockdown|off, 只有管理员可以设置
+func (this *User) DoLockdown(arg string) {
+	if !this.IsAdmin() {
+		this.server.audit(this, "lockdown", "", arg, auditDenied)
+		this.errorReply(ErrPermission, "lockdown.admin_only")
+		return
+	}
+	if arg != "on" && arg != "off" {
+		this.server.audit(this, "lockdown", "", arg, auditInvalid)
+		this.errorReply(ErrUsage, "lockdown.usage")
+		return
+	}
+	// 奇数表示开启, 每次开启都是一个新的禁言期间
+	// 两个管理员同时设置时只有一个能改成功, 另一个看到已经是这个状态
+	for {
+		period := atomic.LoadUint64(&this.server.lockdown)
+		if (arg == "on") == (period%2 == 1) {
+			this.server.audit(this, "lockdown", "", arg, auditInvalid)
+			this.errorReply(ErrAlready, "lockdown.already", arg)
+			return
+		}
+		if atomic.CompareAndSwapUint64(&this.server.lockdown, period, period+1) {
+			break
+		}
+	}
+	this.server.audit(this, "lockdown", "", arg, auditOK)
+
+	if arg == "on" {
+		this.server.Announce(t(nil, "lockdown.on"))
+	} else {
+		this.server.Announce(t(nil, "lockdown.off"))
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// 几个管理员同时开启禁言: 只有一个开启成功, 其余的看到已经开启, 通知只发一次
+func TestLockdownConcurrentAdmins(t *testing.T) {
+	server := startTestServer(t, WithAdminToken("secret"))
+	alice := dialTest(t, server, "alice")
+	var admins []*testConn
+	for i := 0; i < 8; i++ {
+		admins = append(admins, dialAdmin(t, server, fmt.Sprintf("admin%d", i)))
+	}
+
+	for round, arg := range []string{"on", "off", "on"} {
+		before := 0
+		for _, admin := range admins {
+			before += admin.count(text("lockdown.already", arg))
+		}
+		var wg sync.WaitGroup
+		for _, admin := range admins {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				admin.conn.Write([]byte("lockdown|" + arg + "\n"))
+			}()
+		}
+		wg.Wait()
+
+		// 每个管理员的同步消息都不一样, 不会被别人的广播提前等到
+		already := -before
+		for i, admin := range admins {
+			sync := fmt.Sprintf("sync-%d-%d", round, i)
+			admin.send(sync)
+			admin.waitFor(admin.name + ":" + sync)
+			already += admin.count(text("lockdown.already", arg))
+		}
+		alice.waitFor(fmt.Sprintf("sync-%d-%d", round, len(admins)-1))
+		if already != len(admins)-1 {
+			t.Fatalf("round %d: %d 个管理员看到已经是 %s", round, already, arg)
+		}
+	}
+
+	if got := alice.count(text("lockdown.on")); got != 2 {
+		t.Fatalf("开启的通知收到 %d 次", got)
+	}
+	if got := alice.count(text("lockdown.off")); got != 1 {
+		t.Fatalf("关闭的通知收到 %d 次", got)
+	}
+	if !server.Lockdown() {
+		t.Fatal("没有处于禁言")
+	}
+	alice.send("hi")
+	alice.waitFor(text("lockdown.notice"))
+}
@@ -1,6 +1,248 @@
 package main
 
+import (
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+var serverIp string
+var serverPort int
+var adminToken string
+var historySize int
+var prefsFile string
+var storeKind string
+var storePath string
+var redisAddr string
+var instanceName string
+var grpcPort int
+var lang string
+var legacyWho bool
+var statusInterval time.Duration
+var proxyProtocol bool
+var proxyTrusted string
+var renameInterval time.Duration
+var renameLimit int
+var apiToken string
+var broadcastFormat string
+var showAddr bool
+var healthPort int
+var auditLog string
+var pmInboundLimit int
+var validateOnly bool
+var publicStats bool
+var debugLog bool
+var presenceWindow time.Duration
+var readRate int
+var maxFileSize int64
+var fileRate int
+var maxCompressed int
+var readBurst int
+var presenceThreshold int
+var serverName string
+var idleTimeout time.Duration
+var idleWarn float64
+var extraListen string
+var tlsListen string
+var tlsCert string
+var tlsKey string
+var configFile string
+var initConfig bool
+var assumeYes bool
+var greetingFile string
+var repeatWindow time.Duration
+var suspectWindow time.Duration
+var suspectLogins int
+var suspectCommands int
+var suspectBlock time.Duration
+var nameWarnWindow time.Duration
+var storeTTL time.Duration
+var storeMaxNames int
+
+func init() {
+	flag.StringVar(&serverIp, "ip", "127.0.0.1", "设置监听的IP地址(默认是127.0.0.1)")
+	flag.IntVar(&serverPort, "port", 8888, "设置监听的端口(默认是8888), 0表示由系统分配一个空闲端口, 实际端口见启动日志")
+	host, _ := os.Hostname()
+	flag.StringVar(&extraListen, "listen", "", "除了 -ip -port 之外同时监听的明文TCP地址, 逗号分隔, 例如 10.0.0.5:8888")
+	flag.StringVar(&tlsListen, "tls-listen", "", "同时监听的TLS地址, 逗号分隔, 例如 :8889, 需要 -tls-cert 和 -tls-key")
+	flag.StringVar(&tlsCert, "tls-cert", "", "TLS证书文件(PEM)")
+	flag.StringVar(&tlsKey, "tls-key", "", "TLS私钥文件(PEM)")
+	flag.StringVar(&serverName, "server-name", host, "服务器的名字, 连接时在hello里告诉客户端(默认是主机名)")
+	flag.StringVar(&adminToken, "admin-token", "", "管理员口令, 用户发送 admin|口令 成为管理员(默认不开放)")
+	flag.IntVar(&historySize, "history", defaultHistorySize, "保留最近多少条公聊消息, 新上线的用户会先收到这些消息")
+	flag.StringVar(&redisAddr, "redis", "", "集群模式: 通过这个Redis地址跟其他实例共享广播和在线用户(默认单实例)")
+	flag.StringVar(&instanceName, "instance", "", "集群模式下本实例的名字(默认是 主机名:实际监听的端口)")
+	flag.DurationVar(&idleTimeout, "idle-timeout", defaultIdleTimeout, "用户多久不发消息会被踢掉, 0表示不踢")
+	flag.Float64Var(&idleWarn, "idle-warn", defaultIdleWarn, "闲置到 -idle-timeout 的这个比例时提醒用户, 例如0.8是5分钟超时时闲置4分钟提醒, 0表示不提醒")
+	flag.DurationVar(&renameInterval, "rename-interval", defaultRenameInterval, "两次改名之间至少间隔多久, 管理员不受限制")
+	flag.DurationVar(&repeatWindow, "repeat-window", defaultRepeatWindow, "多久以内连续发同样的公聊消息算重复, 重复的不转发, 连续第三次时禁言30秒, 0表示不检查")
+	flag.DurationVar(&suspectWindow, "suspect-window", defaultSuspectWindow, "按IP统计管理员口令错误和协议错误(不认识的命令、格式不对的帧)的窗口, 超过次数后这个IP的消息处理变慢, 再多就暂时封禁, 0表示不检查")
+	flag.IntVar(&suspectLogins, "suspect-logins", defaultSuspectLogins, "窗口里同一个IP允许多少次管理员口令错误, 到两倍时封禁")
+	flag.IntVar(&suspectCommands, "suspect-commands", defaultSuspectCommands, "窗口里同一个IP允许多少次协议错误, 到两倍时封禁")
+	flag.DurationVar(&suspectBlock, "suspect-block", defaultSuspectBlock, "试探太多次的IP封禁多久, 0表示只变慢不封禁")
+	flag.DurationVar(&nameWarnWindow, "name-warn-window", defaultNameWarnWindow, "有人改成这段时间里别的地址用过的名字时广播提示, 防止冒充, 0表示不提示")
+	flag.IntVar(&renameLimit, "rename-limit", defaultRenameLimit, "一次连接最多改名几次, 0表示不限制")
+	flag.Int64Var(&maxFileSize, "max-file-size", defaultMaxFileSize, "用户之间传文件时单个文件最多多少字节, 服务器只转发不保存")
+	flag.IntVar(&fileRate, "file-rate", defaultFileRate, "传文件时每个文件每秒最多转发多少字节, 0表示不限制")
+	flag.IntVar(&maxCompressed, "max-compressed", defaultMaxCompressed, "最多多少个连接同时开启deflate压缩, 0表示不支持压缩")
+	flag.IntVar(&readRate, "read-rate", defaultReadRate, "每个连接每秒最多发送多少字节, 持续超出5秒后断开, 0表示不限制")
+	flag.IntVar(&readBurst, "read-burst", defaultReadBurst, "每个连接一次最多可以连续发送多少字节, 超过后按 -read-rate 的速度读取")
+	flag.DurationVar(&presenceWindow, "presence-window", defaultPresenceWindow, "合并上下线通知的窗口, 窗口里超过 -presence-threshold 条的合并成一行, 0表示不合并")
+	flag.IntVar(&presenceThreshold, "presence-threshold", defaultPresenceThreshold, "每个窗口里照常广播多少条上线(下线分开算)通知, 超过的合并")
+	flag.BoolVar(&proxyProtocol, "proxy-protocol", false, "在HAProxy/nginx后面时, 从PROXY协议(v1/v2)头部取得客户端的真实地址")
+	flag.StringVar(&proxyTrusted, "proxy-trusted", "", "只信任这些地址发来的PROXY头部, 逗号分隔的CIDR(默认信任所有连接)")
+	flag.DurationVar(&statusInterval, "status-interval", 0, "每隔多久在日志里输出一行运行状态, 例如 1m(默认不输出)")
+	flag.BoolVar(&publicStats, "public-stats", false, "所有人都可以用 stats 查看运行状态(默认只有管理员可以)")
+	flag.BoolVar(&legacyWho, "legacy-who", false, "who的回复不加 who-begin/who-end 标记, 兼容旧客户端")
+	flag.StringVar(&lang, "lang", "zh", "系统提示的默认语言(zh/en), 用户可以用 settings|lang|en 单独设置")
+	flag.IntVar(&grpcPort, "grpc-port", 0, "在这个端口上开启gRPC接口, 接口定义见 proto/chat.proto(默认不开启)")
+	flag.StringVar(&apiToken, "api-token", "", "gRPC接口的口令, 请求需要在 x-api-token 中带上这个口令")
+	flag.StringVar(&greetingFile, "greeting", "", "用户上线后单独发给他的欢迎语模板文件, 可以用 {name} {id} {online} {server} {date} {time}, 每次上线时重新读取")
+	flag.StringVar(&broadcastFormat, "broadcast-format", "", "广播消息的格式, 可以用 {time} {id} {name} {addr} {msg}, {{ 和 }} 表示括号本身; 也可以写 default、addr 或 noaddr(默认是 default, 开启 -show-addr 时是 addr)")
+	flag.BoolVar(&showAddr, "show-addr", false, "在广播、who和whois里向所有人显示用户的IP地址(默认只有管理员和本人能看到)")
+	flag.IntVar(&healthPort, "health-port", 0, "在这个端口上开启HTTP健康检查 /healthz, 维护模式下返回503(默认不开启)")
+	flag.IntVar(&pmInboundLimit, "pm-inbound-limit", defaultPMInboundLimit, "每个用户每分钟最多收到多少条私聊(所有发送方加起来), 超过后拒绝, 0表示不限制")
+	flag.StringVar(&auditLog, "audit-log", "", "把管理操作的审计记录追加写到这个文件(默认只保存在内存里, 用 audit|tail|条数 查看)")
+	flag.StringVar(&storeKind, "store", "", "保存个人设置等数据的方式: mem(内存, 重启后丢失)、file(JSON文件)或 sqlite(需要用 -tags sqlite 编译), 默认不保存")
+	flag.StringVar(&storePath, "store-path", "", "-store file 的文件路径, 或者 -store sqlite 的数据库文件")
+	flag.DurationVar(&storeTTL, "store-ttl", defaultStoreTTL, "Store里的离线记录和名字记录多久没有更新就删掉, 0表示不删")
+	flag.IntVar(&storeMaxNames, "store-max-names", defaultStoreMaxNames, "Store里最多保存多少个用户名的数据, 超过时删掉最久没用过的, 0表示不限制")
+	flag.StringVar(&prefsFile, "prefs-file", "", "兼容旧参数, 相当于 -store file -store-path 这个文件")
+	flag.BoolVar(&debugLog, "debug", false, "输出调试日志, 例如连接被对方重置(默认不输出)")
+	flag.StringVar(&configFile, "config", "", "从这个JSON文件读取参数, 命令行上明确给出的参数优先")
+	flag.BoolVar(&initConfig, "init", false, "运行设置向导, 把回答写到 -config 指定的文件(默认 "+defaultConfigFile+")后退出")
+	flag.BoolVar(&assumeYes, "yes", false, "和 -init 一起使用: 不提问, 全部使用默认值")
+	flag.BoolVar(&validateOnly, "validate", false, "只检查参数, 列出全部问题后退出, 不启动服务器")
+}
+
 func main() {
-	server := NewServer("127.0.0.1", 8888)
-	server.Start()
+	// 命令行解析
+	flag.Parse()
+
+	if initConfig {
+		path := configFile
+		if path == "" {
+			path = defaultConfigFile
+		}
+		if err := runInitWizard(os.Stdin, os.Stdout, path, assumeYes); err != nil {
+			fmt.Println("设置失败:", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if configFile != "" {
+		if err := loadServerConfig(configFile); err != nil {
+			fmt.Println("读取配置文件失败:", err)
+			os.Exit(1)
+		}
+	}
+
+	// -prefs-file 是以前的参数, 换成 -store file
+	if prefsFile != "" && storeKind == "" {
+		storeKind, storePath = "file", prefsFile
+	}
+
+	// 先检查全部参数, 有问题时一次列出来
+	if err := checkConfig(); err != nil {
+		printConfigErrors(err)
+		os.Exit(1)
+	}
+	if validateOnly {
+		fmt.Println("参数检查通过")
+		return
+	}
+	defaultLang = lang
+
+	if broadcastFormat == "" {
+		broadcastFormat = defaultLineFormat
+		if showAddr {
+			broadcastFormat = addrLineFormat
+		}
+	}
+	// 下面的解析在checkConfig里都检查过了
+	lineFormat, _ := ParseLineFormat(broadcastFormat)
+	opts := []Option{
+		WithName(serverName),
+		WithAdminToken(adminToken),
+		WithIdleTimeout(idleTimeout),
+		WithIdleWarn(idleWarn),
+		WithHistorySize(historySize),
+		WithRenameLimit(renameInterval, renameLimit),
+		WithRepeatWindow(repeatWindow),
+		WithSuspects(suspectWindow, suspectLogins, suspectCommands, suspectBlock),
+		WithNameWarnWindow(nameWarnWindow),
+		WithStoreRetention(storeTTL, storeMaxNames),
+		WithPMInboundLimit(pmInboundLimit),
+		WithMaxFileSize(maxFileSize),
+		WithFileRate(fileRate),
+		WithMaxCompressed(maxCompressed),
+		WithReadRate(readRate, readBurst),
+		WithPresence(presenceWindow, presenceThreshold),
+		WithStatusInterval(statusInterval),
+		WithGreeting(greetingFile, nil),
+		WithLegacyWho(legacyWho),
+		WithPublicStats(publicStats),
+		WithDebug(debugLog),
+		WithShowAddr(showAddr),
+		WithLineFormat(lineFormat),
+	}
+	if proxyProtocol {
+		trusted, _ := ParseTrustedProxies(proxyTrusted)
+		opts = append(opts, WithProxyProtocol(&ProxyProtocol{Trusted: trusted}))
+	}
+	if auditLog != "" {
+		audit, err := OpenAuditLog(auditLog)
+		if err != nil {
+			fmt.Println("open audit log err:", err)
+			os.Exit(1)
+		}
+		opts = append(opts, WithAuditLog(audit))
+	}
+	if storeKind != "" {
+		store, err := OpenStore(storeKind, storePath)
+		if err != nil {
+			fmt.Println("open store err:", err)
+			os.Exit(1)
+		}
+		opts = append(opts, WithStore(store))
+	}
+	if redisAddr != "" {
+		opts = append(opts, WithCluster(NewRedisBroker(redisAddr), instanceName))
+	}
+	// 下面的地址和证书在checkConfig里都检查过了
+	listenAddrs, _ := parseListenAddrs(extraListen)
+	for _, addr := range listenAddrs {
+		opts = append(opts, WithListener(transportTCP, addr, nil))
+	}
+	if tlsAddrs, _ := parseListenAddrs(tlsListen); len(tlsAddrs) > 0 {
+		cert, _ := tls.LoadX509KeyPair(tlsCert, tlsKey)
+		tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+		for _, addr := range tlsAddrs {
+			opts = append(opts, WithListener(transportTLS, addr, tlsConfig))
+		}
+	}
+	server := NewServer(serverIp, serverPort, opts...)
+	if grpcPort != 0 {
+		addr := net.JoinHostPort(serverIp, strconv.Itoa(grpcPort))
+		go func() {
+			fmt.Println("grpc listen err:", NewGRPCServer(server, apiToken).ListenAndServe(addr))
+		}()
+	}
+	if healthPort != 0 {
+		addr := net.JoinHostPort(serverIp, strconv.Itoa(healthPort))
+		go func() {
+			fmt.Println("health listen err:", server.ListenHealth(addr))
+		}()
+	}
+	// kill -USR1 切换维护模式, 见drain.go
+	go server.handleDrainSignal()
+
+	if err := server.Start(); err != nil {
+		fmt.Println("server start err:", err)
+		os.Exit(1)
+	}
 }
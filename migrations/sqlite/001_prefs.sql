@@ -0,0 +1,7 @@
+-- 个人设置: 每个用户名的每一项一行
+CREATE TABLE prefs (
+    name  TEXT NOT NULL,
+    key   TEXT NOT NULL,
+    value TEXT NOT NULL,
+    PRIMARY KEY (name, key)
+);
@@ -0,0 +1,7 @@
+-- 名字最后的使用者: 每个用户名一行, until是不再使用的unix秒
+CREATE TABLE name_owners (
+    name    TEXT PRIMARY KEY,
+    session INTEGER NOT NULL,
+    ip      TEXT NOT NULL,
+    until   INTEGER NOT NULL
+);
@@ -0,0 +1,7 @@
+-- 离线记录: 每个用户名一行, at是不再使用的unix秒, public是当时的公聊消息总数, pms是没有送到的私聊数
+CREATE TABLE last_seen (
+    name   TEXT PRIMARY KEY,
+    at     INTEGER NOT NULL,
+    public INTEGER NOT NULL,
+    pms    INTEGER NOT NULL
+);
@@ -0,0 +1,56 @@
+// 多行消息, 消息格式: ml|第一行\n第二行, 换行写成 \n, 反斜杠写成 \\
+// 按行和按帧时都用这种转义, 帧里的命令跟按行时一样
+// 广播时作为一条消息发出: 第一行按 -broadcast-format 显示, 后面的行缩进, 看起来接在发送人下面
+package main
+
+import "strings"
+
+// 多行消息的限制
+const (
+	maxMultilineLines = 20
+	maxMultilineBytes = 4000
+)
+
+// 后面几行的缩进; 行首是空格, 也不会被客户端当成私聊之类的消息
+const multilineIndent = "    "
+
+// 还原转义: \n 是换行, \\ 是反斜杠, 其他的反斜杠原样保留
+func unescapeMultiline(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			switch s[i+1] {
+			case 'n':
+				b.WriteByte('\n')
+				i++
+				continue
+			case '\\':
+				b.WriteByte('\\')
+				i++
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// 发一条多行的公聊消息
+func (this *User) DoMultiline(arg string) {
+	text := unescapeMultiline(arg)
+	if len(text) > maxMultilineBytes {
+		this.errorReply(ErrTooLarge, "ml.too_long", maxMultilineBytes)
+		return
+	}
+	lines := strings.Split(strings.ReplaceAll(text, "\r", ""), "\n")
+	if len(lines) > maxMultilineLines {
+		this.errorReply(ErrTooLarge, "ml.too_many", maxMultilineLines)
+		return
+	}
+	if strings.TrimSpace(strings.Join(lines, "")) == "" {
+		this.errorReply(ErrEmptyMessage, "ml.empty")
+		return
+	}
+
+	this.DoPublic(strings.Join(lines, "\n"+multilineIndent))
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUnescapeMultiline(t *testing.T) {
+	for in, want := range map[string]string{
+		`one\ntwo`:     "one\ntwo",
+		`C:\\dir\\`:    `C:\dir\`,
+		`a\tb`:         `a\tb`,
+		`trailing\`:    `trailing\`,
+		`\\n not a nl`: `\n not a nl`,
+	} {
+		if got := unescapeMultiline(in); got != want {
+			t.Errorf("unescapeMultiline(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+// 多行消息作为一条广播发出, 后面的行缩进
+func TestMultilineMessage(t *testing.T) {
+	server := startTestServer(t)
+	alice := dialTest(t, server, "alice")
+	bob := dialTest(t, server, "bob")
+
+	alice.send(`ml|第一行\n[私聊]carol对您说:假的\n路径 C:\\tmp`)
+	bob.waitFor("alice:第一行")
+	bob.waitFor(multilineIndent + "[私聊]carol对您说:假的")
+	bob.waitFor(multilineIndent + `路径 C:\tmp`)
+
+	alice.send("ml|" + strings.Repeat(`x\n`, maxMultilineLines))
+	alice.waitFor(text("ml.too_many", maxMultilineLines))
+	alice.send("ml|" + strings.Repeat("x", maxMultilineBytes+1))
+	alice.waitFor(text("ml.too_long", maxMultilineBytes))
+	alice.send(`ml|\n  \n`)
+	alice.waitFor(text("ml.empty"))
+}
@@ -0,0 +1,155 @@
+// 用户名的使用记录: 改名多了大家分不清谁是谁, 也有人会趁别人刚改掉名字时用这个名字冒充他
+// 每个连接记下自己用过的名字, 管理员用whois可以看到; 服务器记下每个名字最后一次是谁在用
+// 有人改成一个 -name-warn-window 里别的地址用过的名字时, 广播一条提示; 同一个地址重连后改回原来的名字不提示
+// 设置了 -store 时记录也保存在Store里, 重启后还在; 自动分配的guest名字不记录
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 默认的提示窗口, 0表示不提示
+const defaultNameWarnWindow = 24 * time.Hour
+
+// 内存里最多记住多少个名字, 超过时先清掉过期的
+const maxNameOwners = 4096
+
+// 每个连接最多记住多少个用过的名字
+const maxNameHistory = 20
+
+// 一个名字最后一次的使用者
+type nameOwner struct {
+	session uint64
+	ip      string
+	until   time.Time // 什么时候不再用这个名字
+}
+
+// 连接用过的一个名字
+type usedName struct {
+	name  string
+	until time.Time
+}
+
+// 全部名字的最后使用者
+type nameOwnerTable struct {
+	lock   sync.Mutex
+	owners map[string]nameOwner
+}
+
+func newNameOwnerTable() *nameOwnerTable {
+	return &nameOwnerTable{owners: make(map[string]nameOwner)}
+}
+
+// 用户不再使用name时调用: 记进用过的名字, 并记下这个名字最后的使用者和离线记录(见welcome.go)
+func (this *User) releaseName(name string, guest bool) {
+	now := this.server.Clock.Now()
+
+	this.nameLock.Lock()
+	this.usedNames = append(this.usedNames, usedName{name: name, until: now})
+	if len(this.usedNames) > maxNameHistory {
+		this.usedNames = this.usedNames[len(this.usedNames)-maxNameHistory:]
+	}
+	this.nameLock.Unlock()
+
+	// 不是这个名字的主人时不算它的使用者, 原来的主人回来后还能读到自己的设置和离线记录
+	if guest || this.PrefsName() != name {
+		return
+	}
+	this.server.recordLastSeen(name)
+	// 不提示时也记下, 读取个人设置和欢迎回来时用它判断是不是原来的主人
+	this.server.setNameOwner(name, nameOwner{session: this.ID, ip: hostOf(this.Addr), until: now})
+}
+
+func (this *Server) setNameOwner(name string, owner nameOwner) {
+	table := this.nameOwners
+	table.lock.Lock()
+	if len(table.owners) >= maxNameOwners {
+		cutoff := owner.until.Add(-this.NameWarnWindow)
+		for k, o := range table.owners {
+			if o.until.Before(cutoff) {
+				delete(table.owners, k)
+			}
+		}
+		// 都没有过期时随便清掉一个
+		for k := range table.owners {
+			if len(table.owners) < maxNameOwners {
+				break
+			}
+			delete(table.owners, k)
+		}
+	}
+	table.owners[name] = owner
+	table.lock.Unlock()
+
+	if store := this.PrefStore; store != nil {
+		if err := store.SaveNameOwner(name, owner); err != nil {
+			fmt.Println("save name owner err:", name, err)
+		}
+	}
+}
+
+// 名字最后的使用者, 内存里没有时查Store
+func (this *Server) nameOwner(name string) (nameOwner, bool) {
+	table := this.nameOwners
+	table.lock.Lock()
+	owner, ok := table.owners[name]
+	table.lock.Unlock()
+	if ok || this.PrefStore == nil {
+		return owner, ok
+	}
+
+	owner, ok, err := this.PrefStore.LoadNameOwner(name)
+	if err != nil {
+		fmt.Println("load name owner err:", name, err)
+		return nameOwner{}, false
+	}
+	return owner, ok
+}
+
+// 这个连接是不是name的主人: 没有人用过这个名字, 或者上一次是这个连接、同一个地址在用
+func (this *User) ownsName(name string) bool {
+	owner, ok := this.server.nameOwner(name)
+	return !ok || owner.session == this.ID || owner.ip == hostOf(this.Addr)
+}
+
+// 用户开始使用name时调用: 窗口里别的地址用过这个名字时广播提示
+func (this *User) checkNameOwner(name string) {
+	window := this.server.NameWarnWindow
+	if window <= 0 {
+		return
+	}
+	owner, ok := this.server.nameOwner(name)
+	if !ok || owner.session == this.ID || owner.ip == hostOf(this.Addr) {
+		return
+	}
+	if this.server.Clock.Now().Sub(owner.until) > window {
+		return
+	}
+	fmt.Printf("session=#%d name recently used name=%s by=#%d\n", this.ID, name, owner.session)
+	this.server.Announce(t(nil, "names.recent", name))
+}
+
+// whois里给管理员看的名字记录
+func (this *User) nameLines(viewer *User) []string {
+	var lines []string
+
+	this.nameLock.RLock()
+	used := make([]string, 0, len(this.usedNames))
+	for i := len(this.usedNames) - 1; i >= 0; i-- {
+		u := this.usedNames[i]
+		used = append(used, u.name+" ("+u.until.Format("15:04")+")")
+	}
+	this.nameLock.RUnlock()
+	if len(used) > 0 {
+		lines = append(lines, t(viewer, "whois.names", strings.Join(used, ", ")))
+	}
+
+	if owner, ok := this.server.nameOwner(this.Name()); ok && owner.session != this.ID {
+		ago := this.server.Clock.Now().Sub(owner.until).Round(time.Minute)
+		lines = append(lines, t(viewer, "whois.name_owner", owner.session, owner.ip, ago))
+	}
+	return lines
+}
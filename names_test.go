@@ -0,0 +1,104 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// 窗口里别的地址用过的名字提示一次, 正好在窗口边上也算; 同一个地址、过了窗口、关掉提示时不提示
+func TestNameWarnWindow(t *testing.T) {
+	tests := []struct {
+		what   string
+		window time.Duration
+		ip     string
+		ago    time.Duration
+		warn   bool
+	}{
+		{"刚用过", time.Hour, "10.0.0.9", 0, true},
+		{"正好一个窗口以前", time.Hour, "10.0.0.9", time.Hour, true},
+		{"过了窗口", time.Hour, "10.0.0.9", time.Hour + time.Second, false},
+		{"同一个地址", time.Hour, "127.0.0.1", time.Minute, false},
+		{"不提示", 0, "10.0.0.9", time.Minute, false},
+	}
+	for _, tt := range tests {
+		clock := NewFakeClock(testEpoch)
+		server := startTestServer(t, WithClock(clock), WithRenameLimit(0, 0), WithNameWarnWindow(tt.window))
+		carol := dialTest(t, server, "carol")
+		alice := dialTest(t, server, "alice")
+		server.setNameOwner("bob", nameOwner{session: 99, ip: tt.ip, until: clock.Now().Add(-tt.ago)})
+
+		alice.send("rename|bob")
+		alice.waitFor(text("rename.done", "bob"))
+		alice.send("sync-bob")
+		carol.waitFor("bob:sync-bob")
+		if got := carol.saw(text("names.recent", "bob")); got != tt.warn {
+			t.Errorf("%s: 提示 = %v, want %v", tt.what, got, tt.warn)
+		}
+	}
+}
+
+// 放弃的名字记下最后的使用者, 自动分配的guest名字不记
+func TestNameRelease(t *testing.T) {
+	clock := NewFakeClock(testEpoch)
+	server := startTestServer(t, WithClock(clock), WithRenameLimit(0, 0))
+	alice := dialTest(t, server, "")
+	first := onlineUser(t, server, "guest-1")
+
+	alice.send("rename|alice")
+	alice.waitFor(text("rename.done", "alice"))
+	if _, ok := server.nameOwner("guest-1"); ok {
+		t.Fatal("guest名字也记了使用者")
+	}
+	clock.Advance(time.Minute)
+	alice.send("rename|alice2")
+	alice.waitFor(text("rename.done", "alice2"))
+	owner, ok := server.nameOwner("alice")
+	if !ok || owner.session != first.ID || owner.ip != "127.0.0.1" || !owner.until.Equal(testEpoch.Add(time.Minute)) {
+		t.Fatalf("alice 的使用者 = %+v, %v", owner, ok)
+	}
+
+	// 同一个地址重连后拿回原来的名字不提示
+	bob := dialTest(t, server, "bob")
+	again := dialTest(t, server, "alice")
+	again.send("sync-again")
+	bob.waitFor("alice:sync-again")
+	if bob.saw(text("names.recent", "alice")) {
+		t.Fatal("同一个地址拿回名字也提示了")
+	}
+}
+
+// 管理员的whois能看到用过的名字和名字上一个使用者, 普通用户看不到
+func TestWhoisNameHistory(t *testing.T) {
+	clock := NewFakeClock(testEpoch)
+	server := startTestServer(t, WithClock(clock), WithRenameLimit(0, 0), WithAdminToken("secret"))
+	alice := dialTest(t, server, "alice")
+	admin := dialAdmin(t, server, "admin")
+	bob := dialTest(t, server, "bob")
+
+	clock.Advance(time.Hour)
+	server.setNameOwner("carol", nameOwner{session: 99, ip: "10.0.0.9", until: clock.Now()})
+	clock.Advance(30 * time.Minute)
+	alice.send("rename|carol")
+	alice.waitFor(text("rename.done", "carol"))
+
+	admin.send("whois|carol", "sync-whois")
+	admin.waitFor("sync-whois")
+	want := []string{
+		text("whois.names", "alice (16:34), guest-1 (15:04)"),
+		text("whois.name_owner", 99, "10.0.0.9", 30*time.Minute),
+	}
+	for _, line := range want {
+		if !admin.saw(line) {
+			t.Fatalf("管理员的whois没有 %q: %q", line, admin.seen)
+		}
+	}
+
+	bob.send("whois|carol", "sync-whois")
+	bob.waitFor("sync-whois")
+	bob.waitFor(text("whois.name", "carol"))
+	for _, line := range want {
+		if bob.saw(line) {
+			t.Fatalf("普通用户的whois有 %q", line)
+		}
+	}
+}
@@ -0,0 +1,225 @@
+// NewServer的选项, 例如 NewServer(ip, port, WithIdleTimeout(time.Minute), WithStore(store))
+// 嵌入到别的程序里时用选项配置服务器, 不用在NewServer之后逐个修改字段; 按顺序应用, 后面的覆盖前面的
+// 没有给出的选项使用跟命令行参数一样的默认值; Start之后配置只读, 见Server
+package main
+
+import (
+	"crypto/tls"
+	"time"
+)
+
+type Option func(*Server)
+
+// 监听的地址和端口, 端口为0时由系统分配, 见Addr
+func WithListenAddr(ip string, port int) Option {
+	return func(s *Server) {
+		s.Ip, s.Port = ip, port
+	}
+}
+
+// 除了监听的地址之外再监听一个地址, tlsConfig不为nil时用TLS; 可以多次使用
+func WithListener(transport string, addr string, tlsConfig *tls.Config) Option {
+	return func(s *Server) {
+		s.Listeners = append(s.Listeners, ListenerConfig{Transport: transport, Addr: addr, TLS: tlsConfig})
+	}
+}
+
+// 服务器的名字, 在hello里告诉客户端
+func WithName(name string) Option {
+	return func(s *Server) {
+		s.Name = name
+	}
+}
+
+// 管理员口令, 为空时不开放管理员身份
+func WithAdminToken(token string) Option {
+	return func(s *Server) {
+		s.AdminToken = token
+	}
+}
+
+// 用户多久不发消息会被踢掉, 0表示不踢
+func WithIdleTimeout(d time.Duration) Option {
+	return func(s *Server) {
+		s.IdleTimeout = d
+	}
+}
+
+// 闲置到超时时间的多少比例时提醒, 0表示不提醒
+func WithIdleWarn(ratio float64) Option {
+	return func(s *Server) {
+		s.IdleWarn = ratio
+	}
+}
+
+// 保留最近多少条公聊消息
+func WithHistorySize(n int) Option {
+	return func(s *Server) {
+		s.history = NewHistory(n)
+	}
+}
+
+// 两次改名之间至少间隔多久, 以及一次连接最多改名几次
+func WithRenameLimit(interval time.Duration, limit int) Option {
+	return func(s *Server) {
+		s.RenameInterval, s.RenameLimit = interval, limit
+	}
+}
+
+// 多久以内连续发同样的公聊消息算重复, 0表示不检查
+func WithRepeatWindow(d time.Duration) Option {
+	return func(s *Server) {
+		s.RepeatWindow = d
+	}
+}
+
+// 按IP限制试探: 统计的窗口(0表示不检查), 允许的口令错误和命令错误次数(0表示这一种不算), 封禁多久(0表示不封禁)
+func WithSuspects(window time.Duration, logins int, commands int, block time.Duration) Option {
+	return func(s *Server) {
+		s.SuspectWindow, s.SuspectLogins, s.SuspectCommands, s.SuspectBlock = window, logins, commands, block
+	}
+}
+
+// 多久以内别的地址用过的名字算最近用过, 改成这样的名字时广播提示, 0表示不提示
+func WithNameWarnWindow(d time.Duration) Option {
+	return func(s *Server) {
+		s.NameWarnWindow = d
+	}
+}
+
+// Store里的记录保存多久, 最多保存多少个用户名的数据, 0表示不清理
+func WithStoreRetention(ttl time.Duration, maxNames int) Option {
+	return func(s *Server) {
+		s.StoreTTL, s.StoreMaxNames = ttl, maxNames
+	}
+}
+
+// 每个用户每分钟最多收到多少条私聊, 0表示不限制
+func WithPMInboundLimit(n int) Option {
+	return func(s *Server) {
+		s.PMInboundLimit = n
+	}
+}
+
+// 用户之间传文件时单个文件最多多少字节
+func WithMaxFileSize(n int64) Option {
+	return func(s *Server) {
+		s.MaxFileSize = n
+	}
+}
+
+// 传文件时每个文件每秒最多转发多少字节, 为0时不限制
+func WithFileRate(rate int) Option {
+	return func(s *Server) {
+		s.FileRate = rate
+	}
+}
+
+// 最多多少个连接同时开启压缩, 为0时不支持压缩
+func WithMaxCompressed(n int) Option {
+	return func(s *Server) {
+		s.MaxCompressed = n
+	}
+}
+
+// 每个连接每秒最多读多少字节和最多攒多少字节, rate为0时不限制
+func WithReadRate(rate int, burst int) Option {
+	return func(s *Server) {
+		s.ReadRate, s.ReadBurst = rate, burst
+	}
+}
+
+// 上下线通知的合并窗口和窗口里照常广播的条数, 窗口为0时不合并
+func WithPresence(window time.Duration, threshold int) Option {
+	return func(s *Server) {
+		s.PresenceWindow, s.PresenceThreshold = window, threshold
+	}
+}
+
+// 每隔多久在日志里输出一行运行状态, 0表示不输出
+func WithStatusInterval(d time.Duration) Option {
+	return func(s *Server) {
+		s.StatusInterval = d
+	}
+}
+
+// 新用户的默认用户名
+func WithGuestNamer(namer GuestNamer) Option {
+	return func(s *Server) {
+		s.GuestName = namer
+	}
+}
+
+// 上线后的欢迎语: 模板文件和部署方的Greeter, 不需要的传空字符串或nil
+func WithGreeting(file string, greeter Greeter) Option {
+	return func(s *Server) {
+		s.GreetingFile, s.Greeter = file, greeter
+	}
+}
+
+// 广播消息的格式, 见ParseLineFormat
+func WithLineFormat(f *LineFormat) Option {
+	return func(s *Server) {
+		s.LineFormat = f
+	}
+}
+
+// 在代理后面时从PROXY协议头部取得客户端地址
+func WithProxyProtocol(p *ProxyProtocol) Option {
+	return func(s *Server) {
+		s.ProxyProtocol = p
+	}
+}
+
+// 管理操作的审计记录, 默认只保存在内存里
+func WithAuditLog(audit *AuditLog) Option {
+	return func(s *Server) {
+		s.Audit = audit
+	}
+}
+
+// 保存个人设置的Store, 默认不保存
+func WithStore(store Store) Option {
+	return func(s *Server) {
+		s.PrefStore = store
+	}
+}
+
+// 时钟, 测试时换成FakeClock
+func WithClock(clock Clock) Option {
+	return func(s *Server) {
+		s.SetClock(clock)
+	}
+}
+
+// 集群模式, 通过broker跟其他实例共享广播和在线用户
+func WithCluster(broker Broker, instance string) Option {
+	return func(s *Server) {
+		s.EnableCluster(broker, instance)
+	}
+}
+
+// 开关: who不加 who-begin/who-end 标记, 所有人都能看stats, 输出调试日志, 向所有人显示地址
+func WithLegacyWho(on bool) Option {
+	return func(s *Server) {
+		s.LegacyWho = on
+	}
+}
+
+func WithPublicStats(on bool) Option {
+	return func(s *Server) {
+		s.PublicStats = on
+	}
+}
+
+func WithDebug(on bool) Option {
+	return func(s *Server) {
+		s.Debug = on
+	}
+}
+
+func WithShowAddr(on bool) Option {
+	return func(s *Server) {
+		s.ShowAddr = on
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// 没有给出选项时跟命令行参数的默认值一样
+func TestNewServerDefaults(t *testing.T) {
+	s := NewServer("127.0.0.1", 8888)
+	if s.IdleTimeout != defaultIdleTimeout || s.RenameLimit != defaultRenameLimit ||
+		s.StoreTTL != defaultStoreTTL || s.MaxFileSize != defaultMaxFileSize ||
+		s.MaxCompressed != defaultMaxCompressed || s.ReadRate != defaultReadRate {
+		t.Fatalf("defaults = %+v", s)
+	}
+	if _, ok := s.Clock.(realClock); !ok {
+		t.Fatalf("clock = %T", s.Clock)
+	}
+}
+
+func TestOptionsApply(t *testing.T) {
+	clock := NewFakeClock(testEpoch)
+	store := NewMemStore()
+	for _, c := range []struct {
+		name string
+		opt  Option
+		ok   func(s *Server) bool
+	}{
+		{"listen", WithListenAddr("0.0.0.0", 9000), func(s *Server) bool { return s.Ip == "0.0.0.0" && s.Port == 9000 }},
+		{"admin", WithAdminToken("secret"), func(s *Server) bool { return s.AdminToken == "secret" }},
+		{"idle", WithIdleTimeout(time.Minute), func(s *Server) bool { return s.IdleTimeout == time.Minute }},
+		{"rename", WithRenameLimit(time.Second, 3), func(s *Server) bool { return s.RenameInterval == time.Second && s.RenameLimit == 3 }},
+		{"retention", WithStoreRetention(time.Hour, 10), func(s *Server) bool { return s.StoreTTL == time.Hour && s.StoreMaxNames == 10 }},
+		{"file rate", WithFileRate(1024), func(s *Server) bool { return s.FileRate == 1024 }},
+		{"compressed", WithMaxCompressed(2), func(s *Server) bool { return s.MaxCompressed == 2 }},
+		{"show addr", WithShowAddr(true), func(s *Server) bool { return s.ShowAddr }},
+		{"store", WithStore(store), func(s *Server) bool { return s.PrefStore == store }},
+		{"clock", WithClock(clock), func(s *Server) bool { return s.Clock == clock }},
+	} {
+		if s := NewServer("127.0.0.1", 0, c.opt); !c.ok(s) {
+			t.Errorf("%s: option not applied", c.name)
+		}
+	}
+}
+
+// 后面的选项覆盖前面的
+func TestOptionsOrder(t *testing.T) {
+	s := NewServer("127.0.0.1", 0, WithIdleTimeout(time.Minute), WithIdleTimeout(time.Hour))
+	if s.IdleTimeout != time.Hour {
+		t.Fatalf("idle = %v", s.IdleTimeout)
+	}
+}
+
+func TestStartTwice(t *testing.T) {
+	server := startTestServer(t)
+	if err := server.Start(); err != errServerStarted {
+		t.Fatalf("second Start = %v", err)
+	}
+}
+
+// Start之后不能再换时钟或者开启集群
+func TestConfigAfterStartPanics(t *testing.T) {
+	server := startTestServer(t)
+	for name, change := range map[string]func(){
+		"SetClock":      func() { server.SetClock(NewFakeClock(testEpoch)) },
+		"EnableCluster": func() { server.EnableCluster(NewRedisBroker("127.0.0.1:1"), "a") },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s after Start did not panic", name)
+				}
+			}()
+			change()
+		}()
+	}
+}
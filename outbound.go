@@ -0,0 +1,151 @@
+// 每个用户的发送队列: 广播先放进user.C, 由ListenMessage按顺序写到连接上
+// 以前user.C没有缓冲, 一个不读消息的客户端会卡住整条广播; 现在最多缓冲outboundQueue条, 满了再等outboundWait还放不进去就按慢客户端断开
+// 用户说收不到消息时, 管理员用 queue|用户名 查看队列里积压了多少、最多积压过多少、最后一次写成功的时间和写入的状态
+// queue|用户名|flush 丢掉积压的消息, queue|用户名|kick 按慢客户端断开; 都只读写channel和atomic字段, 跟写入的goroutine不用加锁
+// deliverLocal在mapLock外面往队列里放消息, 用户可能同时在下线, 所以放消息和关闭user.C都要持有outbound.lock
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 每个用户最多缓冲多少条广播
+const outboundQueue = 256
+
+// 队列满了时最多等多久, 网络只是慢一下的客户端不会被断开
+const outboundWait = 2 * time.Second
+
+// 发送队列的状态, 除了lock保护的字段都用atomic读写
+type outboundState struct {
+	lock       sync.Mutex
+	closed     bool          // user.C已经关闭, lock保护
+	done       chan struct{} // 开始下线时关闭, 队列满了在等的广播不再等
+	highWater  int32         // 队列里最多积压过多少条
+	lastWrite  int64         // 最后一次写成功的时间(UnixNano), 0表示还没有写过
+	writeStart int64         // 正在写的这条开始写的时间(UnixNano), 0表示没有在写
+	stopped    int32         // ListenMessage已经退出
+	slow       int32         // 已经按慢客户端断开过, 只断开和记日志一次
+}
+
+// 把一条广播放进发送队列, 队列满了等outboundWait还放不进去时返回false
+// 用户已经下线时直接丢掉, 返回true
+func (this *User) queueMessage(msg string) bool {
+	this.outbound.lock.Lock()
+	defer this.outbound.lock.Unlock()
+
+	if this.outbound.closed {
+		return true
+	}
+	select {
+	case this.C <- msg:
+	default:
+		if atomic.LoadInt32(&this.outbound.slow) == 1 {
+			return false
+		}
+		select {
+		case this.C <- msg:
+		case <-this.outbound.done:
+			return true
+		case <-this.server.Clock.After(outboundWait):
+			return false
+		}
+	}
+	n := int32(len(this.C))
+	for {
+		high := atomic.LoadInt32(&this.outbound.highWater)
+		if n <= high || atomic.CompareAndSwapInt32(&this.outbound.highWater, high, n) {
+			return true
+		}
+	}
+}
+
+// 按慢客户端断开, 读消息的goroutine读到错误后执行下线
+func (this *User) slowDisconnect(reason string) {
+	if !atomic.CompareAndSwapInt32(&this.outbound.slow, 0, 1) {
+		return
+	}
+	fmt.Printf("session=#%d slow client disconnected name=%s queued=%d reason=%s\n", this.ID, this.Name(), len(this.C), reason)
+	this.disconnect()
+}
+
+// 丢掉队列里积压的消息, 返回丢掉了多少条; 写入的goroutine同时在取也没关系
+func (this *User) flushOutbound() int {
+	n := 0
+	for {
+		select {
+		case _, ok := <-this.C:
+			if !ok {
+				return n
+			}
+			n++
+		default:
+			return n
+		}
+	}
+}
+
+// 写入的状态: stopped(已经退出)、broken(写失败过)、writing(正在写, 加上已经写了多久)、idle(在等消息)
+func (this *User) writerState() string {
+	if atomic.LoadInt32(&this.outbound.stopped) == 1 {
+		return "stopped"
+	}
+	if atomic.LoadInt32(&this.broken) == 1 {
+		return "broken"
+	}
+	if start := atomic.LoadInt64(&this.outbound.writeStart); start != 0 {
+		took := this.server.Clock.Now().Sub(time.Unix(0, start)).Round(time.Millisecond)
+		return "writing " + took.String()
+	}
+	return "idle"
+}
+
+// 查看和处理用户的发送队列, 消息格式: queue|用户名 或 queue|用户名|flush 或 queue|用户名|kick
+func (this *User) DoQueue(arg string) {
+	if !this.IsAdmin() {
+		this.server.audit(this, "queue", "", arg, auditDenied)
+		this.errorReply(ErrPermission, "queue.admin_only")
+		return
+	}
+	name, action, _ := strings.Cut(arg, "|")
+	if name == "" || (action != "" && action != "flush" && action != "kick") {
+		this.server.audit(this, "queue", "", arg, auditInvalid)
+		this.errorReply(ErrUsage, "queue.usage")
+		return
+	}
+	user, ok := this.server.lookupUser(name)
+	if !ok {
+		this.userNotFound(name)
+		return
+	}
+
+	switch action {
+	case "flush":
+		n := user.flushOutbound()
+		this.server.audit(this, "queue", user.Name(), action, auditOK)
+		this.SendMsg(t(this, "queue.flushed", user.Name(), n) + "\n")
+		return
+	case "kick":
+		// 查到以后用户可能已经自己下线了, 这时不算踢掉
+		if !this.server.stillOnline(user, name) {
+			this.userNotFound(name)
+			return
+		}
+		user.slowDisconnect("admin")
+		this.server.audit(this, "queue", user.Name(), action, auditOK)
+		this.SendMsg(t(this, "queue.kicked", user.Name()) + "\n")
+		return
+	}
+
+	lastWrite := t(this, "queue.never")
+	if at := atomic.LoadInt64(&user.outbound.lastWrite); at != 0 {
+		when := time.Unix(0, at)
+		ago := this.server.Clock.Now().Sub(when).Round(time.Second)
+		lastWrite = t(this, "queue.ago", when.Format("15:04:05"), ago)
+	}
+	this.SendMsg(t(this, "queue.info", user.Name(), len(user.C), cap(user.C),
+		atomic.LoadInt32(&user.outbound.highWater), lastWrite, user.writerState()) + "\n")
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 通过net.Pipe接一个用户, 改名成功以后就不再读, 服务器往这个连接写的第一条就会卡住
+func dialStalled(t *testing.T, server *Server, name string) *User {
+	t.Helper()
+	serverSide, clientSide := net.Pipe()
+	t.Cleanup(func() { clientSide.Close() })
+	go server.Handler(serverSide)
+
+	reader := bufio.NewReader(clientSide)
+	readUntil := func(want string) {
+		clientSide.SetReadDeadline(time.Now().Add(e2eWait))
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				t.Fatalf("%s: 没有读到 %q: %v", name, want, err)
+			}
+			if strings.Contains(line, want) {
+				return
+			}
+		}
+	}
+	readUntil("hello|")
+	go clientSide.Write([]byte("rename|" + name + "\n"))
+	readUntil(name)
+	return onlineUser(t, server, name)
+}
+
+func dialAdmin(t *testing.T, server *Server, name string) *testConn {
+	t.Helper()
+	admin := dialTest(t, server, name)
+	admin.send("admin|secret")
+	admin.waitFor(text("admin.granted"))
+	return admin
+}
+
+// 从from一条一条地发公聊, 直到user的队列里积压了n条
+// 先等写入的goroutine卡住, 之后每条都等到进了队列再发下一条, 上线和改名的通知也都已经放进去了
+func fillQueue(t *testing.T, from *testConn, user *User, n int) {
+	t.Helper()
+	from.send("stall")
+	eventually(t, "写入没有卡住", func() bool { return strings.HasPrefix(user.writerState(), "writing") })
+	for i := 0; len(user.C) < n; i++ {
+		before := len(user.C)
+		from.send("fill-" + strconv.Itoa(i))
+		eventually(t, "广播没有积压在慢用户的队列里", func() bool { return len(user.C) > before })
+	}
+}
+
+// 等到条件成立
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(e2eWait)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal(what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestQueueInspectAndFlush(t *testing.T) {
+	clock := NewFakeClock(testEpoch)
+	server := startTestServer(t, WithClock(clock), WithAdminToken("secret"), WithReadRate(0, 0))
+	root := dialAdmin(t, server, "root")
+	alice := dialTest(t, server, "alice")
+	slow := dialStalled(t, server, "slow")
+
+	fillQueue(t, alice, slow, 5)
+	root.send("queue|slow")
+	root.waitFor(text("queue.info", "slow", 5, outboundQueue, 5,
+		text("queue.ago", testEpoch.Format("15:04:05"), time.Duration(0)), "writing 0s"))
+
+	root.send("queue|slow|flush")
+	root.waitFor(text("queue.flushed", "slow", 5))
+	if len(slow.C) != 0 {
+		t.Fatalf("flush以后还积压 %d 条", len(slow.C))
+	}
+
+	root.send("queue|slow|kick")
+	root.waitFor(text("queue.kicked", "slow"))
+	eventually(t, "慢用户没有被断开", func() bool {
+		_, ok := server.lookupUser("slow")
+		return !ok
+	})
+}
+
+// 慢用户的队列满了时广播要等outboundWait, 这期间其他用户照样能上线和收消息
+func TestQueueFullDoesNotBlockServer(t *testing.T) {
+	clock := NewFakeClock(testEpoch)
+	server := startTestServer(t, WithClock(clock), WithReadRate(0, 0))
+	alice := dialTest(t, server, "alice")
+	slow := dialStalled(t, server, "slow")
+
+	// 写入的goroutine卡在第一条上, 再放满队列
+	fillQueue(t, alice, slow, outboundQueue)
+
+	waiters := clock.Waiters()
+	alice.send("blocked-broadcast")
+	eventually(t, "广播没有等队列的空位", func() bool { return clock.Waiters() > waiters })
+
+	// 广播在等的时候不能拿着mapLock: 新用户能上线, who能列出在线用户
+	// 不改名, 改名的通知要等广播队列
+	bob := dialTest(t, server, "")
+	bob.send("who")
+	bob.waitFor("alice")
+
+	clock.Advance(outboundWait)
+	alice.waitFor("blocked-broadcast")
+	bob.waitFor("blocked-broadcast")
+	eventually(t, "队列满了的用户没有被断开", func() bool {
+		_, ok := server.lookupUser("slow")
+		return !ok
+	})
+}
+
+func TestQueueKickOfflineUser(t *testing.T) {
+	server := startTestServer(t, WithAdminToken("secret"))
+	root := dialAdmin(t, server, "root")
+	root.send("caps|errors", "queue|nobody|kick")
+	root.waitFor("err|" + strconv.Itoa(ErrNoSuchUser.Num) + "|")
+	if root.saw(text("queue.kicked", "nobody")) {
+		t.Fatal("不在线的用户也说已经断开")
+	}
+}
@@ -0,0 +1,21 @@
+// 测量延迟: 客户端发 ping|客户端时间, 服务器马上回复 pong|客户端时间|服务器时间(Unix毫秒)
+// 回复直接写到连接上, 不经过广播队列, 测到的是网络和这个连接本身的延迟
+// ping是客户端自动发的, 不算用户活跃(命令表里的NoActivity), 开着看门狗的客户端照样会被闲置提醒和踢掉
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// 客户端时间最长多少字节, 服务器不解析, 原样带回去
+const maxPingToken = 64
+
+// 消息格式: ping|客户端时间
+func (this *User) DoPing(arg string) {
+	if len(arg) > maxPingToken || strings.ContainsAny(arg, "|\r\n") {
+		this.errorReply(ErrUsage, "ping.usage")
+		return
+	}
+	this.SendMsg("pong|" + arg + "|" + strconv.FormatInt(this.server.Clock.Now().UnixMilli(), 10) + "\n")
+}
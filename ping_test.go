@@ -0,0 +1,54 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// pong直接回复给发送方, 广播队列卡住时照样马上收到
+func TestPingBypassesBroadcast(t *testing.T) {
+	clock := NewFakeClock(testEpoch)
+	server := startTestServer(t, WithClock(clock), WithReadRate(0, 0))
+	alice := dialTest(t, server, "alice")
+	slow := dialStalled(t, server, "slow")
+
+	// 广播卡在慢用户的队列上, 等outboundWait
+	fillQueue(t, alice, slow, outboundQueue)
+	waiters := clock.Waiters()
+	alice.send("blocked-broadcast")
+	eventually(t, "广播没有等队列的空位", func() bool { return clock.Waiters() > waiters })
+
+	alice.send("ping|1767366245000")
+	line := alice.waitFor("pong|")
+	if want := "pong|1767366245000|" + strconv.FormatInt(testEpoch.UnixMilli(), 10); line != want {
+		t.Fatalf("pong = %q, want %q", line, want)
+	}
+	// 广播还卡着, alice自己可能已经先收到了, 所以只看等待的还在不在
+	if clock.Waiters() <= waiters {
+		t.Fatal("pong排在了广播后面")
+	}
+
+	clock.Advance(outboundWait)
+	eventually(t, "队列满了的用户没有被断开", func() bool {
+		_, ok := server.lookupUser("slow")
+		return !ok
+	})
+}
+
+// 客户端时间原样带回去, 太长或者带|的不回复
+func TestPingToken(t *testing.T) {
+	server := startTestServer(t)
+	alice := dialTest(t, server, "alice")
+	alice.send("ping|abc-123")
+	alice.waitFor("pong|abc-123|")
+
+	alice.send("caps|errors", "ping|a|b", "ping|"+strings.Repeat("x", maxPingToken+1))
+	usage := "err|" + strconv.Itoa(ErrUsage.Num) + "|" + text("ping.usage")
+	alice.waitFor(usage)
+	alice.send("sync-alice")
+	alice.waitFor("sync-alice")
+	if n := alice.count(usage); n != 2 || alice.count("pong|") != 1 {
+		t.Fatalf("收到 %d 个用法错误, %d 个pong", n, alice.count("pong|"))
+	}
+}
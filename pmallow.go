@@ -0,0 +1,99 @@
+// 私聊白名单: 开启后只接收允许过的用户发来的私聊
+// 消息格式: pm|allowlist|on 或 pm|allowlist|off, pm|allow|用户名, pm|deny|用户名
+// 开关和名单保存在个人设置 pm_allowlist 和 pm_allow 里, 开启 -prefs-file 时下次上线还在
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// 私聊白名单里的用户名
+func (this *User) pmAllowed() []string {
+	v := this.Pref("pm_allow")
+	if v == "" {
+		return nil
+	}
+	return strings.Split(v, ",")
+}
+
+// 名单里有没有这个用户名, 不看白名单有没有开启
+func (this *User) allowsPM(name string) bool {
+	for _, n := range this.pmAllowed() {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// 互相在对方的名单里, 私聊不受接收上限的限制
+func (this *User) isFriend(from *User) bool {
+	return this.allowsPM(from.Name()) && from.allowsPM(this.Name())
+}
+
+// 是否接收from发来的私聊: 没有开启白名单、from在名单里、或者from是管理员
+// 不接收时通知接收方一次, 同一个发送方在这次连接里只通知一次
+func (this *User) checkAllowList(from *User) bool {
+	if this.Pref("pm_allowlist") != "on" || from.IsAdmin() || this.allowsPM(from.Name()) {
+		return true
+	}
+
+	this.inboundLock.Lock()
+	if this.pmRequests == nil {
+		this.pmRequests = make(map[string]bool)
+	}
+	first := !this.pmRequests[from.Name()]
+	this.pmRequests[from.Name()] = true
+	this.inboundLock.Unlock()
+
+	if first {
+		this.SendMsg(systemPrefix + t(this, "pm.request", from.Name()) + "\n")
+	}
+	return false
+}
+
+// 修改私聊白名单, 消息格式见文件开头
+func (this *User) DoPM(arg string) {
+	parts := strings.SplitN(arg, "|", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		this.errorReply(ErrUsage, "pm.allow_usage")
+		return
+	}
+
+	switch parts[0] {
+	case "allowlist":
+		if err := validateOnOff(parts[1]); err != nil {
+			this.errorReplyFor(ErrUsage, err)
+			return
+		}
+		this.setPref("pm_allowlist", parts[1])
+		this.SendMsg(t(this, "pm.allowlist_"+parts[1]) + "\n")
+
+	case "allow", "deny":
+		name := parts[1]
+		names := make([]string, 0)
+		for _, n := range this.pmAllowed() {
+			if n != name {
+				names = append(names, n)
+			}
+		}
+		if parts[0] == "allow" {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		if err := settings["pm_allow"].Validate(strings.Join(names, ",")); err != nil {
+			this.errorReplyFor(ErrBadData, err)
+			return
+		}
+		this.setPref("pm_allow", strings.Join(names, ","))
+		if parts[0] == "allow" {
+			this.SendMsg(t(this, "pm.allowed", name) + "\n")
+		} else {
+			this.SendMsg(t(this, "pm.denied", name) + "\n")
+		}
+
+	default:
+		this.errorReply(ErrUsage, "pm.allow_usage")
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import "testing"
+
+// 开启白名单后: 不在名单里的发送方被拒绝, 接收方只收到一次请求; 允许以后能发, 移出名单后又被拒绝
+func TestPMAllowList(t *testing.T) {
+	server := startTestServer(t, WithAdminToken("secret"))
+	alice := dialTest(t, server, "alice")
+	bob := dialTest(t, server, "bob")
+	admin := dialAdmin(t, server, "admin")
+
+	alice.send("pm|allowlist|on")
+	alice.waitFor(text("pm.allowlist_on"))
+
+	bob.send("to|alice|one")
+	bob.waitFor(text("pm.not_allowed"))
+	bob.send("to|alice|two")
+	bob.waitFor(text("pm.not_allowed"))
+	admin.send("to|alice|from admin")
+	alice.waitFor(privateLine("admin", "from admin"))
+	if n := alice.count(text("pm.request", "bob")); n != 1 {
+		t.Fatalf("alice 收到 %d 次请求", n)
+	}
+
+	alice.send("pm|allow|bob")
+	alice.waitFor(text("pm.allowed", "bob"))
+	bob.send("to|alice|three")
+	alice.waitFor(privateLine("bob", "three"))
+
+	alice.send("pm|deny|bob")
+	alice.waitFor(text("pm.denied", "bob"))
+	bob.send("to|alice|four")
+	bob.waitFor(text("pm.not_allowed"))
+
+	alice.send("pm|allowlist|off")
+	alice.waitFor(text("pm.allowlist_off"))
+	bob.send("to|alice|five")
+	alice.waitFor(privateLine("bob", "five"))
+
+	for _, line := range alice.seen {
+		for _, blocked := range []string{"one", "two", "four"} {
+			if line == privateLine("bob", blocked) {
+				t.Fatalf("被拒绝的私聊送到了: %q", line)
+			}
+		}
+	}
+
+	alice.send("pm|allow", "pm|block|bob")
+	alice.waitFor(text("pm.allow_usage"))
+	alice.waitFor(text("pm.allow_usage"))
+}
@@ -0,0 +1,43 @@
+// 私聊的接收上限: 很多人同时给一个用户发私聊时, 每个发送方都没有超过限制, 接收方还是会被刷屏
+// 所以按接收方统计每分钟收到的私聊数, 超过 -pm-inbound-limit 后其他私聊直接拒绝
+package main
+
+import "time"
+
+// 统计私聊数的时间窗口
+const pmInboundWindow = time.Minute
+
+// 接收方在一个时间窗口里收到的私聊
+type pmWindow struct {
+	start    time.Time
+	count    int
+	rejected bool // 这个窗口里已经拒绝过, 接收方只收到一次提示
+}
+
+// 是否接收from发来的私聊, 超过上限时返回false
+// 管理员和互相在私聊白名单里的用户不受限制; 每个窗口里第一次拒绝时提示接收方一次, 而不是每条都提示
+func (this *User) acceptPrivate(from *User) bool {
+	limit := this.server.PMInboundLimit
+	if limit <= 0 || from.IsAdmin() || this.isFriend(from) {
+		return true
+	}
+
+	now := this.server.Clock.Now()
+	this.inboundLock.Lock()
+	w := &this.inbound
+	if now.Sub(w.start) >= pmInboundWindow {
+		*w = pmWindow{start: now}
+	}
+	w.count++
+	accepted := w.count <= limit
+	notify := !accepted && !w.rejected
+	if !accepted {
+		w.rejected = true
+	}
+	this.inboundLock.Unlock()
+
+	if notify {
+		this.SendMsg(systemPrefix + t(this, "pm.flood_notice", limit) + "\n")
+	}
+	return accepted
+}
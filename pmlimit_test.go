@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// 所有发送方加起来每分钟最多3条: 多出来的被拒绝, 接收方每个窗口只收到一次提示, 管理员不受限制
+func TestPMInboundLimit(t *testing.T) {
+	clock := NewFakeClock(testEpoch)
+	server := startTestServer(t, WithClock(clock), WithPMInboundLimit(3), WithAdminToken("secret"))
+	target := dialTest(t, server, "target")
+	var senders []*testConn
+	for i := 0; i < 5; i++ {
+		senders = append(senders, dialTest(t, server, fmt.Sprintf("s%d", i)))
+	}
+	admin := dialAdmin(t, server, "admin")
+
+	for i, s := range senders {
+		s.send(fmt.Sprintf("to|target|hi-%d", i))
+		if i < 3 {
+			target.waitFor(privateLine(s.name, fmt.Sprintf("hi-%d", i)))
+		} else {
+			s.waitFor(text("pm.recipient_busy"))
+		}
+	}
+	admin.send("to|target|admin-hi")
+	target.waitFor(privateLine("admin", "admin-hi"))
+	if n := target.count(text("pm.flood_notice", 3)); n != 1 {
+		t.Fatalf("接收方收到 %d 次提示", n)
+	}
+	if target.saw("hi-3") || target.saw("hi-4") {
+		t.Fatal("超过上限的私聊送到了")
+	}
+
+	// 下一分钟重新计数
+	clock.Advance(time.Minute)
+	senders[4].send("to|target|again")
+	target.waitFor(privateLine("s4", "again"))
+}
@@ -0,0 +1,36 @@
+// 私聊找不到对方时的错误: 区分"以前有人用过这个名字, 只是现在不在线"和"从来没见过这个名字"
+// 私聊的对象在全部在线用户(OnlineMap, 集群模式下还有其他实例)里找, 跟在哪里聊天无关; 以后加了房间也不要按房间查找
+// 不在线回复 ERR_USER_OFFLINE, 程序可以稍后重发; 没见过的回复 ERR_NO_SUCH_USER, 多半是打错了, 附上相近的在线用户名(见suggest.go)
+package main
+
+import "strings"
+
+// 以前有没有人用过这个名字: 有名字记录(见names.go)或者离线记录(见welcome.go)
+func (this *Server) nameKnown(name string) bool {
+	if _, ok := this.nameOwner(name); ok {
+		return true
+	}
+	store := this.PrefStore
+	if store == nil {
+		return false
+	}
+
+	this.seenLock.Lock()
+	defer this.seenLock.Unlock()
+
+	_, ok, err := store.LoadLastSeen(name)
+	return err == nil && ok
+}
+
+// 私聊的对象不在线时的错误
+func (this *User) privateTargetMissing(remoteName string) *replyError {
+	// 连接编号只在连接期间有效, 找不到就是不在了
+	if strings.HasPrefix(remoteName, "#") {
+		return this.newError(ErrNoSuchUser, "user.not_found")
+	}
+	if this.server.nameKnown(remoteName) {
+		this.server.countMissedPM(remoteName)
+		return this.newError(ErrUserOffline, "pm.offline", remoteName)
+	}
+	return this.userNotFoundError(remoteName)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// 私聊的对象: 在线的送到, 离线的回复 ERR_USER_OFFLINE, 没见过的回复 ERR_NO_SUCH_USER
+func TestPrivateTargets(t *testing.T) {
+	server := startTestServer(t)
+	alice := dialTest(t, server, "alice")
+	bob := dialTest(t, server, "bob")
+	leaveName(t, server, "carol")
+	alice.send("caps|errors")
+
+	alice.send("to|bob|hi bob")
+	bob.waitFor("hi bob")
+
+	alice.send("to|carol|hi carol")
+	alice.waitFor("err|" + strconv.Itoa(ErrUserOffline.Num) + "|" + text("pm.offline", "carol"))
+
+	// 打错的名字附上相近的在线用户名
+	alice.send("to|bpb|hi")
+	line := alice.waitFor("err|" + strconv.Itoa(ErrNoSuchUser.Num) + "|")
+	if !strings.Contains(line, "bob") {
+		t.Fatalf("没有提示相近的用户名: %s", line)
+	}
+
+	alice.send("to|#999|hi")
+	alice.waitFor("err|" + strconv.Itoa(ErrNoSuchUser.Num) + "|" + text("user.not_found"))
+}
+
+// hello里带着全部错误编号, 客户端不用自己维护一份
+func TestHelloErrorTable(t *testing.T) {
+	server := startTestServer(t)
+	c := dialTest(t, server, "")
+	hello := c.seen[0]
+	for _, code := range errCodes {
+		if !strings.Contains(hello, strconv.Itoa(code.Num)+":"+code.Name) {
+			t.Errorf("hello里没有 %d %s: %s", code.Num, code.Name, hello)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+// 合并上下线通知: 服务器重启后大量客户端同时重连时, 每个人都会收到几百条"已上线"
+// 一个窗口(-presence-window)里的前 -presence-threshold 条照常马上广播, 超过的先攒起来
+// 窗口结束时合并成一行, 如: [系统] 另有 23 位用户上线: a, b, c, d, e, ... (+18)
+package main
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 默认的合并窗口和阈值
+const (
+	defaultPresenceWindow    = 2 * time.Second
+	defaultPresenceThreshold = 10
+)
+
+// 合并后的一行里最多列出几个用户名
+const presenceNames = 5
+
+// 上线和下线分开计数
+const (
+	presenceOnline = iota
+	presenceOffline
+)
+
+// 当前窗口的状态
+type presenceState struct {
+	lock   sync.Mutex
+	open   bool        // 窗口是否已经开始
+	gen    int         // 第几个窗口, 窗口结束时只合并自己的那一个
+	passed [2]int      // 这个窗口里已经直接广播的条数
+	held   [2][]string // 超过阈值后攒起来的用户名
+}
+
+// 广播用户上线或下线, 同一个窗口里太多时合并
+func (this *Server) announcePresence(user *User, kind int) error {
+	msg := t(nil, "user.online")
+	if kind == presenceOffline {
+		msg = t(nil, "user.offline")
+	}
+	if this.PresenceWindow <= 0 {
+		return this.BroadCast(user, msg)
+	}
+
+	p := &this.presence
+	p.lock.Lock()
+	if !p.open {
+		p.open = true
+		p.gen++
+		go this.closePresenceWindow(p.gen)
+	}
+	if p.passed[kind] < this.PresenceThreshold {
+		p.passed[kind]++
+		p.lock.Unlock()
+		return this.BroadCast(user, msg)
+	}
+	p.held[kind] = append(p.held[kind], user.DisplayName())
+	p.lock.Unlock()
+	return nil
+}
+
+// 等窗口结束后合并
+func (this *Server) closePresenceWindow(gen int) {
+	<-this.Clock.After(this.PresenceWindow)
+	this.flushPresence(gen)
+}
+
+// 把攒起来的上下线合并广播, 开始下一个窗口; gen为0时不管是哪个窗口都马上合并, 例如服务器停止时
+func (this *Server) flushPresence(gen int) {
+	p := &this.presence
+	p.lock.Lock()
+	if gen != 0 && gen != p.gen {
+		p.lock.Unlock()
+		return
+	}
+	held := p.held
+	p.open = false
+	p.passed = [2]int{}
+	p.held = [2][]string{}
+	p.lock.Unlock()
+
+	ids := [2]string{"presence.online_many", "presence.offline_many"}
+	for kind, names := range held {
+		if len(names) == 0 {
+			continue
+		}
+		this.Announce(t(nil, ids[kind], len(names), presenceList(names)))
+	}
+}
+
+// 最多列出presenceNames个用户名, 其余的只显示个数
+func presenceList(names []string) string {
+	if len(names) <= presenceNames {
+		return strings.Join(names, ", ")
+	}
+	return strings.Join(names[:presenceNames], ", ") + ", ... (+" + strconv.Itoa(len(names)-presenceNames) + ")"
+}
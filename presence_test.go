@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPresenceList(t *testing.T) {
+	if got := presenceList([]string{"a", "b"}); got != "a, b" {
+		t.Fatalf("presenceList = %q", got)
+	}
+	names := []string{"a", "b", "c", "d", "e", "f", "g"}
+	if got := presenceList(names); got != "a, b, c, d, e, ... (+2)" {
+		t.Fatalf("presenceList = %q", got)
+	}
+}
+
+// 一个窗口里超过阈值的上线通知攒起来, 窗口结束时合并成一行; 上线和下线分开计数
+func TestPresenceCoalesce(t *testing.T) {
+	clock := NewFakeClock(testEpoch)
+	server := startTestServer(t, WithClock(clock), WithPresence(2*time.Second, 2))
+	watcher := dialTest(t, server, "")
+	var others []*testConn
+	for i := 0; i < 8; i++ {
+		others = append(others, dialTest(t, server, ""))
+		eventually(t, "没有上线", func() bool { return server.OnlineCount() == i+2 })
+	}
+	watcher.waitFor("guest-2:" + text("user.online"))
+
+	// 下线的还没到阈值, 马上广播
+	others[0].conn.Close()
+	watcher.waitFor("guest-2:" + text("user.offline"))
+
+	clock.Advance(2 * time.Second)
+	merged := watcher.waitFor(systemPrefix + text("presence.online_many", 7, ""))
+	if !strings.HasSuffix(merged, ", ... (+2)") {
+		t.Fatalf("合并的通知 = %q", merged)
+	}
+	for i := 3; i <= 9; i++ {
+		if watcher.saw(fmt.Sprintf("guest-%d:%s", i, text("user.online"))) {
+			t.Fatalf("guest-%d 的上线通知没有合并", i)
+		}
+	}
+
+	// 新的窗口重新计数
+	dialTest(t, server, "")
+	watcher.waitFor("guest-10:" + text("user.online"))
+}
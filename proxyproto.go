@@ -0,0 +1,206 @@
+// PROXY协议: server在HAProxy/nginx后面时, 从代理发来的头部里取得客户端的真实地址
+// 协议说明见 https://www.haproxy.org/download/2.9/doc/proxy-protocol.txt
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 等待代理发送头部的最长时间
+const proxyHeaderTimeout = 5 * time.Second
+
+// v1头部的最大长度, 包括结尾的\r\n
+const proxyV1MaxLen = 107
+
+// v2头部的固定开头
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// 接受PROXY协议头部的设置
+type ProxyProtocol struct {
+	// 可信的代理地址, 为空时所有连接都必须带头部
+	// 不在列表里的连接按普通连接处理, 它们发的头部不会被信任
+	Trusted []*net.IPNet
+}
+
+// 解析逗号分隔的CIDR列表, 例如 10.0.0.0/8,127.0.0.1/32
+func ParseTrustedProxies(list string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, s := range strings.Split(list, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// 连接是不是来自可信的代理
+func (this *ProxyProtocol) trusted(addr net.Addr) bool {
+	if len(this.Trusted) == 0 {
+		return true
+	}
+	tcp, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	for _, n := range this.Trusted {
+		if n.Contains(tcp.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// 读取可信代理发来的头部, 返回使用真实客户端地址的连接
+// 可信代理没有发来合法的头部时返回错误, 调用方应该关闭连接
+func (this *ProxyProtocol) Wrap(conn net.Conn) (net.Conn, error) {
+	if !this.trusted(conn.RemoteAddr()) {
+		return conn, nil
+	}
+
+	conn.SetReadDeadline(time.Now().Add(proxyHeaderTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	reader := bufio.NewReader(conn)
+	remote, err := readProxyHeader(reader)
+	if err != nil {
+		return nil, err
+	}
+	if remote == nil {
+		// LOCAL 或 UNKNOWN: 代理自己的连接, 例如健康检查
+		remote = conn.RemoteAddr()
+	}
+	return &proxiedConn{Conn: conn, reader: reader, remote: remote}, nil
+}
+
+// 读取v1或v2头部, 返回客户端地址, 头部里没有地址时返回nil
+// 先只看第一个字节: v1头部可能比v2的固定开头还短(PROXY UNKNOWN\r\n), 一次看12个字节会一直等到超时
+func readProxyHeader(reader *bufio.Reader) (net.Addr, error) {
+	first, err := reader.Peek(1)
+	if err != nil {
+		return nil, fmt.Errorf("proxy header: %w", err)
+	}
+	if first[0] != proxyV2Signature[0] {
+		return readProxyV1(reader)
+	}
+	sig, err := reader.Peek(len(proxyV2Signature))
+	if err != nil {
+		return nil, fmt.Errorf("proxy header: %w", err)
+	}
+	if !bytes.Equal(sig, proxyV2Signature) {
+		return nil, errors.New("proxy header: bad v2 signature")
+	}
+	return readProxyV2(reader)
+}
+
+// v1: PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\n
+func readProxyV1(reader *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("proxy header: %w", err)
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+		if len(line) >= proxyV1MaxLen {
+			return nil, errors.New("proxy header: v1 header too long")
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, errors.New("proxy header: missing CRLF")
+	}
+
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if fields[0] != "PROXY" || len(fields) < 2 {
+		return nil, errors.New("proxy header: missing PROXY prefix")
+	}
+	switch fields[1] {
+	case "UNKNOWN":
+		return nil, nil
+	case "TCP4", "TCP6":
+	default:
+		return nil, fmt.Errorf("proxy header: unknown protocol %q", fields[1])
+	}
+	if len(fields) != 6 {
+		return nil, errors.New("proxy header: wrong number of fields")
+	}
+
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if ip == nil || err != nil || (fields[1] == "TCP4") != (ip.To4() != nil) {
+		return nil, errors.New("proxy header: bad source address")
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// v2: 固定开头 + 版本和命令 + 地址族 + 长度 + 地址, 后面可能跟着TLV, 这里跳过
+func readProxyV2(reader *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, fmt.Errorf("proxy header: %w", err)
+	}
+	verCmd, family := header[12], header[13]
+	body := make([]byte, binary.BigEndian.Uint16(header[14:]))
+	if _, err := io.ReadFull(reader, body); err != nil {
+		return nil, fmt.Errorf("proxy header: %w", err)
+	}
+
+	if verCmd>>4 != 2 {
+		return nil, errors.New("proxy header: unsupported version")
+	}
+	switch verCmd & 0xf {
+	case 0: // LOCAL
+		return nil, nil
+	case 1: // PROXY
+	default:
+		return nil, errors.New("proxy header: unknown command")
+	}
+
+	switch family {
+	case 0x11: // TCP over IPv4
+		if len(body) < 12 {
+			return nil, errors.New("proxy header: short address")
+		}
+		return &net.TCPAddr{IP: net.IP(body[0:4]), Port: int(binary.BigEndian.Uint16(body[8:]))}, nil
+	case 0x21: // TCP over IPv6
+		if len(body) < 36 {
+			return nil, errors.New("proxy header: short address")
+		}
+		return &net.TCPAddr{IP: net.IP(body[0:16]), Port: int(binary.BigEndian.Uint16(body[32:]))}, nil
+	case 0x00: // UNSPEC
+		return nil, nil
+	}
+	return nil, fmt.Errorf("proxy header: unsupported address family %#x", family)
+}
+
+// 读头部时多读的数据在reader里, 之后的读取都要经过reader
+type proxiedConn struct {
+	net.Conn
+	reader *bufio.Reader
+	remote net.Addr
+}
+
+func (this *proxiedConn) Read(b []byte) (int, error) {
+	return this.reader.Read(b)
+}
+
+func (this *proxiedConn) RemoteAddr() net.Addr {
+	return this.remote
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 拼一个v2头部, body是地址部分
+func proxyV2(verCmd, family byte, body []byte) string {
+	header := append([]byte{}, proxyV2Signature...)
+	header = append(header, verCmd, family, 0, 0)
+	binary.BigEndian.PutUint16(header[14:], uint16(len(body)))
+	return string(append(header, body...))
+}
+
+func TestReadProxyHeader(t *testing.T) {
+	v4 := []byte{192, 0, 2, 1, 10, 0, 0, 1, 0xdc, 0x04, 0x01, 0xbb}
+	v6 := make([]byte, 36)
+	copy(v6, net.ParseIP("2001:db8::1"))
+	binary.BigEndian.PutUint16(v6[32:], 56324)
+
+	tests := []struct {
+		name   string
+		input  string
+		remote string // 空串表示头部里没有地址
+		err    bool
+	}{
+		{"v1 tcp4", "PROXY TCP4 192.0.2.1 10.0.0.1 56324 443\r\nhello", "192.0.2.1:56324", false},
+		{"v1 tcp6", "PROXY TCP6 2001:db8::1 ::1 56324 443\r\n", "[2001:db8::1]:56324", false},
+		{"v1 unknown", "PROXY UNKNOWN\r\n", "", false},
+		{"v1 truncated", "PROXY TCP4 192.0.2.1", "", true},
+		{"v1 no crlf", "PROXY TCP4 192.0.2.1 10.0.0.1 56324 443\n", "", true},
+		{"v1 too long", "PROXY TCP4 " + strings.Repeat("1", proxyV1MaxLen) + "\r\n", "", true},
+		{"v1 not a header", "rename|alice\r\n", "", true},
+		{"v1 spoofed family", "PROXY TCP4 2001:db8::1 ::1 56324 443\r\n", "", true},
+		{"v1 bad port", "PROXY TCP4 192.0.2.1 10.0.0.1 99999 443\r\n", "", true},
+		{"v2 tcp4", proxyV2(0x21, 0x11, v4) + "hello", "192.0.2.1:56324", false},
+		{"v2 tcp6", proxyV2(0x21, 0x21, v6), "[2001:db8::1]:56324", false},
+		{"v2 local", proxyV2(0x20, 0x00, nil), "", false},
+		{"v2 truncated signature", string(proxyV2Signature[:5]), "", true},
+		{"v2 truncated body", proxyV2(0x21, 0x11, v4)[:20], "", true},
+		{"v2 short address", proxyV2(0x21, 0x11, v4[:8]), "", true},
+		{"v2 spoofed signature", "\r\n\r\n\x00\r\nQUIX\n" + proxyV2(0x21, 0x11, v4)[12:], "", true},
+		{"v2 bad version", proxyV2(0x11, 0x11, v4), "", true},
+		{"v2 bad command", proxyV2(0x2f, 0x11, v4), "", true},
+	}
+	for _, test := range tests {
+		reader := bufio.NewReader(strings.NewReader(test.input))
+		remote, err := readProxyHeader(reader)
+		if (err != nil) != test.err {
+			t.Errorf("%s: err = %v", test.name, err)
+			continue
+		}
+		if test.err {
+			continue
+		}
+		got := ""
+		if remote != nil {
+			got = remote.String()
+		}
+		if got != test.remote {
+			t.Errorf("%s: remote = %q, want %q", test.name, got, test.remote)
+		}
+		// 头部后面的数据留在reader里
+		if rest, _ := io.ReadAll(reader); strings.HasSuffix(test.input, "hello") && string(rest) != "hello" {
+			t.Errorf("%s: rest = %q", test.name, rest)
+		}
+	}
+}
+
+// 代理的一端可以直接读写, 地址可以指定
+type addrConn struct {
+	net.Conn
+	remote net.Addr
+}
+
+func (this *addrConn) RemoteAddr() net.Addr {
+	return this.remote
+}
+
+// 比v2固定开头还短的v1头部马上就能读完, 不用等更多的数据
+func TestProxyShortV1DoesNotStall(t *testing.T) {
+	serverSide, clientSide := net.Pipe()
+	defer clientSide.Close()
+	go clientSide.Write([]byte("PROXY UNKNOWN\r\n"))
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := (&ProxyProtocol{}).Wrap(serverSide)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(proxyHeaderTimeout / 2):
+		t.Fatal("读短的v1头部时卡住了")
+	}
+}
+
+// 不可信的地址发来的头部不解析, 原样留给后面当普通数据读
+func TestProxyUntrustedPeer(t *testing.T) {
+	_, trusted, _ := net.ParseCIDR("10.0.0.0/8")
+	proxy := &ProxyProtocol{Trusted: []*net.IPNet{trusted}}
+	serverSide, clientSide := net.Pipe()
+	defer clientSide.Close()
+	peer := &net.TCPAddr{IP: net.ParseIP("192.0.2.9"), Port: 4000}
+	header := "PROXY TCP4 10.0.0.1 10.0.0.2 56324 443\r\n"
+	go clientSide.Write([]byte(header))
+
+	conn, err := proxy.Wrap(&addrConn{Conn: serverSide, remote: peer})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conn.RemoteAddr().String() != peer.String() {
+		t.Fatalf("remote = %v", conn.RemoteAddr())
+	}
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil || line != header {
+		t.Fatalf("line = %q, err = %v", line, err)
+	}
+
+	// 可信的代理不发头部时拒绝
+	serverSide, clientSide = net.Pipe()
+	defer clientSide.Close()
+	go clientSide.Write([]byte("rename|alice\n"))
+	if _, err := proxy.Wrap(&addrConn{Conn: serverSide, remote: &net.TCPAddr{IP: net.ParseIP("10.1.2.3")}}); err == nil {
+		t.Fatal("可信的代理没有头部也接受了")
+	}
+}
@@ -0,0 +1,107 @@
+// 每个连接的入站流量限制: 令牌桶, 每秒补充 -read-rate 字节, 最多攒 -read-burst 字节
+// 超出时先放慢读取, 连续超出超过readGrace就断开连接, 防止客户端不停地发数据占住服务器
+package main
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// 默认每秒8KB, 最多一次64KB
+const (
+	defaultReadRate  = 8 * 1024
+	defaultReadBurst = 64 * 1024
+)
+
+// 连续超出限制多久后断开
+const readGrace = 5 * time.Second
+
+var errReadFlood = errors.New("inbound bandwidth limit exceeded")
+
+// 按令牌桶限制读取速度的Reader
+type limitedReader struct {
+	r     io.Reader
+	clock Clock
+	rate  float64 // 每秒补充的字节数
+	burst float64 // 桶的容量
+
+	lock      sync.Mutex
+	tokens    float64
+	last      time.Time // 上一次补充的时间
+	overSince time.Time // 开始超出限制的时间, 没有超出时为零
+}
+
+// 每秒最多读rate字节, 最多攒burst字节; 开始时桶是满的
+func newLimitedReader(r io.Reader, clock Clock, rate, burst int) *limitedReader {
+	return &limitedReader{
+		r:      r,
+		clock:  clock,
+		rate:   float64(rate),
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   clock.Now(),
+	}
+}
+
+// 按经过的时间补充令牌
+func (this *limitedReader) refill(now time.Time) {
+	this.tokens += now.Sub(this.last).Seconds() * this.rate
+	if this.tokens > this.burst {
+		this.tokens = this.burst
+	}
+	this.last = now
+}
+
+// 等到有令牌为止, 返回这次最多能读多少字节
+// 不用等就能读时说明没有超出; 一直要等的时间超过readGrace时返回errReadFlood
+func (this *limitedReader) wait(want int) (int, error) {
+	for waited := false; ; waited = true {
+		this.lock.Lock()
+		now := this.clock.Now()
+		this.refill(now)
+		if this.tokens >= 1 {
+			if !waited {
+				this.overSince = time.Time{}
+			}
+			n := want
+			if float64(n) > this.tokens {
+				n = int(this.tokens)
+			}
+			this.lock.Unlock()
+			return n, nil
+		}
+		if this.overSince.IsZero() {
+			this.overSince = now
+		}
+		if now.Sub(this.overSince) > readGrace {
+			this.lock.Unlock()
+			return 0, errReadFlood
+		}
+		// 等到攒够一次读的量, 不一个字节一个字节地读
+		need := this.burst / 8
+		if need > float64(want) {
+			need = float64(want)
+		}
+		delay := time.Duration((need - this.tokens) / this.rate * float64(time.Second))
+		this.lock.Unlock()
+		<-this.clock.After(delay)
+	}
+}
+
+func (this *limitedReader) Read(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+	n, err := this.wait(len(b))
+	if err != nil {
+		return 0, err
+	}
+	n, err = this.r.Read(b[:n])
+
+	this.lock.Lock()
+	this.tokens -= float64(n)
+	this.lock.Unlock()
+	return n, err
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// 要多少给多少的Reader
+type endlessReader struct{}
+
+func (endlessReader) Read(b []byte) (int, error) {
+	for i := range b {
+		b[i] = 'x'
+	}
+	return len(b), nil
+}
+
+type readResult struct {
+	n   int
+	err error
+}
+
+// 在另一个goroutine里读, 读完把结果发回来
+func readAsync(r *limitedReader, size int) <-chan readResult {
+	c := make(chan readResult, 1)
+	go func() {
+		n, err := r.Read(make([]byte, size))
+		c <- readResult{n, err}
+	}()
+	return c
+}
+
+// 开始时可以一次读完整个桶, 之后按速度等待, 一直超出超过readGrace就返回errReadFlood
+func TestLimitedReaderFlood(t *testing.T) {
+	clock := NewFakeClock(testEpoch)
+	r := newLimitedReader(endlessReader{}, clock, 100, 1000)
+	if n, err := r.Read(make([]byte, 2000)); n != 1000 || err != nil {
+		t.Fatalf("第一次读 = %d, %v", n, err)
+	}
+
+	// 每次等到攒够桶的1/8, 也就是125字节, 要1.25秒
+	for elapsed := time.Duration(0); ; {
+		c := readAsync(r, 2000)
+		select {
+		case res := <-c:
+			if res.err != errReadFlood {
+				t.Fatalf("没有等待就读到了 %d, %v", res.n, res.err)
+			}
+			if elapsed <= readGrace {
+				t.Fatalf("只超出了 %s 就断开了", elapsed)
+			}
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+		eventually(t, "读取没有开始等待", func() bool { return clock.Waiters() == 1 })
+		clock.Advance(1250 * time.Millisecond)
+		elapsed += 1250 * time.Millisecond
+		if res := <-c; res.n != 125 || res.err != nil {
+			t.Fatalf("等待以后读到 %d, %v", res.n, res.err)
+		}
+	}
+}
+
+// 速度降下来以后不算超出, 重新计算宽限时间
+func TestLimitedReaderRecovers(t *testing.T) {
+	clock := NewFakeClock(testEpoch)
+	r := newLimitedReader(endlessReader{}, clock, 100, 1000)
+	r.Read(make([]byte, 1000))
+	for i := 0; i < 20; i++ {
+		clock.Advance(time.Second)
+		if n, err := r.Read(make([]byte, 100)); n != 100 || err != nil {
+			t.Fatalf("第%d秒读到 %d, %v", i, n, err)
+		}
+	}
+}
+
+// 一直超出限制的连接收到提示后被断开
+func TestReadFloodDisconnects(t *testing.T) {
+	clock := NewFakeClock(testEpoch)
+	server := startTestServer(t, WithClock(clock), WithReadRate(100, 1000))
+	alice := dialTest(t, server, "alice")
+	go alice.conn.Write([]byte(strings.Repeat("x", 100) + "\n" + strings.Repeat("y", 20000)))
+
+	done := make(chan struct{})
+	go func() {
+		alice.waitFor(text("user.flood"))
+		close(done)
+	}()
+	for {
+		select {
+		case <-done:
+			alice.waitClosed()
+			if server.Stats().FloodKicks != 1 {
+				t.Fatal("没有记进 flood_kicks")
+			}
+			return
+		case <-time.After(time.Millisecond):
+			clock.Advance(time.Second)
+		}
+	}
+}
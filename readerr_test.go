@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestReadErrKind(t *testing.T) {
+	timeout := &net.OpError{Op: "read", Err: os.ErrDeadlineExceeded}
+	for _, c := range []struct {
+		err  error
+		want string
+	}{
+		{io.EOF, readEOF},
+		{fmt.Errorf("read: %w", io.EOF), readEOF},
+		{errReadFlood, readFlood},
+		{errFrameTooLarge, readOversize},
+		{&net.OpError{Op: "read", Err: net.ErrClosed}, readClosed},
+		{&net.OpError{Op: "read", Err: os.NewSyscallError("read", syscall.ECONNRESET)}, readReset},
+		{timeout, readTimeout},
+		{errors.New("boom"), readFailed},
+	} {
+		if got := readErrKind(c.err); got != c.want {
+			t.Errorf("readErrKind(%v) = %s, want %s", c.err, got, c.want)
+		}
+	}
+}
+
+// 关闭连接前最后一行没有换行也照常处理, 之后下线
+func TestLastLineWithoutNewline(t *testing.T) {
+	server := startTestServer(t)
+	alice := dialTest(t, server, "alice")
+	bob := dialTest(t, server, "bob")
+
+	alice.conn.Write([]byte("last words"))
+	alice.conn.(*net.TCPConn).CloseWrite()
+	bob.waitFor("alice:last words")
+	eventually(t, "alice 没有下线", func() bool {
+		_, ok := server.lookupUser("alice")
+		return !ok
+	})
+}
+
+// 半行之后连接被重置: 这半行不处理
+func TestPartialLineOnReset(t *testing.T) {
+	server := startTestServer(t)
+	alice := dialTest(t, server, "alice")
+	bob := dialTest(t, server, "bob")
+
+	alice.conn.Write([]byte("half a li"))
+	time.Sleep(10 * time.Millisecond)
+	alice.conn.(*net.TCPConn).SetLinger(0)
+	alice.conn.Close()
+	eventually(t, "alice 没有下线", func() bool {
+		_, ok := server.lookupUser("alice")
+		return !ok
+	})
+	bob.send("sync-bob")
+	bob.waitFor("sync-bob")
+	if bob.saw("half a li") {
+		t.Fatal("重置前的半行被当作消息发出去了")
+	}
+}
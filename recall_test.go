@@ -0,0 +1,119 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// 后上线的用户补发到的历史消息, 发一条同步消息等到回复, 补发的消息都已经读到了
+func joinAndSync(t *testing.T, server *Server, name string) *testConn {
+	t.Helper()
+	c := dialTest(t, server, name)
+	c.send("sync-" + name)
+	c.waitFor("sync-" + name)
+	return c
+}
+
+func TestRecallWindow(t *testing.T) {
+	clock := NewFakeClock(testEpoch)
+	server := startTestServer(t, WithClock(clock))
+	alice := dialTest(t, server, "alice")
+
+	alice.send("too old")
+	alice.waitFor("too old")
+	clock.Advance(recallWindow + time.Second)
+	alice.send("recall")
+	alice.waitFor(text("recall.too_late", int(recallWindow.Minutes())))
+
+	alice.send("take back")
+	alice.waitFor("take back")
+	clock.Advance(recallWindow - time.Second)
+	alice.send("recall")
+	alice.waitFor(text("recall.notice", "alice"))
+
+	// 同一条不能撤回两次
+	alice.send("recall")
+	alice.waitFor(text("recall.none"))
+
+	// 撤回的消息从历史里删掉了, 后上线的人看不到
+	bob := joinAndSync(t, server, "bob")
+	if !bob.saw("too old") {
+		t.Fatal("没有撤回的消息没有补发")
+	}
+	if bob.saw("take back") {
+		t.Fatal("撤回的消息还在历史里")
+	}
+}
+
+func TestRecallAdmin(t *testing.T) {
+	clock := NewFakeClock(testEpoch)
+	server := startTestServer(t, WithClock(clock), WithAdminToken("secret"))
+	alice := dialTest(t, server, "alice")
+	bob := dialTest(t, server, "bob")
+
+	alice.send("spam")
+	alice.waitFor("spam")
+	clock.Advance(2 * recallWindow)
+
+	// 普通用户不能按序号撤回, 也不能撤回别人的消息
+	bob.send("recall|1")
+	bob.waitFor(text("recall.admin_only"))
+
+	// 管理员不受时间窗口限制
+	admin := dialAdmin(t, server, "admin")
+	admin.send("recall|list")
+	line := admin.waitFor("spam")
+	seq := strings.TrimPrefix(strings.Fields(line)[0], "#")
+	admin.send("recall|" + seq)
+	bob.waitFor(text("recall.notice", "alice"))
+
+	admin.send("recall|" + seq)
+	admin.waitFor(text("recall.none"))
+	admin.send("recall|abc")
+	admin.waitFor(text("recall.usage"))
+
+	if carol := joinAndSync(t, server, "carol"); carol.saw("spam") {
+		t.Fatal("管理员撤回的消息还在历史里")
+	}
+}
+
+// 自己撤回不用去历史记录里找, 关掉历史也能撤回
+func TestRecallWithoutHistory(t *testing.T) {
+	server := startTestServer(t, WithHistorySize(0))
+	alice := dialTest(t, server, "alice")
+
+	alice.send("oops")
+	alice.waitFor("oops")
+	alice.send("recall")
+	alice.waitFor(text("recall.notice", "alice"))
+	alice.send("recall")
+	alice.waitFor(text("recall.none"))
+}
+
+// 声明了 recall 能力的客户端另外收到 recall|序号
+func TestRecallFrame(t *testing.T) {
+	server := startTestServer(t, WithAdminToken("secret"))
+	alice := dialTest(t, server, "alice")
+	bob := dialTest(t, server, "bob")
+	bob.send("caps|recall")
+
+	alice.send("first")
+	bob.waitFor("first")
+	// 提示走广播队列, recall|序号 直接发, 两个谁先到都可以
+	alice.send("recall")
+	alice.waitFor(text("recall.notice", "alice"))
+	bob.waitFor("recall|1")
+	if alice.saw("recall|1") {
+		t.Fatal("没有声明能力的客户端也收到了 recall|序号")
+	}
+
+	// 管理员撤回以后作者不能再撤回同一条
+	alice.send("second")
+	bob.waitFor("second")
+	admin := dialAdmin(t, server, "admin")
+	admin.send("recall|2")
+	bob.waitFor("recall|2")
+	alice.send("recall")
+	alice.waitFor(text("recall.none"))
+}
@@ -0,0 +1,82 @@
+// 私聊消息的已读回执
+package main
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// 最多记录多少条等待回执的私聊消息, 超出后丢弃最早的
+const maxPendingReceipts = 4096
+
+// 一条等待回执的私聊消息
+type receipt struct {
+	from *User  // 私聊的发送方, 回执要发回给他
+	toID uint64 // 接收方的连接编号, 只有这个连接能发回执, 改名了也一样
+	to   string // 发送时接收方的用户名, 回执里展示给发送方
+}
+
+// 等待回执的私聊消息表, key是消息序号
+type receiptTable struct {
+	lock    sync.Mutex
+	pending map[uint64]receipt
+	order   []uint64 // 按登记顺序记录序号, 用来淘汰最早的记录
+}
+
+func newReceiptTable() *receiptTable {
+	return &receiptTable{
+		pending: make(map[uint64]receipt),
+	}
+}
+
+// 登记一条私聊消息
+func (this *receiptTable) Add(seq uint64, from *User, to *User) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if len(this.order) >= maxPendingReceipts {
+		delete(this.pending, this.order[0])
+		this.order = this.order[1:]
+	}
+	this.pending[seq] = receipt{from: from, toID: to.ID, to: to.Name()}
+	this.order = append(this.order, seq)
+}
+
+// 连接编号是toID的接收方读到了一条私聊消息, 取出并删除它的记录
+// 按连接编号而不是用户名对比: 接收方改名后还能发回执, 别人改成原来的名字也发不了
+// 同一序号只能取出一次, 重复的回执直接忽略; 不是接收方发来的回执不删除记录, 否则别人可以吞掉回执
+func (this *receiptTable) Take(seq uint64, toID uint64) (receipt, bool) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	r, ok := this.pending[seq]
+	if !ok || r.toID != toID {
+		return receipt{}, false
+	}
+	delete(this.pending, seq)
+	return r, true
+}
+
+// 分配下一个消息序号
+func (this *Server) nextSeq() uint64 {
+	return atomic.AddUint64(&this.seq, 1)
+}
+
+// 处理客户端发来的已读回执, 消息格式: read|序号
+func (this *User) DoRead(arg string) {
+	seq, err := strconv.ParseUint(arg, 10, 64)
+	if err != nil {
+		return
+	}
+
+	// 只有这条消息的接收方才能发回执
+	r, ok := this.server.receipts.Take(seq, this.ID)
+	if !ok {
+		return
+	}
+
+	if r.from.HasCap(capReceipts) {
+		r.from.SendMsg("[已读] " + r.to + "\n")
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// 声明能力, 等whoami回复确认已经生效
+func declareCaps(t *testing.T, c *testConn, caps string) {
+	t.Helper()
+	c.send("caps|"+caps, "whoami")
+	c.waitFor("caps=" + caps)
+}
+
+// 收到一条带序号的私聊, 返回序号
+func waitPM(t *testing.T, c *testConn, from, content string) string {
+	t.Helper()
+	line := c.waitFor("|" + from + "|" + content)
+	parts := strings.Split(line, "|")
+	if parts[0] != "pm" || len(parts) != 4 {
+		t.Fatalf("声明了回执能力的接收方收到: %q", line)
+	}
+	return parts[1]
+}
+
+// 读过的行里有几行包含want
+func (this *lineWatcher) count(want string) int {
+	n := 0
+	for _, line := range this.seen {
+		if strings.Contains(line, want) {
+			n++
+		}
+	}
+	return n
+}
+
+// 已读回执发回给发送方, 同一条消息的回执只转发一次, 别人发的回执不算
+func TestReceiptRelay(t *testing.T) {
+	server := startTestServer(t)
+	alice := dialTest(t, server, "alice")
+	bob := dialTest(t, server, "bob")
+	carol := dialTest(t, server, "carol")
+	declareCaps(t, alice, capReceipts)
+	declareCaps(t, bob, capReceipts)
+
+	alice.send("to|bob|hi")
+	seq := waitPM(t, bob, "alice", "hi")
+
+	carol.send("read|"+seq, "sync-carol")
+	carol.waitFor("sync-carol")
+	bob.send("read|"+seq, "read|"+seq, "sync-bob")
+	alice.waitFor("[已读] bob")
+	alice.waitFor("sync-bob")
+	if n := alice.count("[已读]"); n != 1 {
+		t.Fatalf("收到 %d 条回执", n)
+	}
+}
+
+// 老客户端收到普通的私聊, 发给老客户端的私聊没有回执
+func TestReceiptLegacyPeer(t *testing.T) {
+	server := startTestServer(t)
+	alice := dialTest(t, server, "alice")
+	bob := dialTest(t, server, "bob")
+	declareCaps(t, bob, capReceipts)
+
+	bob.send("to|alice|to legacy")
+	alice.waitFor(privateLine("bob", "to legacy"))
+
+	// 接收方照常发回执, 但发送方没有声明能力, 不转发
+	alice.send("to|bob|from legacy")
+	seq := waitPM(t, bob, "alice", "from legacy")
+	bob.send("read|"+seq, "sync-read")
+	alice.waitFor("sync-read")
+	if alice.saw("[已读]") || bob.saw("[已读]") {
+		t.Fatal("没有声明回执能力的一方也有回执")
+	}
+}
+
+// 接收方改名以后还能发回执, 别人改成原来的名字发的回执不算
+func TestReceiptAfterRename(t *testing.T) {
+	server := startTestServer(t, WithRenameLimit(0, 0))
+	alice := dialTest(t, server, "alice")
+	bob := dialTest(t, server, "bob")
+	declareCaps(t, alice, capReceipts)
+	declareCaps(t, bob, capReceipts)
+
+	alice.send("to|bob|first", "to|bob|second")
+	first := waitPM(t, bob, "alice", "first")
+	second := waitPM(t, bob, "alice", "second")
+
+	bob.send("rename|robert")
+	bob.waitFor(text("rename.done", "robert"))
+	mallory := dialTest(t, server, "bob")
+	mallory.send("read|"+first, "sync-mallory")
+	mallory.waitFor("sync-mallory")
+
+	bob.send("read|"+first, "read|"+second, "sync-bob")
+	alice.waitFor("sync-bob")
+	if n := alice.count("[已读] bob"); n != 2 {
+		t.Fatalf("改名以后收到 %d 条回执", n)
+	}
+}
@@ -0,0 +1,261 @@
+// 一个只实现了集群模式需要的几个命令的Redis客户端, 不依赖第三方库
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 连接Redis的超时时间
+const redisDialTimeout = 3 * time.Second
+
+// Redis返回的错误, 例如 -ERR unknown command
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+// 一条Redis连接
+type redisConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func dialRedis(addr string) (*redisConn, error) {
+	conn, err := net.DialTimeout("tcp", addr, redisDialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return &redisConn{conn: conn, r: bufio.NewReader(conn)}, nil
+}
+
+// 按RESP协议发送一条命令
+func (this *redisConn) write(args ...string) error {
+	buf := []byte("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		buf = append(buf, "$"+strconv.Itoa(len(arg))+"\r\n"...)
+		buf = append(buf, arg...)
+		buf = append(buf, "\r\n"...)
+	}
+	_, err := this.conn.Write(buf)
+	return err
+}
+
+// 读取一个RESP回复, 返回值是 string、int64、nil、[]interface{} 之一, Redis返回的错误作为redisError返回
+func (this *redisConn) read() (interface{}, error) {
+	line, err := this.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, errors.New("redis: malformed reply")
+	}
+	kind, body := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return body, nil
+	case '-':
+		return nil, redisError(body)
+	case ':':
+		return strconv.ParseInt(body, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(this.r, data); err != nil {
+			return nil, err
+		}
+		return string(data[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			items[i], err = this.read()
+			if err != nil {
+				// 数组中的错误元素不影响读取后面的内容
+				if _, ok := err.(redisError); !ok {
+					return nil, err
+				}
+				items[i] = err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redis: unknown reply type %q", kind)
+}
+
+// 通过Redis实现的Broker
+type RedisBroker struct {
+	addr string
+
+	lock sync.Mutex // 命令连接同一时间只能执行一条命令
+	cmd  *redisConn
+}
+
+func NewRedisBroker(addr string) *RedisBroker {
+	return &RedisBroker{addr: addr}
+}
+
+// 执行一条命令, 连接断开时下次调用会重新连接
+func (this *RedisBroker) do(args ...string) (interface{}, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if this.cmd == nil {
+		c, err := dialRedis(this.addr)
+		if err != nil {
+			return nil, err
+		}
+		this.cmd = c
+	}
+
+	err := this.cmd.write(args...)
+	var reply interface{}
+	if err == nil {
+		reply, err = this.cmd.read()
+	}
+	if err != nil {
+		if _, ok := err.(redisError); !ok {
+			this.cmd.conn.Close()
+			this.cmd = nil
+		}
+		return nil, err
+	}
+	return reply, nil
+}
+
+// 把Redis回复的字符串数组转换成[]string
+func redisStrings(reply interface{}) []string {
+	items, _ := reply.([]interface{})
+	list := make([]string, 0, len(items))
+	for _, item := range items {
+		if s, ok := item.(string); ok {
+			list = append(list, s)
+		}
+	}
+	return list
+}
+
+func (this *RedisBroker) Publish(channel string, payload string) error {
+	_, err := this.do("PUBLISH", channel, payload)
+	return err
+}
+
+// 订阅频道, 一直阻塞到连接出错或者stop关闭为止; stop关闭时关掉订阅的连接, 让读返回
+func (this *RedisBroker) Subscribe(channels []string, handler func(channel string, payload string), stop <-chan struct{}) error {
+	c, err := dialRedis(this.addr)
+	if err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-stop:
+		case <-done:
+		}
+		c.conn.Close()
+	}()
+
+	if err := c.write(append([]string{"SUBSCRIBE"}, channels...)...); err != nil {
+		return err
+	}
+
+	for {
+		reply, err := c.read()
+		if err != nil {
+			return err
+		}
+		msg := redisStrings(reply)
+		if len(msg) == 3 && msg[0] == "message" {
+			handler(msg[1], msg[2])
+		}
+	}
+}
+
+// 用新的在线用户列表替换一个实例的在线用户, 并设置过期时间
+func (this *RedisBroker) SetPresence(instance string, users map[string]string, ttl time.Duration) error {
+	key := presenceKey(instance)
+	if _, err := this.do("SADD", redisInstancesKey, instance); err != nil {
+		return err
+	}
+	if len(users) == 0 {
+		_, err := this.do("DEL", key)
+		return err
+	}
+
+	// 先写到临时key再改名, 其他实例不会看到写了一半的列表
+	tmp := key + ":tmp"
+	args := []string{"HSET", tmp}
+	for name, addr := range users {
+		args = append(args, name, addr)
+	}
+	if _, err := this.do("DEL", tmp); err != nil {
+		return err
+	}
+	if _, err := this.do(args...); err != nil {
+		return err
+	}
+	if _, err := this.do("PEXPIRE", tmp, strconv.FormatInt(ttl.Milliseconds(), 10)); err != nil {
+		return err
+	}
+	_, err := this.do("RENAME", tmp, key)
+	return err
+}
+
+// 全部实例的在线用户, key是用户名
+func (this *RedisBroker) Presence() (map[string]Presence, error) {
+	reply, err := this.do("SMEMBERS", redisInstancesKey)
+	if err != nil {
+		return nil, err
+	}
+
+	all := make(map[string]Presence)
+	for _, instance := range redisStrings(reply) {
+		reply, err := this.do("HGETALL", presenceKey(instance))
+		if err != nil {
+			return nil, err
+		}
+		fields := redisStrings(reply)
+		if len(fields) == 0 {
+			// 在线用户列表已经过期, 这个实例可能已经退出
+			this.do("SREM", redisInstancesKey, instance)
+			continue
+		}
+		for i := 0; i+1 < len(fields); i += 2 {
+			all[fields[i]] = Presence{Instance: instance, Addr: fields[i+1]}
+		}
+	}
+	return all, nil
+}
+
+// 集群模式在Redis中使用的key和频道
+const (
+	redisInstancesKey = "im:instances"
+	redisBroadcast    = "im:broadcast"
+)
+
+func presenceKey(instance string) string {
+	return "im:presence:" + instance
+}
+
+func instanceChannel(instance string) string {
+	return "im:user:" + instance
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Achiyun/Golang-IM-System/internal/leakcheck"
+)
+
+// 每个连接最多改名的次数, 改名失败的不算; 管理员不受限制
+func TestRenameLimit(t *testing.T) {
+	clock := NewFakeClock(testEpoch)
+	server := startTestServer(t, WithClock(clock), WithRenameLimit(time.Second, 2), WithAdminToken("secret"))
+	dialTest(t, server, "bob")
+	alice := dialTest(t, server, "alice")
+
+	clock.Advance(time.Second)
+	alice.send("rename|bob")
+	alice.waitFor(text("rename.taken"))
+	alice.send("rename|alice2")
+	alice.waitFor(text("rename.done", "alice2"))
+	clock.Advance(time.Second)
+	alice.send("rename|alice3")
+	alice.waitFor(text("rename.limit", 2))
+
+	admin := dialAdmin(t, server, "admin")
+	for _, name := range []string{"admin2", "admin3", "admin4"} {
+		admin.send("rename|" + name)
+		admin.waitFor(text("rename.done", name))
+	}
+}
+
+// 用 -race 跑: 几个用户同时改名、发公聊和私聊、查who和whois、成为管理员, 不能有数据竞争
+// 结束后OnlineMap的键和用户名一一对应
+func TestConcurrentRenameStress(t *testing.T) {
+	leakcheck.Check(t)
+	server := startTestServer(t, WithRenameLimit(0, 0), WithAdminToken("secret"), WithPMInboundLimit(0))
+	const users, rounds = 8, 30
+	var conns []*testConn
+	for i := 0; i < users; i++ {
+		conns = append(conns, dialTest(t, server, fmt.Sprintf("u%d", i)))
+	}
+
+	var wg sync.WaitGroup
+	for i, c := range conns {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var lines []string
+			for r := 0; r < rounds; r++ {
+				other := (i + 1) % users
+				lines = append(lines,
+					fmt.Sprintf("rename|u%d-%d", i, r%2),
+					fmt.Sprintf("hi %d", r),
+					fmt.Sprintf("to|u%d-%d|pm %d", other, r%2, r),
+					"who|json",
+					fmt.Sprintf("whois|u%d-%d", other, (r+1)%2),
+				)
+				if r == rounds/2 {
+					lines = append(lines, "admin|secret")
+				}
+			}
+			lines = append(lines, fmt.Sprintf("rename|done-%d", i))
+			c.conn.Write([]byte(strings.Join(lines, "\n") + "\n"))
+		}()
+	}
+	wg.Wait()
+
+	for i, c := range conns {
+		c.waitFor(text("rename.done", fmt.Sprintf("done-%d", i)))
+	}
+	server.mapLock.RLock()
+	defer server.mapLock.RUnlock()
+	if len(server.OnlineMap) != users {
+		t.Fatalf("在线 %d 人", len(server.OnlineMap))
+	}
+	for name, user := range server.OnlineMap {
+		if user.Name() != name || !strings.HasPrefix(name, "done-") || !user.IsAdmin() {
+			t.Fatalf("OnlineMap[%q] = %s admin=%v", name, user.Name(), user.IsAdmin())
+		}
+	}
+}
@@ -0,0 +1,49 @@
+// 重复消息: 同一个用户在 -repeat-window 里连续发同样的公聊消息时不转发, 只提醒他自己
+// 连续第三次发同样的消息时自动禁言一小会儿, 禁言期间的公聊消息都不转发; 管理员不受限制
+// 比较的是去掉首尾空白之后的内容
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// 默认多久以内的相同消息算重复, 0表示不检查
+const defaultRepeatWindow = 10 * time.Second
+
+// 窗口里第几次发同样的消息时禁言, 以及禁言多久
+const (
+	repeatMuteAfter = 3
+	repeatMute      = 30 * time.Second
+)
+
+// 这条公聊消息是不是要因为重复被拦下, 拦下时返回给用户的错误
+func (this *User) repeatBlocked(msg string) *replyError {
+	window := this.server.RepeatWindow
+	if window <= 0 || this.IsAdmin() {
+		return nil
+	}
+
+	now := this.server.Clock.Now()
+	if now.Before(this.mutedUntil) {
+		return this.newError(ErrRateLimited, "repeat.muted", int(this.mutedUntil.Sub(now).Seconds())+1)
+	}
+
+	text := strings.TrimSpace(msg)
+	if text != this.lastText || now.Sub(this.lastTextAt) > window {
+		this.lastText, this.lastTextAt, this.repeats = text, now, 1
+		return nil
+	}
+
+	// 一直重复时窗口跟着往后移
+	this.lastTextAt = now
+	this.repeats++
+	if this.repeats >= repeatMuteAfter {
+		this.mutedUntil = now.Add(repeatMute)
+		this.repeats = 0
+		fmt.Printf("session=#%d muted for repeating name=%s\n", this.ID, this.Name())
+		return this.newError(ErrRateLimited, "repeat.mute", int(repeatMute.Seconds()))
+	}
+	return this.newError(ErrRateLimited, "repeat.dropped")
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// 窗口里重复的消息不转发, 去掉首尾空白后比较; 过了窗口再发同样的消息照常转发
+func TestRepeatWindow(t *testing.T) {
+	clock := NewFakeClock(testEpoch)
+	server := startTestServer(t, WithClock(clock), WithRepeatWindow(10*time.Second))
+	alice := dialTest(t, server, "alice")
+	bob := dialTest(t, server, "bob")
+
+	alice.send("hello")
+	bob.waitFor("alice:hello")
+	clock.Advance(5 * time.Second)
+	alice.send("  hello ")
+	alice.waitFor(text("repeat.dropped"))
+
+	// 不一样的消息重新开始算
+	alice.send("hello again")
+	bob.waitFor("alice:hello again")
+	clock.Advance(11 * time.Second)
+	alice.send("hello again")
+	bob.waitFor("alice:hello again")
+
+	bob.send("sync-bob")
+	bob.waitFor("sync-bob")
+	if n := bob.count("alice:hello"); n != 3 {
+		t.Fatalf("bob 收到了 %d 条 alice:hello..., want 3", n)
+	}
+}
+
+// 连续第三次发同样的消息时禁言, 禁言期间什么公聊都发不出去, 到期后恢复; 管理员不受限制
+func TestRepeatMute(t *testing.T) {
+	clock := NewFakeClock(testEpoch)
+	server := startTestServer(t, WithClock(clock), WithRepeatWindow(10*time.Second), WithAdminToken("secret"))
+	alice := dialTest(t, server, "alice")
+	bob := dialTest(t, server, "bob")
+	admin := dialAdmin(t, server, "admin")
+
+	alice.send("spam", "spam")
+	alice.waitFor(text("repeat.dropped"))
+	alice.send("spam")
+	alice.waitFor(text("repeat.mute", 30))
+
+	clock.Advance(20 * time.Second)
+	alice.send("something else")
+	alice.waitFor(text("repeat.muted", 11))
+	clock.Advance(10 * time.Second)
+	alice.send("back")
+	bob.waitFor("alice:back")
+	if bob.saw("alice:something else") || bob.count("alice:spam") != 1 {
+		t.Fatal("禁言期间的消息发出去了")
+	}
+
+	admin.send("admin-spam", "admin-spam", "admin-spam")
+	for bob.count("admin:admin-spam") < 3 {
+		bob.waitFor("admin:admin-spam")
+	}
+}
+
+// -repeat-window 0 不检查
+func TestRepeatDisabled(t *testing.T) {
+	server := startTestServer(t, WithRepeatWindow(0))
+	alice := dialTest(t, server, "alice")
+	bob := dialTest(t, server, "bob")
+	alice.send("same", "same", "same")
+	for bob.count("alice:same") < 3 {
+		bob.waitFor("alice:same")
+	}
+	if alice.saw(text("repeat.dropped")) {
+		t.Fatal("关闭以后还在拦")
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// say|后面的内容原样作为公聊发出, 即使看起来是命令; // 开头的消息本来就不是命令, 原样广播
+func TestSayLiteral(t *testing.T) {
+	server := startTestServer(t)
+	alice := dialTest(t, server, "alice")
+	bob := dialTest(t, server, "bob")
+
+	alice.send("say|to|bob|这不是私聊", "say|who", "say|rename|carol", "//who")
+	bob.waitFor("alice:to|bob|这不是私聊")
+	bob.waitFor("alice:who")
+	bob.waitFor("alice:rename|carol")
+	bob.waitFor("alice://who")
+	if bob.saw(privateLine("alice", "这不是私聊")) || bob.saw("who-begin") || alice.saw(text("rename.done", "carol")) {
+		t.Fatal("say|的内容被当作命令执行了")
+	}
+	if _, ok := server.lookupUser("alice"); !ok {
+		t.Fatal("say|rename 改了名字")
+	}
+
+	// 跟别的格式不对的命令一样, 只有 say| 时当作普通消息
+	alice.send("say|")
+	bob.waitFor("alice:say|")
+}
+
+// 客户端用来判断哪些消息要加 say| 的命令名跟服务器的命令一致
+func TestClientKnowsServerCommands(t *testing.T) {
+	src, err := os.ReadFile("cmd/client/client_say.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var client []string
+	for _, m := range regexp.MustCompile(`"([a-z-]+)": true`).FindAllStringSubmatch(string(src), -1) {
+		client = append(client, m[1])
+	}
+	var server []string
+	for _, c := range commands {
+		server = append(server, c.Name)
+	}
+	sort.Strings(client)
+	sort.Strings(server)
+	if strings.Join(client, ",") != strings.Join(server, ",") {
+		t.Fatalf("客户端的命令名:\n%v\n服务器的命令:\n%v", client, server)
+	}
+}
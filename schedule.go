@@ -0,0 +1,269 @@
+// 定时消息: 提醒和定时公告
+package main
+
+import (
+	"container/heap"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 每个用户名最多同时有多少条待发送的定时消息
+const maxRemindersPerUser = 10
+
+// 定时消息最长可以延后多久
+const maxRemindDelay = 24 * time.Hour
+
+// 一条定时消息
+type scheduledJob struct {
+	ID     uint64
+	At     time.Time // 发送时间
+	Owner  string    // 创建者的用户名, 下线后重新上线还是他的
+	Public bool      // true是公告, 发给全部在线用户; false是提醒, 只发回给创建者
+	Text   string
+
+	index int // 在堆里的位置
+}
+
+// 按发送时间排列的最小堆
+type jobHeap []*scheduledJob
+
+func (h jobHeap) Len() int           { return len(h) }
+func (h jobHeap) Less(i, j int) bool { return h[i].At.Before(h[j].At) }
+func (h jobHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *jobHeap) Push(x interface{}) {
+	job := x.(*scheduledJob)
+	job.index = len(*h)
+	*h = append(*h, job)
+}
+
+func (h *jobHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	job := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return job
+}
+
+// 定时消息调度器, 全部定时消息由一个goroutine统一管理
+type Scheduler struct {
+	lock   sync.Mutex
+	jobs   jobHeap
+	byID   map[uint64]*scheduledJob
+	nextID uint64
+
+	clock Clock
+	wake  chan struct{}       // 最早的发送时间变化时唤醒Run
+	fire  func(*scheduledJob) // 到时间后发送消息
+}
+
+func NewScheduler(clock Clock, fire func(*scheduledJob)) *Scheduler {
+	return &Scheduler{
+		byID:  make(map[uint64]*scheduledJob),
+		clock: clock,
+		wake:  make(chan struct{}, 1),
+		fire:  fire,
+	}
+}
+
+// 添加一条定时消息, 超出每个用户名的上限时返回错误
+func (this *Scheduler) Add(owner string, delay time.Duration, public bool, text string) (*scheduledJob, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	count := 0
+	for _, job := range this.jobs {
+		if job.Owner == owner {
+			count++
+		}
+	}
+	if count >= maxRemindersPerUser {
+		return nil, errorT("schedule.too_many", maxRemindersPerUser)
+	}
+
+	this.nextID++
+	job := &scheduledJob{
+		ID:     this.nextID,
+		At:     this.clock.Now().Add(delay),
+		Owner:  owner,
+		Public: public,
+		Text:   text,
+	}
+	heap.Push(&this.jobs, job)
+	this.byID[job.ID] = job
+	this.notify()
+
+	return job, nil
+}
+
+// 取消一条定时消息, 只能取消自己的用户名创建的
+func (this *Scheduler) Cancel(owner string, id uint64) bool {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	job, ok := this.byID[id]
+	if !ok || job.Owner != owner {
+		return false
+	}
+	heap.Remove(&this.jobs, job.index)
+	delete(this.byID, id)
+	this.notify()
+
+	return true
+}
+
+// 按发送时间返回某个用户名的全部定时消息
+func (this *Scheduler) List(owner string) []scheduledJob {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	var list []scheduledJob
+	for _, job := range this.jobs {
+		if job.Owner == owner {
+			list = append(list, *job)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].At.Before(list[j].At) })
+	return list
+}
+
+// 唤醒Run重新计算等待时间, 调用时需要持有lock
+func (this *Scheduler) notify() {
+	select {
+	case this.wake <- struct{}{}:
+	default:
+	}
+}
+
+// 取出全部已经到时间的定时消息
+func (this *Scheduler) due(now time.Time) []*scheduledJob {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	var jobs []*scheduledJob
+	for len(this.jobs) > 0 && !this.jobs[0].At.After(now) {
+		job := heap.Pop(&this.jobs).(*scheduledJob)
+		delete(this.byID, job.ID)
+		jobs = append(jobs, job)
+	}
+	return jobs
+}
+
+// 距离最早的一条定时消息还要等多久, 没有定时消息时返回false
+func (this *Scheduler) next(now time.Time) (time.Duration, bool) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if len(this.jobs) == 0 {
+		return 0, false
+	}
+	return this.jobs[0].At.Sub(now), true
+}
+
+// 调度器的goroutine, 等到最早的一条定时消息到时间后发送, stop关闭后退出
+func (this *Scheduler) Run(stop <-chan struct{}) {
+	for {
+		for _, job := range this.due(this.clock.Now()) {
+			this.fire(job)
+		}
+
+		var after <-chan time.Time
+		if d, ok := this.next(this.clock.Now()); ok {
+			after = this.clock.After(d)
+		}
+		select {
+		case <-after:
+		case <-this.wake:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// 发送一条到时间的定时消息
+func (this *Server) fireJob(job *scheduledJob) {
+	if job.Public {
+		this.announce(noticePrefix, job.Text)
+		return
+	}
+
+	// 提醒发给到时间时用这个名字在线的用户, 不在线时丢掉
+	user, ok := this.lookupUser(job.Owner)
+	if !ok {
+		fmt.Println("reminder dropped, user offline:", job.Owner, job.Text)
+		return
+	}
+	user.SendMsg(t(user, "schedule.fired", job.Text) + "\n")
+}
+
+// 添加提醒或定时公告, 消息格式: remind|10m|开会了 或 schedule|10m|开会了
+func (this *User) DoRemind(arg string, public bool) {
+	cmd := "remind"
+	if public {
+		cmd = "schedule"
+		if !this.IsAdmin() {
+			this.server.audit(this, "schedule", "", arg, auditDenied)
+			this.errorReply(ErrPermission, "schedule.admin_only")
+			return
+		}
+	}
+
+	parts := strings.SplitN(arg, "|", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		this.errorReply(ErrUsage, "schedule.usage", cmd)
+		return
+	}
+
+	delay, err := time.ParseDuration(parts[0])
+	if err != nil || delay <= 0 || delay > maxRemindDelay {
+		this.errorReply(ErrUsage, "schedule.bad_delay")
+		return
+	}
+
+	job, err := this.server.scheduler.Add(this.Name(), delay, public, parts[1])
+	if err != nil {
+		this.errorReplyFor(ErrRateLimited, err)
+		return
+	}
+	if public {
+		this.server.audit(this, "schedule", "#"+strconv.FormatUint(job.ID, 10), arg, auditOK)
+	}
+	this.SendMsg(t(this, "schedule.added", job.ID, delay) + "\n")
+}
+
+// 查看自己的定时消息, 消息格式: reminders
+func (this *User) DoReminders() {
+	list := this.server.scheduler.List(this.Name())
+	if len(list) == 0 {
+		this.SendMsg(t(this, "schedule.none") + "\n")
+		return
+	}
+
+	for _, job := range list {
+		kind := t(this, "schedule.reminder")
+		if job.Public {
+			kind = t(this, "schedule.announce")
+		}
+		left := job.At.Sub(this.server.Clock.Now()).Round(time.Second)
+		this.SendMsg(t(this, "schedule.item", job.ID, kind, left, job.Text) + "\n")
+	}
+}
+
+// 取消自己的定时消息, 消息格式: unremind|编号
+func (this *User) DoUnremind(arg string) {
+	id, err := strconv.ParseUint(strings.TrimPrefix(arg, "#"), 10, 64)
+	if err != nil || !this.server.scheduler.Cancel(this.Name(), id) {
+		this.errorReply(ErrNotFound, "schedule.not_found")
+		return
+	}
+	this.SendMsg(t(this, "schedule.cancelled", id) + "\n")
+}
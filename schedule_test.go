@@ -0,0 +1,112 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// 到时间的提醒只发回给创建者, 按用户的语言加上前缀
+func TestRemindFires(t *testing.T) {
+	clock := NewFakeClock(testEpoch)
+	server := startTestServer(t, WithClock(clock))
+	alice := dialTest(t, server, "alice")
+	bob := dialTest(t, server, "bob")
+
+	waiters := clock.Waiters()
+	alice.send("remind|10m|stand-up")
+	alice.waitFor(text("schedule.added", 1, 10*time.Minute))
+	eventually(t, "调度器没有等待", func() bool { return clock.Waiters() > waiters })
+
+	clock.Advance(10 * time.Minute)
+	alice.waitFor(text("schedule.fired", "stand-up"))
+	bob.send("sync-bob")
+	bob.waitFor("sync-bob")
+	if bob.saw("stand-up") {
+		t.Fatal("别人的提醒发给了bob")
+	}
+}
+
+// 每个用户名的名额单独计算, 定时公告也算在名额里
+func TestSchedulerPerName(t *testing.T) {
+	clock := NewFakeClock(testEpoch)
+	s := NewScheduler(clock, func(*scheduledJob) {})
+
+	for i := 0; i < maxRemindersPerUser-1; i++ {
+		if _, err := s.Add("alice", time.Minute, false, "r"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	notice, err := s.Add("alice", time.Hour, true, "notice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Add("alice", time.Minute, false, "over"); err == nil {
+		t.Fatal("超出上限也添加成功了")
+	}
+	if _, err := s.Add("bob", time.Minute, false, "bob"); err != nil {
+		t.Fatal("别人的提醒占了bob的名额:", err)
+	}
+	if s.Cancel("bob", notice.ID) || !s.Cancel("alice", notice.ID) {
+		t.Fatal("只有创建者的用户名能取消")
+	}
+	if len(s.List("alice")) != maxRemindersPerUser-1 || len(s.List("bob")) != 1 {
+		t.Fatalf("alice %d 条, bob %d 条", len(s.List("alice")), len(s.List("bob")))
+	}
+
+	clock.Advance(time.Hour)
+	if due := s.due(clock.Now()); len(due) != maxRemindersPerUser {
+		t.Fatalf("到时间的定时消息 %d 条", len(due))
+	}
+}
+
+// 下线后提醒还在, 用同一个名字重新上线后能查看、取消和收到
+func TestRemindSurvivesReconnect(t *testing.T) {
+	clock := NewFakeClock(testEpoch)
+	server := startTestServer(t, WithClock(clock))
+	alice := dialTest(t, server, "alice")
+	alice.send("remind|10m|first", "remind|20m|second")
+	alice.waitFor(text("schedule.added", 2, 20*time.Minute))
+	alice.conn.Close()
+	eventually(t, "alice 没有下线", func() bool {
+		_, ok := server.lookupUser("alice")
+		return !ok
+	})
+
+	again := dialTest(t, server, "alice")
+	again.send("reminders")
+	again.waitFor(text("schedule.item", 2, text("schedule.reminder"), 20*time.Minute, "second"))
+	again.send("unremind|#2")
+	again.waitFor(text("schedule.cancelled", 2))
+
+	clock.Advance(10 * time.Minute)
+	again.waitFor(text("schedule.fired", "first"))
+	clock.Advance(10 * time.Minute)
+	again.send("reminders")
+	again.waitFor(text("schedule.none"))
+	if again.saw(text("schedule.fired", "second")) {
+		t.Fatal("取消的提醒也发了")
+	}
+}
+
+// 到时间时不在线的提醒丢掉, 之后再上线也收不到
+func TestRemindDroppedOffline(t *testing.T) {
+	clock := NewFakeClock(testEpoch)
+	server := startTestServer(t, WithClock(clock))
+	alice := dialTest(t, server, "alice")
+	alice.send("remind|10m|missed")
+	alice.waitFor(text("schedule.added", 1, 10*time.Minute))
+	alice.conn.Close()
+	eventually(t, "alice 没有下线", func() bool {
+		_, ok := server.lookupUser("alice")
+		return !ok
+	})
+
+	clock.Advance(10 * time.Minute)
+	eventually(t, "提醒没有到时间", func() bool { return len(server.scheduler.List("alice")) == 0 })
+	again := dialTest(t, server, "alice")
+	again.send("reminders")
+	again.waitFor(text("schedule.none"))
+	if again.saw(text("schedule.fired", "missed")) {
+		t.Fatal("下线时到时间的提醒在重新上线后发了")
+	}
+}
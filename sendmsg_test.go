@@ -0,0 +1,33 @@
+package main
+
+import (
+	"net"
+	"testing"
+)
+
+// 写失败时返回错误并把连接标记为坏的, 之后的写直接返回errConnBroken, 用户随后下线
+func TestSendMsgBrokenConn(t *testing.T) {
+	server := startTestServer(t)
+	dialTest(t, server, "alice")
+	bob := dialTest(t, server, "bob")
+	user := onlineUser(t, server, "alice")
+
+	// 只关掉服务器这边的写, 读消息的goroutine还在等, 用户仍然在线
+	if err := user.conn.(*countingConn).Conn.(*net.TCPConn).CloseWrite(); err != nil {
+		t.Fatal(err)
+	}
+	if err := user.SendMsg("lost\n"); err == nil || err == errConnBroken {
+		t.Fatalf("第一次写 err = %v", err)
+	}
+	if err := user.SendMsg("lost again\n"); err != errConnBroken {
+		t.Fatalf("第二次写 err = %v", err)
+	}
+	eventually(t, "写失败的用户没有下线", func() bool {
+		_, ok := server.lookupUser("alice")
+		return !ok
+	})
+
+	// 广播照常发给其他人
+	bob.send("still here")
+	bob.waitFor("bob:still here")
+}
@@ -2,13 +2,22 @@
 package main
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
 	"io"
 	"net"
+	"os"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
+// 导出的字段是配置, 用NewServer的选项(见options.go)或者在Start之前设置
+// Start之后配置只读: 连接和后台的goroutine随时在读, 再修改会有数据竞争; 运行中要改的设置用管理员命令(例如 slow、lockdown)
 type Server struct {
 	Ip   string
 	Port int
@@ -27,133 +36,636 @@ type Server struct {
 
 	// 消息广播的channel
 	Message chan string
+
+	// 消息序号, 私聊消息用它作为id
+	seq uint64
+
+	// 最后分配的连接编号
+	sessions uint64
+
+	// 新用户的默认用户名, 为nil时用 guest-编号; guests是最后用过的编号, 见guest.go
+	GuestName GuestNamer
+	guests    uint64
+
+	// 上线后单独发给新用户的欢迎语: GreetingFile是模板文件, Greeter由部署方设置, 见greeting.go
+	GreetingFile string
+	Greeter      Greeter
+
+	// 等待已读回执的私聊消息
+	receipts *receiptTable
+
+	// 公聊消息的历史记录
+	history *History
+
+	// 服务器的名字, 在hello里告诉客户端
+	Name string
+
+	// 管理员口令, 为空时不开放管理员身份
+	AdminToken string
+
+	// 定时消息调度器
+	scheduler *Scheduler
+
+	// 时钟, 所有跟时间有关的逻辑都从这里取时间, 测试时用SetClock换成FakeClock
+	Clock Clock
+
+	// 用户多久不发消息会被踢掉, 闲置到超时时间的多少比例时提醒, 见idle.go
+	IdleTimeout time.Duration
+	IdleWarn    float64
+
+	// 两次改名之间至少间隔多久, 以及一次连接最多改名几次, 管理员不受限制
+	RenameInterval time.Duration
+	RenameLimit    int
+
+	// 多久以内连续发同样的公聊消息算重复, 0表示不检查, 见repeat.go
+	RepeatWindow time.Duration
+
+	// 按IP限制试探: 统计的窗口, 窗口里允许的口令错误和命令错误次数, 以及封禁多久, 见suspects.go
+	SuspectWindow   time.Duration
+	SuspectLogins   int
+	SuspectCommands int
+	SuspectBlock    time.Duration
+	suspects        *suspectTable
+
+	// 多久以内别的地址用过的名字算最近用过, 0表示不提示; 每个名字最后的使用者, 见names.go
+	NameWarnWindow time.Duration
+	nameOwners     *nameOwnerTable
+
+	// 读改写离线记录时使用; 还没写进Store的私聊数, seenLock保护, 见welcome.go
+	seenLock  sync.Mutex
+	missedPMs map[string]int
+
+	// Store里的记录保存多久, 最多保存多少个用户名的数据, 0表示不清理; 上一次清理的结果, 见storegc.go
+	StoreTTL      time.Duration
+	StoreMaxNames int
+	storeGC       storeGCStats
+
+	// 慢速模式的间隔(纳秒), 见slowmode.go
+	slowMode int64
+
+	// 全员禁言, 奇数表示开启, 每开启一次加一, 见lockdown.go
+	lockdown uint64
+
+	// 上下线通知的合并窗口和窗口里照常广播的条数, 窗口为0时不合并, 见presence.go
+	PresenceWindow    time.Duration
+	PresenceThreshold int
+	presence          presenceState
+
+	// 传输中的文件和单个文件的最大字节数, 见filexfer.go
+	offers      *offerTable
+	MaxFileSize int64
+	FileRate    int // 每个文件每秒最多转发多少字节, 为0时不限制
+
+	// 每个连接每秒最多读多少字节和最多攒多少字节, 为0时不限制, 见ratelimit.go
+	ReadRate  int
+	ReadBurst int
+
+	// 最多多少个连接同时开启压缩, 为0时不支持压缩, 见compress.go
+	MaxCompressed int
+	compressed    int32
+
+	// 维护模式, 为1时不接受新连接, 见drain.go
+	draining int32
+
+	// 管理操作的审计记录, 见audit.go
+	Audit *AuditLog
+
+	// who的回复不加 who-begin/who-end 标记, 兼容按行显示的旧客户端
+	LegacyWho bool
+
+	// 广播消息的格式, 见format.go
+	LineFormat *LineFormat
+
+	// 每个用户每分钟最多收到多少条私聊, 为0时不限制, 见pmlimit.go
+	PMInboundLimit int
+
+	// 向所有人显示用户的地址, 为false时只有管理员和本人能看到, 见User.addrFor
+	ShowAddr bool
+
+	// 收到的消息和字节数
+	stats serverStats
+
+	// 所有人都可以用stats查看运行状态, 为false时只有管理员可以
+	PublicStats bool
+
+	// 输出调试日志, 例如连接被对方重置
+	Debug bool
+
+	// 每隔多久输出一行运行状态, 为0时不输出
+	StatusInterval time.Duration
+
+	// 保存个人设置的Store, 为nil时个人设置只在本次连接有效
+	PrefStore Store
+
+	// 集群模式, 为nil时是单实例
+	cluster *Cluster
+
+	// 在代理后面时从PROXY协议头部取得客户端地址, 为nil时不解析
+	ProxyProtocol *ProxyProtocol
+
+	// 除了 -ip -port 之外同时监听的地址, 见listeners.go
+	Listeners []ListenerConfig
+	listeners listenerSet
+
+	// 实际监听的地址, 见Addr
+	addr     net.Addr
+	addrLock sync.RWMutex
+
+	// 已经调用过Start, 之后不能再改配置
+	started int32
+
+	// Start返回前关闭, 后台的goroutine看到后退出
+	stopped chan struct{}
 }
 
+// 默认的闲置超时时间
+const defaultIdleTimeout = time.Second * 300
+
+// 广播队列满了之后最多等多久
+const broadcastTimeout = 5 * time.Second
+
+var errBroadcastTimeout = errors.New("broadcast queue is full")
+
+var errServerStopped = errors.New("server stopped")
+
+var errServerStarted = errors.New("server already started")
+
+// 连续收到多少条空消息后提示用户
+const emptyHintAfter = 3
+
+// 默认每个用户每分钟最多收到的私聊数
+const defaultPMInboundLimit = 30
+
+// 默认的改名限制
+const (
+	defaultRenameInterval = time.Second * 30
+	defaultRenameLimit    = 10
+)
+
 // 创建一个server的接口
-func NewServer(ip string, port int) *Server {
+// 以前的调用 NewServer(ip, port) 不用修改, 其他设置用选项给出, 见options.go
+func NewServer(ip string, port int, opts ...Option) *Server {
 	server := &Server{
-		Ip:        ip,
-		Port:      port,
-		OnlineMap: make(map[string]*User),
-		Message:   make(chan string),
+		Ip:         ip,
+		Port:       port,
+		OnlineMap:  make(map[string]*User),
+		Message:    make(chan string),
+		stopped:    make(chan struct{}),
+		receipts:   newReceiptTable(),
+		history:    NewHistory(defaultHistorySize),
+		Audit:      NewAuditLog(),
+		offers:     newOfferTable(),
+		suspects:   newSuspectTable(),
+		nameOwners: newNameOwnerTable(),
+
+		Clock:       realClock{},
+		IdleTimeout: defaultIdleTimeout,
+		IdleWarn:    defaultIdleWarn,
+
+		RenameInterval: defaultRenameInterval,
+		RenameLimit:    defaultRenameLimit,
+
+		RepeatWindow: defaultRepeatWindow,
+
+		SuspectWindow:   defaultSuspectWindow,
+		SuspectLogins:   defaultSuspectLogins,
+		SuspectCommands: defaultSuspectCommands,
+		SuspectBlock:    defaultSuspectBlock,
+
+		NameWarnWindow: defaultNameWarnWindow,
+
+		StoreTTL:      defaultStoreTTL,
+		StoreMaxNames: defaultStoreMaxNames,
+
+		PMInboundLimit: defaultPMInboundLimit,
+
+		MaxFileSize: defaultMaxFileSize,
+		FileRate:    defaultFileRate,
+
+		ReadRate:  defaultReadRate,
+		ReadBurst: defaultReadBurst,
+
+		MaxCompressed: defaultMaxCompressed,
+
+		PresenceWindow:    defaultPresenceWindow,
+		PresenceThreshold: defaultPresenceThreshold,
+	}
+	server.LineFormat, _ = ParseLineFormat(defaultLineFormat)
+	server.scheduler = NewScheduler(server.Clock, server.fireJob)
+
+	for _, opt := range opts {
+		opt(server)
 	}
 
 	return server
 }
 
+// 分配一个新的连接编号
+func (this *Server) nextSession() uint64 {
+	return atomic.AddUint64(&this.sessions, 1)
+}
+
+// 按用户名或 #连接编号 查找在线用户
+// 按编号查找时, 对方改名了也能找到同一个连接, 别人改成了原来的名字也不会找错
+func (this *Server) lookupUser(target string) (*User, bool) {
+	this.mapLock.RLock()
+	defer this.mapLock.RUnlock()
+
+	if strings.HasPrefix(target, "#") {
+		id, err := strconv.ParseUint(target[1:], 10, 64)
+		if err != nil {
+			return nil, false
+		}
+		for _, user := range this.OnlineMap {
+			if user.ID == id {
+				return user, true
+			}
+		}
+		return nil, false
+	}
+
+	user, ok := this.OnlineMap[target]
+	return user, ok
+}
+
+// Start之后还在改配置是调用方的错误, 直接panic, 不让它变成难查的数据竞争
+func (this *Server) mustNotStarted(what string) {
+	if atomic.LoadInt32(&this.started) == 1 {
+		panic(what + " must be called before Start")
+	}
+}
+
+// 开启集群模式, 需要在Start之前调用, instance为空时使用 主机名:实际端口
+func (this *Server) EnableCluster(broker Broker, instance string) {
+	this.mustNotStarted("EnableCluster")
+	this.cluster = NewCluster(this, broker, instance)
+}
+
+// 更换时钟, 需要在Start之前调用
+func (this *Server) SetClock(clock Clock) {
+	this.mustNotStarted("SetClock")
+	this.Clock = clock
+	this.scheduler.clock = clock
+}
+
 // 监听Message广播消息channel的goroutine, 一旦有消息就发送给全部的在线User
 func (this *Server) ListenMessage() {
 	for {
-		msg := <-this.Message
+		var msg string
+		select {
+		case msg = <-this.Message:
+		case <-this.stopped:
+			return
+		}
 
 		//将msg发送给全部的在线User
-		this.mapLock.Lock()
-		for _, cli := range this.OnlineMap {
-			cli.C <- msg
+		this.deliverLocal(msg)
+
+		// 集群模式下同时发给其他实例
+		if this.cluster != nil {
+			this.cluster.PublishBroadcast(msg)
+		}
+	}
+}
+
+// 将msg发送给本实例全部的在线User
+// 记录整条广播的耗时, 最慢的用户超过slowRecipient时记一条日志
+func (this *Server) deliverLocal(msg string) {
+	start := this.Clock.Now()
+	var slowest *User
+	var slowestTook time.Duration
+
+	// 先在读锁里取到在线用户, 放进队列时可能要等outboundWait, 不能一直拿着mapLock
+	this.mapLock.RLock()
+	users := make([]*User, 0, len(this.OnlineMap))
+	for _, cli := range this.OnlineMap {
+		users = append(users, cli)
+	}
+	this.mapLock.RUnlock()
+
+	for _, cli := range users {
+		before := this.Clock.Now()
+		// 发送队列满了说明客户端一直不读, 不能让它卡住整条广播, 见outbound.go
+		if !cli.queueMessage(msg) {
+			cli.slowDisconnect("queue full")
+			continue
 		}
-		this.mapLock.Unlock()
+		if took := this.Clock.Now().Sub(before); took > slowestTook {
+			slowest, slowestTook = cli, took
+		}
+	}
+
+	this.stats.fanout.observe(this.Clock.Now().Sub(start))
+	if slowestTook > slowRecipient {
+		fmt.Printf("session=#%d slow recipient name=%s took=%s\n", slowest.ID, slowest.Name(), slowestTook)
+	}
+}
+
+// 广播消息的方法, 广播队列一直没有空位时返回错误
+func (this *Server) BroadCast(user *User, msg string) error {
+	sendMsg := this.LineFormat.Render(user, msg, this.Clock.Now())
+
+	return this.enqueue(sendMsg)
+}
+
+// 把一条消息放进广播队列, 等待超过broadcastTimeout或者服务器已经停止时放弃
+func (this *Server) enqueue(msg string) error {
+	select {
+	case this.Message <- msg:
+		return nil
+	case <-this.Clock.After(broadcastTimeout):
+		return errBroadcastTimeout
+	case <-this.stopped:
+		return errServerStopped
 	}
 }
 
-// 广播消息的方法
-func (this *Server) BroadCast(user *User, msg string) {
-	sendMsg := "[" + user.Addr + "]" + user.Name + ":" + msg
+// 读消息出错的原因
+const (
+	readEOF      = "eof"     // 对方正常关闭
+	readClosed   = "closed"  // 服务器自己关闭的, 例如空闲被踢或者写失败
+	readReset    = "reset"   // 对方异常断开, 例如客户端被杀掉或者网络断了
+	readTimeout  = "timeout" // 读超时
+	readFlood    = "flood"   // 超出入站流量限制, 见ratelimit.go
+	readOversize = "frame"   // 帧太长, 见framing.go
+	readFailed   = "error"   // 其他错误
+)
+
+func readErrKind(err error) string {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, io.EOF):
+		return readEOF
+	case errors.Is(err, errReadFlood):
+		return readFlood
+	case errors.Is(err, errFrameTooLarge):
+		return readOversize
+	case errors.Is(err, net.ErrClosed):
+		return readClosed
+	case errors.Is(err, syscall.ECONNRESET):
+		return readReset
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return readTimeout
+	}
+	return readFailed
+}
 
-	this.Message <- sendMsg
+// 记录读消息的错误; 正常关闭不记录, 对方异常断开很常见, 只在 -debug 时记录
+func (this *Server) logReadErr(user *User, err error) {
+	switch kind := readErrKind(err); kind {
+	case readEOF, readClosed:
+	case readReset:
+		if this.Debug {
+			fmt.Printf("session=#%d read %s: %v\n", user.ID, kind, err)
+		}
+	default:
+		fmt.Printf("session=#%d read %s: %v\n", user.ID, kind, err)
+	}
 }
 
 func (this *Server) Handler(conn net.Conn) {
+	this.handleConn(conn, transportTCP)
+}
+
+// 处理一个连接, transport是连接方式, 见listeners.go
+func (this *Server) handleConn(conn net.Conn, transport string) {
+	// 先读代理发来的头部, 之后才能知道客户端的真实地址
+	if this.ProxyProtocol != nil {
+		proxied, err := this.ProxyProtocol.Wrap(conn)
+		if err != nil {
+			fmt.Println("reject", conn.RemoteAddr(), err)
+			conn.Close()
+			return
+		}
+		conn = proxied
+	}
+	conn = &countingConn{Conn: conn}
+
+	// 试探太多次被暂时封禁的IP, 见suspects.go
+	if this.ipBlocked(hostOf(conn.RemoteAddr().String())) {
+		this.rejectBlocked(conn)
+		return
+	}
 
 	// ...当前链接的业务
 	user := NewUser(conn, this)
+	user.Transport = transport
+
+	// 第一行告诉客户端这是IM服务器, 见hello.go
+	user.SendMsg(this.helloLine() + "\n")
 
 	// 用户的上线业务
 	user.Online()
 
-	// 监听用户是否活跃的channel
-	isLive := make(chan bool)
-	// 接受客户端传递发送的消息
+	// 读消息的goroutine退出时关闭, 这时用户已经下线
+	done := make(chan struct{})
+	// 接受客户端传递发送的消息, 除了ping这样自动发的命令, 每条消息都会更新用户的最后活跃时间, 见DoMessage
 	go func() {
-		buf := make([]byte, 4096)
-		for {
-			n, err := conn.Read(buf) // Read 从连接中读取数据
-			if n == 0 {
-				user.Offline() // 用户的下线业务
+		defer close(done)
 
-				return
-			}
+		// 按行读取, 客户端连续写入的多条消息可能在一次Read里一起到达
+		var r io.Reader = conn
+		if this.ReadRate > 0 {
+			r = newLimitedReader(conn, this.Clock, this.ReadRate, this.ReadBurst)
+		}
+		reader := bufio.NewReader(r)
+		empties := 0 // 连续收到的空消息数
+		handle := func(line string) {
+			this.stats.received(line)
 
-			if err != nil && err != io.EOF {
-				fmt.Println("Conn Read err:", err)
+			// 提取用户的消息(去除'\n')
+			msg := strings.TrimSuffix(line, "\n")
+
+			// 空消息和只有空白的消息直接丢掉, 不算活跃; 连续发了好几条才提示一次
+			if strings.TrimSpace(msg) == "" {
+				empties++
+				if empties == emptyHintAfter {
+					user.errorReply(ErrEmptyMessage, "user.empty")
+				}
 				return
 			}
+			empties = 0
 
-			// 提取用户的消息(去除'\n')
-			msg := string(buf[:n-1]) // 将字节形式转换成字符串形式
+			// 同一个IP错误太多时先等一会儿再处理, 见suspects.go
+			if d := this.suspectDelay(hostOf(user.Addr)); d > 0 {
+				<-this.Clock.After(d)
+			}
 
 			// 用户针对msg进行消息处理
 			user.DoMessage(msg)
-
-			// 用户的任意消息，代表当前用户是一个活跃的
-			isLive <- true
+		}
+		for {
+			line, err := user.readMessage(reader) // 从连接中读取一条消息, 见framing.go
+			if err != nil {
+				// 对方正常关闭前发的最后一行可能没有换行符, 照常处理; 其他错误时读到一半的行丢掉
+				if err == io.EOF && line != "" {
+					handle(line)
+				}
+				if err == errFrameTooLarge {
+					user.errorReply(ErrTooLarge, "frame.too_large", maxFrameSize)
+					user.disconnect()
+				}
+				if err == errReadFlood {
+					atomic.AddUint64(&this.stats.floodKicks, 1)
+					user.errorReply(ErrRateLimited, "user.flood")
+					user.disconnect()
+				}
+				this.logReadErr(user, err)
+				user.releaseCompress() // 让出压缩的名额, 见compress.go
+				user.Offline()         // 用户的下线业务
+				return
+			}
+			handle(line)
+			reader = user.inflateReader(reader) // 刚开启压缩时换成解压, 见compress.go
 		}
 	}()
 
-	// 当前handler阻塞
-	// 很多时候我们需要让main函数不退出，让它在后台一直执行，例如： select{}
+	// 当前handler阻塞, 定期检查用户多久没有发消息了, 快超时时提醒, 超时就踢掉, 见idle.go
+	// 检查间隔是超时时间的1/20, 所以实际提醒和踢掉的时间最多晚1/20
+	if this.IdleTimeout <= 0 {
+		<-done
+		return
+	}
+	ticker := this.Clock.NewTicker(this.IdleTimeout / 20)
+	defer ticker.Stop()
+	var warnedFor time.Time
 	for {
 		select {
-		case <-isLive:
-			// 当前用户是活跃的， 应该重置定时器
-			// 不做任何事情， 为了激活select, 更新下面的定时器
-			// isLive 写在 time.After 前面是因为当 isLive被执行时 会尝试 执行之后的case 也就是 time.After(time.Second * 10)
-		case <-time.After(time.Second * 300): // 十秒触发， 只有执行这句话就是重置定时器
-			// case进来东西的话说明已经超时
-			// 将当前的User强制关闭
-
-			user.SendMsg("您被踢了")
+		case <-done:
+			return
+		case <-ticker.C():
+			// 机器人不会因为空闲被踢
+			if user.IsBot() {
+				continue
+			}
+			if user.Idle() < this.IdleTimeout {
+				user.checkIdleWarn(&warnedFor)
+				continue
+			}
 
-			// 销毁用的资源
-			close(user.C)
+			// 将当前的User强制关闭, 并告诉其他人原因
+			user.SendMsg(t(user, "user.kicked") + "\n")
+			atomic.AddUint64(&this.stats.kicked, 1)
+			this.Announce(t(nil, "idle.kicked_note", user.Name()))
 
-			// 关闭连接
-			conn.Close()
+			// 关闭连接, 读消息的goroutine读到错误后会执行下线业务, 下线时关闭user.C
+			user.disconnect()
 
 			// 退出当前Handler
-			return // 也可以用 runtime.Goecit()
+			return
 		}
-
 	}
 }
 
-// 启动服务器的接口
-func (this *Server) Start() {
+// 启动服务器的接口, 监听失败时返回错误, 成功后一直阻塞到Close; 只能调用一次
+// 除了 -ip -port 之外还可以同时监听Listeners里的地址, 见listeners.go
+func (this *Server) Start() error {
+	if !atomic.CompareAndSwapInt32(&this.started, 0, 1) {
+		return errServerStarted
+	}
 
-	// socket listen
-	listener, err := net.Listen("tcp", fmt.Sprintf("%s:%d", this.Ip, this.Port)) // fmt.Sprintf 拼接字符串
+	// socket listen, 全部地址都绑定成功才继续
+	bound, err := bindListeners(this.listenerConfigs())
 	if err != nil {
-		fmt.Println("net.Listen err:", err)
-		return
+		return err
+	}
+	if !this.listeners.set(bound) {
+		for _, b := range bound {
+			b.listener.Close()
+		}
+		return net.ErrClosed
 	}
 	// socket 的原意是“插座”，在计算机通信领域，socket 被翻译为“套接字”，
 	// 它是计算机之间进行通信的一种约定或一种方式。通过 socket 这种约定，
 	// 一台计算机可以接收其他计算机的数据，也可以向其他计算机发送数据。
-	defer listener.Close() // close listen socket
+	defer this.Close() // close listen socket, 一个监听停下时全部一起停
+
+	// 端口为0时由系统分配, 之后都使用实际的端口
+	// Port和开始的时间在addr之前设置好, 看到Addr()不为nil的调用方读到的Port也是实际的端口, Uptime也是对的
+	listener := bound[0].listener
+	this.addrLock.Lock()
+	this.stats.started = this.Clock.Now()
+	if tcp, ok := listener.Addr().(*net.TCPAddr); ok {
+		this.Port = tcp.Port
+	}
+	this.addr = listener.Addr()
+	this.addrLock.Unlock()
+	if this.cluster != nil && this.cluster.id == "" {
+		host, _ := os.Hostname()
+		this.cluster.id = host + ":" + strconv.Itoa(this.Port)
+	}
+	fmt.Println(this.banner())
 
 	// 启动监听Message的goroutine
 	go this.ListenMessage()
+	go this.history.run()
+	go this.sampleLoop()
 
-	for {
-		// accept
-		conn, err := listener.Accept() // 返回链接的客户端地址
-		if err != nil {
-			fmt.Println("listener accept err", err)
-			continue
-		}
+	if this.cluster != nil {
+		this.cluster.Start()
+	}
 
-		// do handler
-		go this.Handler(conn)
+	// 启动定时消息的goroutine
+	go this.scheduler.Run(this.stopped)
+
+	// 定时作废没有回复和传到一半停下的文件, 见filexfer.go; ticker先建好, 测试拨动FakeClock时不会错过
+	go this.offerJanitor(this.Clock.NewTicker(fileJanitorInterval))
+
+	if this.StatusInterval > 0 {
+		go this.statusLoop(this.StatusInterval)
+	}
+
+	// 定时把错过的私聊数写进Store, 见welcome.go
+	if this.PrefStore != nil {
+		go this.missedPMFlusher(this.Clock.NewTicker(missedPMFlushInterval))
+	}
 
+	// 定时清理Store, 见storegc.go
+	if this.PrefStore != nil && (this.StoreTTL > 0 || this.StoreMaxNames > 0) {
+		go this.storeJanitor(this.Clock.NewTicker(storeJanitorInterval))
 	}
 
+	// 每个监听一个accept循环, 任何一个停下时关闭全部, 等全部停下后返回
+	var wg sync.WaitGroup
+	errs := make([]error, len(bound))
+	for i, b := range bound {
+		wg.Add(1)
+		go func(i int, b boundListener) {
+			defer wg.Done()
+			errs[i] = this.acceptLoop(b)
+			this.Close()
+		}(i, b)
+	}
+	wg.Wait()
+
+	// 停止前把攒着的上下线通知发出去
+	this.flushPresence(0)
+	this.flushMissedPMs()
+	this.history.Close()
+	close(this.stopped)
+	return errs[0]
+}
+
+// 实际监听的地址, 还没有开始监听时返回nil
+func (this *Server) Addr() net.Addr {
+	this.addrLock.RLock()
+	defer this.addrLock.RUnlock()
+
+	return this.addr
+}
+
+// 启动时输出的一行配置, 方便确认实际生效的设置
+func onOff(on bool) string {
+	if on {
+		return "on"
+	}
+	return "off"
+}
+
+func (this *Server) banner() string {
+	return fmt.Sprintf("server started name=%q version=%s addr=%s listeners=%s idle_timeout=%s history=%d rename_interval=%s rename_limit=%d pm_inbound_limit=%d max_file_size=%d file_rate=%d read_rate=%d read_burst=%d max_compressed=%d presence_window=%s presence_threshold=%d "+
+		"admin=%s cluster=%s proxy_protocol=%s prefs=%s status_interval=%s legacy_who=%s show_addr=%s public_stats=%s broadcast_format=%q",
+		this.Name, version, this.Addr(), this.listenAddrs(), this.IdleTimeout, this.history.size, this.RenameInterval, this.RenameLimit, this.PMInboundLimit, this.MaxFileSize, this.FileRate, this.ReadRate, this.ReadBurst, this.MaxCompressed, this.PresenceWindow, this.PresenceThreshold,
+		onOff(this.AdminToken != ""), onOff(this.cluster != nil), onOff(this.ProxyProtocol != nil),
+		onOff(this.PrefStore != nil), this.StatusInterval, onOff(this.LegacyWho), onOff(this.ShowAddr), onOff(this.PublicStats), this.LineFormat)
 }
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/Achiyun/Golang-IM-System/internal/leakcheck"
+)
+
+// 每个连接的编号递增, 改名不变; 私聊和whois都可以用 #编号 指定连接
+func TestSessionID(t *testing.T) {
+	leakcheck.Check(t)
+	server := startTestServer(t, WithRenameLimit(0, 0))
+	alice := dialTest(t, server, "alice")
+	bob := dialTest(t, server, "bob")
+	first, second := onlineUser(t, server, "alice"), onlineUser(t, server, "bob")
+	if second.ID <= first.ID {
+		t.Fatalf("编号没有递增: alice=#%d bob=#%d", first.ID, second.ID)
+	}
+
+	bob.send("rename|robert")
+	bob.waitFor(text("rename.done", "robert"))
+	if user := onlineUser(t, server, "robert"); user != second || user.ID != second.ID {
+		t.Fatal("改名以后编号变了")
+	}
+
+	id := "#" + strconv.FormatUint(second.ID, 10)
+	alice.send("to|"+id+"|hi", "whois|"+id)
+	bob.waitFor(privateLine("alice", "hi"))
+	alice.waitFor(text("whois.name", "robert"))
+	alice.waitFor(text("whois.session", second.ID))
+
+	alice.send("whois|#999")
+	alice.waitFor(text("user.not_found"))
+}
@@ -0,0 +1,199 @@
+// 用户的个人设置
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// 离开原因的最大长度
+const maxAwayLen = 50
+
+// 私聊白名单最多有多少个用户名
+const maxPMAllow = 100
+
+// 一项个人设置
+type setting struct {
+	Desc     string // 说明的消息编号, 见i18n.go
+	Default  string
+	Validate func(value string) error
+}
+
+// 全部可以修改的个人设置
+var settings = map[string]setting{
+	"history": {
+		Desc:     "setting.history",
+		Default:  "on",
+		Validate: validateOnOff,
+	},
+	"away": {
+		Desc:    "setting.away",
+		Default: "",
+		Validate: func(value string) error {
+			if utf8.RuneCountInString(value) > maxAwayLen {
+				return errorT("settings.away", maxAwayLen)
+			}
+			return nil
+		},
+	},
+	"pm_allowlist": {
+		Desc:     "setting.pm_allowlist",
+		Default:  "off",
+		Validate: validateOnOff,
+	},
+	"pm_allow": {
+		Desc:    "setting.pm_allow",
+		Default: "",
+		Validate: func(value string) error {
+			if strings.Contains(value, "|") {
+				return errorT("settings.pm_allow_bar")
+			}
+			if strings.Count(value, ",")+1 > maxPMAllow {
+				return errorT("settings.pm_allow_many", maxPMAllow)
+			}
+			return nil
+		},
+	},
+	"lang": {
+		Desc:    "setting.lang",
+		Default: "",
+		Validate: func(value string) error {
+			if _, ok := catalog[value]; value != "" && !ok {
+				return errorT("settings.lang")
+			}
+			return nil
+		},
+	},
+}
+
+func validateOnOff(value string) error {
+	if value != "on" && value != "off" {
+		return errorT("settings.on_off")
+	}
+	return nil
+}
+
+// 读取一项个人设置, 没有设置过时返回默认值
+func (this *User) Pref(key string) string {
+	this.prefLock.RLock()
+	defer this.prefLock.RUnlock()
+
+	if v, ok := this.prefs[key]; ok {
+		return v
+	}
+	return settings[key].Default
+}
+
+// 修改一项个人设置, 值等于默认值时删除
+func (this *User) setPref(key string, value string) {
+	this.prefLock.Lock()
+	if value == settings[key].Default {
+		delete(this.prefs, key)
+	} else {
+		this.prefs[key] = value
+	}
+	this.prefLock.Unlock()
+
+	this.savePrefs()
+}
+
+// 开始使用当前用户名时调用(上线和改名)
+// 这个连接是名字的主人时读取这个名字保存过的设置, 没有保存过时保留当前的设置, 以后都保存在这个名字下
+// 不是主人时不读也不保存, 别人保存的设置既不会被看到也不会被覆盖
+func (this *User) loadPrefs() {
+	name := this.Name()
+	if this.guest || !this.ownsName(name) {
+		name = ""
+	}
+	this.prefLock.Lock()
+	this.prefsName = name
+	this.prefLock.Unlock()
+
+	store := this.server.PrefStore
+	if store == nil || name == "" {
+		return
+	}
+
+	prefs, err := store.LoadPrefs(name)
+	if err != nil {
+		fmt.Println("load prefs err:", name, err)
+		return
+	}
+	if len(prefs) == 0 {
+		return
+	}
+
+	this.prefLock.Lock()
+	this.prefs = prefs
+	this.prefLock.Unlock()
+}
+
+// 保存个人设置的用户名, 为空时不保存
+func (this *User) PrefsName() string {
+	this.prefLock.RLock()
+	defer this.prefLock.RUnlock()
+
+	return this.prefsName
+}
+
+// 按当前用户名保存个人设置, 修改设置和下线时调用
+// 还在使用默认用户名、或者不是这个名字的主人时不保存
+func (this *User) savePrefs() {
+	store := this.server.PrefStore
+	if store == nil {
+		return
+	}
+
+	this.prefLock.RLock()
+	name := this.prefsName
+	prefs := make(map[string]string)
+	for k, v := range this.prefs {
+		prefs[k] = v
+	}
+	this.prefLock.RUnlock()
+	if name == "" || name != this.Name() {
+		return
+	}
+
+	if err := store.SavePrefs(name, prefs); err != nil {
+		fmt.Println("save prefs err:", name, err)
+	}
+}
+
+// 查看和修改个人设置, 消息格式: settings 或 settings|away|开会中
+func (this *User) DoSettings(arg string) {
+	if arg == "" {
+		keys := make([]string, 0, len(settings))
+		for key := range settings {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			this.SendMsg(t(this, "settings.line", key, this.Pref(key), t(this, settings[key].Desc)) + "\n")
+		}
+		return
+	}
+
+	parts := strings.SplitN(arg, "|", 2)
+	key := parts[0]
+	s, ok := settings[key]
+	if !ok {
+		this.errorReply(ErrUsage, "settings.unknown", key)
+		return
+	}
+	if len(parts) < 2 {
+		this.SendMsg(t(this, "settings.line", key, this.Pref(key), t(this, s.Desc)) + "\n")
+		return
+	}
+
+	value := strings.TrimSpace(parts[1])
+	if err := s.Validate(value); err != nil {
+		this.errorReply(ErrUsage, "settings.invalid", key, localize(this, err))
+		return
+	}
+	this.setPref(key, value)
+	this.SendMsg(t(this, "settings.set", key, value) + "\n")
+}
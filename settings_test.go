@@ -0,0 +1,72 @@
+package main
+
+import "testing"
+
+// 一项设置当前的值
+func prefLine(key, value string) string {
+	return text("settings.line", key, value, text(settings[key].Desc))
+}
+
+// 改成自己用过的名字时读回保存过的设置, 改成没人用过的名字时保留当前的设置
+func TestPrefsOwnName(t *testing.T) {
+	store := NewMemStore()
+	server := startTestServer(t, WithStore(store), WithRenameLimit(0, 0))
+	bob := dialTest(t, server, "bob")
+	bob.send("settings|away|午饭")
+	bob.waitFor(text("settings.set", "away", "午饭"))
+	bob.conn.Close()
+	eventually(t, "bob 没有下线", func() bool {
+		_, ok := server.lookupUser("bob")
+		return !ok
+	})
+
+	c := dialTest(t, server, "carol")
+	c.send("settings|history|off")
+	c.waitFor(text("settings.set", "history", "off"))
+	c.send("rename|bob", "settings|away", "settings|history")
+	c.waitFor(text("rename.done", "bob"))
+	c.waitFor(prefLine("away", "午饭"))
+	c.waitFor(prefLine("history", "on"))
+
+	c.send("rename|dave", "settings|away")
+	c.waitFor(prefLine("away", "午饭"))
+	c.conn.Close()
+	eventually(t, "下线时没有保存在新名字下", func() bool {
+		prefs, _ := store.LoadPrefs("dave")
+		return prefs["away"] == "午饭"
+	})
+}
+
+// 上一次用这个名字的是别的地址: 看不到原来主人的设置, 也不会覆盖它, 下线后原来的主人还是主人
+func TestPrefsOtherOwner(t *testing.T) {
+	store := NewMemStore()
+	server := startTestServer(t, WithStore(store), WithRenameLimit(0, 0))
+	store.SavePrefs("bob", map[string]string{"away": "出差", "pm_allowlist": "on"})
+	owner := nameOwner{session: 999, ip: "192.0.2.1", until: server.Clock.Now()}
+	server.setNameOwner("bob", owner)
+
+	alice := dialTest(t, server, "alice")
+	alice.send("settings|away|开会")
+	alice.waitFor(text("settings.set", "away", "开会"))
+	alice.send("rename|bob", "settings|away", "settings|pm_allowlist")
+	alice.waitFor(text("rename.done", "bob"))
+	alice.waitFor(prefLine("away", "开会"))
+	alice.waitFor(prefLine("pm_allowlist", "off"))
+	alice.send("settings|away|冒充")
+	alice.waitFor(text("settings.set", "away", "冒充"))
+	alice.conn.Close()
+	eventually(t, "alice 没有下线", func() bool {
+		_, ok := server.lookupUser("bob")
+		return !ok
+	})
+
+	if prefs, _ := store.LoadPrefs("bob"); prefs["away"] != "出差" || prefs["pm_allowlist"] != "on" {
+		t.Fatalf("原来主人的设置被覆盖了: %v", prefs)
+	}
+	if got, _ := server.nameOwner("bob"); got.session != owner.session || got.ip != owner.ip {
+		t.Fatalf("名字的主人变成了 %+v", got)
+	}
+	if prefs, _ := store.LoadPrefs("alice"); prefs["away"] != "开会" {
+		t.Fatalf("alice 的设置 %v", prefs)
+	}
+}
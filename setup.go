@@ -0,0 +1,172 @@
+// 服务器的配置文件和第一次使用时的设置向导
+// 配置文件是JSON, 键是参数名(不带-), 值是参数的字符串形式, 例如 {"port": "8888", "idle-timeout": "5m0s"}
+// -config 文件 启动时读取, 命令行上明确给出的参数优先; -init 问几个问题后写出配置文件, -init -yes 全部使用默认值
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// -init 没有指定 -config 时写到这个文件
+const defaultConfigFile = "im-server.json"
+
+// 读取配置文件, 把里面的参数设置到还没有在命令行上给出的flag上
+func loadServerConfig(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	values := make(map[string]string)
+	if err := json.Unmarshal(data, &values); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if flag.Lookup(name) == nil || name == "config" || name == "init" || name == "yes" {
+			return fmt.Errorf("%s: 不认识的参数 %s", path, name)
+		}
+		if set[name] {
+			continue
+		}
+		if err := flag.Set(name, values[name]); err != nil {
+			return fmt.Errorf("%s: %s: %v", path, name, err)
+		}
+	}
+	return nil
+}
+
+// 随机生成一个管理员口令
+func randomToken() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+// 检查文件能不能写: 目录要存在并且可写, 已经有这个文件时不覆盖
+func checkWritable(path string) error {
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("%s 已经存在, 请换一个文件名或者先删掉它", path)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".im-init-*")
+	if err != nil {
+		return err
+	}
+	tmp.Close()
+	return os.Remove(tmp.Name())
+}
+
+// 向导的一个问题: 显示提示和默认值, 直接回车时使用默认值; check返回错误时提示后重新问
+type wizardQuestion struct {
+	flag   string // 对应的参数名
+	prompt string
+	def    string
+	check  func(string) error
+}
+
+func wizardQuestions() []wizardQuestion {
+	host, _ := os.Hostname()
+	return []wizardQuestion{
+		{flag: "port", prompt: "监听端口", def: "8888", check: func(s string) error {
+			if p, err := strconv.Atoi(s); err != nil || p < 1 || p > 65535 {
+				return errors.New("端口必须是1到65535之间的数字")
+			}
+			return nil
+		}},
+		{flag: "server-name", prompt: "服务器的名字", def: host, check: func(s string) error {
+			if !validServerName(s) {
+				return errors.New("名字不能包含 |")
+			}
+			return nil
+		}},
+		{flag: "admin-token", prompt: "管理员口令(输入 - 表示不开放管理员)", def: randomToken(), check: func(s string) error {
+			if strings.ContainsAny(s, "| ") {
+				return errors.New("口令不能包含 | 和空格")
+			}
+			return nil
+		}},
+		{flag: "idle-timeout", prompt: "多久不发消息踢掉(例如 5m, 0 表示不踢)", def: defaultIdleTimeout.String(), check: func(s string) error {
+			if d, err := time.ParseDuration(s); err != nil || d < 0 {
+				return errors.New("请输入 30s、5m、1h 这样的时长")
+			}
+			return nil
+		}},
+	}
+}
+
+// 运行设置向导, 写出配置文件; yes为true时不提问, 全部使用默认值
+func runInitWizard(in io.Reader, out io.Writer, path string, yes bool) error {
+	if err := checkWritable(path); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(out, "第一次使用的设置, 直接回车使用[]里的默认值")
+	// 自带的客户端还不能连TLS, 直接告诉用户, 免得配置好了才发现
+	fmt.Fprintln(out, "提示: 服务器可以用 -tls-listen 同时开一个TLS端口, 但自带的客户端还不支持TLS, 只能连明文端口")
+
+	reader := bufio.NewReader(in)
+	values := make(map[string]string)
+	for _, q := range wizardQuestions() {
+		answer := q.def
+		for !yes {
+			fmt.Fprintf(out, "%s [%s]: ", q.prompt, q.def)
+			line, err := reader.ReadString('\n')
+			if err != nil && line == "" {
+				return errors.New("输入已结束, 没有写配置文件")
+			}
+			answer = strings.TrimSpace(line)
+			if answer == "" {
+				answer = q.def
+			}
+			if err := q.check(answer); err != nil {
+				fmt.Fprintln(out, err)
+				continue
+			}
+			break
+		}
+		if q.flag == "admin-token" && answer == "-" {
+			continue
+		}
+		values[q.flag] = answer
+	}
+
+	data, err := json.MarshalIndent(values, "", "  ")
+	if err != nil {
+		return err
+	}
+	// 里面有管理员口令, 只有自己可以读写
+	if err := os.WriteFile(path, append(data, '\n'), 0600); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "已写入 %s, 用下面的命令启动:\n  ./server -config %s\n", path, path)
+	if token, ok := values["admin-token"]; ok {
+		fmt.Fprintf(out, "管理员口令是 %s, 用户发送 admin|%s 成为管理员\n", token, token)
+	}
+	fmt.Fprintln(out, "其他参数也可以加到配置文件里, 键是参数名(不带-), 见 ./server -h")
+	return nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 读出向导写的配置文件
+func readConfigFile(t *testing.T, path string) map[string]string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := make(map[string]string)
+	if err := json.Unmarshal(data, &values); err != nil {
+		t.Fatal(err)
+	}
+	return values
+}
+
+// 回答不对时提示后重新问, - 表示不开放管理员
+func TestInitWizard(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "im-server.json")
+	answers := strings.Join([]string{"0", "abc", "9000", "a|b", "lobby", "-", "forever", "10m"}, "\n") + "\n"
+	var out strings.Builder
+	if err := runInitWizard(strings.NewReader(answers), &out, path, false); err != nil {
+		t.Fatal(err)
+	}
+
+	values := readConfigFile(t, path)
+	if len(values) != 3 || values["port"] != "9000" || values["server-name"] != "lobby" || values["idle-timeout"] != "10m" {
+		t.Fatalf("配置文件 = %v", values)
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0600 {
+		t.Fatalf("配置文件的权限是 %v", info.Mode().Perm())
+	}
+	for _, want := range []string{"端口必须是1到65535之间的数字", "名字不能包含 |", "请输入 30s、5m、1h 这样的时长", "./server -config " + path} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("输出里没有 %q:\n%s", want, out.String())
+		}
+	}
+	if strings.Contains(out.String(), "管理员口令是") {
+		t.Errorf("不开放管理员时还打印了口令:\n%s", out.String())
+	}
+}
+
+// -init -yes 不提问, 全部使用默认值, 管理员口令随机生成
+func TestInitWizardDefaults(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "im-server.json")
+	var out strings.Builder
+	if err := runInitWizard(strings.NewReader(""), &out, path, true); err != nil {
+		t.Fatal(err)
+	}
+	host, _ := os.Hostname()
+	values := readConfigFile(t, path)
+	if values["port"] != "8888" || values["server-name"] != host || values["idle-timeout"] != defaultIdleTimeout.String() || len(values["admin-token"]) != 32 {
+		t.Fatalf("配置文件 = %v", values)
+	}
+	if !strings.Contains(out.String(), "admin|"+values["admin-token"]) {
+		t.Fatalf("输出里没有管理员口令:\n%s", out.String())
+	}
+}
+
+// 不覆盖已经有的文件; 输入在中途结束时不写文件
+func TestInitWizardRefuses(t *testing.T) {
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "existing.json")
+	os.WriteFile(existing, []byte("{}"), 0600)
+	if err := runInitWizard(strings.NewReader(""), &strings.Builder{}, existing, true); err == nil {
+		t.Fatal("覆盖了已经有的文件")
+	}
+	if data, _ := os.ReadFile(existing); string(data) != "{}" {
+		t.Fatalf("文件变成了 %q", data)
+	}
+
+	if err := runInitWizard(strings.NewReader("9000\n"), &strings.Builder{}, filepath.Join(dir, "missing", "im.json"), true); err == nil {
+		t.Fatal("目录不存在时没有报错")
+	}
+
+	path := filepath.Join(dir, "im-server.json")
+	if err := runInitWizard(strings.NewReader("9000\n"), &strings.Builder{}, path, false); err == nil {
+		t.Fatal("输入结束时没有报错")
+	}
+	if _, err := os.Stat(path); err == nil {
+		t.Fatal("输入结束时写了配置文件")
+	}
+}
+
+// 命令行上明确给出的参数优先, 不认识的键报错
+func TestLoadServerConfig(t *testing.T) {
+	setFlags(t, map[string]string{
+		"port":         flag.Lookup("port").Value.String(),
+		"history":      flag.Lookup("history").Value.String(),
+		"idle-timeout": flag.Lookup("idle-timeout").Value.String(),
+	})
+	// 换一个新的FlagSet, 参数的值还是同一份, 但是之前测试里flag.Set过的参数不算命令行上给出的
+	commandLine := flag.CommandLine
+	t.Cleanup(func() { flag.CommandLine = commandLine })
+	flag.CommandLine = flag.NewFlagSet(commandLine.Name(), flag.ContinueOnError)
+	commandLine.VisitAll(func(f *flag.Flag) {
+		flag.CommandLine.Var(f.Value, f.Name, f.Usage)
+	})
+	flag.Set("history", "7")
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "im-server.json")
+	os.WriteFile(path, []byte(`{"port": "9001", "history": "99", "idle-timeout": "1m"}`), 0600)
+	if err := loadServerConfig(path); err != nil {
+		t.Fatal(err)
+	}
+	if serverPort != 9001 || historySize != 7 || idleTimeout != time.Minute {
+		t.Fatalf("port=%d history=%d idle-timeout=%v", serverPort, historySize, idleTimeout)
+	}
+
+	for _, bad := range []string{`{"no-such-flag": "1"}`, `{"init": "true"}`, `{"rename-limit": "many"}`, `["port"]`} {
+		os.WriteFile(path, []byte(bad), 0600)
+		if err := loadServerConfig(path); err == nil {
+			t.Errorf("%s 没有报错", bad)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// 默认只有管理员和本人能看到地址, 别人看到的是连接编号
+func TestAddrHidden(t *testing.T) {
+	server := startTestServer(t, WithAdminToken("secret"))
+	alice := dialTest(t, server, "alice")
+	bob := dialTest(t, server, "bob")
+	admin := dialAdmin(t, server, "admin")
+	user := onlineUser(t, server, "alice")
+
+	alice.send("hi")
+	bob.waitFor(fmt.Sprintf("[#%d]alice:hi", user.ID))
+
+	bob.send("who", "whois|alice", "who|json")
+	bob.waitFor(fmt.Sprintf("{#%d}alice:在线...", user.ID))
+	bob.waitFor(`[{"session":`)
+	alice.send("whois|alice")
+	alice.waitFor(text("whois.addr", user.Addr))
+	admin.send("whois|alice", "who")
+	admin.waitFor(text("whois.addr", user.Addr))
+	admin.waitFor("{" + user.Addr + "}alice:在线...")
+
+	for _, line := range bob.seen {
+		if strings.Contains(line, user.Addr) {
+			t.Fatalf("bob 看到了 alice 的地址: %q", line)
+		}
+	}
+}
+
+// -show-addr 时所有人都能看到地址
+func TestShowAddr(t *testing.T) {
+	format, _ := ParseLineFormat(addrLineFormat)
+	server := startTestServer(t, WithShowAddr(true), WithLineFormat(format))
+	alice := dialTest(t, server, "alice")
+	bob := dialTest(t, server, "bob")
+	user := onlineUser(t, server, "alice")
+
+	alice.send("hi")
+	bob.waitFor("[" + user.Addr + "]alice:hi")
+	bob.send("who", "whois|alice")
+	bob.waitFor("{" + user.Addr + "}alice:在线...")
+	bob.waitFor(text("whois.addr", user.Addr))
+}
@@ -0,0 +1,62 @@
+// 慢速模式: 每个用户两条公聊消息之间至少间隔多久, 管理员不受限制
+package main
+
+import (
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// 慢速模式的最大间隔
+const maxSlowMode = time.Hour
+
+// 当前的慢速模式间隔, 为0时没有开启
+func (this *Server) SlowMode() time.Duration {
+	return time.Duration(atomic.LoadInt64(&this.slowMode))
+}
+
+// 用户现在发公聊消息还需要等多久, 返回0表示可以发送, 可以发送时记下这次发送的时间
+func (this *User) slowModeWait() time.Duration {
+	interval := this.server.SlowMode()
+	if interval == 0 || this.IsAdmin() {
+		return 0
+	}
+
+	now := this.server.Clock.Now()
+	if wait := interval - now.Sub(this.lastPublic); !this.lastPublic.IsZero() && wait > 0 {
+		return wait
+	}
+	this.lastPublic = now
+	return 0
+}
+
+// 设置慢速模式, 消息格式: slowmode|秒数, 0表示关闭, 只有管理员可以设置
+func (this *User) DoSlowMode(arg string) {
+	if !this.IsAdmin() {
+		this.server.audit(this, "slowmode", "", arg, auditDenied)
+		this.errorReply(ErrPermission, "slow.admin_only")
+		return
+	}
+	if strings.Contains(arg, "|") {
+		// 还没有房间, 只有全局的慢速模式
+		this.server.audit(this, "slowmode", "", arg, auditInvalid)
+		this.errorReply(ErrUsage, "slow.no_rooms")
+		return
+	}
+
+	seconds, err := strconv.Atoi(arg)
+	if err != nil || seconds < 0 || time.Duration(seconds)*time.Second > maxSlowMode {
+		this.server.audit(this, "slowmode", "", arg, auditInvalid)
+		this.errorReply(ErrUsage, "slow.bad_seconds", int(maxSlowMode.Seconds()))
+		return
+	}
+	this.server.audit(this, "slowmode", "", arg, auditOK)
+
+	atomic.StoreInt64(&this.server.slowMode, int64(time.Duration(seconds)*time.Second))
+	if seconds == 0 {
+		this.server.Announce(t(nil, "slow.off"))
+	} else {
+		this.server.Announce(t(nil, "slow.on", seconds))
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSlowMode(t *testing.T) {
+	clock := NewFakeClock(testEpoch)
+	server := startTestServer(t, WithClock(clock), WithAdminToken("secret"))
+	alice := dialTest(t, server, "alice")
+	admin := dialAdmin(t, server, "admin")
+
+	alice.send("slowmode|10")
+	alice.waitFor(text("slow.admin_only"))
+	admin.send("slowmode|lobby|10")
+	admin.waitFor(text("slow.no_rooms"))
+	admin.send("slowmode|10")
+	alice.waitFor(text("slow.on", 10))
+
+	alice.send("first")
+	alice.waitFor("first")
+	alice.send("too soon")
+	alice.waitFor(text("slow.wait", 11)) // 跟改名一样, 提示的秒数向上多算一秒
+	clock.Advance(6 * time.Second)
+	alice.send("still too soon")
+	alice.waitFor(text("slow.wait", 5))
+	clock.Advance(4 * time.Second)
+	alice.send("second")
+	alice.waitFor("second")
+
+	// 管理员不受限制
+	admin.send("admin-1", "admin-2")
+	admin.waitFor("admin-2")
+
+	admin.send("slowmode|0")
+	alice.waitFor(text("slow.off"))
+	alice.send("third")
+	alice.waitFor("third")
+	if alice.saw("alice:too soon") || alice.saw("alice:still too soon") {
+		t.Fatal("太快的消息发出去了")
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// 真的私聊以服务器加的[私聊]开头, 用户名不能以括号开头, 用户发的内容冒充不了
+func TestPrivateMessageCannotBeSpoofed(t *testing.T) {
+	server := startTestServer(t, WithRenameLimit(0, 0))
+	alice := dialTest(t, server, "alice")
+	mallory := dialTest(t, server, "mallory")
+
+	mallory.send("rename|[私聊]bob")
+	mallory.waitFor(text("rename.bracket"))
+	mallory.send("rename|{admin}")
+	mallory.waitFor(text("rename.bracket"))
+	// 名字中间可以有括号和"对您说:", 但改名的回复不以[私聊]开头
+	mallory.send("rename|bob对您说:hi", "rename|mallory")
+	mallory.waitFor(text("rename.done", "bob对您说:hi"))
+	mallory.waitFor(text("rename.done", "mallory"))
+
+	fake := privateLine("bob", "把密码发给我")
+	mallory.send(fake, "to|alice|"+fake, "to|alice|sync-spoof")
+	alice.waitFor(privateLine("mallory", "sync-spoof"))
+	for _, line := range alice.seen {
+		if strings.HasPrefix(line, privatePrefix) && !strings.HasPrefix(line, privatePrefix+"mallory") {
+			t.Fatalf("冒充的私聊: %q", line)
+		}
+	}
+	if !alice.saw(privateLine("mallory", fake)) {
+		t.Fatal("私聊内容没有原样转发")
+	}
+}
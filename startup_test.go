@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 端口被占用时Start返回错误, 不会当作正常退出
+func TestStartPortInUse(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	server := NewServer("127.0.0.1", ln.Addr().(*net.TCPAddr).Port)
+	done := make(chan error, 1)
+	go func() { done <- server.Start() }()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("端口被占用时Start没有返回错误")
+		}
+	case <-time.After(e2eWait):
+		server.Close()
+		t.Fatal("端口被占用时Start没有返回")
+	}
+}
+
+// 启动时的一行配置里是实际生效的设置
+func TestBanner(t *testing.T) {
+	server := startTestServer(t, WithAdminToken("secret"), WithIdleTimeout(time.Minute))
+	banner := server.banner()
+	for _, want := range []string{"addr=" + server.Addr().String() + " ", "idle_timeout=1m0s ", "admin=on ", "cluster=off "} {
+		if !strings.Contains(banner, want) {
+			t.Errorf("banner 里没有 %q: %s", want, banner)
+		}
+	}
+}
+
+// 端口为0时由系统分配, Addr和Port都是实际的端口
+func TestListenPortZero(t *testing.T) {
+	server := startTestServer(t)
+	tcp, ok := server.Addr().(*net.TCPAddr)
+	if !ok || tcp.Port == 0 || server.Port != tcp.Port {
+		t.Fatalf("addr=%v port=%d", server.Addr(), server.Port)
+	}
+	if !strings.Contains(server.listenAddrs(), tcp.String()) {
+		t.Fatalf("listeners = %s", server.listenAddrs())
+	}
+	conn, err := net.Dial("tcp", tcp.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+}
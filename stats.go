@@ -0,0 +1,294 @@
+// 运行状态的计数器
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 一次广播发给单个用户超过这么久就记一条日志, 说明这个用户的连接跟不上
+const slowRecipient = 100 * time.Millisecond
+
+// server收到的消息和字节数, 只增不减
+type serverStats struct {
+	messages uint64 // 收到的消息条数, 包括命令
+	bytesIn  uint64 // 收到的字节数
+	kicked   uint64 // 因为空闲被踢掉的用户数
+
+	floodKicks uint64 // 超出入站流量限制被断开的连接数
+
+	announcements uint64 // 服务器自己发的系统广播数, 见announce.go
+
+	publicMsgs uint64 // 发出的公聊消息数, 见welcome.go
+
+	fanout fanoutHistogram // 每条广播发给全部本地用户用了多久
+
+	started time.Time  // 开始监听的时间
+	recent  rateWindow // 最近一分钟的消息数
+}
+
+// 最近一分钟的消息速率: 每秒记一次消息总数, 用最早和最新的两次相减
+type rateWindow struct {
+	lock    sync.Mutex
+	samples [61]rateSample // 61个采样之间正好是60秒
+	next    int
+	count   int
+}
+
+type rateSample struct {
+	at       time.Time
+	messages uint64
+}
+
+// 记一次采样, 满了之后覆盖最早的
+func (this *rateWindow) add(at time.Time, messages uint64) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	this.samples[this.next] = rateSample{at, messages}
+	this.next = (this.next + 1) % len(this.samples)
+	if this.count < len(this.samples) {
+		this.count++
+	}
+}
+
+// 最早一次采样到now之间每秒的消息数, 还没有采样时返回0
+func (this *rateWindow) perSecond(now time.Time, messages uint64) float64 {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if this.count == 0 {
+		return 0
+	}
+	oldest := this.samples[(this.next-this.count+len(this.samples))%len(this.samples)]
+	seconds := now.Sub(oldest.at).Seconds()
+	if seconds <= 0 {
+		return 0
+	}
+	return float64(messages-oldest.messages) / seconds
+}
+
+// 广播耗时的分布, 每个桶是耗时不超过这个值的次数, 最后一个桶是超过最大值的次数
+var fanoutBounds = []time.Duration{time.Millisecond, 10 * time.Millisecond, 100 * time.Millisecond, time.Second}
+
+type fanoutHistogram struct {
+	counts [5]uint64 // 比fanoutBounds多一个桶
+}
+
+// 记录一次广播的耗时, 只有一次原子加法
+func (this *fanoutHistogram) observe(d time.Duration) {
+	i := 0
+	for i < len(fanoutBounds) && d > fanoutBounds[i] {
+		i++
+	}
+	atomic.AddUint64(&this.counts[i], 1)
+}
+
+// 输出成一项, 如 1ms:10,10ms:2,100ms:0,1s:0,inf:0, 是启动以来的累计次数
+func (this *fanoutHistogram) String() string {
+	parts := make([]string, 0, len(this.counts))
+	for i := range this.counts {
+		bound := "inf"
+		if i < len(fanoutBounds) {
+			bound = fanoutBounds[i].String()
+		}
+		parts = append(parts, bound+":"+strconv.FormatUint(atomic.LoadUint64(&this.counts[i]), 10))
+	}
+	return strings.Join(parts, ",")
+}
+
+// 记录收到的一条消息
+func (this *serverStats) received(line string) {
+	atomic.AddUint64(&this.messages, 1)
+	atomic.AddUint64(&this.bytesIn, uint64(len(line)))
+}
+
+// 在线用户数
+func (this *Server) OnlineCount() int {
+	this.mapLock.RLock()
+	defer this.mapLock.RUnlock()
+
+	return len(this.OnlineMap)
+}
+
+// 运行状态的一次采样, 两次采样相减得到速率
+type statusSample struct {
+	at       time.Time
+	messages uint64
+	bytes    uint64
+}
+
+func (this *Server) sampleStatus() statusSample {
+	return statusSample{
+		at:       this.Clock.Now(),
+		messages: atomic.LoadUint64(&this.stats.messages),
+		bytes:    atomic.LoadUint64(&this.stats.bytesIn),
+	}
+}
+
+// 一行运行状态, 速率按last到now之间的增量计算
+func (this *Server) statusLine(last, now statusSample) string {
+	seconds := now.at.Sub(last.at).Seconds()
+	lockdown := "off"
+	if this.Lockdown() {
+		lockdown = "on"
+	}
+	return fmt.Sprintf("status online=%d msgs_per_sec=%.2f bytes_per_sec=%.2f goroutines=%d lockdown=%s queue=%d fanout=%s",
+		this.OnlineCount(),
+		float64(now.messages-last.messages)/seconds,
+		float64(now.bytes-last.bytes)/seconds,
+		runtime.NumGoroutine(),
+		lockdown,
+		len(this.Message),
+		&this.stats.fanout)
+}
+
+// 每隔interval输出一行运行状态, 速率按上一次输出以来的增量计算
+func (this *Server) statusLoop(interval time.Duration) {
+	ticker := this.Clock.NewTicker(interval)
+	defer ticker.Stop()
+
+	last := this.sampleStatus()
+	for {
+		select {
+		case <-ticker.C():
+		case <-this.stopped:
+			return
+		}
+		now := this.sampleStatus()
+		if !now.at.After(last.at) {
+			continue
+		}
+		fmt.Println(this.statusLine(last, now))
+		last = now
+	}
+}
+
+// 每秒采样一次消息数, 给stats计算最近一分钟的速率
+func (this *Server) sampleLoop() {
+	ticker := this.Clock.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	this.stats.recent.add(this.Clock.Now(), atomic.LoadUint64(&this.stats.messages))
+	for {
+		select {
+		case now := <-ticker.C():
+			this.stats.recent.add(now, atomic.LoadUint64(&this.stats.messages))
+		case <-this.stopped:
+			return
+		}
+	}
+}
+
+// stats命令返回的运行状态
+type statsReport struct {
+	Name          string  `json:"name"`
+	Version       string  `json:"version"`
+	UptimeSeconds int64   `json:"uptime_seconds"`
+	Online        int     `json:"online"`
+	Messages      uint64  `json:"messages"`
+	BytesIn       uint64  `json:"bytes_in"`
+	MsgsPerSec    float64 `json:"msgs_per_sec"` // 最近一分钟的平均值
+	Kicked        uint64  `json:"kicked"`
+	FloodKicks    uint64  `json:"flood_kicks"`
+	Announcements uint64  `json:"announcements"`
+	HistoryDrops  uint64  `json:"history_drops"` // feed满了没有记入历史的公聊消息数, 见history.go
+	Goroutines    int     `json:"goroutines"`
+	HeapAlloc     uint64  `json:"heap_alloc"` // 字节数
+	Sys           uint64  `json:"sys"`        // 从系统申请的内存, 字节数
+	Queue         int     `json:"queue"`
+	Fanout        string  `json:"fanout"`
+}
+
+// 开始监听以来运行了多久, 还没有开始监听时是0
+func (this *Server) Uptime() time.Duration {
+	if this.stats.started.IsZero() {
+		return 0
+	}
+	return this.Clock.Now().Sub(this.stats.started)
+}
+
+// 当前的运行状态, 跟statusLoop用的是同样的计数器
+func (this *Server) Stats() statsReport {
+	now := this.Clock.Now()
+	messages := atomic.LoadUint64(&this.stats.messages)
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	return statsReport{
+		Name:          this.Name,
+		Version:       version,
+		UptimeSeconds: int64(now.Sub(this.stats.started).Seconds()),
+		Online:        this.OnlineCount(),
+		Messages:      messages,
+		BytesIn:       atomic.LoadUint64(&this.stats.bytesIn),
+		MsgsPerSec:    this.stats.recent.perSecond(now, messages),
+		Kicked:        atomic.LoadUint64(&this.stats.kicked),
+		FloodKicks:    atomic.LoadUint64(&this.stats.floodKicks),
+		Announcements: atomic.LoadUint64(&this.stats.announcements),
+		HistoryDrops:  this.history.Dropped(),
+		Goroutines:    runtime.NumGoroutine(),
+		HeapAlloc:     mem.HeapAlloc,
+		Sys:           mem.Sys,
+		Queue:         len(this.Message),
+		Fanout:        this.stats.fanout.String(),
+	}
+}
+
+// 查看运行状态, 消息格式: stats 或 stats|json, 只回复给发送的用户
+// 默认只有管理员可以查看, 开启 -public-stats 时所有人都可以
+func (this *User) DoStats(arg string) {
+	if !this.IsAdmin() && !this.server.PublicStats {
+		this.errorReply(ErrPermission, "stats.admin_only")
+		return
+	}
+	r := this.server.Stats()
+	switch arg {
+	case "":
+		this.SendMsg(fmt.Sprintf("stats name=%q version=%s uptime=%s online=%d messages=%d bytes_in=%d msgs_per_sec=%.2f kicked=%d flood_kicks=%d announcements=%d history_drops=%d goroutines=%d heap_alloc=%d sys=%d queue=%d fanout=%s\n",
+			r.Name, r.Version, time.Duration(r.UptimeSeconds)*time.Second, r.Online, r.Messages, r.BytesIn, r.MsgsPerSec,
+			r.Kicked, r.FloodKicks, r.Announcements, r.HistoryDrops, r.Goroutines, r.HeapAlloc, r.Sys, r.Queue, r.Fanout))
+	case "json":
+		data, _ := json.Marshal(r)
+		this.SendMsg(string(data) + "\n")
+	default:
+		this.errorReply(ErrUsage, "stats.usage")
+	}
+}
+
+// 统计读写字节数的连接, 每个TCP连接都会包一层
+type countingConn struct {
+	net.Conn
+	read    uint64
+	written uint64
+}
+
+func (this *countingConn) Read(b []byte) (int, error) {
+	n, err := this.Conn.Read(b)
+	atomic.AddUint64(&this.read, uint64(n))
+	return n, err
+}
+
+func (this *countingConn) Write(b []byte) (int, error) {
+	n, err := this.Conn.Write(b)
+	atomic.AddUint64(&this.written, uint64(n))
+	return n, err
+}
+
+// 这个连接收到和发出的字节数, 包括协议头部之后的全部数据
+// 不是TCP连接的用户(例如gRPC订阅)返回false
+func (this *User) Traffic() (in uint64, out uint64, ok bool) {
+	c, ok := this.conn.(*countingConn)
+	if !ok {
+		return 0, 0, false
+	}
+	return atomic.LoadUint64(&c.read), atomic.LoadUint64(&c.written), true
+}
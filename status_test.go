@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 状态行的速率按两次采样之间的增量计算
+func TestStatusLine(t *testing.T) {
+	clock := NewFakeClock(testEpoch)
+	server := startTestServer(t, WithClock(clock))
+	alice := dialTest(t, server, "alice")
+
+	// 10秒里20条消息, 每条算上换行7个字节
+	last := server.sampleStatus()
+	for i := 0; i < 20; i++ {
+		alice.send(fmt.Sprintf("msg-%02d", i))
+	}
+	alice.waitFor("msg-19")
+	clock.Advance(10 * time.Second)
+
+	line := server.statusLine(last, server.sampleStatus())
+	if !strings.HasPrefix(line, "status online=1 msgs_per_sec=2.00 bytes_per_sec=14.00 ") {
+		t.Fatalf("status = %q", line)
+	}
+}
+
+// stats 默认只有管理员能用, 只回复给自己; stats|json 是同样的内容
+func TestStatsCommand(t *testing.T) {
+	clock := NewFakeClock(testEpoch)
+	server := startTestServer(t, WithClock(clock), WithAdminToken("secret"))
+	alice := dialTest(t, server, "alice")
+	admin := dialAdmin(t, server, "admin")
+
+	alice.send("stats")
+	alice.waitFor(text("stats.admin_only"))
+	admin.send("stats|xml")
+	admin.waitFor(text("stats.usage"))
+
+	clock.Advance(90 * time.Second)
+	admin.send("stats|json")
+	var r statsReport
+	if err := json.Unmarshal([]byte(admin.waitFor(`{"name":`)), &r); err != nil {
+		t.Fatal(err)
+	}
+	if r.Version != version || r.UptimeSeconds != 90 || r.Online != 2 || r.Messages == 0 || r.BytesIn == 0 {
+		t.Fatalf("stats|json = %+v", r)
+	}
+	admin.send("stats")
+	admin.waitFor(fmt.Sprintf("stats name=%q version=%s uptime=1m30s online=2 ", server.Name, version))
+
+	alice.send("sync-alice")
+	alice.waitFor("sync-alice")
+	if alice.saw("stats name=") || alice.saw(`{"name":`) {
+		t.Fatal("alice 收到了管理员的 stats")
+	}
+}
+
+// 开启 -public-stats 时所有人都可以查看
+func TestPublicStats(t *testing.T) {
+	server := startTestServer(t, WithPublicStats(true))
+	alice := dialTest(t, server, "alice")
+	alice.send("stats")
+	alice.waitFor("stats name=")
+}
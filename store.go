@@ -0,0 +1,462 @@
+// 持久化存储: 需要跨连接保存的数据都通过Store读写, 用 -store 选择实现
+// mem 只保存在内存里, 重启后丢失; file 保存在一个JSON文件里; sqlite 需要用 -tags sqlite 编译, 见store_sqlite.go
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 需要跨连接保存的数据都通过Store读写
+// 每种数据一组方法, 每种实现都要支持; 以后增加的持久化数据也在这里加一组
+type Store interface {
+	// 读取某个用户名的个人设置, 没有保存过时返回空
+	LoadPrefs(name string) (map[string]string, error)
+	// 保存某个用户名的个人设置
+	SavePrefs(name string, prefs map[string]string) error
+	// 保存过设置的全部用户名, 清理过期数据时使用, 见storegc.go
+	Names() ([]string, error)
+	// 一次删掉多个用户名的设置
+	DeletePrefs(names []string) error
+
+	// 读取名字最后的使用者(见names.go), 没有记录时返回false
+	LoadNameOwner(name string) (nameOwner, bool, error)
+	// 保存名字最后的使用者
+	SaveNameOwner(name string, owner nameOwner) error
+	// 全部名字记录, 清理过期数据时使用
+	ListNameOwners() (map[string]nameOwner, error)
+	// 删掉这些名字的记录, 没有记录的名字不算错
+	DeleteNameOwner(names ...string) error
+
+	// 读取名字的离线记录(见welcome.go), 没有记录时返回false
+	LoadLastSeen(name string) (lastSeen, bool, error)
+	// 保存名字的离线记录
+	SaveLastSeen(name string, seen lastSeen) error
+	// 全部离线记录, 清理过期数据时使用
+	ListLastSeen() (map[string]lastSeen, error)
+	// 删掉这些名字的离线记录, 没有记录的名字不算错
+	DeleteLastSeen(names ...string) error
+}
+
+// 打开一种Store, path是文件路径或者连接串, 由各个实现自己解释
+type storeOpener func(path string) (Store, error)
+
+// 可以用 -store 选择的实现, 需要编译标签的实现在自己的文件里注册
+var storeOpeners = map[string]storeOpener{
+	"mem": func(path string) (Store, error) {
+		return NewMemStore(), nil
+	},
+	"file": func(path string) (Store, error) {
+		if path == "" {
+			return nil, errors.New("file 需要用 -store-path 指定文件")
+		}
+		return NewFileStore(path)
+	},
+}
+
+// 全部实现的名字, 按字母排序
+func storeKinds() []string {
+	kinds := make([]string, 0, len(storeOpeners))
+	for kind := range storeOpeners {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	return kinds
+}
+
+// 按名字打开Store
+func OpenStore(kind, path string) (Store, error) {
+	open, ok := storeOpeners[kind]
+	if !ok {
+		if kind == "sqlite" {
+			return nil, errors.New("sqlite 需要用 -tags sqlite 编译")
+		}
+		return nil, fmt.Errorf("-store 只能是 %s", strings.Join(storeKinds(), "/"))
+	}
+	return open(path)
+}
+
+// 只保存在内存里的Store, 断线重连后个人设置还在, 重启后丢失
+type MemStore struct {
+	lock   sync.Mutex
+	prefs  map[string]map[string]string
+	owners map[string]nameOwner
+	seen   map[string]lastSeen
+}
+
+func NewMemStore() *MemStore {
+	return &MemStore{
+		prefs:  make(map[string]map[string]string),
+		owners: make(map[string]nameOwner),
+		seen:   make(map[string]lastSeen),
+	}
+}
+
+func (this *MemStore) LoadPrefs(name string) (map[string]string, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	return copyPrefs(this.prefs[name]), nil
+}
+
+func (this *MemStore) SavePrefs(name string, prefs map[string]string) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	setPrefs(this.prefs, name, prefs)
+	return nil
+}
+
+func (this *MemStore) Names() ([]string, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	return prefNames(this.prefs), nil
+}
+
+func (this *MemStore) DeletePrefs(names []string) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	for _, name := range names {
+		delete(this.prefs, name)
+	}
+	return nil
+}
+
+func (this *MemStore) LoadNameOwner(name string) (nameOwner, bool, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	owner, ok := this.owners[name]
+	return owner, ok, nil
+}
+
+func (this *MemStore) SaveNameOwner(name string, owner nameOwner) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	this.owners[name] = owner
+	return nil
+}
+
+func (this *MemStore) ListNameOwners() (map[string]nameOwner, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	return maps.Clone(this.owners), nil
+}
+
+func (this *MemStore) DeleteNameOwner(names ...string) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	for _, name := range names {
+		delete(this.owners, name)
+	}
+	return nil
+}
+
+func (this *MemStore) LoadLastSeen(name string) (lastSeen, bool, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	seen, ok := this.seen[name]
+	return seen, ok, nil
+}
+
+func (this *MemStore) SaveLastSeen(name string, seen lastSeen) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	this.seen[name] = seen
+	return nil
+}
+
+func (this *MemStore) ListLastSeen() (map[string]lastSeen, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	return maps.Clone(this.seen), nil
+}
+
+func (this *MemStore) DeleteLastSeen(names ...string) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	for _, name := range names {
+		delete(this.seen, name)
+	}
+	return nil
+}
+
+// 全部用户名, 按字母排序
+func prefNames(all map[string]map[string]string) []string {
+	names := make([]string, 0, len(all))
+	for name := range all {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// 复制一份个人设置, 调用方修改时不影响Store里的数据
+func copyPrefs(prefs map[string]string) map[string]string {
+	c := make(map[string]string, len(prefs))
+	for k, v := range prefs {
+		c[k] = v
+	}
+	return c
+}
+
+// 保存一个用户名的个人设置, 没有设置时删掉这个用户名
+func setPrefs(all map[string]map[string]string, name string, prefs map[string]string) {
+	if len(prefs) == 0 {
+		delete(all, name)
+		return
+	}
+	all[name] = copyPrefs(prefs)
+}
+
+// 保存在一个JSON文件里的Store
+// 每次保存都要重写整个文件; 同时有多个保存时, 正在写文件期间的修改合并到下一次写, 见flush
+type FileStore struct {
+	path string
+
+	lock   sync.Mutex
+	prefs  map[string]map[string]string
+	owners map[string]nameOwner
+	seen   map[string]lastSeen
+	dirty  bool // 有还没写进文件的修改
+
+	writeLock sync.Mutex // 同一时间只有一个goroutine写文件
+	writes    int        // 写文件的次数, 由writeLock保护
+}
+
+// 文件的格式; 以前的文件只有个人设置, 整个文件就是prefs, 没有version
+type fileStoreData struct {
+	Version int                          `json:"version"`
+	Prefs   map[string]map[string]string `json:"prefs"`
+	Owners  map[string]fileNameOwner     `json:"owners,omitempty"`
+	Seen    map[string]fileLastSeen      `json:"seen,omitempty"`
+}
+
+// 文件里的名字记录
+type fileNameOwner struct {
+	Session uint64 `json:"session"`
+	IP      string `json:"ip"`
+	Until   int64  `json:"until"` // unix秒
+}
+
+// 文件里的离线记录
+type fileLastSeen struct {
+	At     int64  `json:"at"` // unix秒
+	Public uint64 `json:"public"`
+	PMs    int    `json:"pms"`
+}
+
+// 现在的文件格式版本
+const fileStoreVersion = 2
+
+// 打开JSON文件, 文件不存在时会在第一次保存时创建
+func NewFileStore(path string) (*FileStore, error) {
+	store := &FileStore{
+		path:   path,
+		prefs:  make(map[string]map[string]string),
+		owners: make(map[string]nameOwner),
+		seen:   make(map[string]lastSeen),
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var top map[string]json.RawMessage
+	if err := json.Unmarshal(data, &top); err != nil {
+		return nil, err
+	}
+	var version int
+	if json.Unmarshal(top["version"], &version) != nil {
+		// 以前的格式
+		if err := json.Unmarshal(data, &store.prefs); err != nil {
+			return nil, err
+		}
+		return store, nil
+	}
+
+	var file fileStoreData
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+	if file.Prefs != nil {
+		store.prefs = file.Prefs
+	}
+	for name, o := range file.Owners {
+		store.owners[name] = nameOwner{session: o.Session, ip: o.IP, until: time.Unix(o.Until, 0)}
+	}
+	for name, s := range file.Seen {
+		store.seen[name] = lastSeen{at: time.Unix(s.At, 0), public: s.Public, pms: s.PMs}
+	}
+	return store, nil
+}
+
+func (this *FileStore) LoadPrefs(name string) (map[string]string, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	return copyPrefs(this.prefs[name]), nil
+}
+
+func (this *FileStore) SavePrefs(name string, prefs map[string]string) error {
+	this.lock.Lock()
+
+	setPrefs(this.prefs, name, prefs)
+	this.dirty = true
+	this.lock.Unlock()
+	return this.flush()
+}
+
+func (this *FileStore) Names() ([]string, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	return prefNames(this.prefs), nil
+}
+
+// 全部删完后只写一次文件
+func (this *FileStore) DeletePrefs(names []string) error {
+	this.lock.Lock()
+	for _, name := range names {
+		delete(this.prefs, name)
+	}
+	this.dirty = true
+	this.lock.Unlock()
+	return this.flush()
+}
+
+func (this *FileStore) LoadNameOwner(name string) (nameOwner, bool, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	owner, ok := this.owners[name]
+	return owner, ok, nil
+}
+
+func (this *FileStore) SaveNameOwner(name string, owner nameOwner) error {
+	this.lock.Lock()
+	this.owners[name] = owner
+	this.dirty = true
+	this.lock.Unlock()
+	return this.flush()
+}
+
+func (this *FileStore) ListNameOwners() (map[string]nameOwner, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	return maps.Clone(this.owners), nil
+}
+
+func (this *FileStore) DeleteNameOwner(names ...string) error {
+	this.lock.Lock()
+	for _, name := range names {
+		delete(this.owners, name)
+	}
+	this.dirty = true
+	this.lock.Unlock()
+	return this.flush()
+}
+
+func (this *FileStore) LoadLastSeen(name string) (lastSeen, bool, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	seen, ok := this.seen[name]
+	return seen, ok, nil
+}
+
+func (this *FileStore) SaveLastSeen(name string, seen lastSeen) error {
+	this.lock.Lock()
+	this.seen[name] = seen
+	this.dirty = true
+	this.lock.Unlock()
+	return this.flush()
+}
+
+func (this *FileStore) ListLastSeen() (map[string]lastSeen, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	return maps.Clone(this.seen), nil
+}
+
+func (this *FileStore) DeleteLastSeen(names ...string) error {
+	this.lock.Lock()
+	for _, name := range names {
+		delete(this.seen, name)
+	}
+	this.dirty = true
+	this.lock.Unlock()
+	return this.flush()
+}
+
+// 文件的内容, 调用时需要持有lock
+func (this *FileStore) marshal() ([]byte, error) {
+	file := fileStoreData{
+		Version: fileStoreVersion,
+		Prefs:   this.prefs,
+		Owners:  make(map[string]fileNameOwner, len(this.owners)),
+		Seen:    make(map[string]fileLastSeen, len(this.seen)),
+	}
+	for name, o := range this.owners {
+		file.Owners[name] = fileNameOwner{Session: o.session, IP: o.ip, Until: o.until.Unix()}
+	}
+	for name, s := range this.seen {
+		file.Seen[name] = fileLastSeen{At: s.at.Unix(), Public: s.public, PMs: s.pms}
+	}
+	return json.MarshalIndent(file, "", "  ")
+}
+
+// 把全部数据写回文件, 先写临时文件再改名, 调用时不能持有lock
+// 等writeLock的时候别人可能已经把这次的修改一起写进去了, 这时不用再写; 写失败时留给下一次重试
+func (this *FileStore) flush() error {
+	this.writeLock.Lock()
+	defer this.writeLock.Unlock()
+
+	this.lock.Lock()
+	if !this.dirty {
+		this.lock.Unlock()
+		return nil
+	}
+	data, err := this.marshal()
+	this.dirty = false
+	this.lock.Unlock()
+	if err == nil {
+		err = this.writeFile(data)
+	}
+	if err != nil {
+		this.lock.Lock()
+		this.dirty = true
+		this.lock.Unlock()
+	}
+	return err
+}
+
+func (this *FileStore) writeFile(data []byte) error {
+	this.writes++
+	tmp := this.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, this.path)
+}
@@ -0,0 +1,282 @@
+//go:build sqlite
+
+// 保存在SQLite数据库里的Store, 需要用 -tags sqlite 编译, 依赖 github.com/mattn/go-sqlite3(需要cgo)
+// 表结构在 migrations/sqlite 里, 打开时按文件名顺序执行还没有执行过的
+package main
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+//go:embed migrations/sqlite/*.sql
+var sqliteMigrations embed.FS
+
+func init() {
+	storeOpeners["sqlite"] = func(dsn string) (Store, error) {
+		if dsn == "" {
+			return nil, fmt.Errorf("sqlite 需要用 -store-path 指定数据库文件")
+		}
+		return NewSQLiteStore(dsn)
+	}
+}
+
+type SQLiteStore struct {
+	db *sql.DB
+}
+
+// 打开数据库并执行没有执行过的迁移
+func NewSQLiteStore(dsn string) (*SQLiteStore, error) {
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite同一时间只能有一个写入
+	db.SetMaxOpenConns(1)
+
+	if err := migrateSQLite(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &SQLiteStore{db: db}, nil
+}
+
+// 迁移文件名以版本号开头, 如 001_prefs.sql, 执行过的版本记在 schema_migrations 里
+func migrateSQLite(db *sql.DB) error {
+	if _, err := db.Exec("CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY)"); err != nil {
+		return err
+	}
+
+	names, err := sqliteMigrations.ReadDir("migrations/sqlite")
+	if err != nil {
+		return err
+	}
+	sort.Slice(names, func(i, j int) bool { return names[i].Name() < names[j].Name() })
+
+	for _, entry := range names {
+		prefix, _, _ := strings.Cut(entry.Name(), "_")
+		version, err := strconv.Atoi(prefix)
+		if err != nil {
+			return fmt.Errorf("迁移文件名要以版本号开头: %s", entry.Name())
+		}
+
+		var done int
+		if err := db.QueryRow("SELECT COUNT(*) FROM schema_migrations WHERE version = ?", version).Scan(&done); err != nil {
+			return err
+		}
+		if done > 0 {
+			continue
+		}
+
+		script, err := sqliteMigrations.ReadFile(path.Join("migrations/sqlite", entry.Name()))
+		if err != nil {
+			return err
+		}
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(string(script)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("%s: %v", entry.Name(), err)
+		}
+		if _, err := tx.Exec("INSERT INTO schema_migrations (version) VALUES (?)", version); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (this *SQLiteStore) LoadPrefs(name string) (map[string]string, error) {
+	rows, err := this.db.Query("SELECT key, value FROM prefs WHERE name = ?", name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	prefs := make(map[string]string)
+	for rows.Next() {
+		var k, v string
+		if err := rows.Scan(&k, &v); err != nil {
+			return nil, err
+		}
+		prefs[k] = v
+	}
+	return prefs, rows.Err()
+}
+
+// 先删掉这个用户名的全部设置再写入, 在一个事务里完成
+func (this *SQLiteStore) SavePrefs(name string, prefs map[string]string) error {
+	tx, err := this.db.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM prefs WHERE name = ?", name); err != nil {
+		tx.Rollback()
+		return err
+	}
+	for k, v := range prefs {
+		if _, err := tx.Exec("INSERT INTO prefs (name, key, value) VALUES (?, ?, ?)", name, k, v); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (this *SQLiteStore) Names() ([]string, error) {
+	rows, err := this.db.Query("SELECT DISTINCT name FROM prefs ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+// 在一个事务里删掉全部用户名的设置
+func (this *SQLiteStore) DeletePrefs(names []string) error {
+	tx, err := this.db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if _, err := tx.Exec("DELETE FROM prefs WHERE name = ?", name); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (this *SQLiteStore) LoadNameOwner(name string) (nameOwner, bool, error) {
+	var owner nameOwner
+	var until int64
+	err := this.db.QueryRow("SELECT session, ip, until FROM name_owners WHERE name = ?", name).Scan(&owner.session, &owner.ip, &until)
+	if err == sql.ErrNoRows {
+		return nameOwner{}, false, nil
+	}
+	if err != nil {
+		return nameOwner{}, false, err
+	}
+	owner.until = time.Unix(until, 0)
+	return owner, true, nil
+}
+
+func (this *SQLiteStore) SaveNameOwner(name string, owner nameOwner) error {
+	_, err := this.db.Exec("INSERT OR REPLACE INTO name_owners (name, session, ip, until) VALUES (?, ?, ?, ?)",
+		name, owner.session, owner.ip, owner.until.Unix())
+	return err
+}
+
+func (this *SQLiteStore) ListNameOwners() (map[string]nameOwner, error) {
+	rows, err := this.db.Query("SELECT name, session, ip, until FROM name_owners")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	owners := make(map[string]nameOwner)
+	for rows.Next() {
+		var name string
+		var owner nameOwner
+		var until int64
+		if err := rows.Scan(&name, &owner.session, &owner.ip, &until); err != nil {
+			return nil, err
+		}
+		owner.until = time.Unix(until, 0)
+		owners[name] = owner
+	}
+	return owners, rows.Err()
+}
+
+// 在一个事务里删掉这些名字的记录
+func (this *SQLiteStore) DeleteNameOwner(names ...string) error {
+	tx, err := this.db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if _, err := tx.Exec("DELETE FROM name_owners WHERE name = ?", name); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (this *SQLiteStore) LoadLastSeen(name string) (lastSeen, bool, error) {
+	var seen lastSeen
+	var at int64
+	err := this.db.QueryRow("SELECT at, public, pms FROM last_seen WHERE name = ?", name).Scan(&at, &seen.public, &seen.pms)
+	if err == sql.ErrNoRows {
+		return lastSeen{}, false, nil
+	}
+	if err != nil {
+		return lastSeen{}, false, err
+	}
+	seen.at = time.Unix(at, 0)
+	return seen, true, nil
+}
+
+func (this *SQLiteStore) SaveLastSeen(name string, seen lastSeen) error {
+	_, err := this.db.Exec("INSERT OR REPLACE INTO last_seen (name, at, public, pms) VALUES (?, ?, ?, ?)",
+		name, seen.at.Unix(), seen.public, seen.pms)
+	return err
+}
+
+func (this *SQLiteStore) ListLastSeen() (map[string]lastSeen, error) {
+	rows, err := this.db.Query("SELECT name, at, public, pms FROM last_seen")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	all := make(map[string]lastSeen)
+	for rows.Next() {
+		var name string
+		var seen lastSeen
+		var at int64
+		if err := rows.Scan(&name, &at, &seen.public, &seen.pms); err != nil {
+			return nil, err
+		}
+		seen.at = time.Unix(at, 0)
+		all[name] = seen
+	}
+	return all, rows.Err()
+}
+
+// 在一个事务里删掉这些名字的离线记录
+func (this *SQLiteStore) DeleteLastSeen(names ...string) error {
+	tx, err := this.db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if _, err := tx.Exec("DELETE FROM last_seen WHERE name = ?", name); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,20 @@
+//go:build sqlite
+
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestSQLiteStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "prefs.db")
+	open := func() Store {
+		store, err := NewSQLiteStore(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return store
+	}
+	testStore(t, open(), open)
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// 每种Store都要通过的检查; reopen不为nil时重新打开同一份数据, 检查保存的内容还在
+func testStore(t *testing.T, store Store, reopen func() Store) {
+	t.Helper()
+	check := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	load := func(s Store, name string) map[string]string {
+		t.Helper()
+		prefs, err := s.LoadPrefs(name)
+		check(err)
+		return prefs
+	}
+	names := func(s Store) string {
+		t.Helper()
+		list, err := s.Names()
+		check(err)
+		return strings.Join(list, ",")
+	}
+
+	if prefs := load(store, "nobody"); len(prefs) != 0 {
+		t.Fatalf("没有保存过的用户名 = %v", prefs)
+	}
+
+	// 保存的是一份复制, 调用方之后改map不影响Store
+	prefs := map[string]string{"away": "午饭", "lang": "en"}
+	check(store.SavePrefs("bob", prefs))
+	prefs["away"] = "改了"
+	if got := load(store, "bob"); !reflect.DeepEqual(got, map[string]string{"away": "午饭", "lang": "en"}) {
+		t.Fatalf("LoadPrefs(bob) = %v", got)
+	}
+	load(store, "bob")["away"] = "也改了"
+	if load(store, "bob")["away"] != "午饭" {
+		t.Fatal("修改读出来的map影响了Store")
+	}
+
+	// 再次保存是整个替换, 不是合并
+	check(store.SavePrefs("bob", map[string]string{"history": "off"}))
+	if got := load(store, "bob"); !reflect.DeepEqual(got, map[string]string{"history": "off"}) {
+		t.Fatalf("替换以后 LoadPrefs(bob) = %v", got)
+	}
+
+	// 用户名按字母排序; 保存空的设置等于删掉
+	check(store.SavePrefs("dave", map[string]string{"lang": "en"}))
+	check(store.SavePrefs("alice", map[string]string{"away": "开会"}))
+	check(store.SavePrefs("carol", map[string]string{"away": "x"}))
+	check(store.SavePrefs("carol", map[string]string{}))
+	if got := names(store); got != "alice,bob,dave" {
+		t.Fatalf("Names = %s", got)
+	}
+
+	// 一次删掉多个, 不存在的用户名不算错
+	check(store.DeletePrefs([]string{"dave", "nobody"}))
+	if got := names(store); got != "alice,bob" {
+		t.Fatalf("删除以后 Names = %s", got)
+	}
+
+	// 名字记录和个人设置分开保存, 不出现在Names里
+	owner := nameOwner{session: 7, ip: "10.0.0.1", until: testEpoch}
+	if _, ok, err := store.LoadNameOwner("alice"); err != nil || ok {
+		t.Fatalf("没有保存过的名字记录 = %v %v", ok, err)
+	}
+	check(store.SaveNameOwner("alice", owner))
+	check(store.SaveNameOwner("erin", nameOwner{session: 8, ip: "10.0.0.2", until: testEpoch}))
+	if got, ok, err := store.LoadNameOwner("alice"); err != nil || !ok || got.session != 7 || got.ip != "10.0.0.1" || !got.until.Equal(testEpoch) {
+		t.Fatalf("LoadNameOwner(alice) = %+v %v %v", got, ok, err)
+	}
+	if got := names(store); got != "alice,bob" {
+		t.Fatalf("保存名字记录以后 Names = %s", got)
+	}
+	check(store.DeleteNameOwner("erin", "nobody"))
+	if all, err := store.ListNameOwners(); err != nil || len(all) != 1 || all["alice"].session != 7 {
+		t.Fatalf("ListNameOwners = %v %v", all, err)
+	}
+
+	// 离线记录也单独保存
+	if _, ok, err := store.LoadLastSeen("alice"); err != nil || ok {
+		t.Fatalf("没有保存过的离线记录 = %v %v", ok, err)
+	}
+	check(store.SaveLastSeen("alice", lastSeen{at: testEpoch, public: 12, pms: 3}))
+	check(store.SaveLastSeen("erin", lastSeen{at: testEpoch}))
+	if got, ok, err := store.LoadLastSeen("alice"); err != nil || !ok || got.public != 12 || got.pms != 3 || !got.at.Equal(testEpoch) {
+		t.Fatalf("LoadLastSeen(alice) = %+v %v %v", got, ok, err)
+	}
+	check(store.DeleteLastSeen("erin", "nobody"))
+	if all, err := store.ListLastSeen(); err != nil || len(all) != 1 || all["alice"].pms != 3 {
+		t.Fatalf("ListLastSeen = %v %v", all, err)
+	}
+	if got := names(store); got != "alice,bob" {
+		t.Fatalf("保存离线记录以后 Names = %s", got)
+	}
+
+	if reopen == nil {
+		return
+	}
+	again := reopen()
+	if got := names(again); got != "alice,bob" {
+		t.Fatalf("重新打开以后 Names = %s", got)
+	}
+	if got := load(again, "alice"); !reflect.DeepEqual(got, map[string]string{"away": "开会"}) {
+		t.Fatalf("重新打开以后 LoadPrefs(alice) = %v", got)
+	}
+	if got, ok, err := again.LoadNameOwner("alice"); err != nil || !ok || got.session != 7 || !got.until.Equal(testEpoch) {
+		t.Fatalf("重新打开以后 LoadNameOwner(alice) = %+v %v %v", got, ok, err)
+	}
+	if got, ok, err := again.LoadLastSeen("alice"); err != nil || !ok || got.public != 12 || got.pms != 3 || !got.at.Equal(testEpoch) {
+		t.Fatalf("重新打开以后 LoadLastSeen(alice) = %+v %v %v", got, ok, err)
+	}
+}
+
+func TestMemStore(t *testing.T) {
+	testStore(t, NewMemStore(), nil)
+}
+
+func TestFileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "prefs.json")
+	open := func() Store {
+		store, err := NewFileStore(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return store
+	}
+	testStore(t, open(), open)
+}
+
+// 以前的文件整个就是个人设置, 还能读出来
+func TestFileStoreOldFormat(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "prefs.json")
+	if err := os.WriteFile(path, []byte(`{"alice":{"away":"开会"}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	store, err := NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prefs, _ := store.LoadPrefs("alice"); prefs["away"] != "开会" {
+		t.Fatalf("LoadPrefs(alice) = %v", prefs)
+	}
+	if owners, _ := store.ListNameOwners(); len(owners) != 0 {
+		t.Fatalf("ListNameOwners = %v", owners)
+	}
+	if seen, _ := store.ListLastSeen(); len(seen) != 0 {
+		t.Fatalf("ListLastSeen = %v", seen)
+	}
+}
+
+func TestOpenStore(t *testing.T) {
+	if _, err := OpenStore("mem", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenStore("file", ""); err == nil {
+		t.Fatal("file 没有路径也打开了")
+	}
+	if _, err := OpenStore("redis", "x"); err == nil {
+		t.Fatal("不认识的实现也打开了")
+	}
+}
+
+// 正在写文件时的多次保存合并成一次写
+func TestFileStoreBatchesWrites(t *testing.T) {
+	store, err := NewFileStore(filepath.Join(t.TempDir(), "prefs.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	const n = 20
+
+	// 假装有一次写文件还没有完成, 这期间的保存都在等它
+	store.writeLock.Lock()
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := store.SavePrefs(fmt.Sprint("user", i), map[string]string{"away": "x"}); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	eventually(t, "保存没有开始", func() bool {
+		store.lock.Lock()
+		defer store.lock.Unlock()
+		return len(store.prefs) == n
+	})
+	store.writeLock.Unlock()
+	wg.Wait()
+
+	if store.writes != 1 {
+		t.Fatalf("写了 %d 次文件", store.writes)
+	}
+	reopened, err := NewFileStore(store.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list, _ := reopened.Names(); len(list) != n {
+		t.Fatalf("文件里有 %d 个用户名", len(list))
+	}
+}
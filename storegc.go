@@ -0,0 +1,296 @@
+// 清理Store: guest来来去去, 离线记录、名字记录和个人设置只增不减
+// 离线记录(见welcome.go)和名字记录(见names.go)超过 -store-ttl 没有更新的删掉
+// 保存的用户名超过 -store-max-names 个时, 最久没用过的用户名连同它的全部数据一起删掉; 还没有登录功能, 所有用户名都算
+// 每隔 storeJanitorInterval 清理一次, 时间从Server的Clock取, 管理员也可以用 store|purge 马上清理
+// 删除离线记录时持有seenLock并重新检查, 跟写入私聊数的flushMissedPMs不会交错, 也不会删掉刚刚更新过的记录
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// 默认的保存时间和用户名上限, 0表示不清理
+const defaultStoreTTL = 7 * 24 * time.Hour
+const defaultStoreMaxNames = 10000
+
+// 多久清理一次
+const storeJanitorInterval = time.Hour
+
+// 上一次清理的结果, store|stats 显示
+type storeGCStats struct {
+	lock    sync.Mutex
+	lastRun time.Time
+	expired int // 删掉的过期记录数
+	evicted int // 超出上限删掉的用户名数
+}
+
+// 一个用户名在Store里的数据
+type storedName struct {
+	prefs    bool      // 有个人设置
+	owner    bool      // 有名字记录
+	seenAt   time.Time // 离线记录的时间, 没有时为零
+	lastUsed time.Time // 最后一次使用的时间, 按离线记录和名字记录中较新的算
+}
+
+// 定时清理, 没有设置Store或者两种清理都关掉时不启动; ticker在Start里建好
+func (this *Server) storeJanitor(ticker Ticker) {
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C():
+		case <-this.stopped:
+			return
+		}
+		expired, evicted, err := this.purgeStore()
+		if err != nil {
+			fmt.Println("store purge err:", err)
+			continue
+		}
+		if expired > 0 || evicted > 0 {
+			fmt.Printf("store purged expired=%d evicted=%d\n", expired, evicted)
+		}
+	}
+}
+
+// 清理一次, 返回删掉的过期记录数和超出上限删掉的用户名数
+func (this *Server) purgeStore() (int, int, error) {
+	store := this.PrefStore
+	if store == nil {
+		return 0, 0, nil
+	}
+	now := this.Clock.Now()
+
+	prefNames, err := store.Names()
+	if err != nil {
+		return 0, 0, err
+	}
+	owners, err := store.ListNameOwners()
+	if err != nil {
+		return 0, 0, err
+	}
+	seen, err := store.ListLastSeen()
+	if err != nil {
+		return 0, 0, err
+	}
+	names := make(map[string]*storedName)
+	get := func(name string) *storedName {
+		n := names[name]
+		if n == nil {
+			n = &storedName{}
+			names[name] = n
+		}
+		return n
+	}
+	for _, name := range prefNames {
+		get(name).prefs = true
+	}
+	for name, record := range seen {
+		n := get(name)
+		n.seenAt = record.at
+		n.lastUsed = record.at
+	}
+	var expiredOwners []string
+	for name, owner := range owners {
+		n := get(name)
+		n.owner = true
+		if owner.until.After(n.lastUsed) {
+			n.lastUsed = owner.until
+		}
+		if this.StoreTTL > 0 && now.Sub(owner.until) > this.StoreTTL {
+			expiredOwners = append(expiredOwners, name)
+		}
+	}
+
+	// 超出上限时按最后使用的时间从旧到新删, 在线的用户名不删; 没有记录的(以前保存的个人设置)算最旧
+	var evict []string
+	if max := this.StoreMaxNames; max > 0 && len(names) > max {
+		this.mapLock.RLock()
+		candidates := make([]string, 0, len(names))
+		for name := range names {
+			if _, online := this.OnlineMap[name]; !online {
+				candidates = append(candidates, name)
+			}
+		}
+		this.mapLock.RUnlock()
+		sort.Slice(candidates, func(i, j int) bool {
+			a, b := names[candidates[i]], names[candidates[j]]
+			if !a.lastUsed.Equal(b.lastUsed) {
+				return a.lastUsed.Before(b.lastUsed)
+			}
+			return candidates[i] < candidates[j]
+		})
+		if over := len(names) - max; over < len(candidates) {
+			candidates = candidates[:over]
+		}
+		evict = candidates
+	}
+
+	// 离线记录在锁里重新读一次: 读出来以后又有人用过这个名字的不删
+	// 挑选之后刚上线或者刚改成这个名字的也不删, seenLock里再查一次在线; 不会有人拿着mapLock去等seenLock
+	this.seenLock.Lock()
+	defer this.seenLock.Unlock()
+
+	this.mapLock.RLock()
+	for i, name := range evict {
+		if _, online := this.OnlineMap[name]; online {
+			evict[i] = ""
+		}
+	}
+	this.mapLock.RUnlock()
+
+	var removePrefs, removeOwners, removeSeen []string
+	expired, evicted := 0, 0
+	evicting := make(map[string]bool, len(evict))
+	for _, name := range evict {
+		if name == "" {
+			continue
+		}
+		n := names[name]
+		if !this.seenUnchanged(name, n.seenAt) {
+			continue
+		}
+		evicting[name] = true
+		if n.prefs {
+			removePrefs = append(removePrefs, name)
+		}
+		if n.owner {
+			removeOwners = append(removeOwners, name)
+		}
+		if !n.seenAt.IsZero() {
+			removeSeen = append(removeSeen, name)
+		}
+		evicted++
+	}
+	// 整个用户名都删掉的, 名字记录已经算在evicted里了
+	for _, name := range expiredOwners {
+		if !evicting[name] {
+			removeOwners = append(removeOwners, name)
+			expired++
+		}
+	}
+	if this.StoreTTL > 0 {
+		for name, n := range names {
+			if evicting[name] || n.seenAt.IsZero() || now.Sub(n.seenAt) <= this.StoreTTL {
+				continue
+			}
+			if !this.seenUnchanged(name, n.seenAt) {
+				continue
+			}
+			removeSeen = append(removeSeen, name)
+			expired++
+		}
+	}
+
+	if len(removePrefs) > 0 {
+		if err := store.DeletePrefs(removePrefs); err != nil {
+			return 0, 0, err
+		}
+	}
+	if len(removeOwners) > 0 {
+		if err := store.DeleteNameOwner(removeOwners...); err != nil {
+			return 0, 0, err
+		}
+	}
+	if len(removeSeen) > 0 {
+		if err := store.DeleteLastSeen(removeSeen...); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	this.storeGC.lock.Lock()
+	this.storeGC.lastRun = now
+	this.storeGC.expired, this.storeGC.evicted = expired, evicted
+	this.storeGC.lock.Unlock()
+	return expired, evicted, nil
+}
+
+// 离线记录的时间还是at(或者已经没有了)时返回true, 调用时需要持有seenLock
+// 只记了一条私聊时时间不变, 照样可以删; 删掉之后flushMissedPMs找不到记录, 不会再写回来
+func (this *Server) seenUnchanged(name string, at time.Time) bool {
+	current, ok, err := this.PrefStore.LoadLastSeen(name)
+	if err != nil {
+		return false
+	}
+	return !ok || current.at.Equal(at)
+}
+
+// 数一下Store里有多少用户名, 个人设置, 离线记录和名字记录
+func storeCounts(store Store) (names, prefs, seen, owners int, err error) {
+	prefNames, err := store.Names()
+	if err != nil {
+		return 0, 0, 0, 0, err
+	}
+	nameOwners, err := store.ListNameOwners()
+	if err != nil {
+		return 0, 0, 0, 0, err
+	}
+	seenRecords, err := store.ListLastSeen()
+	if err != nil {
+		return 0, 0, 0, 0, err
+	}
+	all := make(map[string]bool)
+	for _, name := range prefNames {
+		all[name] = true
+	}
+	for name := range nameOwners {
+		all[name] = true
+	}
+	for name := range seenRecords {
+		all[name] = true
+	}
+	return len(all), len(prefNames), len(seenRecords), len(nameOwners), nil
+}
+
+// store命令: store|stats 查看Store的大小和上一次清理, store|purge 马上清理一次
+func (this *User) DoStore(arg string) {
+	if !this.IsAdmin() {
+		this.server.audit(this, "store", "", arg, auditDenied)
+		this.errorReply(ErrPermission, "store.admin_only")
+		return
+	}
+	if arg != "stats" && arg != "purge" {
+		this.server.audit(this, "store", "", arg, auditInvalid)
+		this.errorReply(ErrUsage, "store.usage")
+		return
+	}
+	store := this.server.PrefStore
+	if store == nil {
+		this.errorReply(ErrNotFound, "store.none")
+		return
+	}
+
+	if arg == "purge" {
+		expired, evicted, err := this.server.purgeStore()
+		if err != nil {
+			fmt.Println("store purge err:", err)
+			this.errorReply(ErrUnavailable, "store.failed")
+			return
+		}
+		this.server.audit(this, "store", "", arg, auditOK)
+		this.SendMsg(t(this, "store.purged", expired, evicted) + "\n")
+		return
+	}
+
+	names, prefs, seen, owners, err := storeCounts(store)
+	if err != nil {
+		fmt.Println("store names err:", err)
+		this.errorReply(ErrUnavailable, "store.failed")
+		return
+	}
+	this.SendMsg(t(this, "store.stats", names, prefs, seen, owners) + "\n")
+
+	gc := &this.server.storeGC
+	gc.lock.Lock()
+	lastRun, expired, evicted := gc.lastRun, gc.expired, gc.evicted
+	gc.lock.Unlock()
+	if lastRun.IsZero() {
+		this.SendMsg(t(this, "store.never") + "\n")
+		return
+	}
+	ago := this.server.Clock.Now().Sub(lastRun).Round(time.Second)
+	this.SendMsg(t(this, "store.last_purge", lastRun.Format("2006-01-02 15:04:05"), ago, expired, evicted) + "\n")
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// Store里放一个用户名的离线记录和名字记录, 时间是多久以前
+func storeName(t *testing.T, store Store, now time.Time, name string, seenAgo, ownerAgo time.Duration) {
+	t.Helper()
+	if seenAgo >= 0 {
+		if err := store.SaveLastSeen(name, lastSeen{at: now.Add(-seenAgo)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if ownerAgo >= 0 {
+		if err := store.SaveNameOwner(name, nameOwner{until: now.Add(-ownerAgo)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func storeHasSeen(store Store, name string) bool {
+	_, ok, err := store.LoadLastSeen(name)
+	return err == nil && ok
+}
+
+func storeHasOwner(store Store, name string) bool {
+	_, ok, err := store.LoadNameOwner(name)
+	return err == nil && ok
+}
+
+func TestPurgeStoreExpired(t *testing.T) {
+	clock := NewFakeClock(testEpoch)
+	store := NewMemStore()
+	server := NewServer("127.0.0.1", 0, WithClock(clock), WithStore(store), WithStoreRetention(time.Hour, 0))
+	now := clock.Now()
+	storeName(t, store, now, "old", 2*time.Hour, 2*time.Hour)
+	storeName(t, store, now, "new", time.Minute, time.Minute)
+
+	expired, evicted, err := server.purgeStore()
+	if err != nil || expired != 2 || evicted != 0 {
+		t.Fatalf("purge = %d %d %v", expired, evicted, err)
+	}
+	if storeHasSeen(store, "old") || storeHasOwner(store, "old") || !storeHasSeen(store, "new") {
+		t.Fatal("删错了")
+	}
+}
+
+// 整个用户名删掉时, 它过期的名字记录不再算一次过期
+func TestPurgeStoreEvictCountsOnce(t *testing.T) {
+	clock := NewFakeClock(testEpoch)
+	store := NewMemStore()
+	server := NewServer("127.0.0.1", 0, WithClock(clock), WithStore(store), WithStoreRetention(time.Hour, 1))
+	now := clock.Now()
+	storeName(t, store, now, "old", 30*time.Minute, 2*time.Hour)
+	storeName(t, store, now, "new", time.Minute, -1)
+	if err := store.SavePrefs("old", map[string]string{"lang": "en"}); err != nil {
+		t.Fatal(err)
+	}
+
+	expired, evicted, err := server.purgeStore()
+	if err != nil || expired != 0 || evicted != 1 {
+		t.Fatalf("purge = %d %d %v", expired, evicted, err)
+	}
+	if storeHasSeen(store, "old") || storeHasOwner(store, "old") || !storeHasSeen(store, "new") {
+		t.Fatal("删错了")
+	}
+	// 个人设置也一起删掉
+	if prefs, _ := store.LoadPrefs("old"); len(prefs) != 0 {
+		t.Fatalf("old 的个人设置还在: %v", prefs)
+	}
+}
+
+// 在线的用户名不会因为超出上限被删掉
+func TestPurgeStoreKeepsOnline(t *testing.T) {
+	clock := NewFakeClock(testEpoch)
+	store := NewMemStore()
+	server := NewServer("127.0.0.1", 0, WithClock(clock), WithStore(store), WithStoreRetention(0, 1))
+	now := clock.Now()
+	storeName(t, store, now, "online", 3*time.Hour, -1)
+	storeName(t, store, now, "offline", time.Hour, -1)
+	server.OnlineMap["online"] = &User{}
+
+	if _, evicted, err := server.purgeStore(); err != nil || evicted != 1 {
+		t.Fatalf("evicted = %d %v", evicted, err)
+	}
+	if !storeHasSeen(store, "online") || storeHasSeen(store, "offline") {
+		t.Fatal("删掉了在线的用户名")
+	}
+}
+
+// 定时清理用的是Server的时钟
+func TestStoreJanitor(t *testing.T) {
+	clock := NewFakeClock(testEpoch)
+	store := NewMemStore()
+	storeName(t, store, clock.Now(), "old", 2*time.Hour, -1)
+	startTestServer(t, WithClock(clock), WithStore(store), WithStoreRetention(time.Hour, 0))
+
+	// 开始监听时定时器可能还没建好, 没删掉就再过一个周期
+	eventually(t, "过期的离线记录没有删掉", func() bool {
+		clock.Advance(storeJanitorInterval)
+		return !storeHasSeen(store, "old")
+	})
+}
@@ -0,0 +1,130 @@
+// 打错用户名时提示相近的名字: 私聊和whois找不到用户时, 回复 "用户不存在，您是指: bobby, bobo ?"
+// 相近是指不分大小写时编辑距离不超过suggestMaxDistance, 或者前suggestPrefix个字符相同, 最多提示suggestMax个
+// 每个用户改名时就算好小写的名字(User.nameFold), 查找时只算一次输入的; 长度差太多的直接跳过, 距离超过上限时提前结束
+// 在线用户超过suggestMaxOnline时不提示, 找不到用户时不会把服务器拖慢
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// 打错字时最多差几个字符
+const suggestMaxDistance = 2
+
+// 前几个字符相同也算相近
+const suggestPrefix = 3
+
+// 最多提示几个名字
+const suggestMax = 3
+
+// 在线用户超过多少时不提示
+const suggestMaxOnline = 5000
+
+// 找不到用户时回复错误, 有相近的在线用户名时一起提示
+func (this *User) userNotFound(name string) {
+	this.sendError(this.userNotFoundError(name))
+}
+
+// 找不到用户的错误, 有相近的在线用户名时带上
+func (this *User) userNotFoundError(name string) *replyError {
+	if names := this.server.similarNames(name); len(names) > 0 {
+		return this.newError(ErrNoSuchUser, "user.not_found_suggest", strings.Join(names, ", "))
+	}
+	return this.newError(ErrNoSuchUser, "user.not_found")
+}
+
+// 跟name相近的在线用户名, 按距离和名字排序, 最多suggestMax个
+// 以#开头的是连接编号, 不提示
+func (this *Server) similarNames(name string) []string {
+	if name == "" || strings.HasPrefix(name, "#") {
+		return nil
+	}
+	type match struct {
+		name     string
+		distance int // 只是前缀相同时是suggestMaxDistance+1, 排在后面
+	}
+	var matches []match
+	want := []rune(strings.ToLower(name))
+
+	this.mapLock.RLock()
+	if len(this.OnlineMap) <= suggestMaxOnline {
+		for online, user := range this.OnlineMap {
+			user.nameLock.RLock()
+			fold := user.nameFold
+			user.nameLock.RUnlock()
+
+			d, ok := withinDistance(want, fold, suggestMaxDistance)
+			if !ok && sharesPrefix(want, fold, suggestPrefix) {
+				d, ok = suggestMaxDistance+1, true
+			}
+			if ok {
+				matches = append(matches, match{online, d})
+			}
+		}
+	}
+	this.mapLock.RUnlock()
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].distance != matches[j].distance {
+			return matches[i].distance < matches[j].distance
+		}
+		return matches[i].name < matches[j].name
+	})
+	if len(matches) > suggestMax {
+		matches = matches[:suggestMax]
+	}
+	names := make([]string, len(matches))
+	for i, m := range matches {
+		names[i] = m.name
+	}
+	return names
+}
+
+// 两个前缀是否都至少有n个字符并且相同
+func sharesPrefix(a, b []rune, n int) bool {
+	if len(a) < n || len(b) < n {
+		return false
+	}
+	for i := 0; i < n; i++ {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// 编辑距离(插入、删除、替换一个字符各算1)不超过max时返回距离和true
+// 长度差超过max时不用算; 一行里最小的距离已经超过max时后面只会更大, 提前返回false
+func withinDistance(a, b []rune, max int) (int, bool) {
+	if len(a) < len(b) {
+		a, b = b, a
+	}
+	if len(a)-len(b) > max {
+		return 0, false
+	}
+	// 只保留上一行, prev[j]是a的前i个字符到b的前j个字符的距离
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		rowMin := cur[0]
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			rowMin = min(rowMin, cur[j])
+		}
+		if rowMin > max {
+			return 0, false
+		}
+		prev, cur = cur, prev
+	}
+	d := prev[len(b)]
+	return d, d <= max
+}
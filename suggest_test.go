@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestWithinDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		d    int
+		ok   bool
+	}{
+		{"bob", "bob", 0, true},
+		{"bob", "bobo", 1, true},
+		{"bob", "bobby", 2, true},
+		{"bob", "rob", 1, true},
+		{"alice", "ailce", 2, true},
+		{"张三", "张四", 1, true},
+		{"bob", "bobbyx", 0, false}, // 长度差3, 不用算
+		{"abcdef", "uvwxyz", 0, false},
+		{"", "ab", 2, true},
+	}
+	for _, tt := range tests {
+		for _, swap := range []bool{false, true} {
+			a, b := tt.a, tt.b
+			if swap {
+				a, b = b, a
+			}
+			d, ok := withinDistance([]rune(a), []rune(b), 2)
+			if ok != tt.ok || ok && d != tt.d {
+				t.Errorf("withinDistance(%q, %q) = %d, %v, want %d, %v", a, b, d, ok, tt.d, tt.ok)
+			}
+		}
+	}
+}
+
+func TestSharesPrefix(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"alexander", "alex", true},
+		{"ale", "alexander", true},
+		{"al", "alexander", false},
+		{"bobby", "robby", false},
+	}
+	for _, tt := range tests {
+		if got := sharesPrefix([]rune(tt.a), []rune(tt.b), suggestPrefix); got != tt.want {
+			t.Errorf("sharesPrefix(%q, %q) = %v", tt.a, tt.b, got)
+		}
+	}
+}
+
+// 只为查找相近的名字准备的服务器, OnlineMap里是names
+func suggestServer(names ...string) *Server {
+	server := NewServer("127.0.0.1", 0)
+	for _, name := range names {
+		user := &User{}
+		user.setName(name)
+		server.OnlineMap[name] = user
+	}
+	return server
+}
+
+// 按距离再按名字排序, 只是前缀相同的排在最后, 最多suggestMax个; 不分大小写
+func TestSimilarNames(t *testing.T) {
+	server := suggestServer("bob", "bobo", "Bobby", "robert", "alice", "alexander")
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"bobb", "Bobby, bob, bobo"},
+		{"BOB", "bob, bobo, Bobby"},
+		{"alexa", "alexander"},
+		{"alise", "alice"},
+		{"zed", ""},
+		{"#2", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := strings.Join(server.similarNames(tt.name), ", "); got != tt.want {
+			t.Errorf("similarNames(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	// 在线用户太多时不提示
+	var many []string
+	for i := 0; i <= suggestMaxOnline; i++ {
+		many = append(many, fmt.Sprintf("user%d", i))
+	}
+	if got := suggestServer(many...).similarNames("user1"); len(got) != 0 {
+		t.Fatalf("在线 %d 人时还提示了 %q", len(many), got)
+	}
+}
+
+// 私聊和whois找不到用户时的回复带上相近的名字, 没有相近的时跟原来一样
+func TestUserNotFoundSuggest(t *testing.T) {
+	server := startTestServer(t)
+	alice := dialTest(t, server, "alice")
+	dialTest(t, server, "bobby")
+	dialTest(t, server, "bobo")
+
+	suggest := text("user.not_found_suggest", "bobby, bobo")
+	prefix, _, _ := strings.Cut(text("user.not_found_suggest", "\x00"), "\x00")
+	alice.send("to|bobb|hi")
+	if line := alice.waitFor(prefix); line != suggest {
+		t.Fatalf("私聊 = %q, want %q", line, suggest)
+	}
+	alice.send("whois|bobb")
+	if line := alice.waitFor(prefix); line != suggest {
+		t.Fatalf("whois = %q, want %q", line, suggest)
+	}
+
+	alice.send("caps|errors", "whois|zed")
+	if line := alice.waitFor("err|"); line != fmt.Sprintf("err|%d|%s", ErrNoSuchUser.Num, text("user.not_found")) {
+		t.Fatalf("没有相近的名字时 = %q", line)
+	}
+}
@@ -0,0 +1,271 @@
+// 按IP限制试探: 管理员口令错误、不认识的命令和格式不对的帧都算一次失败, 同一个IP的所有连接一起计算
+// 命令的参数写错只回复错误, 不算失败, 见protocolError
+// 在 -suspect-window 里口令错误超过 -suspect-logins 次或者命令错误超过 -suspect-commands 次后,
+// 这个IP的每条消息处理前先等一会儿, 每多错一次等待加倍; 错误次数到了阈值的两倍时暂时封禁这个IP:
+// 断开它的连接, -suspect-block 之内不接受新连接, 到期自动解除; 这跟手动的永久封禁不是一回事
+// 管理员用 suspects 查看, suspects|clear|IP 解除
+package main
+
+import (
+	"container/list"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 默认值
+const (
+	defaultSuspectWindow   = 10 * time.Minute // 0表示不检查
+	defaultSuspectLogins   = 5
+	defaultSuspectCommands = 20
+	defaultSuspectBlock    = 15 * time.Minute
+)
+
+// 超过阈值后的第一次等待和最长的等待
+const (
+	suspectBaseDelay = 250 * time.Millisecond
+	maxSuspectDelay  = 8 * time.Second
+)
+
+// 最多记住多少个IP, 超过时忘掉最久没有出错的
+const maxSuspects = 4096
+
+// 失败的种类
+const (
+	failLogin   = iota // 管理员口令错误
+	failCommand        // 不认识的命令或者格式不对的帧
+)
+
+// 一个IP的失败记录
+type suspect struct {
+	ip           string
+	since        time.Time // 这个窗口的开始时间
+	logins       int
+	commands     int
+	blockedUntil time.Time
+}
+
+// 全部IP的失败记录, 最近出错的在前面
+type suspectTable struct {
+	lock  sync.Mutex
+	order *list.List
+	ips   map[string]*list.Element
+}
+
+func newSuspectTable() *suspectTable {
+	return &suspectTable{order: list.New(), ips: make(map[string]*list.Element)}
+}
+
+// 连接地址里的IP, 同一个IP的不同端口算一个
+func hostOf(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+// 超过阈值多少次, 没有超过时返回-1
+func (this *Server) suspectExcess(s *suspect) int {
+	excess := -1
+	if this.SuspectLogins > 0 && s.logins >= this.SuspectLogins {
+		excess = s.logins - this.SuspectLogins
+	}
+	if this.SuspectCommands > 0 && s.commands >= this.SuspectCommands && s.commands-this.SuspectCommands > excess {
+		excess = s.commands - this.SuspectCommands
+	}
+	return excess
+}
+
+// 错误次数是否到了封禁的程度
+func (this *Server) suspectShouldBlock(s *suspect) bool {
+	return (this.SuspectLogins > 0 && s.logins >= 2*this.SuspectLogins) ||
+		(this.SuspectCommands > 0 && s.commands >= 2*this.SuspectCommands)
+}
+
+// 记下一次失败, 这次失败让这个IP被封禁时返回true
+func (this *Server) recordFailure(user *User, kind int) bool {
+	if this.SuspectWindow <= 0 || user.IsAdmin() {
+		return false
+	}
+	ip := hostOf(user.Addr)
+	now := this.Clock.Now()
+
+	table := this.suspects
+	table.lock.Lock()
+	defer table.lock.Unlock()
+
+	var s *suspect
+	if e, ok := table.ips[ip]; ok {
+		table.order.MoveToFront(e)
+		s = e.Value.(*suspect)
+	} else {
+		s = &suspect{ip: ip, since: now}
+		table.ips[ip] = table.order.PushFront(s)
+		if table.order.Len() > maxSuspects {
+			oldest := table.order.Back()
+			table.order.Remove(oldest)
+			delete(table.ips, oldest.Value.(*suspect).ip)
+		}
+	}
+	// 封禁中还连着的其他连接出的错不算, 到期后从零开始
+	if now.Before(s.blockedUntil) {
+		return false
+	}
+	if now.Sub(s.since) > this.SuspectWindow {
+		s.since, s.logins, s.commands = now, 0, 0
+	}
+	if kind == failLogin {
+		s.logins++
+	} else {
+		s.commands++
+	}
+
+	if this.SuspectBlock > 0 && this.suspectShouldBlock(s) {
+		s.blockedUntil = now.Add(this.SuspectBlock)
+		// 封禁到期后重新计数
+		s.since, s.logins, s.commands = s.blockedUntil, 0, 0
+		fmt.Printf("suspect blocked ip=%s until=%s\n", ip, s.blockedUntil.Format(time.RFC3339))
+		return true
+	}
+	return false
+}
+
+// 这个IP的消息处理前要等多久
+func (this *Server) suspectDelay(ip string) time.Duration {
+	if this.SuspectWindow <= 0 {
+		return 0
+	}
+	table := this.suspects
+	table.lock.Lock()
+	defer table.lock.Unlock()
+
+	e, ok := table.ips[ip]
+	if !ok {
+		return 0
+	}
+	s := e.Value.(*suspect)
+	if this.Clock.Now().Sub(s.since) > this.SuspectWindow {
+		return 0
+	}
+	return suspectDelayFor(this.suspectExcess(s))
+}
+
+// 超过阈值excess次时的等待, 每次加倍
+func suspectDelayFor(excess int) time.Duration {
+	if excess < 0 {
+		return 0
+	}
+	d := suspectBaseDelay
+	for i := 0; i < excess && d < maxSuspectDelay; i++ {
+		d *= 2
+	}
+	if d > maxSuspectDelay {
+		d = maxSuspectDelay
+	}
+	return d
+}
+
+// 这个IP是否在封禁中
+func (this *Server) ipBlocked(ip string) bool {
+	table := this.suspects
+	table.lock.Lock()
+	defer table.lock.Unlock()
+
+	e, ok := table.ips[ip]
+	return ok && this.Clock.Now().Before(e.Value.(*suspect).blockedUntil)
+}
+
+// 拒绝封禁中的IP的新连接
+func (this *Server) rejectBlocked(conn net.Conn) {
+	conn.Write([]byte(this.helloLine() + "\n" + systemPrefix + t(nil, "suspect.reject") + "\n"))
+	conn.Close()
+}
+
+// 记下用户的一次失败, 因此被封禁时断开连接
+func (this *User) failed(kind int) {
+	if this.server.recordFailure(this, kind) {
+		this.errorReply(ErrRateLimited, "suspect.blocked", int(this.server.SuspectBlock.Minutes()))
+		this.disconnect()
+	}
+}
+
+// 查看和解除, 消息格式: suspects 或 suspects|clear|IP, 只有管理员可以使用
+func (this *User) DoSuspects(arg string) {
+	if !this.IsAdmin() {
+		this.server.audit(this, "suspects", "", arg, auditDenied)
+		this.errorReply(ErrPermission, "suspects.admin_only")
+		return
+	}
+	if arg != "" {
+		ip, ok := strings.CutPrefix(arg, "clear|")
+		if !ok || ip == "" {
+			this.server.audit(this, "suspects", "", arg, auditInvalid)
+			this.errorReply(ErrUsage, "suspects.usage")
+			return
+		}
+		if !this.server.clearSuspect(ip) {
+			this.server.audit(this, "suspects", ip, "clear", auditInvalid)
+			this.errorReply(ErrNotFound, "suspects.not_found", ip)
+			return
+		}
+		this.server.audit(this, "suspects", ip, "clear", auditOK)
+		this.SendMsg(t(this, "suspects.cleared", ip) + "\n")
+		return
+	}
+
+	lines := this.server.suspectLines()
+	if len(lines) == 0 {
+		this.SendMsg(t(this, "suspects.none") + "\n")
+		return
+	}
+	for _, line := range lines {
+		this.SendMsg(line + "\n")
+	}
+}
+
+// 忘掉一个IP的记录, 也就解除了封禁
+func (this *Server) clearSuspect(ip string) bool {
+	table := this.suspects
+	table.lock.Lock()
+	defer table.lock.Unlock()
+
+	e, ok := table.ips[ip]
+	if ok {
+		table.order.Remove(e)
+		delete(table.ips, ip)
+	}
+	return ok
+}
+
+// 还在窗口里或者封禁中的IP, 每个一行, 封禁的在前面
+func (this *Server) suspectLines() []string {
+	now := this.Clock.Now()
+	table := this.suspects
+	table.lock.Lock()
+	var list []suspect
+	for e := table.order.Front(); e != nil; e = e.Next() {
+		s := e.Value.(*suspect)
+		if now.Before(s.blockedUntil) || now.Sub(s.since) <= this.SuspectWindow {
+			list = append(list, *s)
+		}
+	}
+	table.lock.Unlock()
+
+	sort.SliceStable(list, func(i, j int) bool {
+		return list[i].blockedUntil.After(list[j].blockedUntil)
+	})
+	lines := make([]string, 0, len(list))
+	for i := range list {
+		s := &list[i]
+		line := fmt.Sprintf("suspect ip=%s logins=%d commands=%d delay=%s", s.ip, s.logins, s.commands, suspectDelayFor(this.suspectExcess(s)))
+		if now.Before(s.blockedUntil) {
+			line += " blocked=" + s.blockedUntil.Sub(now).Round(time.Second).String()
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
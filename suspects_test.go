@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 一个从ip连上来的普通用户, 只用来记失败
+func suspectUser(server *Server, ip string) *User {
+	return &User{Addr: net.JoinHostPort(ip, "4000"), server: server}
+}
+
+// 到了阈值开始等待, 每多错一次加倍; 到两倍时封禁, 到期后重新计数
+func TestSuspectThresholds(t *testing.T) {
+	clock := NewFakeClock(testEpoch)
+	server := NewServer("127.0.0.1", 0, WithClock(clock), WithSuspects(time.Minute, 2, 3, 5*time.Minute))
+	user := suspectUser(server, "10.0.0.1")
+
+	delays := []time.Duration{0, suspectBaseDelay, 2 * suspectBaseDelay}
+	for i, want := range delays {
+		if server.recordFailure(user, failLogin) {
+			t.Fatalf("第 %d 次口令错误就封禁了", i+1)
+		}
+		if got := server.suspectDelay("10.0.0.1"); got != want {
+			t.Fatalf("第 %d 次口令错误后等待 %v, want %v", i+1, got, want)
+		}
+	}
+	if !server.recordFailure(user, failLogin) || !server.ipBlocked("10.0.0.1") {
+		t.Fatal("口令错误到两倍阈值没有封禁")
+	}
+	if server.ipBlocked("10.0.0.2") {
+		t.Fatal("别的IP也被封禁了")
+	}
+	if got := server.suspectDelay("10.0.0.1"); got != 0 {
+		t.Fatalf("封禁后等待 %v", got)
+	}
+
+	// 封禁中再出错不会延长封禁
+	clock.Advance(4 * time.Minute)
+	for i := 0; i < 4; i++ {
+		if server.recordFailure(user, failLogin) {
+			t.Fatal("封禁中又封禁了一次")
+		}
+	}
+	clock.Advance(time.Minute)
+	if server.ipBlocked("10.0.0.1") {
+		t.Fatal("封禁到期没有解除")
+	}
+	if got := server.suspectDelay("10.0.0.1"); got != 0 {
+		t.Fatalf("封禁到期后等待 %v", got)
+	}
+
+	// 命令错误单独计数
+	other := suspectUser(server, "10.0.0.2")
+	for i := 0; i < 3; i++ {
+		server.recordFailure(other, failLogin)
+		server.recordFailure(other, failCommand)
+	}
+	if got := server.suspectDelay("10.0.0.2"); got != 2*suspectBaseDelay {
+		t.Fatalf("口令错误3次命令错误3次后等待 %v", got)
+	}
+}
+
+// 窗口过了以后重新计数
+func TestSuspectWindow(t *testing.T) {
+	clock := NewFakeClock(testEpoch)
+	server := NewServer("127.0.0.1", 0, WithClock(clock), WithSuspects(time.Minute, 0, 2, time.Minute))
+	user := suspectUser(server, "10.0.0.1")
+
+	server.recordFailure(user, failLogin)
+	server.recordFailure(user, failCommand)
+	server.recordFailure(user, failCommand)
+	if got := server.suspectDelay("10.0.0.1"); got != suspectBaseDelay {
+		t.Fatalf("命令错误2次后等待 %v", got)
+	}
+	clock.Advance(time.Minute + time.Second)
+	if got := server.suspectDelay("10.0.0.1"); got != 0 {
+		t.Fatalf("窗口过了还在等待 %v", got)
+	}
+	if lines := server.suspectLines(); len(lines) != 0 {
+		t.Fatalf("窗口过了还在列表里: %q", lines)
+	}
+	if server.recordFailure(user, failCommand) || server.suspectDelay("10.0.0.1") != 0 {
+		t.Fatal("窗口过了没有重新计数")
+	}
+
+	// 管理员不记失败, 窗口为0时不检查
+	admin := suspectUser(server, "10.0.0.3")
+	admin.admin = 1
+	for i := 0; i < 10; i++ {
+		admin.server.recordFailure(admin, failCommand)
+	}
+	if server.suspectDelay("10.0.0.3") != 0 {
+		t.Fatal("管理员的失败也记了")
+	}
+	off := NewServer("127.0.0.1", 0, WithClock(clock), WithSuspects(0, 1, 1, time.Minute))
+	for i := 0; i < 10; i++ {
+		if off.recordFailure(suspectUser(off, "10.0.0.1"), failLogin) {
+			t.Fatal("不检查时也封禁了")
+		}
+	}
+}
+
+func TestSuspectDelayFor(t *testing.T) {
+	tests := []struct {
+		excess int
+		want   time.Duration
+	}{
+		{-1, 0},
+		{0, suspectBaseDelay},
+		{1, 2 * suspectBaseDelay},
+		{5, maxSuspectDelay},
+		{100, maxSuspectDelay},
+	}
+	for _, tt := range tests {
+		if got := suspectDelayFor(tt.excess); got != tt.want {
+			t.Errorf("suspectDelayFor(%d) = %v, want %v", tt.excess, got, tt.want)
+		}
+	}
+}
+
+// 超过maxSuspects个IP时忘掉最久没有出错的, 刚出错的往前挪
+func TestSuspectEviction(t *testing.T) {
+	clock := NewFakeClock(testEpoch)
+	server := NewServer("127.0.0.1", 0, WithClock(clock), WithSuspects(time.Hour, 1, 0, time.Minute))
+	ip := func(i int) string { return fmt.Sprintf("10.%d.%d.%d", i>>16&0xff, i>>8&0xff, i&0xff) }
+
+	for i := 0; i < maxSuspects; i++ {
+		server.recordFailure(suspectUser(server, ip(i)), failLogin)
+	}
+	// 最早的IP又出错了一次, 第二早的成了最久没有出错的
+	server.recordFailure(suspectUser(server, ip(0)), failCommand)
+	server.recordFailure(suspectUser(server, ip(maxSuspects)), failLogin)
+
+	if n := len(server.suspects.ips); n != maxSuspects || server.suspects.order.Len() != maxSuspects {
+		t.Fatalf("记住了 %d 个IP", n)
+	}
+	if _, ok := server.suspects.ips[ip(1)]; ok {
+		t.Fatal("最久没有出错的IP没有被忘掉")
+	}
+	for _, i := range []int{0, 2, maxSuspects} {
+		if server.suspectDelay(ip(i)) != suspectBaseDelay {
+			t.Fatalf("%s 的记录丢了", ip(i))
+		}
+	}
+	if server.suspectDelay(ip(1)) != 0 {
+		t.Fatal("被忘掉的IP还在等待")
+	}
+}
+
+// 口令错太多次被断开, 同一个IP连不上, 清除记录后恢复
+func TestSuspectBlockDisconnects(t *testing.T) {
+	server := startTestServer(t, WithAdminToken("secret"), WithSuspects(time.Minute, 1, 0, time.Hour))
+	alice := dialTest(t, server, "alice")
+
+	alice.send("caps|errors", "admin|wrong", "admin|wrong")
+	alice.waitFor(fmt.Sprintf("err|%d|%s", ErrRateLimited.Num, text("suspect.blocked", 60)))
+	alice.waitClosed()
+	if lines := server.suspectLines(); len(lines) != 1 || !strings.HasPrefix(lines[0], "suspect ip=127.0.0.1 logins=0 commands=0 delay=0s blocked=") {
+		t.Fatalf("suspects = %q", lines)
+	}
+
+	conn, err := net.Dial("tcp", server.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(e2eWait))
+	reader := bufio.NewReader(conn)
+	var got []string
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			break
+		}
+		got = append(got, strings.TrimSuffix(line, "\n"))
+	}
+	if len(got) != 2 || !strings.HasPrefix(got[0], "hello|") || got[1] != systemPrefix+text("suspect.reject") {
+		t.Fatalf("封禁中的连接收到 %q", got)
+	}
+
+	if !server.clearSuspect("127.0.0.1") {
+		t.Fatal("没有 127.0.0.1 的记录")
+	}
+	dialTest(t, server, "bob")
+}
+
+// 参数写错不算失败, 打错几次字不会被封; 不认识的命令才算
+func TestSuspectTyposNotCounted(t *testing.T) {
+	server := startTestServer(t, WithSuspects(time.Minute, 0, 1, time.Hour))
+	alice := dialTest(t, server, "alice")
+
+	for i := 0; i < 5; i++ {
+		alice.send("history|abc", "settings|lang|xx", "settings|nosuch|1")
+	}
+	alice.send("sync")
+	alice.waitFor("sync")
+	if lines := server.suspectLines(); len(lines) != 0 {
+		t.Fatalf("参数写错也记了失败: %q", lines)
+	}
+
+	alice.send("help|nosuch", "help|nosuch")
+	alice.waitClosed()
+	if !server.ipBlocked("127.0.0.1") {
+		t.Fatal("不认识的命令没有记失败")
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+// whois里自己的流量按实际读写的字节数计算, 别人的流量只有管理员能看到
+func TestWhoisTraffic(t *testing.T) {
+	server := startTestServer(t)
+	alice := dialTest(t, server, "alice")
+	dialTest(t, server, "bob")
+
+	alice.send("whois|alice")
+	line := alice.waitFor("流量: 收到 25 字节")
+	var in, out uint64
+	if _, err := fmt.Sscanf(line, catalog["zh"]["whois.traffic"], &in, &out); err != nil {
+		t.Fatal(line, err)
+	}
+	// "rename|alice\n" 和 "whois|alice\n"; 发出的至少有改名的回复
+	if in != 25 || out < uint64(len(text("rename.done", "alice"))) {
+		t.Fatalf("in=%d out=%d", in, out)
+	}
+
+	alice.send("whois|bob", "sync-whois")
+	alice.waitFor("sync-whois")
+	if alice.count("流量:") != 1 {
+		t.Fatal("普通用户看到了别人的流量")
+	}
+}
@@ -1,30 +1,103 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"net"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type User struct {
-	Name string
-	Addr string      // 当前客户端地址
-	C    chan string // 跟每个用户绑定的chan
-	conn net.Conn    // 表示当前客户端唯一一个可以跟对端客户端的连接
+	ID uint64 // 连接的编号, 从1开始递增, 改名不会变
+
+	// 用户名, 改名时别的goroutine可能正在广播或者查who, 读写都要经过Name和setName
+	name     string
+	nameFold []rune // 小写的用户名, 提示相近的名字时使用, 见suggest.go
+	nameLock sync.RWMutex
+	guest    bool // 还在用上线时分配的默认用户名, 见guest.go
+
+	Addr      string      // 当前客户端地址
+	Transport string      // 从哪种监听连上来的, 例如 tcp、tls, 见listeners.go
+	C         chan string // 跟每个用户绑定的chan
+	conn      net.Conn    // 表示当前客户端唯一一个可以跟对端客户端的连接
 
 	server *Server
+
+	// 客户端声明过的能力, 见caps.go
+	caps    map[string]bool
+	capLock sync.RWMutex
+
+	admin int32 // 是否是管理员, 用atomic读写, 见IsAdmin
+
+	ConnectedAt time.Time // 连接建立的时间
+	lastActive  int64     // 最后一次发消息的时间(UnixNano), 用atomic读写, 见LastActive
+
+	// 改名限制, 见 renameAllowed
+	lastRename time.Time
+	renames    int
+
+	lastPublic time.Time     // 最后一条公聊消息的时间, 慢速模式使用
+	recallable lastPublicMsg // 最后一条公聊消息, 自己撤回时使用, 见history.go
+
+	// 重复消息检查, 见repeat.go
+	lastText   string
+	lastTextAt time.Time
+	repeats    int
+	mutedUntil time.Time
+
+	lockdownNoticed uint64 // 已经提示过全员禁言的禁言期间
+
+	bulkPending bulkPreviewed // 最近一次需要确认的批量操作, 只在读消息的goroutine里使用, 见bulk.go
+
+	// 个人设置, 见settings.go
+	prefs     map[string]string
+	prefsName string // 个人设置保存在哪个用户名下, 只有这个连接是主人的名字才保存, 为空时不保存
+	prefLock  sync.RWMutex
+
+	broken int32 // 连接写失败过, 见SendMsg
+
+	framing   int32         // 分帧方式, 见framing.go
+	compress  compressState // 压缩, 见compress.go
+	writeLock sync.Mutex    // 一次写入完整的一条消息, 切换分帧方式时不能有别的写入
+	outbound  outboundState // 发送队列的状态, 见outbound.go
+
+	// 最近一分钟收到的私聊数, 见pmlimit.go; 这次连接里已经通知过的私聊请求, 见pmallow.go
+	inbound     pmWindow
+	pmRequests  map[string]bool
+	inboundLock sync.Mutex
+
+	sentIDs *msgIDCache // 最近发送成功的消息ID, 见dedupe.go
+
+	usedNames []usedName // 这次连接用过的名字, 由nameLock保护, 见names.go
+
+	// 生命周期, 用atomic读, 在stateLock里修改, 见lifecycle.go
+	state     userState
+	stateLock sync.Mutex
 }
 
 // 创建一个用户的API
 func NewUser(conn net.Conn, server *Server) *User {
 	userAddr := conn.RemoteAddr().String() // 返回当前连接的客户端的地址
 
+	// Name为空时上线时分配默认用户名, 见guestNameLocked
 	user := &User{
-		Name:   userAddr,
-		Addr:   userAddr,
-		C:      make(chan string),
-		conn:   conn,
-		server: server,
+		ID:      server.nextSession(),
+		Addr:    userAddr,
+		C:       make(chan string, outboundQueue),
+		conn:    conn,
+		server:  server,
+		caps:    make(map[string]bool),
+		sentIDs: newMsgIDCache(dedupeSize),
+		prefs:   make(map[string]string),
+
+		ConnectedAt: server.Clock.Now(),
 	}
+	user.outbound.done = make(chan struct{})
+	user.lastActive = user.ConnectedAt.UnixNano()
 
 	// 启动监听当前user channel消息的goroutine
 	go user.ListenMessage()
@@ -32,97 +105,365 @@ func NewUser(conn net.Conn, server *Server) *User {
 	return user
 }
 
+func (this *User) Name() string {
+	this.nameLock.RLock()
+	defer this.nameLock.RUnlock()
+
+	return this.name
+}
+
+func (this *User) setName(name string) {
+	this.nameLock.Lock()
+	defer this.nameLock.Unlock()
+
+	this.name = name
+	this.nameFold = []rune(strings.ToLower(name))
+}
+
+func (this *User) IsAdmin() bool {
+	return atomic.LoadInt32(&this.admin) == 1
+}
+
 // 用户的上线业务
+// 每个连接只执行一次, 见lifecycle.go
 func (this *User) Online() {
+	if !this.transition(stateOnline) {
+		return
+	}
+
 	// 用户上线, 将用户加入到OnlineMap中
+	var remote map[string]Presence
+	if this.Name() == "" && this.server.cluster != nil {
+		remote = this.server.cluster.RemoteUsers()
+	}
 	this.server.mapLock.Lock()
-	this.server.OnlineMap[this.Name] = this
+	if this.Name() == "" {
+		this.setName(this.server.guestNameLocked(this, remote))
+		this.guest = true
+	}
+	this.server.OnlineMap[this.Name()] = this
 	this.server.mapLock.Unlock()
 
+	fmt.Printf("session=#%d online name=%s addr=%s\n", this.ID, this.Name(), this.Addr)
+
+	if this.guest {
+		this.SendMsg(t(this, "user.guest_hint", this.Name()) + "\n")
+	}
+
+	this.loadPrefs()
+	this.presenceChanged()
+
+	// 先补发最近的公聊消息
+	if this.Pref("history") == "on" {
+		this.ReplayHistory()
+	}
+
 	// 广播当前用户上线消息
-	this.server.BroadCast(this, "已上线")
+	if err := this.server.announcePresence(this, presenceOnline); err != nil {
+		fmt.Printf("session=#%d broadcast err: %v\n", this.ID, err)
+	}
+
+	this.greet()
 }
 
-// 用户的下线业务
+// 用户的下线业务, 可以重复调用, 只有第一次生效, 见lifecycle.go
 func (this *User) Offline() {
+	if !this.beginOffline() {
+		return
+	}
+
 	// 用户下线, 将用户从OnlineMap中删除
 	this.server.mapLock.Lock()
-	delete(this.server.OnlineMap, this.Name)
+	if this.server.OnlineMap[this.Name()] == this {
+		delete(this.server.OnlineMap, this.Name())
+	}
 	this.server.mapLock.Unlock()
 
+	fmt.Printf("session=#%d offline name=%s addr=%s\n", this.ID, this.Name(), this.Addr)
+
+	this.savePrefs()
+	this.releaseName(this.Name(), this.guest)
+	this.presenceChanged()
+	this.cancelOffers()
+
+	// 已经不在OnlineMap里了, 不会再有新的广播发给这个用户
+	this.closeChannel()
+	this.transition(stateClosed)
+
 	// 广播当前用户下线消息
-	this.server.BroadCast(this, "下线")
+	if err := this.server.announcePresence(this, presenceOffline); err != nil {
+		fmt.Printf("session=#%d broadcast err: %v\n", this.ID, err)
+	}
+}
+
+// 集群模式下通知其他实例在线用户有变化
+func (this *User) presenceChanged() {
+	if this.server.cluster != nil {
+		this.server.cluster.PresenceChanged()
+	}
 }
 
 // 给当前User对应的客户端发送消息
-func (this *User) SendMsg(msg string) {
-	this.conn.Write([]byte(msg))
+// 写失败说明连接已经坏了, 关闭连接让读消息的goroutine执行下线业务, 之后的发送直接返回错误
+func (this *User) SendMsg(msg string) error {
+	if atomic.LoadInt32(&this.broken) == 1 || this.State() == stateClosed {
+		return errConnBroken
+	}
+	this.writeLock.Lock()
+	err := this.writeRaw(this.encodeMessage(msg))
+	this.writeLock.Unlock()
+	if err != nil {
+		this.markBroken(err)
+		return err
+	}
+	atomic.StoreInt64(&this.outbound.lastWrite, this.server.Clock.Now().UnixNano())
+	return nil
+}
+
+var errConnBroken = errors.New("connection is broken")
+
+// 标记连接已经坏了, 只记一次日志
+func (this *User) markBroken(err error) {
+	if atomic.CompareAndSwapInt32(&this.broken, 0, 1) {
+		fmt.Printf("session=#%d write err: %v\n", this.ID, err)
+		this.disconnect()
+	}
+}
+
+// 用户最后一次发消息的时间, 没发过消息时是上线时间
+func (this *User) LastActive() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&this.lastActive))
+}
+
+// 用户已经多久没有发消息了
+func (this *User) Idle() time.Duration {
+	return this.server.Clock.Now().Sub(this.LastActive())
 }
 
-// 用户处理消息的业务
+// 用户处理消息的业务: 命令交给commands里对应的处理函数, 其他内容作为公聊消息发出
+// 不在线(还没上线或者正在下线)时收到的消息直接丢掉
 func (this *User) DoMessage(msg string) {
-	if msg == "who" {
+	if this.State() != stateOnline {
+		return
+	}
+	cmd, arg, ok := findCommand(msg)
+	if !ok || !cmd.NoActivity {
+		atomic.StoreInt64(&this.lastActive, this.server.Clock.Now().UnixNano())
+	}
 
-		// 查询当前在线用户都有哪些
-		this.server.mapLock.Lock()
-		for _, user := range this.server.OnlineMap {
-			onlineMsg := "{" + user.Addr + "}" + user.Name + ":" + "在线...\n"
-			this.SendMsg(onlineMsg)
-		}
+	if ok {
+		cmd.Run(this, arg)
+		return
+	}
+
+	this.DoPublic(msg)
+}
+
+// 发一条公聊消息, 返回是否发出去了
+func (this *User) DoPublic(msg string) bool {
+	e, quiet := this.publish(msg)
+	if e != nil && !quiet {
+		this.sendError(e)
+	}
+	return e == nil
+}
+
+// 发一条公聊消息, 发不出去时返回原因, 由调用方回复; quiet为true表示这次禁言已经提示过, 不用再回复
+func (this *User) publish(msg string) (*replyError, bool) {
+	if e, quiet := this.lockedDown(); e != nil {
+		return e, quiet
+	}
+	if e := this.repeatBlocked(msg); e != nil {
+		return e, false
+	}
+	if wait := this.slowModeWait(); wait > 0 {
+		return this.newError(ErrRateLimited, "slow.wait", int(wait.Seconds())+1), false
+	}
+	if err := this.server.PublicChat(this, msg); err != nil {
+		return this.newError(ErrUnavailable, "public.failed"), false
+	}
+	return nil, false
+}
+
+// 改名, 消息格式: rename|张三
+// 声明了 rename-reply 能力的客户端收到 rename-ok|新用户名 或 rename-err|原因, 其他客户端收到提示文字
+func (this *User) DoRename(arg string) {
+	newName := strings.Split(arg, "|")[0]
+
+	err := this.rename(newName)
+	switch {
+	case this.HasCap(capRenameReply) && err == nil:
+		this.SendMsg("rename-ok|" + this.Name() + "\n")
+	case this.HasCap(capRenameReply):
+		this.SendMsg("rename-err|" + err.text + "\n")
+	case err == nil:
+		this.SendMsg(t(this, "rename.done", this.Name()) + "\n")
+	default:
+		this.sendError(err)
+	}
+	if err == nil {
+		this.welcomeBack(this.Name())
+	}
+}
+
+// 改成newName, 不允许时返回发给用户的错误
+func (this *User) rename(newName string) *replyError {
+	if err := this.renameAllowed(); err != nil {
+		return err
+	}
+	// #开头的是连接编号, 见 lookupUser
+	if strings.HasPrefix(newName, "#") {
+		return this.newError(ErrBadName, "rename.hash")
+	}
+	if spoofsPrefix(newName) {
+		return this.newError(ErrBadName, "rename.bracket")
+	}
+
+	// 判断name是否存在, 检查和修改OnlineMap、用户名都在mapLock里完成
+	// 别人在mapLock里看到的OnlineMap的key和用户名总是一致的
+	// 别的实例上的用户要查Redis, 在锁外面先查好
+	var remote map[string]Presence
+	if this.server.cluster != nil {
+		remote = this.server.cluster.RemoteUsers()
+	}
+	this.server.mapLock.Lock()
+	_, ok := this.server.OnlineMap[newName]
+	if _, taken := remote[newName]; taken {
+		ok = true
+	}
+	if ok {
 		this.server.mapLock.Unlock()
+		return this.newError(ErrNameTaken, "rename.taken")
+	}
+	oldName, wasGuest := this.Name(), this.guest
+	delete(this.server.OnlineMap, oldName)
+	this.server.OnlineMap[newName] = this
+	this.setName(newName)
+	this.guest = false
+	this.server.mapLock.Unlock()
+	fmt.Printf("session=#%d rename name=%s new=%s\n", this.ID, oldName, newName)
+	this.releaseName(oldName, wasGuest)
+	this.checkNameOwner(newName)
+	this.lastRename = this.server.Clock.Now()
+	this.renames++
+	this.loadPrefs()
+	this.presenceChanged()
+	return nil
+}
 
-	} else if len(msg) > 7 && msg[:7] == "rename|" {
-		// 消息格式: rename|张三
-		newName := strings.Split(msg, "|")[1] // 截取 "|" 且下标为0，所以张三下标为1，也就是[1]
-
-		// 判断name是否存在
-		_, ok := this.server.OnlineMap[newName]
-		if ok {
-			this.SendMsg("当前用户名被使用\n")
-		} else {
-			this.server.mapLock.Lock()
-			delete(this.server.OnlineMap, this.Name)
-			this.server.OnlineMap[newName] = this
-			this.server.mapLock.Unlock()
-			this.Name = newName
-			this.SendMsg("您已经更新用户名:" + this.Name + "\n")
-		}
+// 私聊消息的前缀, 客户端只按这个前缀识别私聊
+// 服务器发出的每一行都以服务器自己写的内容开头, 用户名又不能以括号开头, 用户发的内容不可能冒充这个前缀
+const privatePrefix = "[私聊]"
 
+// 发给接收方的私聊消息, 格式: [私聊]张三对您说:消息内容
+func privateLine(from, content string) string {
+	return privatePrefix + from + "对您说:" + content
+}
 
-	} else if len(msg) > 4 && msg[:3] == "to|" {
-		// 消息格式: to|张三|消息内容
+// 以括号开头的用户名会和服务器的前缀([系统]、[私聊]、{地址}等)混淆
+func spoofsPrefix(name string) bool {
+	return strings.HasPrefix(name, "[") || strings.HasPrefix(name, "{")
+}
 
-		// 1 获取对方的用户名
-		remoteName := strings.Split(msg, "|")[1]
-		if remoteName == "" {
-			this.SendMsg("消息格式不正确， 请使用 \"to|张三|你好啊\"格式. \n")
-			return
-		}
-		// 2 根据用户名 得到对方的User对象
-		remoteUser, ok := this.server.OnlineMap[remoteName]
-		if !ok {
-			this.SendMsg("该用户名不存在\n")
-			return
-		}
-		// 3 获取消息内容，通过对方的User对象将消息发送过去
-		content := strings.Split(msg, "|")[2]
-		if content == "" {
-			this.SendMsg("无消息内容， 请重发 \n")
-			return
+// 私聊, 消息格式: to|张三|消息内容, 消息内容里可以有|
+// 声明了 msg-id 能力时内容里有|的话最后一段是消息ID: to|张三|消息内容|消息ID, 见dedupe.go
+func (this *User) DoPrivate(arg string) {
+	remoteName, content, ok := strings.Cut(arg, "|")
+	if !ok {
+		this.errorReply(ErrUsage, "pm.usage")
+		return
+	}
+	i := strings.LastIndex(content, "|")
+	if !this.HasCap(capMsgID) || i < 0 {
+		if e := this.sendPrivate(remoteName, content); e != nil {
+			this.sendError(e)
 		}
-		remoteUser.SendMsg(this.Name + "对您说:" + content)
+		return
+	}
+
+	if !validMsgID(content[i+1:]) {
+		this.errorReply(ErrUsage, "pm.usage")
+		return
+	}
+	content, id := content[:i], content[i+1:]
+	if this.sentIDs.Seen(id) {
+		this.ackMsgID(id)
+		return
+	}
+	if e := this.sendPrivate(remoteName, content); e != nil {
+		this.sendError(e)
+		this.nakMsgID(id)
+		return
+	}
+	this.sentIDs.Add(id)
+	this.ackMsgID(id)
+}
 
+// 发一条私聊消息, 发不出去时返回给发送方的错误, 由调用方回复
+func (this *User) sendPrivate(remoteName, content string) *replyError {
+	// 1 检查对方的用户名
+	if remoteName == "" {
+		return this.newError(ErrUsage, "pm.usage")
+	}
+	// 2 根据用户名或 #连接编号 得到对方的User对象
+	remoteUser, ok := this.server.lookupUser(remoteName)
+	// 3 检查消息内容，通过对方的User对象将消息发送过去
+	if content == "" {
+		return this.newError(ErrEmptyMessage, "pm.empty")
+	}
+	if !ok {
+		// 集群模式下对方可能在其他实例上, 连接编号只在本实例有效
+		if this.server.cluster == nil || strings.HasPrefix(remoteName, "#") || !this.server.cluster.SendPrivate(this.Name(), remoteName, content) {
+			// 分清不在线和打错了名字, 见pmtarget.go
+			return this.privateTargetMissing(remoteName)
+		}
+		return nil
+	}
+	if !remoteUser.checkAllowList(this) {
+		return this.newError(ErrNotAllowed, "pm.not_allowed")
+	}
+	if !remoteUser.acceptPrivate(this) {
+		return this.newError(ErrRateLimited, "pm.recipient_busy")
+	}
+	// 声明了回执能力的接收方会收到带序号的消息, 读到后回发 read|序号
+	var err error
+	if remoteUser.HasCap(capReceipts) {
+		seq := this.server.nextSeq()
+		this.server.receipts.Add(seq, this, remoteUser)
+		err = remoteUser.SendMsg("pm|" + strconv.FormatUint(seq, 10) + "|" + this.Name() + "|" + content + "\n")
 	} else {
-		this.server.BroadCast(this, msg)
+		err = remoteUser.SendMsg(privateLine(this.Name(), content) + "\n")
 	}
+	if err != nil {
+		return this.newError(ErrUnavailable, "pm.failed")
+	}
+	return nil
+}
 
+// 检查改名是否太频繁, 不允许时返回给用户的原因
+func (this *User) renameAllowed() *replyError {
+	if this.IsAdmin() {
+		return nil
+	}
+	if this.server.RenameLimit > 0 && this.renames >= this.server.RenameLimit {
+		return this.newError(ErrRateLimited, "rename.limit", this.server.RenameLimit)
+	}
+	if !this.lastRename.IsZero() {
+		wait := this.server.RenameInterval - this.server.Clock.Now().Sub(this.lastRename)
+		if wait > 0 {
+			return this.newError(ErrRateLimited, "rename.wait", int(wait.Seconds())+1)
+		}
+	}
+	return nil
 }
 
 // 监听当前User channel的 方法,一旦有消息，就直接发送给对端客户端
+// 连接坏了之后也要继续读channel, 否则会卡住广播, 下线时channel关闭后退出
 func (this *User) ListenMessage() {
-	for {
-		msg := <-this.C // 接受数组
-
-		this.conn.Write([]byte(msg + "\n")) // 将当前消息写入字节数组
+	for msg := range this.C { // 接受数组
+		atomic.StoreInt64(&this.outbound.writeStart, this.server.Clock.Now().UnixNano())
+		this.SendMsg(msg + "\n") // 将当前消息写入字节数组
+		atomic.StoreInt64(&this.outbound.writeStart, 0)
 	}
+	atomic.StoreInt32(&this.outbound.stopped, 1)
 }
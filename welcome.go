@@ -0,0 +1,128 @@
+// 欢迎回来: 用户改回以前用过的名字时, 告诉他离线期间错过了多少消息
+// 不用这个名字时(改名或下线)在Store里记下时间和当时的公聊消息总数; 这期间发给这个名字的私聊因为对方不在线送不到, 也记下条数
+// 再有人改成这个名字时用现在的总数减去记下的, 就是错过的公聊消息数
+// 只在设置了 -store 时记录, guest名字不记录; 服务器重启后总数从0开始, 错过的公聊只按重启以后的算
+// 私聊数先记在内存里, 每隔missedPMFlushInterval和服务器停止时写进Store, 不用每条私聊都读写一次Store
+// 只有名字最后的使用者(见names.go)是同一个连接或者同一个地址时才显示, 别人改成这个名字看不到原来的主人错过了什么
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// 一个名字的离线记录
+type lastSeen struct {
+	at     time.Time // 什么时候不再用这个名字
+	public uint64    // 当时的公聊消息总数
+	pms    int       // 这期间没有送到的私聊数
+}
+
+// 多久把内存里的私聊数写进Store一次
+const missedPMFlushInterval = time.Minute
+
+// 内存里最多给多少个名字记私聊数, 超过时新的名字不记, 等下次写进Store
+const maxMissedPMNames = 4096
+
+// 用户不再使用name时记下离线记录
+func (this *Server) recordLastSeen(name string) {
+	store := this.PrefStore
+	if store == nil {
+		return
+	}
+
+	this.seenLock.Lock()
+	defer this.seenLock.Unlock()
+
+	// 新的离线期间从0开始, 上一次离线时的私聊数已经在回来时显示过了
+	delete(this.missedPMs, name)
+	err := store.SaveLastSeen(name, lastSeen{
+		at:     this.Clock.Now(),
+		public: atomic.LoadUint64(&this.stats.publicMsgs),
+	})
+	if err != nil {
+		fmt.Println("save last seen err:", name, err)
+	}
+}
+
+// 发给不在线的name的私聊没有送到, 在内存里记一条; 写进Store时这个名字没有离线记录的丢掉
+func (this *Server) countMissedPM(name string) {
+	if this.PrefStore == nil {
+		return
+	}
+
+	this.seenLock.Lock()
+	defer this.seenLock.Unlock()
+
+	if this.missedPMs == nil {
+		this.missedPMs = make(map[string]int)
+	}
+	if _, ok := this.missedPMs[name]; !ok && len(this.missedPMs) >= maxMissedPMNames {
+		return
+	}
+	this.missedPMs[name]++
+}
+
+// 把内存里的私聊数加到Store的离线记录上
+func (this *Server) flushMissedPMs() {
+	store := this.PrefStore
+	if store == nil {
+		return
+	}
+
+	this.seenLock.Lock()
+	defer this.seenLock.Unlock()
+
+	for name, n := range this.missedPMs {
+		seen, ok, err := store.LoadLastSeen(name)
+		if err != nil || !ok {
+			continue
+		}
+		seen.pms += n
+		if err := store.SaveLastSeen(name, seen); err != nil {
+			fmt.Println("save last seen err:", name, err)
+		}
+	}
+	this.missedPMs = nil
+}
+
+// 定期写入私聊数, ticker在Start里建好
+func (this *Server) missedPMFlusher(ticker Ticker) {
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C():
+			this.flushMissedPMs()
+		case <-this.stopped:
+			return
+		}
+	}
+}
+
+// 改成name后, 这个名字有离线记录、并且上一次是这个连接或者同一个地址在用时, 告诉用户错过了多少消息
+func (this *User) welcomeBack(name string) {
+	store := this.server.PrefStore
+	if store == nil {
+		return
+	}
+	owner, ok := this.server.nameOwner(name)
+	if !ok || (owner.session != this.ID && owner.ip != hostOf(this.Addr)) {
+		return
+	}
+
+	this.server.seenLock.Lock()
+	seen, ok, err := store.LoadLastSeen(name)
+	pending := this.server.missedPMs[name]
+	this.server.seenLock.Unlock()
+	if err != nil || !ok {
+		return
+	}
+
+	now := atomic.LoadUint64(&this.server.stats.publicMsgs)
+	missed := now
+	if now >= seen.public {
+		missed = now - seen.public
+	}
+	this.SendMsg(t(this, "welcome.back", name, seen.pms+pending, missed) + "\n")
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"testing"
+)
+
+// bob上线再下线, 留下离线记录
+func leaveName(t *testing.T, server *Server, name string) {
+	t.Helper()
+	c := dialTest(t, server, name)
+	c.conn.Close()
+	eventually(t, name+" 没有下线", func() bool {
+		_, ok := server.lookupUser(name)
+		return !ok
+	})
+}
+
+func TestWelcomeBackCounts(t *testing.T) {
+	clock := NewFakeClock(testEpoch)
+	store := NewMemStore()
+	server := startTestServer(t, WithClock(clock), WithStore(store))
+	alice := dialTest(t, server, "alice")
+	leaveName(t, server, "bob")
+
+	alice.send("to|bob|one", "to|bob|two")
+	alice.waitFor(text("pm.offline", "bob"))
+	alice.send("public-1", "public-2", "public-3")
+	alice.waitFor("public-3")
+
+	// 私聊数先记在内存里, 写进Store之前回来也算上
+	bob := dialTest(t, server, "bob")
+	bob.waitFor(text("welcome.back", "bob", 2, 3))
+}
+
+func TestWelcomeBackFlushesPMs(t *testing.T) {
+	clock := NewFakeClock(testEpoch)
+	store := NewMemStore()
+	server := startTestServer(t, WithClock(clock), WithStore(store))
+	alice := dialTest(t, server, "alice")
+	leaveName(t, server, "bob")
+
+	alice.send("to|bob|one", "to|bob|two", "to|bob|three")
+	alice.send("sync-pm")
+	alice.waitFor("sync-pm")
+	if seen, _, _ := store.LoadLastSeen("bob"); seen.pms != 0 {
+		t.Fatalf("每条私聊都写了Store: pms=%d", seen.pms)
+	}
+
+	clock.Advance(missedPMFlushInterval)
+	eventually(t, "私聊数没有写进Store", func() bool {
+		seen, _, _ := store.LoadLastSeen("bob")
+		return seen.pms == 3
+	})
+
+	// 写进Store以后不会再加一遍
+	bob := dialTest(t, server, "bob")
+	bob.waitFor(text("welcome.back", "bob", 3, 1))
+}
+
+func TestWelcomeBackOtherOwner(t *testing.T) {
+	store := NewMemStore()
+	server := startTestServer(t, WithStore(store))
+	alice := dialTest(t, server, "alice")
+	leaveName(t, server, "bob")
+	alice.send("to|bob|secret")
+	alice.waitFor(text("pm.offline", "bob"))
+
+	// 上一次用这个名字的是别的地址, 改成这个名字的人看不到原来的主人错过了什么
+	server.setNameOwner("bob", nameOwner{session: 999, ip: "192.0.2.1", until: server.Clock.Now()})
+	bob := dialTest(t, server, "bob")
+	bob.send("sync-welcome")
+	bob.waitFor("sync-welcome")
+	if bob.saw(text("welcome.back", "bob", 1, 0)) {
+		t.Fatal("别的地址改成这个名字也显示了欢迎回来")
+	}
+}
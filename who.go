@@ -0,0 +1,212 @@
+// 在线用户列表
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 当前用户能不能看到user的地址: 开启了 -show-addr, 或者是管理员, 或者是自己
+func (this *User) canSeeAddr(user *User) bool {
+	return this.server.ShowAddr || this.IsAdmin() || this == user
+}
+
+// who列表里显示的地址, 看不到地址时显示连接编号
+func (this *User) addrFor(user *User) string {
+	if this.canSeeAddr(user) {
+		return user.Addr
+	}
+	return "#" + strconv.FormatUint(user.ID, 10)
+}
+
+// 查询当前在线用户都有哪些
+// 列表前后加上 who-begin 和 who-end|人数, 客户端可以知道列表到哪里结束
+// 在锁里只整理列表, 解锁以后再发送, 请求的用户不读消息时不会卡住别人上下线
+func (this *User) DoWho() {
+	this.server.mapLock.RLock()
+	lines := make([]string, 0, len(this.server.OnlineMap))
+	for _, user := range this.server.OnlineMap {
+		name := user.Name()
+		if user.IsBot() {
+			name = botTag + name
+		}
+		addr := this.addrFor(user)
+		onlineMsg := "{" + addr + "}" + name + ":" + "在线...\n"
+		if away := user.Pref("away"); away != "" {
+			onlineMsg = "{" + addr + "}" + name + ":" + "离开(" + away + ")...\n"
+		}
+		lines = append(lines, onlineMsg)
+	}
+	this.server.mapLock.RUnlock()
+
+	// 集群模式下还有其他实例上的用户, 看不到地址时{}里为空
+	if this.server.cluster != nil {
+		for name, p := range this.server.cluster.RemoteUsers() {
+			addr := ""
+			if this.server.ShowAddr || this.IsAdmin() {
+				addr = p.Addr
+			}
+			lines = append(lines, "{"+addr+"}"+name+":"+"在线...\n")
+		}
+	}
+
+	legacy := this.server.LegacyWho
+	if !legacy {
+		this.SendMsg("who-begin\n")
+	}
+	for _, line := range lines {
+		this.SendMsg(line)
+	}
+	if !legacy {
+		this.SendMsg("who-end|" + strconv.Itoa(len(lines)) + "\n")
+	}
+}
+
+// 给程序解析用的在线用户列表, 格式: wholist|张三,李四
+func (this *User) DoWholist() {
+	this.server.mapLock.RLock()
+	names := make([]string, 0, len(this.server.OnlineMap))
+	for name := range this.server.OnlineMap {
+		names = append(names, name)
+	}
+	this.server.mapLock.RUnlock()
+
+	sort.Strings(names)
+	this.SendMsg("wholist|" + strings.Join(names, ",") + "\n")
+}
+
+// who|json 返回的一个在线用户
+type whoEntry struct {
+	Session     uint64     `json:"session,omitempty"` // 连接编号, 其他实例上的用户没有这一项
+	Name        string     `json:"name"`
+	Addr        string     `json:"addr,omitempty"`        // 看不到地址时没有这一项
+	ConnectedAt *time.Time `json:"connectedAt,omitempty"` // 其他实例上的用户没有这一项
+	Away        string     `json:"away,omitempty"`
+	Bot         bool       `json:"bot,omitempty"`
+	IdleSeconds int64      `json:"idleSeconds,omitempty"` // 多少秒没有发消息, 其他实例上的用户没有这一项
+}
+
+// 给程序解析用的在线用户列表, 只发给请求的用户
+// 消息格式: who|json, 回复一行JSON数组, 按用户名排序
+func (this *User) DoWhoJSON() {
+	this.server.mapLock.RLock()
+	list := make([]whoEntry, 0, len(this.server.OnlineMap))
+	for _, user := range this.server.OnlineMap {
+		connectedAt := user.ConnectedAt
+		addr := ""
+		if this.canSeeAddr(user) {
+			addr = user.Addr
+		}
+		list = append(list, whoEntry{
+			Session:     user.ID,
+			Name:        user.Name(),
+			Addr:        addr,
+			ConnectedAt: &connectedAt,
+			Away:        user.Pref("away"),
+			Bot:         user.IsBot(),
+			IdleSeconds: int64(user.Idle() / time.Second),
+		})
+	}
+	this.server.mapLock.RUnlock()
+
+	if this.server.cluster != nil {
+		for name, p := range this.server.cluster.RemoteUsers() {
+			entry := whoEntry{Name: name}
+			if this.server.ShowAddr || this.IsAdmin() {
+				entry.Addr = p.Addr
+			}
+			list = append(list, entry)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+
+	data, err := json.Marshal(list)
+	if err != nil {
+		fmt.Println("who json err:", err)
+		return
+	}
+	this.SendMsg(string(data) + "\n")
+}
+
+// 查看一个用户的详细信息, 消息格式: whois|用户名 或 whois|#编号
+// 地址见canSeeAddr, 流量只给管理员和本人看
+func (this *User) DoWhois(target string) {
+	user, ok := this.server.lookupUser(target)
+	if !ok {
+		this.userNotFound(target)
+		return
+	}
+
+	lines := []string{
+		t(this, "whois.name", user.Name()),
+		t(this, "whois.session", user.ID),
+	}
+	if this.canSeeAddr(user) {
+		lines = append(lines, t(this, "whois.addr", user.Addr))
+	}
+	if user.Transport != "" {
+		lines = append(lines, t(this, "whois.transport", user.Transport))
+	}
+	lines = append(lines, t(this, "whois.since", user.ConnectedAt.Format("2006-01-02 15:04:05")))
+	lines = append(lines, t(this, "whois.idle", formatIdle(user.Idle())))
+	if away := user.Pref("away"); away != "" {
+		lines = append(lines, t(this, "whois.away", away))
+	}
+	if user.IsBot() {
+		lines = append(lines, t(this, "whois.bot"))
+	}
+	if this.IsAdmin() || user == this {
+		if in, out, ok := user.Traffic(); ok {
+			lines = append(lines, t(this, "whois.traffic", in, out))
+		}
+		if in, out, ok := user.CompressSavings(); ok {
+			lines = append(lines, t(this, "whois.compress", in, out))
+		}
+	}
+	if this.IsAdmin() {
+		lines = append(lines, user.nameLines(this)...)
+	}
+	for _, line := range lines {
+		this.SendMsg(line + "\n")
+	}
+}
+
+// 按最近活跃排序的在线用户, 消息格式: who|idle, 最近发过消息的排在前面
+// 只列出本实例的用户, 其他实例上的用户不知道空闲时间
+func (this *User) DoWhoIdle() {
+	this.server.mapLock.RLock()
+	list := make([]*User, 0, len(this.server.OnlineMap))
+	for _, user := range this.server.OnlineMap {
+		list = append(list, user)
+	}
+	this.server.mapLock.RUnlock()
+
+	sort.Slice(list, func(i, j int) bool { return list[i].LastActive().After(list[j].LastActive()) })
+	for _, user := range list {
+		this.SendMsg(t(this, "who.idle_line", user.Name(), formatIdle(user.Idle())) + "\n")
+	}
+}
+
+// 空闲时间的简短写法, 如 45s、5m、2h10m
+func formatIdle(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return strconv.Itoa(int(d/time.Second)) + "s"
+	case d < time.Hour:
+		return strconv.Itoa(int(d/time.Minute)) + "m"
+	default:
+		return strconv.Itoa(int(d/time.Hour)) + "h" + strconv.Itoa(int(d%time.Hour/time.Minute)) + "m"
+	}
+}
+
+// 查看自己当前的身份, 消息格式: whoami
+// 回复一行, 格式: whoami name=张三 session=#3 addr=地址 admin=off caps=receipts away=离开原因
+// 离开原因里可能有空格, 放在最后, away= 后面全部都是
+func (this *User) DoWhoami() {
+	this.SendMsg(fmt.Sprintf("whoami name=%s session=#%d addr=%s admin=%s caps=%s away=%s\n",
+		this.Name(), this.ID, this.Addr, onOff(this.IsAdmin()), strings.Join(this.Caps(), ","), this.Pref("away")))
+}
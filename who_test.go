@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// who 和 who|json 只回复请求的用户, 不进广播
+func TestWhoRepliesToRequesterOnly(t *testing.T) {
+	server := startTestServer(t)
+	alice := dialTest(t, server, "alice")
+	bob := dialTest(t, server, "bob")
+	bob.send("settings|away|午饭")
+	bob.waitFor(text("settings.set", "away", "午饭"))
+
+	alice.send("who|json")
+	line := alice.waitFor(`[{"session":`)
+	var list []whoEntry
+	if err := json.Unmarshal([]byte(line), &list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[0].Name != "alice" || list[1].Name != "bob" {
+		t.Fatalf("who|json = %s", line)
+	}
+	// 自己的地址看得到, 别人的看不到
+	if list[0].Addr == "" || list[1].Addr != "" || list[1].Away != "午饭" || list[1].ConnectedAt == nil {
+		t.Fatalf("who|json = %s", line)
+	}
+
+	alice.send("who")
+	alice.waitFor("}bob:离开(午饭)...")
+	alice.waitFor("who-end|2")
+
+	bob.send("sync-bob")
+	bob.waitFor("sync-bob")
+	for _, seen := range bob.seen {
+		if strings.Contains(seen, `"session"`) || strings.HasPrefix(seen, "who-") {
+			t.Fatalf("bob 收到了 alice 的在线列表: %q", seen)
+		}
+	}
+}
+
+// who的列表前后有 who-begin 和 who-end|人数, -legacy-who 时跟老版本一样没有
+func TestWhoFraming(t *testing.T) {
+	for _, legacy := range []bool{false, true} {
+		server := startTestServer(t, WithLegacyWho(legacy))
+		alice := dialTest(t, server, "alice")
+		dialTest(t, server, "bob")
+
+		alice.send("who", "sync-who")
+		alice.waitFor("sync-who")
+		var got []string
+		for _, line := range alice.seen {
+			if strings.HasPrefix(line, "who-") || strings.HasPrefix(line, "{") {
+				got = append(got, line)
+			}
+		}
+
+		// 自己的地址看得到, OnlineMap没有顺序
+		users := []string{"{" + onlineUser(t, server, "alice").Addr + "}alice:在线...", "{#2}bob:在线..."}
+		want := users
+		if !legacy {
+			want = append([]string{"who-begin"}, append(users, "who-end|2")...)
+		}
+		if len(got) == len(want) {
+			first := 0
+			if !legacy {
+				first = 1
+			}
+			sort.Strings(got[first : first+2])
+			sort.Strings(want[first : first+2])
+		}
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Fatalf("legacy=%v who = %q", legacy, got)
+		}
+	}
+}
+
+// 请求who的用户读到 who-begin 以后不再读, 别人照常上线、改名和下线
+func TestWhoStalledRequester(t *testing.T) {
+	server := startTestServer(t, WithRenameLimit(0, 0))
+	dialTest(t, server, "alice")
+	dialTest(t, server, "bob")
+
+	serverSide, clientSide := net.Pipe()
+	t.Cleanup(func() { clientSide.Close() })
+	go server.Handler(serverSide)
+	reader := bufio.NewReader(clientSide)
+	readUntil := func(want string) {
+		clientSide.SetReadDeadline(time.Now().Add(e2eWait))
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				t.Fatalf("没有读到 %q: %v", want, err)
+			}
+			if strings.Contains(line, want) {
+				return
+			}
+		}
+	}
+	readUntil("hello|")
+	go clientSide.Write([]byte("rename|stalled\nwho\n"))
+	readUntil("who-begin")
+
+	carol := dialTest(t, server, "carol")
+	carol.send("rename|carol2")
+	carol.waitFor(text("rename.done", "carol2"))
+	carol.conn.Close()
+	eventually(t, "carol2 没有下线", func() bool {
+		_, ok := server.lookupUser("carol2")
+		return !ok
+	})
+}
+
+// whoami 只回复自己, 离开原因里的空格原样保留在最后
+func TestWhoami(t *testing.T) {
+	server := startTestServer(t, WithAdminToken("secret"))
+	alice := dialTest(t, server, "alice")
+	bob := dialTest(t, server, "bob")
+	user := onlineUser(t, server, "alice")
+
+	alice.send("whoami")
+	alice.waitFor(fmt.Sprintf("whoami name=alice session=#%d addr=%s admin=off caps= away=", user.ID, user.Addr))
+
+	alice.send("admin|secret", "caps|receipts", "settings|away|开会 到三点")
+	alice.waitFor(text("settings.set", "away", "开会 到三点"))
+	alice.send("whoami")
+	line := alice.waitFor("whoami name=alice")
+	want := fmt.Sprintf("whoami name=alice session=#%d addr=%s admin=on caps=receipts away=开会 到三点", user.ID, user.Addr)
+	if line != want {
+		t.Fatalf("whoami = %q, want %q", line, want)
+	}
+
+	bob.send("sync-bob")
+	bob.waitFor("sync-bob")
+	if bob.saw("whoami ") {
+		t.Fatal("bob 收到了 alice 的 whoami")
+	}
+}